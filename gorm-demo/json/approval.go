@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"time"
 
 	"gorm.io/datatypes"
@@ -9,18 +8,30 @@ import (
 )
 
 // ApprovalM 审批模型
+//
+// uk_tenant_instance_id 与 ddl.sql.tpl 的 0005、0006 迁移一致，只约束未删除的记录。
+// GORM 的 MySQL 迁移不支持索引的 where 选项，AutoMigrate 会建成普通唯一索引，MySQL 请使用 SchemaMigrator 建表。
 type ApprovalM struct {
-	ID           uint64         `gorm:"column:id;AUTO_INCREMENT;primary_key" json:"id"`
-	CreatedAt    time.Time      `gorm:"column:created_at" json:"created_at"`
-	UpdatedAt    time.Time      `gorm:"column:updated_at" json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `gorm:"column:deleted_at;index" json:"-"`
-	InstanceID   string         `gorm:"column:instance_id;type:varchar(255);NOT NULL" json:"instance_id"`     // 审批实例ID, 飞书: uuid
-	ApprovalCode string         `gorm:"column:approval_code;type:varchar(255);NOT NULL" json:"approval_code"` // 审批实例Code, 飞书: approval_code
-	Type         string         `gorm:"column:type;type:varchar(20);NOT NULL" json:"type"`                    // 审批实例类型, 可选值: lark, dingtalk
-	IsWrittenES  bool           `gorm:"column:is_written_es;type:tinyint(1);NOT NULL" json:"is_written_es"`   // 数据库中 0 对应 false，1 对应 true
-	LarkData     datatypes.JSON `gorm:"column:lark_data;type:json;null" json:"lark_data"`                     // 单个飞书审批实例数据
+	ID           uint64                   `gorm:"column:id;AUTO_INCREMENT;primary_key" json:"id"`
+	CreatedAt    time.Time                `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt    time.Time                `gorm:"column:updated_at" json:"updated_at"`
+	DeletedAt    gorm.DeletedAt           `gorm:"column:deleted_at;index" json:"-"`
+	TenantID     string                   `gorm:"column:tenant_id;type:varchar(64);NOT NULL;default:'';uniqueIndex:uk_tenant_instance_id,priority:1,where:deleted_at IS NULL" json:"tenant_id"` // 租户ID，由 TenantPlugin 根据 context 写入
+	InstanceID   string                   `gorm:"column:instance_id;type:varchar(255);NOT NULL;uniqueIndex:uk_tenant_instance_id,priority:2" json:"instance_id"`                                // 审批实例ID, 飞书: uuid，租户内唯一
+	ApprovalCode string                   `gorm:"column:approval_code;type:varchar(255);NOT NULL" json:"approval_code"`                                                                         // 审批实例Code, 飞书: approval_code
+	Type         string                   `gorm:"column:type;type:varchar(20);NOT NULL" json:"type"`                                                                                            // 审批实例类型, 可选值: lark, dingtalk
+	IsWrittenES  bool                     `gorm:"column:is_written_es;type:tinyint(1);NOT NULL" json:"is_written_es"`                                                                           // 数据库中 0 对应 false，1 对应 true
+	LarkData     JSONColumn[LarkApproval] `gorm:"column:lark_data;type:json;null" json:"lark_data"`                                                                                             // 单个飞书审批实例数据
+	DingTalkData datatypes.JSON           `gorm:"column:dingtalk_data;type:json;null" json:"dingtalk_data"`                                                                                     // 单个钉钉审批实例数据
+	Version      uint64                   `gorm:"column:version;type:bigint unsigned;NOT NULL;default:0" json:"version"`                                                                        // 乐观锁版本号，每次更新加 1
 }
 
+// 审批实例类型
+const (
+	ApprovalTypeLark     = "lark"
+	ApprovalTypeDingTalk = "dingtalk"
+)
+
 // TableName 指定表名
 func (ApprovalM) TableName() string {
 	return "approval"
@@ -28,104 +39,100 @@ func (ApprovalM) TableName() string {
 
 // BeforeCreate GORM钩子，在创建记录前执行验证
 func (a *ApprovalM) BeforeCreate(tx *gorm.DB) error {
-	return a.validateRequiredFields()
-}
-
-// BeforeUpdate GORM钩子，在更新记录前执行验证
-func (a *ApprovalM) BeforeUpdate(tx *gorm.DB) error {
-	// 定义可为null的字段列表
-	nullableFields := []string{"lark_data"} // 可以添加更多可为null的字段，如 "dingtalk_data", "additional_data" 等
-
-	// 定义NOT NULL字段列表（除了InstanceID和IsWrittenES有特殊处理外）
-	notNullFields := []string{"approval_code", "type"}
-
-	// 检查是否只有可为null字段被修改
-	var hasNullableFieldChanged bool
-	for _, field := range nullableFields {
-		if tx.Statement.Changed(field) {
-			hasNullableFieldChanged = true
-			break
+	// instance_id、approval_code、type 不能为空，见 ApprovalColumnRules
+	if err := checkCreateColumnRules(tx, ApprovalColumnRules, a); err != nil {
+		return err
+	}
+	// 审批和任务状态统一为大写
+	if err := normalizeApprovalStatuses(a); err != nil {
+		return err
+	}
+	// upsert（ON CONFLICT）时结构体中可能只是要合并的部分字段，写入后在 AfterCreate 中按最终数据校验
+	if _, upsert := tx.Statement.Clauses["ON CONFLICT"]; !upsert {
+		data, err := a.LarkData.Bytes()
+		if err != nil {
+			return err
+		}
+		if err := validateLarkData(tx, a.InstanceID, data); err != nil {
+			return err
 		}
 	}
+	// 飞书状态为 DELETED/CANCELED 时直接以软删除状态写入
+	softDeleteByStatus(tx, a)
+	// upsert 时记录已有数据，用于生成变更历史
+	return snapshotBeforeUpsert(tx, a)
+}
 
-	if hasNullableFieldChanged {
-		// 检查是否有NOT NULL字段被修改
-		var hasNotNullFieldChanged bool
-		for _, field := range notNullFields {
-			if tx.Statement.Changed(field) {
-				hasNotNullFieldChanged = true
-				break
-			}
-		}
+// AfterCreate GORM钩子，校验 upsert 后的数据，检查状态转移并记录审批历史
+func (a *ApprovalM) AfterCreate(tx *gorm.DB) error {
+	return recordCreateHistory(tx, a)
+}
 
-		// 检查InstanceID是否被修改（特殊NOT NULL字段）
-		if tx.Statement.Changed("instance_id") {
-			hasNotNullFieldChanged = true
-		}
+// AfterUpdate GORM钩子，补写 version 等列，对比 BeforeUpdate 中读取的旧数据检查状态转移（见 approval_state.go）并记录审批历史
+func (a *ApprovalM) AfterUpdate(tx *gorm.DB) error {
+	return recordUpdateHistory(tx, a)
+}
 
-		// 检查IsWrittenES是否被修改（特殊NOT NULL字段）
-		if tx.Statement.Changed("is_written_es") {
-			hasNotNullFieldChanged = true
-		}
+// BeforeDelete GORM钩子，软删除前读取将被删除的记录
+func (a *ApprovalM) BeforeDelete(tx *gorm.DB) error {
+	return snapshotBeforeSoftDelete(tx)
+}
 
-		// 如果只有可为null字段被修改，则跳过验证
-		if !hasNotNullFieldChanged {
-			return nil
-		}
-	}
+// AfterDelete GORM钩子，软删除的记录重新进入 ES 队列，由 ESIndexer 从索引中删除
+func (a *ApprovalM) AfterDelete(tx *gorm.DB) error {
+	return requeueSoftDeleted(tx)
+}
 
-	// 检查被修改的NOT NULL字段并验证
-	// 对于NOT NULL字段，如果被修改了，则需要验证其值不为空
-	if tx.Statement.Changed("approval_code") && a.ApprovalCode == "" {
-		return fmt.Errorf("approval_code cannot be empty")
+// BeforeUpdate GORM钩子，在更新记录前执行验证
+func (a *ApprovalM) BeforeUpdate(tx *gorm.DB) error {
+	// 按 ApprovalColumnRules 校验：instance_id、tenant_id 不可修改，非空列不能写入空值
+	// 需在下面的步骤向本次更新追加列之前执行
+	if err := checkUpdateColumnRules(tx, ApprovalColumnRules); err != nil {
+		return err
 	}
 
-	if tx.Statement.Changed("type") && a.Type == "" {
-		return fmt.Errorf("type cannot be empty")
+	// 校验写入的 lark_data 是否符合 LarkApproval 结构；以 SQL 表达式写入时在 AfterUpdate 中按写入后的数据校验
+	if data, ok := changedLarkData(tx, a); ok {
+		// 状态的大小写在 AfterUpdate 中才写回数据库，这里按统一为大写后的文档校验
+		if normalized, _, err := normalizeLarkStatuses(data); err == nil {
+			data = normalized
+		}
+		if err := validateLarkData(tx, a.InstanceID, data); err != nil {
+			return err
+		}
+		setStatementValue(tx, larkDataValidatedKey, true)
 	}
 
-	// InstanceID通常作为查询条件，不应该被修改
-	if tx.Statement.Changed("instance_id") {
-		return fmt.Errorf("instance_id should not be modified")
-	}
+	// 审批数据变更后需要重新写入 ES
+	requeueSearchIndex(tx, a)
 
-	// IsWrittenES是NOT NULL字段，但它是布尔值，零值(false)也是有效值，所以不需要特殊验证
+	// 飞书状态变为 DELETED/CANCELED 时软删除
+	softDeleteByStatus(tx, a)
 
-	return nil
-}
+	// 每次更新都把版本号加 1，ES 索引和乐观锁据此识别并发修改
+	bumpApprovalVersion(tx)
 
-// validateRequiredFields 验证必填字段是否为空
-// checkInstanceID参数决定是否验证InstanceID字段
-func (a *ApprovalM) validateRequiredFields() error {
-	if a.InstanceID == "" {
-		return fmt.Errorf("instance_id cannot be empty")
-	}
-	if a.ApprovalCode == "" {
-		return fmt.Errorf("approval_code cannot be empty")
-	}
-	if a.Type == "" {
-		return fmt.Errorf("type cannot be empty")
-	}
-	return nil
+	// 读取更新前的数据，用于检查状态转移和生成变更历史
+	return snapshotBeforeUpdate(tx)
 }
 
 // LarkApproval 审批实例数据
 //   - https://open.feishu.cn/document/server-docs/approval-v4/instance/get
 type LarkApproval struct {
-	ApprovalName string `json:"approval_name"` // 审批名称
-	StartTime    string `json:"start_time"`    // 审批创建时间，毫秒级时间戳。
-	EndTime      string `json:"end_time"`      // 审批完成时间，毫秒级时间戳。审批未完成时该参数值为 0。
-	UserID       string `json:"user_id"`       // 发起审批的用户 user_id
-	OpenID       string `json:"open_id"`       // 发起审批的用户 open_id
-	SerialNumber string `json:"serial_number"` // 审批单编号
-	DepartmentID string `json:"department_id"` // 发起审批用户所在部门的 ID
+	ApprovalName string `json:"approval_name" schema:"required"` // 审批名称
+	StartTime    string `json:"start_time"`                      // 审批创建时间，毫秒级时间戳。
+	EndTime      string `json:"end_time"`                        // 审批完成时间，毫秒级时间戳。审批未完成时该参数值为 0。
+	UserID       string `json:"user_id"`                         // 发起审批的用户 user_id
+	OpenID       string `json:"open_id"`                         // 发起审批的用户 open_id
+	SerialNumber string `json:"serial_number"`                   // 审批单编号
+	DepartmentID string `json:"department_id"`                   // 发起审批用户所在部门的 ID
 	// 审批实例状态，可选值有：
 	//  - PENDING：审批中
 	//  - APPROVED：通过
 	//  - REJECTED：拒绝
 	//  - CANCELED：撤回
 	//  - DELETED：删除
-	Status               string              `json:"status"`
+	Status               string              `json:"status" schema:"enum=PENDING|APPROVED|REJECTED|CANCELED|DELETED"`
 	UUID                 string              `json:"uuid"`                   // 审批实例的唯一标识 id
 	Form                 string              `json:"form"`                   // 审批表单控件 JSON 字符串，控件值详细说明参见本文下方 控件值说明 章节。
 	TaskList             []*InstanceTask     `json:"task_list"`              // 审批任务列表
@@ -140,9 +147,9 @@ type LarkApproval struct {
 
 // InstanceTask 审批任务
 type InstanceTask struct {
-	ID     string `json:"id"`      // 	审批任务 ID
-	UserID string `json:"user_id"` // 审批人的 user_id，自动通过、自动拒绝时该参数返回值为空。
-	OpenID string `json:"open_id"` // 审批人的 open_id，自动通过、自动拒绝时该参数返回值为空。
+	ID     string `json:"id" schema:"required"` // 	审批任务 ID
+	UserID string `json:"user_id"`              // 审批人的 user_id，自动通过、自动拒绝时该参数返回值为空。
+	OpenID string `json:"open_id"`              // 审批人的 open_id，自动通过、自动拒绝时该参数返回值为空。
 	// 审批任务状态
 	//
 	// 可选值有：
@@ -151,7 +158,7 @@ type InstanceTask struct {
 	//  - REJECTED：拒绝
 	//  - TRANSFERRED：已转交
 	//  - DONE：完成
-	Status       string `json:"status"`
+	Status       string `json:"status" schema:"enum=PENDING|APPROVED|REJECTED|TRANSFERRED|DONE"`
 	NodeID       string `json:"node_id"`        // 审批任务所属的审批节点 ID
 	NodeName     string `json:"node_name"`      // 审批任务所属的审批节点名称
 	CustomNodeID string `json:"custom_node_id"` // 审批任务所属的审批节点的自定义 ID。如果没设置自定义 ID，则不返回该参数值。
@@ -199,7 +206,7 @@ type InstanceTimeline struct {
 	//  - CANCEL：撤回。对应的 ext 参数不会返回值。
 	//  - DELETE：删除。对应的 ext 参数不会返回值。
 	//  - CC：抄送。对应的 ext 参数返回的 user_id 包含抄送人的用户 ID。
-	Type                 string            `json:"type"`
+	Type                 string            `json:"type" schema:"required"`
 	CreateTime           string            `json:"create_time"`            // 审批动态发生时间，毫秒级时间戳。
 	UserID               string            `json:"user_id"`                // 产生该动态的用户 user_id
 	OpenID               string            `json:"open_id"`                // 产生该动态的用户 open_id
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// millisPerQuarterHour 15 分钟的毫秒数，飞书时间戳为毫秒
+//
+// 各时区的 UTC 偏移都是 15 分钟的整数倍，同一个 15 分钟区间内的时刻总是属于当地的同一天。
+const millisPerQuarterHour = 15 * 60 * 1000
+
+// NodeDuration 一个审批节点的任务耗时
+type NodeDuration struct {
+	NodeID   string
+	NodeName string
+	Tasks    int64         // 已完成的任务数
+	Avg      time.Duration // 平均耗时
+	Max      time.Duration // 最长耗时
+}
+
+// ApproverDuration 一个审批人的任务耗时
+type ApproverDuration struct {
+	UserID string
+	Tasks  int64 // 已完成的任务数
+	Avg    time.Duration
+	Max    time.Duration
+}
+
+// DailyThroughput 一天内发起和完成的审批实例数
+type DailyThroughput struct {
+	Day      time.Time // 当天零点，时区为 ApprovalAnalytics.Location
+	Started  int64     // 当天发起的实例数
+	Finished int64     // 当天完成（通过或拒绝）的实例数
+	Approved int64
+	Rejected int64
+}
+
+// RejectionRate 一个审批定义的拒绝率
+type RejectionRate struct {
+	ApprovalCode string
+	Total        int64   // 实例总数
+	Finished     int64   // 已完成（通过或拒绝）的实例数
+	Rejected     int64   // 被拒绝的实例数
+	Rate         float64 // Rejected / Finished，没有已完成的实例时为 0
+}
+
+// CCFanOut 一个审批定义的抄送规模
+type CCFanOut struct {
+	ApprovalCode  string
+	Instances     int64   // 有抄送的实例数
+	Recipients    int64   // 抄送人次，同一人被多次抄送时重复计数
+	AvgRecipients float64 // 平均每个有抄送的实例的抄送人次
+	MaxRecipients int64
+}
+
+// ApprovalAnalytics 基于 lark_data 的审批统计
+//
+// 统计在数据库中完成：通过 JSONDialect.ArrayElements 把 task_list、timeline 展开为行后分组聚合，
+// 可在 MySQL 8.0.4+、SQLite 和 PostgreSQL 上运行。各方法的 conds 用于限定参与统计的审批记录，
+// 如 helper.Filter(ApprovalFilter{...})、helper.ApprovalCodeIs(code)，nil 会被忽略；软删除的记录不参与统计。
+//
+// 耗时只统计 start_time 和 end_time 均大于 0 的任务，即已完成的任务。
+type ApprovalAnalytics struct {
+	DB       *gorm.DB
+	Dialect  JSONDialect
+	Location *time.Location // DailyThroughput 按天分组的时区，按每个时刻当时的 UTC 偏移换算，跨夏令时切换也正确，默认 time.Local
+}
+
+// NewApprovalAnalytics 创建审批统计
+func NewApprovalAnalytics(db *gorm.DB) *ApprovalAnalytics {
+	return &ApprovalAnalytics{DB: db, Dialect: JSONDialectOf(db), Location: time.Local}
+}
+
+// NodeDurations 按审批节点统计任务耗时，按平均耗时从长到短排序
+func (a *ApprovalAnalytics) NodeDurations(ctx context.Context, conds ...clause.Expression) ([]NodeDuration, error) {
+	var rows []struct {
+		NodeID    string
+		NodeName  string
+		Tasks     int64
+		AvgMillis float64
+		MaxMillis float64
+	}
+	node := a.Dialect.Extract(a.Dialect.ElementColumn("t"), Path("node_id"))
+	name := a.Dialect.Extract(a.Dialect.ElementColumn("t"), Path("node_name"))
+	duration := a.taskDuration("t")
+	err := a.tasks(ctx, conds).
+		Select("? AS node_id, ? AS node_name, COUNT(*) AS tasks, AVG(?) AS avg_millis, MAX(?) AS max_millis", node, name, duration, duration).
+		Group("node_id, node_name").
+		Order("avg_millis DESC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make([]NodeDuration, 0, len(rows))
+	for _, r := range rows {
+		result = append(result, NodeDuration{
+			NodeID:   r.NodeID,
+			NodeName: r.NodeName,
+			Tasks:    r.Tasks,
+			Avg:      millisToDuration(r.AvgMillis),
+			Max:      millisToDuration(r.MaxMillis),
+		})
+	}
+	return result, nil
+}
+
+// ApproverDurations 按审批人统计任务耗时，按平均耗时从长到短排序，第一个即最慢的审批人
+//
+// 自动通过、自动拒绝的任务没有审批人，不参与统计。
+func (a *ApprovalAnalytics) ApproverDurations(ctx context.Context, conds ...clause.Expression) ([]ApproverDuration, error) {
+	var rows []struct {
+		UserID    string
+		Tasks     int64
+		AvgMillis float64
+		MaxMillis float64
+	}
+	user := a.Dialect.Extract(a.Dialect.ElementColumn("t"), Path("user_id"))
+	duration := a.taskDuration("t")
+	err := a.tasks(ctx, conds).
+		Where("? <> ''", user).
+		Select("? AS user_id, COUNT(*) AS tasks, AVG(?) AS avg_millis, MAX(?) AS max_millis", user, duration, duration).
+		Group("user_id").
+		Order("avg_millis DESC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ApproverDuration, 0, len(rows))
+	for _, r := range rows {
+		result = append(result, ApproverDuration{
+			UserID: r.UserID,
+			Tasks:  r.Tasks,
+			Avg:    millisToDuration(r.AvgMillis),
+			Max:    millisToDuration(r.MaxMillis),
+		})
+	}
+	return result, nil
+}
+
+// DailyThroughput 按天统计发起和完成的实例数，按日期升序
+//
+// 发起按 lark_data.start_time 分组，完成按 lark_data.end_time 分组，只统计状态为 APPROVED、REJECTED 的实例。
+// 数据库中按 UTC 的 15 分钟区间分组，再按 Location 把每个区间归入当地的日期。
+func (a *ApprovalAnalytics) DailyThroughput(ctx context.Context, conds ...clause.Expression) ([]DailyThroughput, error) {
+	loc := a.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	type bucketRow struct {
+		Bucket   float64
+		Count    int64
+		Approved int64
+		Rejected int64
+	}
+	var started, finished []bucketRow
+
+	startBucket := a.quarterHourOf(Path("start_time"))
+	err := a.approvals(ctx, conds).
+		Where("? > 0", a.Dialect.ExtractNumber("lark_data", Path("start_time"))).
+		Select("? AS bucket, COUNT(*) AS count", startBucket).
+		Group("bucket").
+		Scan(&started).Error
+	if err != nil {
+		return nil, err
+	}
+
+	status := a.Dialect.Extract("lark_data", Path("status"))
+	endBucket := a.quarterHourOf(Path("end_time"))
+	err = a.approvals(ctx, conds).
+		Where("? > 0 AND ? IN ?", a.Dialect.ExtractNumber("lark_data", Path("end_time")), status, []string{ApprovalStatusApproved, ApprovalStatusRejected}).
+		Select("? AS bucket, COUNT(*) AS count, SUM(CASE WHEN ? = ? THEN 1 ELSE 0 END) AS approved, SUM(CASE WHEN ? = ? THEN 1 ELSE 0 END) AS rejected",
+			endBucket, status, ApprovalStatusApproved, status, ApprovalStatusRejected).
+		Group("bucket").
+		Scan(&finished).Error
+	if err != nil {
+		return nil, err
+	}
+
+	days := map[time.Time]*DailyThroughput{}
+	get := func(bucket float64) *DailyThroughput {
+		// 按区间开始时刻在 loc 中的日期归类，每个区间使用自己的 UTC 偏移
+		y, m, d := time.UnixMilli(int64(bucket)).In(loc).Date()
+		day := time.Date(y, m, d, 0, 0, 0, 0, loc)
+		t, ok := days[day]
+		if !ok {
+			t = &DailyThroughput{Day: day}
+			days[day] = t
+		}
+		return t
+	}
+	for _, r := range started {
+		get(r.Bucket).Started += r.Count
+	}
+	for _, r := range finished {
+		d := get(r.Bucket)
+		d.Finished += r.Count
+		d.Approved += r.Approved
+		d.Rejected += r.Rejected
+	}
+
+	result := make([]DailyThroughput, 0, len(days))
+	for _, d := range days {
+		result = append(result, *d)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Day.Before(result[j].Day) })
+	return result, nil
+}
+
+// RejectionRates 按审批定义统计拒绝率，按拒绝率从高到低排序
+func (a *ApprovalAnalytics) RejectionRates(ctx context.Context, conds ...clause.Expression) ([]RejectionRate, error) {
+	var rows []RejectionRate
+	status := a.Dialect.Extract("lark_data", Path("status"))
+	err := a.approvals(ctx, conds).
+		Select("approval_code, COUNT(*) AS total, SUM(CASE WHEN ? IN ? THEN 1 ELSE 0 END) AS finished, SUM(CASE WHEN ? = ? THEN 1 ELSE 0 END) AS rejected",
+			status, []string{ApprovalStatusApproved, ApprovalStatusRejected}, status, ApprovalStatusRejected).
+		Group("approval_code").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		if rows[i].Finished > 0 {
+			rows[i].Rate = float64(rows[i].Rejected) / float64(rows[i].Finished)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Rate != rows[j].Rate {
+			return rows[i].Rate > rows[j].Rate
+		}
+		return rows[i].ApprovalCode < rows[j].ApprovalCode
+	})
+	return rows, nil
+}
+
+// CCFanOut 按审批定义统计抄送规模，抄送人来自 timeline 中 type 为 CC 的动态的 user_id_list
+func (a *ApprovalAnalytics) CCFanOut(ctx context.Context, conds ...clause.Expression) ([]CCFanOut, error) {
+	timeline := a.Dialect.ElementColumn("t")
+	perInstance := a.approvals(ctx, conds).
+		Joins("CROSS JOIN ?", a.Dialect.ArrayElements("lark_data", Path("timeline"), "t")).
+		Joins("CROSS JOIN ?", a.Dialect.ArrayElements(timeline, Path("user_id_list"), "u")).
+		Where("? = ?", a.Dialect.Extract(timeline, Path("type")), "CC").
+		Select("approval.approval_code AS approval_code, approval.id AS approval_id, COUNT(*) AS recipients").
+		Group("approval.approval_code, approval.id")
+
+	var rows []struct {
+		ApprovalCode  string
+		Instances     int64
+		Recipients    int64
+		MaxRecipients int64
+	}
+	err := a.DB.WithContext(ctx).
+		Table("(?) AS cc", perInstance).
+		Select("approval_code, COUNT(*) AS instances, SUM(recipients) AS recipients, MAX(recipients) AS max_recipients").
+		Group("approval_code").
+		Order("approval_code").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make([]CCFanOut, 0, len(rows))
+	for _, r := range rows {
+		result = append(result, CCFanOut{
+			ApprovalCode:  r.ApprovalCode,
+			Instances:     r.Instances,
+			Recipients:    r.Recipients,
+			AvgRecipients: float64(r.Recipients) / float64(r.Instances),
+			MaxRecipients: r.MaxRecipients,
+		})
+	}
+	return result, nil
+}
+
+// approvals 参与统计的审批记录
+func (a *ApprovalAnalytics) approvals(ctx context.Context, conds []clause.Expression) *gorm.DB {
+	tx := a.DB.WithContext(ctx).Model(&ApprovalM{})
+	for _, cond := range conds {
+		if cond != nil {
+			tx = tx.Where(cond)
+		}
+	}
+	return tx
+}
+
+// tasks 展开为任务的已完成任务，元素别名为 t
+func (a *ApprovalAnalytics) tasks(ctx context.Context, conds []clause.Expression) *gorm.DB {
+	task := a.Dialect.ElementColumn("t")
+	return a.approvals(ctx, conds).
+		Joins("CROSS JOIN ?", a.Dialect.ArrayElements("lark_data", Path("task_list"), "t")).
+		Where("? > 0 AND ? > 0", a.Dialect.ExtractNumber(task, Path("start_time")), a.Dialect.ExtractNumber(task, Path("end_time")))
+}
+
+// taskDuration 任务耗时的毫秒数
+func (a *ApprovalAnalytics) taskDuration(alias string) clause.Expression {
+	task := a.Dialect.ElementColumn(alias)
+	return clause.Expr{
+		SQL:  "(? - ?)",
+		Vars: []any{a.Dialect.ExtractNumber(task, Path("end_time")), a.Dialect.ExtractNumber(task, Path("start_time"))},
+	}
+}
+
+// quarterHourOf 毫秒时间戳向下取整到 UTC 的 15 分钟，% 在 MySQL、SQLite 和 PostgreSQL 上均可用
+func (a *ApprovalAnalytics) quarterHourOf(path JSONPath) clause.Expression {
+	ms := a.Dialect.ExtractNumber("lark_data", path)
+	return clause.Expr{SQL: "(? - (? % ?))", Vars: []any{ms, ms, millisPerQuarterHour}}
+}
+
+func millisToDuration(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
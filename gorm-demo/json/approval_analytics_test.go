@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"gorm.io/gorm"
+)
+
+// millis 飞书格式的毫秒时间戳
+func millis(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// seedAnalyticsApprovals 写入统计用的审批记录，任务耗时见各任务的注释
+func seedAnalyticsApprovals(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	base := time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) string { return millis(base.Add(d)) }
+	seed := func(instanceID, code string, data LarkApproval) *ApprovalM {
+		a := &ApprovalM{InstanceID: instanceID, ApprovalCode: code, Type: ApprovalTypeLark, LarkData: NewJSONColumn(data)}
+		if err := db.Create(a).Error; err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+
+	seed("a", "c1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusApproved, StartTime: at(0), EndTime: at(3 * time.Hour),
+		TaskList: []*InstanceTask{
+			{ID: "t1", NodeID: "n1", NodeName: "经理", UserID: "u1", StartTime: at(0), EndTime: at(time.Hour)},                              // 1h
+			{ID: "t2", NodeID: "n2", NodeName: "财务", UserID: "u2", StartTime: at(time.Hour), EndTime: at(3 * time.Hour)},                  // 2h
+			{ID: "t3", NodeID: "n2", NodeName: "财务", StartTime: at(3 * time.Hour), EndTime: at(3*time.Hour + 10*time.Minute)},             // 自动通过 10m
+			{ID: "t4", NodeID: "n3", NodeName: "总监", UserID: "u3", StartTime: at(3 * time.Hour), EndTime: "0", Status: TaskStatusPending}, // 未完成
+		},
+		Timeline: []*InstanceTimeline{
+			{Type: "CC", UserIDList: []string{"u5", "u6"}},
+			{Type: "CC", UserIDList: []string{"u5"}},
+			{Type: "PASS", UserIDList: []string{"u7"}},
+		}})
+	seed("b", "c1", LarkApproval{ApprovalName: "b", Status: ApprovalStatusRejected, StartTime: at(0), EndTime: at(5 * time.Hour),
+		TaskList: []*InstanceTask{
+			{ID: "t1", NodeID: "n1", NodeName: "经理", UserID: "u1", StartTime: at(0), EndTime: at(5 * time.Hour)}, // 5h
+		}})
+	seed("c", "c2", LarkApproval{ApprovalName: "c", Status: ApprovalStatusPending, StartTime: at(0),
+		Timeline: []*InstanceTimeline{{Type: "CC", UserIDList: []string{"u8"}}}})
+	// 软删除的记录不参与统计
+	deleted := seed("d", "c2", LarkApproval{ApprovalName: "d", Status: ApprovalStatusRejected, StartTime: at(0), EndTime: at(time.Hour),
+		TaskList: []*InstanceTask{{ID: "t1", NodeID: "n1", NodeName: "经理", UserID: "u9", StartTime: at(0), EndTime: at(9 * time.Hour)}},
+		Timeline: []*InstanceTimeline{{Type: "CC", UserIDList: []string{"u9"}}}})
+	if err := db.Delete(deleted).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApprovalAnalyticsDurations(t *testing.T) {
+	db := openTestDB(t)
+	seedAnalyticsApprovals(t, db)
+	a := NewApprovalAnalytics(db)
+	ctx := context.Background()
+
+	nodes, err := a.NodeDurations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantNodes := []NodeDuration{
+		{NodeID: "n1", NodeName: "经理", Tasks: 2, Avg: 3 * time.Hour, Max: 5 * time.Hour},
+		{NodeID: "n2", NodeName: "财务", Tasks: 2, Avg: 65 * time.Minute, Max: 2 * time.Hour},
+	}
+	if !slices.Equal(nodes, wantNodes) {
+		t.Errorf("nodes = %+v, want %+v", nodes, wantNodes)
+	}
+
+	approvers, err := a.ApproverDurations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantApprovers := []ApproverDuration{
+		{UserID: "u1", Tasks: 2, Avg: 3 * time.Hour, Max: 5 * time.Hour},
+		{UserID: "u2", Tasks: 1, Avg: 2 * time.Hour, Max: 2 * time.Hour},
+	}
+	if !slices.Equal(approvers, wantApprovers) {
+		t.Errorf("approvers = %+v, want %+v", approvers, wantApprovers)
+	}
+
+	// conds 限定参与统计的记录，nil 被忽略
+	h := NewJSONQueryHelper(db)
+	approvers, err = a.ApproverDurations(ctx, h.ApprovalNameIs("a"), nil)
+	if err != nil || len(approvers) != 2 || approvers[0].UserID != "u2" || approvers[1].Max != time.Hour {
+		t.Errorf("approvers of a = %+v, %v", approvers, err)
+	}
+}
+
+func TestApprovalAnalyticsRates(t *testing.T) {
+	db := openTestDB(t)
+	seedAnalyticsApprovals(t, db)
+	a := NewApprovalAnalytics(db)
+	ctx := context.Background()
+
+	rates, err := a.RejectionRates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRates := []RejectionRate{
+		{ApprovalCode: "c1", Total: 2, Finished: 2, Rejected: 1, Rate: 0.5},
+		{ApprovalCode: "c2", Total: 1},
+	}
+	if !slices.Equal(rates, wantRates) {
+		t.Errorf("rates = %+v, want %+v", rates, wantRates)
+	}
+
+	cc, err := a.CCFanOut(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCC := []CCFanOut{
+		{ApprovalCode: "c1", Instances: 1, Recipients: 3, AvgRecipients: 3, MaxRecipients: 3},
+		{ApprovalCode: "c2", Instances: 1, Recipients: 1, AvgRecipients: 1, MaxRecipients: 1},
+	}
+	if !slices.Equal(cc, wantCC) {
+		t.Errorf("cc = %+v, want %+v", cc, wantCC)
+	}
+	if cc, err := a.CCFanOut(ctx, NewJSONQueryHelper(db).ApprovalCodeIs("c2")); err != nil || len(cc) != 1 || cc[0].ApprovalCode != "c2" {
+		t.Errorf("cc of c2 = %+v, %v", cc, err)
+	}
+}
+
+func TestDailyThroughputAcrossDST(t *testing.T) {
+	db := openTestDB(t)
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2024-03-10 02:00 纽约进入夏令时，UTC 偏移从 -5 变为 -4；两侧各有一个实例靠近当地零点，
+	// 按任何单一偏移分组都会把其中一个归错日期
+	seed := func(id, status string, start, end time.Time) {
+		data := LarkApproval{ApprovalName: id, Status: status, StartTime: millis(start), EndTime: "0"}
+		if !end.IsZero() {
+			data.EndTime = millis(end)
+		}
+		if err := db.Create(&ApprovalM{InstanceID: id, ApprovalCode: "c", Type: ApprovalTypeLark, LarkData: NewJSONColumn(data)}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	seed("before", ApprovalStatusApproved, time.Date(2024, 3, 9, 23, 30, 0, 0, ny), time.Date(2024, 3, 10, 1, 30, 0, 0, ny))
+	seed("after", ApprovalStatusRejected, time.Date(2024, 3, 11, 0, 30, 0, 0, ny), time.Date(2024, 3, 11, 1, 0, 0, 0, ny))
+	seed("pending", ApprovalStatusPending, time.Date(2024, 3, 11, 12, 0, 0, 0, ny), time.Time{})
+
+	a := NewApprovalAnalytics(db)
+	a.Location = ny
+	days, err := a.DailyThroughput(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DailyThroughput{
+		{Day: time.Date(2024, 3, 9, 0, 0, 0, 0, ny), Started: 1},
+		{Day: time.Date(2024, 3, 10, 0, 0, 0, 0, ny), Finished: 1, Approved: 1},
+		{Day: time.Date(2024, 3, 11, 0, 0, 0, 0, ny), Started: 2, Finished: 1, Rejected: 1},
+	}
+	if len(days) != len(want) {
+		t.Fatalf("days = %+v, want %+v", days, want)
+	}
+	for i, d := range days {
+		w := want[i]
+		if !d.Day.Equal(w.Day) || d.Day.Location() != ny || d.Started != w.Started || d.Finished != w.Finished ||
+			d.Approved != w.Approved || d.Rejected != w.Rejected {
+			t.Errorf("day %d = %+v, want %+v", i, d, w)
+		}
+	}
+
+	// UTC 分组时两个实例落在 3 月 10 日和 11 日
+	a.Location = time.UTC
+	days, err = a.DailyThroughput(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var started []string
+	for _, d := range days {
+		if d.Started > 0 {
+			started = append(started, d.Day.Format(time.DateOnly))
+		}
+	}
+	if !slices.Equal(started, []string{"2024-03-10", "2024-03-11"}) {
+		t.Errorf("started days in UTC = %v", started)
+	}
+}
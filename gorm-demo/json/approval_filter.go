@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// TimeRange 时间范围 [From, To)，零值表示该端不限
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// TaskMatch task_list 中的任务需要同时满足的条件，零值字段不参与过滤
+type TaskMatch struct {
+	UserID    string
+	Status    string
+	NodeID    string
+	StartTime TimeRange
+	EndTime   TimeRange
+}
+
+// TimelineMatch timeline 中的审批动态需要同时满足的条件，零值字段不参与过滤
+type TimelineMatch struct {
+	Type       string
+	UserID     string
+	CreateTime TimeRange
+}
+
+// ApprovalFilter 可组合的审批查询条件
+//
+// 零值字段不参与过滤，同一个 ApprovalFilter 中的条件按 AND 组合；
+// And 中的每个子条件都需要满足，Or 非空时至少满足其中一个。
+// LarkData 中的 start_time、end_time 等毫秒时间戳按数字比较。
+//
+//	helper.Filter(ApprovalFilter{
+//		ApprovalCode: code,
+//		Statuses:     []string{ApprovalStatusPending, ApprovalStatusApproved},
+//		StartTime:    TimeRange{From: monthStart},
+//		Or: []ApprovalFilter{
+//			{ApprovalNameLike: "%报销%"},
+//			{Task: &TaskMatch{UserID: "zhangsan", Status: ApprovalStatusPending}},
+//		},
+//	})
+type ApprovalFilter struct {
+	ApprovalCode     string
+	InstanceIDs      []string
+	ApprovalName     string
+	ApprovalNameLike string         // LIKE 语法，如 %zhangsan%
+	Statuses         []string       // 审批状态为其中之一
+	UserID           string         // 发起人 user_id
+	StartTime        TimeRange      // lark_data.start_time
+	EndTime          TimeRange      // lark_data.end_time
+	CreatedAt        TimeRange      // created_at 列
+	Task             *TaskMatch     // task_list 中存在满足全部条件的任务，所有字段为零值时表示 task_list 非空
+	Timeline         *TimelineMatch // timeline 中存在满足全部条件的审批动态，所有字段为零值时表示 timeline 非空
+
+	// Form 表单控件值，每个条件都需要满足。数据库中查询 FormProjectionPlugin 维护的 approval_form_field，
+	// 没有投影的控件查不到；Match 直接解析 lark_data.form，不受投影配置影响
+	Form []FormFieldMatch
+
+	And []ApprovalFilter
+	Or  []ApprovalFilter
+}
+
+// ApprovalSortField 排序字段
+type ApprovalSortField string
+
+const (
+	SortByID           ApprovalSortField = "id"
+	SortByCreatedAt    ApprovalSortField = "created_at"
+	SortByUpdatedAt    ApprovalSortField = "updated_at"
+	SortByStartTime    ApprovalSortField = "start_time"    // lark_data.start_time，按数字排序
+	SortByEndTime      ApprovalSortField = "end_time"      // lark_data.end_time，按数字排序
+	SortByApprovalName ApprovalSortField = "approval_name" // lark_data.approval_name
+)
+
+// ApprovalSort 一个排序条件
+type ApprovalSort struct {
+	Field ApprovalSortField
+	Desc  bool
+}
+
+// ApprovalQuery 查询条件、排序和分页
+type ApprovalQuery struct {
+	Filter ApprovalFilter
+	Sort   []ApprovalSort // 最后总会追加 id 作为排序条件，保证结果顺序稳定
+	Limit  int            // 为 0 时不限制
+	Offset int
+}
+
+// Search 按 ApprovalQuery 查询，所有条件编译为一条 SQL
+//
+// 结果集较大时应使用 FindPage 或 Stream，并将 Filter 的结果作为查询条件传入。
+func (h *JSONQueryHelper) Search(ctx context.Context, q ApprovalQuery) ([]*ApprovalM, error) {
+	tx := h.DB.WithContext(ctx)
+	if cond := h.Filter(q.Filter); cond != nil {
+		tx = tx.Where(cond)
+	}
+	order, err := h.orderBy(q.Sort)
+	if err != nil {
+		return nil, err
+	}
+	tx = tx.Order(order)
+	if q.Limit > 0 {
+		tx = tx.Limit(q.Limit)
+	}
+	if q.Offset > 0 {
+		tx = tx.Offset(q.Offset)
+	}
+
+	var approvals []*ApprovalM
+	err = tx.Find(&approvals).Error
+	return approvals, err
+}
+
+// Filter 将 ApprovalFilter 编译为查询条件，没有任何条件时返回 nil
+func (h *JSONQueryHelper) Filter(f ApprovalFilter) clause.Expression {
+	var exprs []clause.Expression
+	if f.ApprovalCode != "" {
+		exprs = append(exprs, h.ApprovalCodeIs(f.ApprovalCode))
+	}
+	if len(f.InstanceIDs) > 0 {
+		exprs = append(exprs, clause.IN{Column: clause.Column{Table: clause.CurrentTable, Name: "instance_id"}, Values: anySlice(f.InstanceIDs)})
+	}
+	if f.ApprovalName != "" {
+		exprs = append(exprs, h.ApprovalNameIs(f.ApprovalName))
+	}
+	if f.ApprovalNameLike != "" {
+		exprs = append(exprs, h.ApprovalNameLike(f.ApprovalNameLike))
+	}
+	switch len(f.Statuses) {
+	case 0:
+	case 1:
+		exprs = append(exprs, h.StatusIs(f.Statuses[0]))
+	default:
+		exprs = append(exprs, h.pathIn("lark_data", Path("status"), f.Statuses))
+	}
+	if f.UserID != "" {
+		exprs = append(exprs, h.pathEquals("lark_data", Path("user_id"), f.UserID))
+	}
+	exprs = appendRange(exprs, h.Dialect.ExtractNumber("lark_data", Path("start_time")), f.StartTime, true)
+	exprs = appendRange(exprs, h.Dialect.ExtractNumber("lark_data", Path("end_time")), f.EndTime, true)
+	exprs = appendRange(exprs, clause.Column{Table: clause.CurrentTable, Name: "created_at"}, f.CreatedAt, false)
+	if f.Task != nil {
+		exprs = append(exprs, h.Dialect.ArrayAnyMatch("lark_data", Path("task_list"), f.Task.conditions()))
+	}
+	if f.Timeline != nil {
+		exprs = append(exprs, h.Dialect.ArrayAnyMatch("lark_data", Path("timeline"), f.Timeline.conditions()))
+	}
+	for _, m := range f.Form {
+		exprs = append(exprs, m.expression())
+	}
+
+	for _, sub := range f.And {
+		if cond := h.Filter(sub); cond != nil {
+			exprs = append(exprs, cond)
+		}
+	}
+	if len(f.Or) > 0 {
+		ors := make([]clause.Expression, 0, len(f.Or))
+		for _, sub := range f.Or {
+			cond := h.Filter(sub)
+			if cond == nil {
+				// 空条件恒为真，整个 OR 分组不再需要过滤
+				ors = nil
+				break
+			}
+			ors = append(ors, cond)
+		}
+		if len(ors) > 0 {
+			exprs = append(exprs, clause.Or(ors...))
+		}
+	}
+
+	if len(exprs) == 0 {
+		return nil
+	}
+	return clause.And(exprs...)
+}
+
+// Match 在应用层判断记录是否满足条件，与 Filter 编译出的 SQL 语义一致，供 MemoryApprovalRepository 使用
+//
+// 字符串按字节比较，ApprovalNameLike 区分大小写；数据库中还取决于排序规则，MySQL、SQLite 的 LIKE 默认不区分 ASCII 大小写。
+func (f ApprovalFilter) Match(a *ApprovalM) (bool, error) {
+	data, err := a.LarkData.Bytes()
+	if err != nil {
+		return false, err
+	}
+	doc, err := decodeJSONDocument(data)
+	if err != nil {
+		return false, err
+	}
+	return f.match(a, doc)
+}
+
+// match 以解码后的 lark_data 求值，And/Or 中的子条件共用同一份文档
+func (f ApprovalFilter) match(a *ApprovalM, doc any) (bool, error) {
+	text := func(key string) (string, bool) {
+		return jsonScalarText(lookupJSONPath(doc, Path(key)))
+	}
+	if f.ApprovalCode != "" && a.ApprovalCode != f.ApprovalCode {
+		return false, nil
+	}
+	if len(f.InstanceIDs) > 0 && !slices.Contains(f.InstanceIDs, a.InstanceID) {
+		return false, nil
+	}
+	if f.ApprovalName != "" {
+		if name, ok := text("approval_name"); !ok || name != f.ApprovalName {
+			return false, nil
+		}
+	}
+	if f.ApprovalNameLike != "" {
+		if name, ok := text("approval_name"); !ok || !likeMatch(name, f.ApprovalNameLike) {
+			return false, nil
+		}
+	}
+	if len(f.Statuses) > 0 {
+		if status, ok := text("status"); !ok || !slices.Contains(f.Statuses, status) {
+			return false, nil
+		}
+	}
+	if f.UserID != "" {
+		if userID, ok := text("user_id"); !ok || userID != f.UserID {
+			return false, nil
+		}
+	}
+	if !millisInRange(lookupJSONPath(doc, Path("start_time")), f.StartTime) ||
+		!millisInRange(lookupJSONPath(doc, Path("end_time")), f.EndTime) ||
+		!timeInRange(a.CreatedAt, f.CreatedAt) {
+		return false, nil
+	}
+	if f.Task != nil {
+		if ok, err := arrayAnyMatchValue(lookupJSONPath(doc, Path("task_list")), f.Task.conditions()); !ok || err != nil {
+			return false, err
+		}
+	}
+	if f.Timeline != nil {
+		if ok, err := arrayAnyMatchValue(lookupJSONPath(doc, Path("timeline")), f.Timeline.conditions()); !ok || err != nil {
+			return false, err
+		}
+	}
+	if len(f.Form) > 0 {
+		// 与投影一致，解析失败的控件没有值，不影响其他控件
+		formText, _ := text("form")
+		form, _ := ParseLarkForm(formText)
+		for _, m := range f.Form {
+			if !m.matchForm(form) {
+				return false, nil
+			}
+		}
+	}
+
+	for _, sub := range f.And {
+		if ok, err := sub.match(a, doc); !ok || err != nil {
+			return false, err
+		}
+	}
+	if len(f.Or) == 0 {
+		return true, nil
+	}
+	for _, sub := range f.Or {
+		if ok, err := sub.match(a, doc); ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// pathIn 路径的值为 values 之一，路径已建索引时使用索引列
+func (h *JSONQueryHelper) pathIn(column string, path JSONPath, values []string) clause.Expression {
+	var extract any = h.Dialect.Extract(column, path)
+	if idx, ok := h.Indexes.Lookup(column, path); ok {
+		extract = idx.Expr()
+	}
+	return clause.Expr{SQL: "? IN ?", Vars: []any{extract, values}}
+}
+
+// orderBy 将排序条件转换为 ORDER BY 表达式，最后追加 id
+//
+// 多次调用 Order 传入 clause.OrderBy 表达式时后者会覆盖前者，因此拼接为一个表达式。
+func (h *JSONQueryHelper) orderBy(sorts []ApprovalSort) (clause.OrderBy, error) {
+	sorts = append(sorts[:len(sorts):len(sorts)], ApprovalSort{Field: SortByID})
+	items := make([]string, 0, len(sorts))
+	vars := make([]any, 0, len(sorts))
+	for _, s := range sorts {
+		var target any
+		switch s.Field {
+		case SortByID, SortByCreatedAt, SortByUpdatedAt:
+			target = clause.Column{Table: clause.CurrentTable, Name: string(s.Field)}
+		case SortByStartTime, SortByEndTime:
+			target = h.Dialect.ExtractNumber("lark_data", Path(string(s.Field)))
+		case SortByApprovalName:
+			target = h.Dialect.Extract("lark_data", Path("approval_name"))
+		default:
+			return clause.OrderBy{}, fmt.Errorf("unsupported sort field %q", s.Field)
+		}
+		if s.Desc {
+			items = append(items, "? DESC")
+		} else {
+			items = append(items, "? ASC")
+		}
+		vars = append(vars, target)
+	}
+	return clause.OrderBy{Expression: clause.Expr{SQL: strings.Join(items, ", "), Vars: vars}}, nil
+}
+
+func (m TaskMatch) conditions() []JSONElementCondition {
+	var conds []JSONElementCondition
+	conds = appendElementEquals(conds, "user_id", m.UserID)
+	conds = appendElementEquals(conds, "status", m.Status)
+	conds = appendElementEquals(conds, "node_id", m.NodeID)
+	conds = appendElementRange(conds, "start_time", m.StartTime)
+	conds = appendElementRange(conds, "end_time", m.EndTime)
+	return conds
+}
+
+func (m TimelineMatch) conditions() []JSONElementCondition {
+	var conds []JSONElementCondition
+	conds = appendElementEquals(conds, "type", m.Type)
+	conds = appendElementEquals(conds, "user_id", m.UserID)
+	conds = appendElementRange(conds, "create_time", m.CreateTime)
+	return conds
+}
+
+func appendElementEquals(conds []JSONElementCondition, key, value string) []JSONElementCondition {
+	if value == "" {
+		return conds
+	}
+	return append(conds, JSONElementCondition{Path: Path(key), Op: "=", Value: value})
+}
+
+// appendElementRange 元素中的毫秒时间戳落在 r 内
+func appendElementRange(conds []JSONElementCondition, key string, r TimeRange) []JSONElementCondition {
+	if !r.From.IsZero() {
+		conds = append(conds, JSONElementCondition{Path: Path(key), Op: ">=", Value: r.From.UnixMilli(), Numeric: true})
+	}
+	if !r.To.IsZero() {
+		conds = append(conds, JSONElementCondition{Path: Path(key), Op: "<", Value: r.To.UnixMilli(), Numeric: true})
+	}
+	return conds
+}
+
+// appendRange target 落在 r 内，millis 为 true 时 target 是毫秒时间戳
+func appendRange(exprs []clause.Expression, target any, r TimeRange, millis bool) []clause.Expression {
+	bound := func(t time.Time) any {
+		if millis {
+			return t.UnixMilli()
+		}
+		return t
+	}
+	if !r.From.IsZero() {
+		exprs = append(exprs, clause.Expr{SQL: "? >= ?", Vars: []any{target, bound(r.From)}})
+	}
+	if !r.To.IsZero() {
+		exprs = append(exprs, clause.Expr{SQL: "? < ?", Vars: []any{target, bound(r.To)}})
+	}
+	return exprs
+}
+
+// millisInRange 毫秒时间戳 v 落在 r 内，r 不限时总是成立，v 不是数字时视为 NULL
+func millisInRange(v any, r TimeRange) bool {
+	if r.From.IsZero() && r.To.IsZero() {
+		return true
+	}
+	n, ok := jsonScalarNumber(v)
+	if !ok {
+		return false
+	}
+	return (r.From.IsZero() || n >= float64(r.From.UnixMilli())) && (r.To.IsZero() || n < float64(r.To.UnixMilli()))
+}
+
+// timeInRange t 落在 r 内
+func timeInRange(t time.Time, r TimeRange) bool {
+	return (r.From.IsZero() || !t.Before(r.From)) && (r.To.IsZero() || t.Before(r.To))
+}
+
+func anySlice[T any](values []T) []any {
+	result := make([]any, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestApprovalFilter(t *testing.T) {
+	h := seedDialectApprovals(t)
+	var all []*ApprovalM
+	if err := h.DB.Order("id").Find(&all).Error; err != nil {
+		t.Fatal(err)
+	}
+	ms := time.UnixMilli
+
+	tests := []struct {
+		name   string
+		filter ApprovalFilter
+		want   []string
+	}{
+		{"empty", ApprovalFilter{}, []string{"i1", "i2", "i3"}},
+		{"status", ApprovalFilter{Statuses: []string{ApprovalStatusApproved}}, []string{"i2"}},
+		{"status IN", ApprovalFilter{Statuses: []string{ApprovalStatusPending, ApprovalStatusRejected}}, []string{"i1", "i3"}},
+		{"instance_id IN", ApprovalFilter{InstanceIDs: []string{"i3", "i1", "missing"}}, []string{"i1", "i3"}},
+		{"name like", ApprovalFilter{ApprovalNameLike: "%申请"}, []string{"i2"}},
+		{"start_time from", ApprovalFilter{StartTime: TimeRange{From: ms(1700000100000)}}, []string{"i2"}},
+		{"start_time half open", ApprovalFilter{StartTime: TimeRange{From: ms(1700000000000), To: ms(1700000200000)}}, []string{"i1"}},
+		// i1 的 end_time 为空字符串，按 NULL 处理，不满足任何范围
+		{"end_time empty string", ApprovalFilter{EndTime: TimeRange{From: ms(0)}}, []string{"i2"}},
+		{"end_time to", ApprovalFilter{EndTime: TimeRange{To: ms(1800000000000)}}, []string{"i2"}},
+		{"task any", ApprovalFilter{Task: &TaskMatch{}}, []string{"i1", "i2"}},
+		{"task same element", ApprovalFilter{Task: &TaskMatch{UserID: "lisi", Status: ApprovalStatusApproved}}, []string{"i2"}},
+		{"task across elements", ApprovalFilter{Task: &TaskMatch{UserID: "zhangsan", Status: ApprovalStatusPending}}, nil},
+		{"task start_time", ApprovalFilter{Task: &TaskMatch{StartTime: TimeRange{From: ms(1700000100000)}}}, []string{"i1", "i2"}},
+		{"and", ApprovalFilter{And: []ApprovalFilter{
+			{Statuses: []string{ApprovalStatusPending, ApprovalStatusApproved}},
+			{Task: &TaskMatch{UserID: "zhangsan"}},
+		}}, []string{"i1"}},
+		{"or", ApprovalFilter{Or: []ApprovalFilter{
+			{ApprovalNameLike: "%假%"},
+			{Task: &TaskMatch{UserID: "zhangsan"}},
+		}}, []string{"i1", "i3"}},
+		{"or with empty", ApprovalFilter{Statuses: []string{ApprovalStatusApproved, ApprovalStatusRejected}, Or: []ApprovalFilter{
+			{ApprovalName: "请假"},
+			{},
+		}}, []string{"i2", "i3"}},
+		{"and with or", ApprovalFilter{ApprovalCode: "code", Or: []ApprovalFilter{
+			{Statuses: []string{ApprovalStatusRejected}},
+			{And: []ApprovalFilter{
+				{StartTime: TimeRange{From: ms(1700000000000)}},
+				{EndTime: TimeRange{From: ms(0)}},
+			}},
+		}}, []string{"i2", "i3"}},
+		{"no match", ApprovalFilter{ApprovalCode: "other"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conds []any
+			if cond := h.Filter(tt.filter); cond != nil {
+				conds = append(conds, cond)
+			}
+			if got := instanceIDs(t, h.DB, conds...); !slices.Equal(got, tt.want) {
+				t.Errorf("Filter: got %v, want %v", got, tt.want)
+			}
+
+			var matched []string
+			for _, a := range all {
+				ok, err := tt.filter.Match(a)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok {
+					matched = append(matched, a.InstanceID)
+				}
+			}
+			if !slices.Equal(matched, tt.want) {
+				t.Errorf("Match: got %v, want %v", matched, tt.want)
+			}
+		})
+	}
+}
+
+func TestApprovalSearchSortByNumber(t *testing.T) {
+	h := seedDialectApprovals(t)
+	approvals, err := h.Search(t.Context(), ApprovalQuery{
+		Filter: ApprovalFilter{Task: &TaskMatch{}},
+		Sort:   []ApprovalSort{{Field: SortByStartTime, Desc: true}},
+		Limit:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(approvals) != 1 || approvals[0].InstanceID != "i2" {
+		t.Errorf("got %v, want [i2]", approvals)
+	}
+}
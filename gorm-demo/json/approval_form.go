@@ -0,0 +1,872 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 飞书表单控件类型，radioV2、checkboxV2、attachmentV2 等带 V2 后缀的类型按去掉后缀后的类型解析
+//   - https://open.feishu.cn/document/server-docs/approval-v4/instance/approval-instance-form-control-parameters
+const (
+	FormControlInput        = "input"
+	FormControlTextarea     = "textarea"
+	FormControlNumber       = "number"
+	FormControlAmount       = "amount"
+	FormControlDate         = "date"
+	FormControlDateInterval = "dateInterval"
+	FormControlRadio        = "radio"
+	FormControlCheckbox     = "checkbox"
+	FormControlAttachment   = "attachment"
+	FormControlContact      = "contact"
+	FormControlDepartment   = "department"
+	FormControlFieldList    = "fieldList"
+)
+
+// FormTextValueMaxLength approval_form_field.text_value 的最大字符数，超出部分截断
+const FormTextValueMaxLength = 512
+
+var (
+	// ErrInvalidFormValue 控件值与控件类型不符
+	ErrInvalidFormValue = errors.New("invalid form control value")
+	// ErrFormProjectionNotConfigured 没有注册 FormProjectionPlugin
+	ErrFormProjectionNotConfigured = errors.New("form projection is not configured")
+)
+
+// FormControlError 单个控件解析失败
+type FormControlError struct {
+	ID   string
+	Name string
+	Type string
+	Err  error
+}
+
+func (e *FormControlError) Error() string {
+	return fmt.Sprintf("form control %s(%s) of type %s: %v", e.Name, e.ID, e.Type, e.Err)
+}
+
+func (e *FormControlError) Unwrap() error {
+	return e.Err
+}
+
+// FormAmount 金额控件的值
+type FormAmount struct {
+	Value    float64
+	Currency string // 币种，如 CNY，表单中没有时为空
+}
+
+// FormDateInterval 日期区间控件的值
+type FormDateInterval struct {
+	Start    time.Time
+	End      time.Time
+	Interval float64 // 时长（天）
+}
+
+// FormOption 单选、多选控件选中的选项
+type FormOption struct {
+	Key  string
+	Text string
+}
+
+// FormFile 附件
+type FormFile struct {
+	Name string
+	URL  string
+}
+
+// FormUser 联系人控件中的用户
+type FormUser struct {
+	UserID string
+	OpenID string
+	Name   string
+}
+
+// FormDepartment 部门控件中的部门
+type FormDepartment struct {
+	OpenID string
+	Name   string
+}
+
+// FormControl 表单中的一个控件
+//
+// Value 按控件类型解析：
+//   - input、textarea：string
+//   - number：float64
+//   - amount：FormAmount
+//   - date：time.Time
+//   - dateInterval：FormDateInterval
+//   - radio：FormOption
+//   - checkbox：[]FormOption
+//   - attachment：[]FormFile
+//   - contact：[]FormUser
+//   - department：[]FormDepartment
+//   - fieldList：[]LarkForm，每行一个表单
+//   - 其他类型：value 按 JSON 解码后的值（string、float64、[]any、map[string]any 等）
+//
+// 没有填写（value 为 null 或空字符串）或解析失败时 Value 为 nil，原始 JSON 保存在 Raw 中。
+type FormControl struct {
+	ID       string
+	CustomID string
+	Name     string
+	Type     string // 表单中的原始类型，如 radioV2
+	Value    any
+	Raw      json.RawMessage
+}
+
+// Kind 去掉 V2 后缀后的控件类型
+func (c *FormControl) Kind() string {
+	return strings.TrimSuffix(c.Type, "V2")
+}
+
+// LarkForm 解析后的审批表单，控件顺序与表单一致
+type LarkForm []*FormControl
+
+// Field 按 custom_id、id、名称依次查找顶层控件，不查找明细中的控件，没有时返回 nil
+func (f LarkForm) Field(key string) *FormControl {
+	for _, match := range []func(c *FormControl) bool{
+		func(c *FormControl) bool { return c.CustomID == key },
+		func(c *FormControl) bool { return c.ID == key },
+		func(c *FormControl) bool { return c.Name == key },
+	} {
+		for _, c := range f {
+			if match(c) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// walk 依次访问所有控件，明细中的控件紧跟在明细控件之后，parent 为所在的明细控件，row 为行号
+func (f LarkForm) walk(fn func(c, parent *FormControl, row int)) {
+	var visit func(form LarkForm, parent *FormControl, row int)
+	visit = func(form LarkForm, parent *FormControl, row int) {
+		for _, c := range form {
+			fn(c, parent, row)
+			rows, _ := c.Value.([]LarkForm)
+			for i, r := range rows {
+				visit(r, c, i)
+			}
+		}
+	}
+	visit(f, nil, 0)
+}
+
+// ParseForm 解析 Form 中的表单控件，见 ParseLarkForm
+func (a LarkApproval) ParseForm() (LarkForm, error) {
+	return ParseLarkForm(a.Form)
+}
+
+// ParseLarkForm 解析 LarkApproval.Form 中的表单控件
+//
+// 控件值按类型宽松解析：数字可以是字符串，日期可以是 RFC 3339、"2006-01-02 15:04:05"、"2006-01-02" 或毫秒时间戳。
+// 个别控件解析失败时仍返回完整的表单，失败控件的 Value 为 nil，错误为各控件 *FormControlError 的合并；
+// form 不是控件数组时返回 nil 和错误。form 为空时返回 nil, nil。
+//
+//	form, err := approval.ParseForm()
+//	if amount, ok := form.Field("报销金额").Value.(FormAmount); ok { ... }
+func ParseLarkForm(form string) (LarkForm, error) {
+	if strings.TrimSpace(form) == "" {
+		return nil, nil
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal([]byte(form), &items); err != nil {
+		return nil, fmt.Errorf("parse lark form: %w", err)
+	}
+	return parseFormControls(items)
+}
+
+// larkFormItem 表单中一个控件的 JSON 结构
+type larkFormItem struct {
+	ID       string          `json:"id"`
+	CustomID string          `json:"custom_id"`
+	Name     string          `json:"name"`
+	Type     string          `json:"type"`
+	Value    json.RawMessage `json:"value"`
+	Option   json.RawMessage `json:"option"`
+	Ext      json.RawMessage `json:"ext"`
+	OpenIDs  []string        `json:"open_ids"`
+	Currency string          `json:"currency"`
+}
+
+func parseFormControls(items []json.RawMessage) (LarkForm, error) {
+	form := make(LarkForm, 0, len(items))
+	var errs []error
+	for _, raw := range items {
+		var item larkFormItem
+		if err := json.Unmarshal(raw, &item); err != nil {
+			errs = append(errs, &FormControlError{Err: fmt.Errorf("%w: %v", ErrInvalidFormValue, err)})
+			continue
+		}
+		c := &FormControl{ID: item.ID, CustomID: item.CustomID, Name: item.Name, Type: item.Type, Raw: raw}
+		value, err := item.decode(c.Kind())
+		if err != nil {
+			errs = append(errs, &FormControlError{ID: item.ID, Name: item.Name, Type: item.Type, Err: err})
+		}
+		c.Value = value
+		form = append(form, c)
+	}
+	return form, errors.Join(errs...)
+}
+
+// decode 按控件类型解析 value，fieldList 中子控件的错误与已解析的行一起返回
+func (item larkFormItem) decode(kind string) (any, error) {
+	if isEmptyFormValue(item.Value) {
+		return nil, nil
+	}
+	switch kind {
+	case FormControlInput, FormControlTextarea:
+		var s string
+		if err := json.Unmarshal(item.Value, &s); err != nil {
+			return nil, invalidFormValue("string", item.Value)
+		}
+		return s, nil
+	case FormControlNumber:
+		n, err := formNumber(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		return n, nil
+	case FormControlAmount:
+		n, err := formNumber(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		currency := item.Currency
+		if currency == "" {
+			// 部分版本的金额控件在 option 中给出币种
+			_ = json.Unmarshal(item.Option, &currency)
+		}
+		return FormAmount{Value: n, Currency: currency}, nil
+	case FormControlDate:
+		t, err := formTime(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	case FormControlDateInterval:
+		var v struct {
+			Start    json.RawMessage `json:"start"`
+			End      json.RawMessage `json:"end"`
+			Interval json.RawMessage `json:"interval"`
+		}
+		if err := json.Unmarshal(item.Value, &v); err != nil {
+			return nil, invalidFormValue("date interval", item.Value)
+		}
+		var (
+			result FormDateInterval
+			err    error
+		)
+		if result.Start, err = formTime(v.Start); err != nil {
+			return nil, err
+		}
+		if result.End, err = formTime(v.End); err != nil {
+			return nil, err
+		}
+		if !isEmptyFormValue(v.Interval) {
+			if result.Interval, err = formNumber(v.Interval); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	case FormControlRadio:
+		texts, err := formStrings(item.Value)
+		if err != nil || len(texts) != 1 {
+			return nil, invalidFormValue("string", item.Value)
+		}
+		return formOptions(texts, item.Option)[0], nil
+	case FormControlCheckbox:
+		texts, err := formStrings(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		return formOptions(texts, item.Option), nil
+	case FormControlAttachment:
+		return formFiles(item.Value, item.Ext)
+	case FormControlContact:
+		return formUsers(item.Value, item.OpenIDs)
+	case FormControlDepartment:
+		return formDepartments(item.Value)
+	case FormControlFieldList:
+		var rows [][]json.RawMessage
+		if err := json.Unmarshal(item.Value, &rows); err != nil {
+			return nil, invalidFormValue("rows of controls", item.Value)
+		}
+		result := make([]LarkForm, 0, len(rows))
+		var errs []error
+		for _, row := range rows {
+			form, err := parseFormControls(row)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			result = append(result, form)
+		}
+		return result, errors.Join(errs...)
+	default:
+		var v any
+		if err := json.Unmarshal(item.Value, &v); err != nil {
+			return nil, invalidFormValue("JSON", item.Value)
+		}
+		return v, nil
+	}
+}
+
+func invalidFormValue(expected string, raw json.RawMessage) error {
+	return fmt.Errorf("%w: expected %s, got %s", ErrInvalidFormValue, expected, raw)
+}
+
+// isEmptyFormValue 未填写的控件值为 null 或空字符串
+func isEmptyFormValue(raw json.RawMessage) bool {
+	raw = bytes.TrimSpace(raw)
+	return len(raw) == 0 || string(raw) == "null" || string(raw) == `""`
+}
+
+// formNumber 数字或数字字符串
+func formNumber(raw json.RawMessage) (float64, error) {
+	var n float64
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return n, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if n, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(s), ",", ""), 64); err == nil {
+			return n, nil
+		}
+	}
+	return 0, invalidFormValue("number", raw)
+}
+
+// formTimeLayouts 日期控件可能出现的格式，没有时区的按本地时间解析
+var formTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"}
+
+// formTime RFC 3339 等格式的日期字符串或毫秒时间戳，空值返回零值
+func formTime(raw json.RawMessage) (time.Time, error) {
+	if isEmptyFormValue(raw) {
+		return time.Time{}, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		for _, layout := range formTimeLayouts {
+			if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+				return t, nil
+			}
+		}
+	}
+	if ms, err := formNumber(raw); err == nil {
+		return time.UnixMilli(int64(ms)), nil
+	}
+	return time.Time{}, invalidFormValue("date", raw)
+}
+
+// formStrings 字符串数组，单个字符串视为只有一个元素
+func formStrings(raw json.RawMessage) ([]string, error) {
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []string{s}, nil
+	}
+	return nil, invalidFormValue("strings", raw)
+}
+
+// formOptions 以 option 中的选项补全选中文本对应的 key，option 可以是单个选项或选项数组
+func formOptions(texts []string, rawOption json.RawMessage) []FormOption {
+	type option struct {
+		Key  string `json:"key"`
+		Text string `json:"text"`
+	}
+	var options []option
+	if err := json.Unmarshal(rawOption, &options); err != nil {
+		var single option
+		if json.Unmarshal(rawOption, &single) == nil {
+			options = []option{single}
+		}
+	}
+	result := make([]FormOption, 0, len(texts))
+	for _, text := range texts {
+		o := FormOption{Text: text}
+		for _, candidate := range options {
+			if candidate.Text == text || candidate.Key == text {
+				o = FormOption{Key: candidate.Key, Text: candidate.Text}
+				break
+			}
+		}
+		result = append(result, o)
+	}
+	return result
+}
+
+// formFiles 附件的 value 为 URL 数组，ext 为逗号分隔的文件名；也接受 {"url","name"} 对象数组
+func formFiles(raw, ext json.RawMessage) ([]FormFile, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, invalidFormValue("attachments", raw)
+	}
+	var names []string
+	var joined string
+	if json.Unmarshal(ext, &joined) == nil && joined != "" {
+		names = strings.Split(joined, ",")
+	}
+	files := make([]FormFile, 0, len(items))
+	for i, item := range items {
+		var f FormFile
+		if err := json.Unmarshal(item, &f.URL); err != nil {
+			var obj struct {
+				URL  string `json:"url"`
+				Name string `json:"name"`
+			}
+			if err := json.Unmarshal(item, &obj); err != nil {
+				return nil, invalidFormValue("attachments", raw)
+			}
+			f = FormFile{Name: obj.Name, URL: obj.URL}
+		}
+		if f.Name == "" && i < len(names) {
+			f.Name = strings.TrimSpace(names[i])
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// formUsers 联系人的 value 为 user_id 数组，open_ids 为对应的 open_id；也接受 {"user_id","open_id","name"} 对象数组
+func formUsers(raw json.RawMessage, openIDs []string) ([]FormUser, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, invalidFormValue("users", raw)
+	}
+	users := make([]FormUser, 0, len(items))
+	for i, item := range items {
+		var u FormUser
+		if err := json.Unmarshal(item, &u.UserID); err != nil {
+			var obj struct {
+				UserID string `json:"user_id"`
+				OpenID string `json:"open_id"`
+				Name   string `json:"name"`
+			}
+			if err := json.Unmarshal(item, &obj); err != nil {
+				return nil, invalidFormValue("users", raw)
+			}
+			u = FormUser{UserID: obj.UserID, OpenID: obj.OpenID, Name: obj.Name}
+		}
+		if u.OpenID == "" && i < len(openIDs) {
+			u.OpenID = openIDs[i]
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// formDepartments 部门的 value 为 {"open_id","name"} 对象数组，也接受部门 ID 数组
+func formDepartments(raw json.RawMessage) ([]FormDepartment, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, invalidFormValue("departments", raw)
+	}
+	departments := make([]FormDepartment, 0, len(items))
+	for _, item := range items {
+		var d FormDepartment
+		if err := json.Unmarshal(item, &d.OpenID); err != nil {
+			var obj struct {
+				OpenID string `json:"open_id"`
+				Name   string `json:"name"`
+			}
+			if err := json.Unmarshal(item, &obj); err != nil {
+				return nil, invalidFormValue("departments", raw)
+			}
+			d = FormDepartment{OpenID: obj.OpenID, Name: obj.Name}
+		}
+		departments = append(departments, d)
+	}
+	return departments, nil
+}
+
+// formFieldValue 控件的一个可查询的值，对应 approval_form_field 中的一行
+type formFieldValue struct {
+	Text   *string
+	Number *float64
+	Time   *time.Time
+}
+
+// projectedValues 控件的可查询值，多值控件每个值一项，明细控件本身没有值
+//
+// 文本截断为 FormTextValueMaxLength 个字符，时间统一为 UTC；数据库与 ApprovalFilter.Match 使用同一份结果。
+// 用户取 user_id（没有时取 open_id），部门取 open_id（没有时取名称），附件取文件名，金额的币种放在文本值中。
+func (c *FormControl) projectedValues() []formFieldValue {
+	text := func(s string) formFieldValue {
+		if r := []rune(s); len(r) > FormTextValueMaxLength {
+			s = string(r[:FormTextValueMaxLength])
+		}
+		return formFieldValue{Text: &s}
+	}
+	number := func(n float64) formFieldValue { return formFieldValue{Number: &n} }
+	at := func(t time.Time) formFieldValue {
+		t = t.UTC()
+		return formFieldValue{Time: &t}
+	}
+	firstNonEmpty := func(values ...string) string {
+		for _, v := range values {
+			if v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	var values []formFieldValue
+	switch v := c.Value.(type) {
+	case string:
+		values = append(values, text(v))
+	case float64:
+		values = append(values, number(v))
+	case bool:
+		values = append(values, text(strconv.FormatBool(v)))
+	case FormAmount:
+		value := number(v.Value)
+		if v.Currency != "" {
+			value.Text = text(v.Currency).Text
+		}
+		values = append(values, value)
+	case time.Time:
+		values = append(values, at(v))
+	case FormDateInterval:
+		for _, t := range []time.Time{v.Start, v.End} {
+			if !t.IsZero() {
+				values = append(values, at(t))
+			}
+		}
+	case FormOption:
+		values = append(values, text(v.Text))
+	case []FormOption:
+		for _, o := range v {
+			values = append(values, text(o.Text))
+		}
+	case []FormFile:
+		for _, f := range v {
+			values = append(values, text(firstNonEmpty(f.Name, f.URL)))
+		}
+	case []FormUser:
+		for _, u := range v {
+			values = append(values, text(firstNonEmpty(u.UserID, u.OpenID)))
+		}
+	case []FormDepartment:
+		for _, d := range v {
+			values = append(values, text(firstNonEmpty(d.OpenID, d.Name)))
+		}
+	case []any:
+		for _, item := range v {
+			if s, ok := jsonScalarText(item); ok {
+				values = append(values, text(s))
+			}
+		}
+	}
+	return values
+}
+
+// FormFieldMatch 表单控件需要满足的条件，零值字段不参与过滤
+//
+// 控件按 custom_id 或名称匹配（包括明细中的控件），多值控件（多选、联系人、明细各行等）中存在一个值同时满足全部条件即可；
+// 所有条件都为零值时表示该控件有值。
+type FormFieldMatch struct {
+	Field    string    // 控件的 custom_id 或名称
+	Equals   string    // 文本值相等，单选、多选为选项文本
+	Contains string    // 文本值包含该字符串
+	Min      *float64  // 数字、金额 >= Min
+	Max      *float64  // 数字、金额 <= Max
+	Time     TimeRange // 日期，日期区间的开始或结束时间
+}
+
+// expression 编译为 approval_form_field 上的 EXISTS 子查询
+func (m FormFieldMatch) expression() clause.Expression {
+	var sql strings.Builder
+	sql.WriteString("EXISTS (SELECT 1 FROM approval_form_field ff WHERE ff.approval_id = ? AND (ff.custom_id = ? OR ff.name = ?)")
+	vars := []any{clause.Column{Table: clause.CurrentTable, Name: "id"}, m.Field, m.Field}
+	add := func(cond string, v any) {
+		sql.WriteString(" AND " + cond)
+		vars = append(vars, v)
+	}
+	if m.Equals != "" {
+		add("ff.text_value = ?", m.Equals)
+	}
+	if m.Contains != "" {
+		// 以 ! 作为转义字符，MySQL、PostgreSQL、SQLite 写法相同
+		escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(m.Contains)
+		add("ff.text_value LIKE ? ESCAPE '!'", "%"+escaped+"%")
+	}
+	if m.Min != nil {
+		add("ff.number_value >= ?", *m.Min)
+	}
+	if m.Max != nil {
+		add("ff.number_value <= ?", *m.Max)
+	}
+	if !m.Time.From.IsZero() {
+		add("ff.time_value >= ?", m.Time.From.UTC())
+	}
+	if !m.Time.To.IsZero() {
+		add("ff.time_value < ?", m.Time.To.UTC())
+	}
+	if m.Equals == "" && m.Contains == "" && m.Min == nil && m.Max == nil && m.Time.From.IsZero() && m.Time.To.IsZero() {
+		sql.WriteString(" AND (ff.text_value IS NOT NULL OR ff.number_value IS NOT NULL OR ff.time_value IS NOT NULL)")
+	}
+	sql.WriteString(")")
+	return clause.Expr{SQL: sql.String(), Vars: vars}
+}
+
+// matchValue 与 expression 语义一致的应用层判断
+func (m FormFieldMatch) matchValue(v formFieldValue) bool {
+	if m.Equals != "" && (v.Text == nil || *v.Text != m.Equals) {
+		return false
+	}
+	if m.Contains != "" && (v.Text == nil || !strings.Contains(*v.Text, m.Contains)) {
+		return false
+	}
+	if (m.Min != nil || m.Max != nil) && v.Number == nil {
+		return false
+	}
+	if (m.Min != nil && *v.Number < *m.Min) || (m.Max != nil && *v.Number > *m.Max) {
+		return false
+	}
+	if !m.Time.From.IsZero() || !m.Time.To.IsZero() {
+		if v.Time == nil || !timeInRange(*v.Time, m.Time) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchForm 表单中存在满足条件的控件值
+func (m FormFieldMatch) matchForm(form LarkForm) bool {
+	found := false
+	form.walk(func(c, _ *FormControl, _ int) {
+		if found || (c.CustomID != m.Field && c.Name != m.Field) {
+			return
+		}
+		for _, v := range c.projectedValues() {
+			if m.matchValue(v) {
+				found = true
+				return
+			}
+		}
+	})
+	return found
+}
+
+// ApprovalFormFieldM 表单控件值的投影，每个值一行，由 FormProjectionPlugin 维护，供 ApprovalFilter.Form 查询
+type ApprovalFormFieldM struct {
+	ID          uint64     `gorm:"column:id;AUTO_INCREMENT;primary_key" json:"id"`
+	TenantID    string     `gorm:"column:tenant_id;type:varchar(64);NOT NULL;default:''" json:"tenant_id"`                                                 // 租户ID，与审批记录一致
+	ApprovalID  uint64     `gorm:"column:approval_id;NOT NULL;index:idx_form_field_approval_id" json:"approval_id"`                                        // approval 表主键
+	ControlID   string     `gorm:"column:control_id;type:varchar(255);NOT NULL" json:"control_id"`                                                         // 控件ID
+	CustomID    string     `gorm:"column:custom_id;type:varchar(255);NOT NULL;default:'';index:idx_form_field_custom_id_text,priority:1" json:"custom_id"` // 控件自定义ID
+	Name        string     `gorm:"column:name;type:varchar(255);NOT NULL;index:idx_form_field_name_text,priority:1" json:"name"`                           // 控件名称
+	Type        string     `gorm:"column:type;type:varchar(32);NOT NULL" json:"type"`                                                                      // 控件类型
+	ParentID    string     `gorm:"column:parent_id;type:varchar(255);NOT NULL;default:''" json:"parent_id"`                                                // 所在明细控件的ID，顶层控件为空
+	RowIndex    int        `gorm:"column:row_index;type:int;NOT NULL;default:0" json:"row_index"`                                                          // 在明细中的行号
+	ValueIndex  int        `gorm:"column:value_index;type:int;NOT NULL;default:0" json:"value_index"`                                                      // 多值控件中值的序号
+	TextValue   *string    `gorm:"column:text_value;type:varchar(512);index:idx_form_field_name_text,priority:2;index:idx_form_field_custom_id_text,priority:2" json:"text_value"`
+	NumberValue *float64   `gorm:"column:number_value;type:decimal(20,6)" json:"number_value"`
+	TimeValue   *time.Time `gorm:"column:time_value" json:"time_value"` // UTC
+}
+
+// TableName 指定表名
+func (ApprovalFormFieldM) TableName() string {
+	return "approval_form_field"
+}
+
+// FormProjectionPlugin 将审批表单中的控件值投影到 approval_form_field 表，用于 ApprovalFilter.Form 按表单字段过滤
+//
+// 注册后，ApprovalM 的 Create、Save、Updates、upsert 以及 JSONUpdateHelper 等写入在 AfterCreate/AfterUpdate 中
+// 读取最终的 lark_data，form 发生变化时于同一事务内重建该审批的投影；钩子被跳过的写入（UpdateColumn、
+// WithoutHooks 等）和注册之前的存量数据通过 RebuildApprovalFormFields 补齐。表单解析失败的控件记录告警后跳过，不影响写入。
+//
+// fields 为需要投影的控件 custom_id 或名称，明细控件包含其中的所有控件；为空时投影所有控件。
+// 投影中保存的是明文，同时开启 FieldEncryptionPlugin 加密 $.form 时只应配置不敏感的字段。
+//
+//	db.Use(NewFormProjectionPlugin("报销金额", "费用类型"))
+type FormProjectionPlugin struct {
+	fields map[string]bool
+}
+
+// NewFormProjectionPlugin 创建表单投影插件
+func NewFormProjectionPlugin(fields ...string) *FormProjectionPlugin {
+	p := &FormProjectionPlugin{fields: make(map[string]bool, len(fields))}
+	for _, f := range fields {
+		p.fields[f] = true
+	}
+	return p
+}
+
+// Name 实现 gorm.Plugin
+func (p *FormProjectionPlugin) Name() string {
+	return "form_projection"
+}
+
+// Initialize 实现 gorm.Plugin，投影由 ApprovalM 的钩子维护，不需要注册回调
+func (p *FormProjectionPlugin) Initialize(*gorm.DB) error {
+	return nil
+}
+
+// formProjectionOf 返回 db 上注册的表单投影插件，没有注册时返回 nil
+func formProjectionOf(db *gorm.DB) *FormProjectionPlugin {
+	if db == nil || db.Config == nil {
+		return nil
+	}
+	p, _ := db.Config.Plugins[(*FormProjectionPlugin)(nil).Name()].(*FormProjectionPlugin)
+	return p
+}
+
+func (p *FormProjectionPlugin) projected(c *FormControl) bool {
+	return len(p.fields) == 0 || p.fields[c.CustomID] || p.fields[c.Name]
+}
+
+// rows 生成审批的投影行，表单解析失败的控件记录告警后跳过
+func (p *FormProjectionPlugin) rows(a *ApprovalM) ([]*ApprovalFormFieldM, error) {
+	form, err := approvalFormOf(a.LarkData)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := ParseLarkForm(form)
+	if err != nil {
+		slog.Warn("parse approval form failed", "instance_id", a.InstanceID, "error", err.Error())
+	}
+
+	var rows []*ApprovalFormFieldM
+	included := map[*FormControl]bool{}
+	parsed.walk(func(c, parent *FormControl, row int) {
+		included[c] = p.projected(c) || (parent != nil && included[parent])
+		if !included[c] {
+			return
+		}
+		var parentID string
+		if parent != nil {
+			parentID = parent.ID
+		}
+		for i, v := range c.projectedValues() {
+			rows = append(rows, &ApprovalFormFieldM{
+				TenantID:    a.TenantID,
+				ApprovalID:  a.ID,
+				ControlID:   c.ID,
+				CustomID:    c.CustomID,
+				Name:        c.Name,
+				Type:        c.Type,
+				ParentID:    parentID,
+				RowIndex:    row,
+				ValueIndex:  i,
+				TextValue:   v.Text,
+				NumberValue: v.Number,
+				TimeValue:   v.Time,
+			})
+		}
+	})
+	return rows, nil
+}
+
+// replace 在 tx 中重建 approvals 的投影
+func (p *FormProjectionPlugin) replace(tx *gorm.DB, approvals []*ApprovalM) error {
+	if len(approvals) == 0 {
+		return nil
+	}
+	ids := make([]uint64, 0, len(approvals))
+	var rows []*ApprovalFormFieldM
+	for _, a := range approvals {
+		ids = append(ids, a.ID)
+		r, err := p.rows(a)
+		if err != nil {
+			return fmt.Errorf("project form of approval %s: %w", a.InstanceID, err)
+		}
+		rows = append(rows, r...)
+	}
+	if err := tx.Where("approval_id IN ?", ids).Delete(&ApprovalFormFieldM{}).Error; err != nil {
+		return fmt.Errorf("delete approval form fields: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	if err := tx.CreateInBatches(rows, 200).Error; err != nil {
+		return fmt.Errorf("save approval form fields: %w", err)
+	}
+	return nil
+}
+
+// approvalFormOf 取出 lark_data.form
+func approvalFormOf(data JSONColumn[LarkApproval]) (string, error) {
+	raw, err := data.Bytes()
+	if err != nil || raw == nil {
+		return "", err
+	}
+	var doc struct {
+		Form string `json:"form"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return "", err
+	}
+	return doc.Form, nil
+}
+
+// syncApprovalFormFields 在 AfterCreate/AfterUpdate 读取最终数据后调用，form 变化时重建投影
+func syncApprovalFormFields(tx *gorm.DB, after *ApprovalM, before JSONColumn[LarkApproval]) error {
+	p := formProjectionOf(tx)
+	if p == nil {
+		return nil
+	}
+	afterForm, err := approvalFormOf(after.LarkData)
+	if err != nil {
+		return err
+	}
+	beforeForm, err := approvalFormOf(before)
+	if err != nil {
+		return err
+	}
+	if afterForm == beforeForm {
+		return nil
+	}
+	return p.replace(tx.Session(&gorm.Session{NewDB: true}), []*ApprovalM{after})
+}
+
+// DefaultFormProjectionBatchSize RebuildApprovalFormFields 每批处理的记录数
+const DefaultFormProjectionBatchSize = 200
+
+// RebuildApprovalFormFields 按当前配置重建所有审批（包括已软删除的记录）的表单投影，返回处理的审批数
+//
+// 用于首次开启投影、修改投影字段后补齐存量数据。每批在一个事务中删除旧投影并写入新投影。
+// batchSize <= 0 时使用 DefaultFormProjectionBatchSize。context 中有租户时只处理该租户，跨租户处理时使用 WithAllTenants。
+func RebuildApprovalFormFields(ctx context.Context, db *gorm.DB, batchSize int) (int, error) {
+	p := formProjectionOf(db)
+	if p == nil {
+		return 0, ErrFormProjectionNotConfigured
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultFormProjectionBatchSize
+	}
+	db = db.WithContext(ctx)
+
+	var n int
+	for lastID := uint64(0); ; {
+		var batch []*ApprovalM
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Unscoped().Select("id", "tenant_id", "instance_id", "lark_data").
+				Where("id > ?", lastID).Order("id").Limit(batchSize).Find(&batch).Error; err != nil {
+				return err
+			}
+			return p.replace(tx, batch)
+		})
+		if err != nil {
+			return n, err
+		}
+		n += len(batch)
+		if len(batch) < batchSize {
+			return n, nil
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+}
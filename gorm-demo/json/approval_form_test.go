@@ -0,0 +1,333 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// reimbursementForm 包含各类控件的飞书报销表单
+const reimbursementForm = `[
+	{"id":"w1","custom_id":"reason","name":"事由","type":"input","value":"出差"},
+	{"id":"w2","name":"说明","type":"textarea","value":""},
+	{"id":"w3","name":"天数","type":"number","value":"1,024.5"},
+	{"id":"w4","custom_id":"amount","name":"报销金额","type":"amount","value":300.5,"option":"CNY"},
+	{"id":"w5","name":"日期","type":"date","value":"2024-03-01T08:00:00+08:00"},
+	{"id":"w6","name":"行程","type":"dateInterval","value":{"start":"2024-03-01","end":1709424000000,"interval":2}},
+	{"id":"w7","name":"费用类型","type":"radioV2","value":"交通","option":[{"key":"k1","text":"交通"},{"key":"k2","text":"住宿"}]},
+	{"id":"w8","name":"标签","type":"checkboxV2","value":["a","k9"],"option":[{"key":"k9","text":"b"}]},
+	{"id":"w9","name":"附件","type":"attachmentV2","value":["https://f/1","https://f/2"],"ext":"发票.pdf, 行程单.pdf"},
+	{"id":"w10","name":"同行人","type":"contact","value":["u1",{"user_id":"u2","name":"李四"}],"open_ids":["ou1"]},
+	{"id":"w11","name":"部门","type":"department","value":[{"open_id":"od1","name":"研发"}]},
+	{"id":"w12","custom_id":"items","name":"明细","type":"fieldList","value":[
+		[{"id":"w12a","name":"项目","type":"input","value":"机票"},{"id":"w12b","name":"金额","type":"amount","value":"200"}],
+		[{"id":"w12a","name":"项目","type":"input","value":"酒店"},{"id":"w12b","name":"金额","type":"amount","value":100.5}]
+	]},
+	{"id":"w13","name":"公式","type":"formula","value":[1,"x"]}
+]`
+
+func TestParseLarkForm(t *testing.T) {
+	form, err := ParseLarkForm(reimbursementForm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(form) != 13 {
+		t.Fatalf("controls = %d, want 13", len(form))
+	}
+	value := func(key string) any {
+		c := form.Field(key)
+		if c == nil {
+			t.Fatalf("control %s not found", key)
+		}
+		return c.Value
+	}
+
+	if v := value("reason"); v != "出差" {
+		t.Errorf("input = %v", v)
+	}
+	if v := value("事由"); v != "出差" {
+		t.Errorf("lookup by name = %v", v)
+	}
+	if v := value("说明"); v != nil {
+		t.Errorf("empty textarea = %v", v)
+	}
+	if v := value("天数"); v != 1024.5 {
+		t.Errorf("number = %v", v)
+	}
+	if v := value("amount"); v != (FormAmount{Value: 300.5, Currency: "CNY"}) {
+		t.Errorf("amount = %v", v)
+	}
+	if v, _ := value("日期").(time.Time); !v.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("date = %v", v)
+	}
+	interval, _ := value("行程").(FormDateInterval)
+	if !interval.Start.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)) || !interval.End.Equal(time.UnixMilli(1709424000000)) ||
+		interval.Interval != 2 {
+		t.Errorf("date interval = %+v", interval)
+	}
+	if v := value("费用类型"); v != (FormOption{Key: "k1", Text: "交通"}) {
+		t.Errorf("radio = %v", v)
+	}
+	if c := form.Field("费用类型"); c.Kind() != FormControlRadio || c.Type != "radioV2" {
+		t.Errorf("kind = %s, type = %s", c.Kind(), c.Type)
+	}
+	if v, _ := value("标签").([]FormOption); !slices.Equal(v, []FormOption{{Text: "a"}, {Key: "k9", Text: "b"}}) {
+		t.Errorf("checkbox = %v", v)
+	}
+	if v, _ := value("附件").([]FormFile); !slices.Equal(v, []FormFile{{Name: "发票.pdf", URL: "https://f/1"}, {Name: "行程单.pdf", URL: "https://f/2"}}) {
+		t.Errorf("attachment = %v", v)
+	}
+	if v, _ := value("同行人").([]FormUser); !slices.Equal(v, []FormUser{{UserID: "u1", OpenID: "ou1"}, {UserID: "u2", Name: "李四"}}) {
+		t.Errorf("contact = %v", v)
+	}
+	if v, _ := value("部门").([]FormDepartment); !slices.Equal(v, []FormDepartment{{OpenID: "od1", Name: "研发"}}) {
+		t.Errorf("department = %v", v)
+	}
+	rows, _ := value("items").([]LarkForm)
+	if len(rows) != 2 || rows[0].Field("项目").Value != "机票" || rows[1].Field("金额").Value != (FormAmount{Value: 100.5}) {
+		t.Errorf("field list = %v", rows)
+	}
+	// 未知类型按 JSON 解码
+	if v, _ := value("公式").([]any); len(v) != 2 || v[1] != "x" {
+		t.Errorf("unknown type = %v", v)
+	}
+	if form.Field("项目") != nil {
+		t.Error("Field found a control inside a field list")
+	}
+}
+
+func TestParseLarkFormErrors(t *testing.T) {
+	if form, err := ParseLarkForm("  "); form != nil || err != nil {
+		t.Errorf("empty form = %v, %v", form, err)
+	}
+	if _, err := ParseLarkForm(`{"id":"w1"}`); err == nil {
+		t.Error("form that is not an array was accepted")
+	}
+
+	// 解析失败的控件值为 nil，其他控件不受影响
+	form, err := ParseLarkForm(`[
+		{"id":"w1","name":"金额","type":"number","value":"abc"},
+		{"id":"w2","name":"日期","type":"date","value":true},
+		{"id":"w3","name":"选项","type":"radio","value":["a","b"]},
+		{"id":"w4","name":"明细","type":"fieldList","value":[[{"id":"w4a","name":"数量","type":"number","value":{}}]]},
+		{"id":"w5","name":"事由","type":"input","value":"ok"}
+	]`)
+	if !errors.Is(err, ErrInvalidFormValue) {
+		t.Fatalf("err = %v, want ErrInvalidFormValue", err)
+	}
+	var cerr *FormControlError
+	if !errors.As(err, &cerr) || cerr.ID != "w1" || cerr.Type != FormControlNumber {
+		t.Errorf("first error = %+v", cerr)
+	}
+	for _, name := range []string{"金额", "日期", "选项"} {
+		if v := form.Field(name).Value; v != nil {
+			t.Errorf("%s = %v, want nil", name, v)
+		}
+	}
+	if rows, _ := form.Field("明细").Value.([]LarkForm); len(rows) != 1 || rows[0].Field("数量").Value != nil {
+		t.Errorf("field list = %v", rows)
+	}
+	if !strings.Contains(err.Error(), "数量(w4a)") || form.Field("事由").Value != "ok" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// openFormDB 返回注册了 FormProjectionPlugin 的测试库
+func openFormDB(t *testing.T, fields ...string) *gorm.DB {
+	t.Helper()
+	db := openTestDB(t)
+	if err := db.AutoMigrate(&ApprovalFormFieldM{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(NewFormProjectionPlugin(fields...)); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// formFieldRows 按写入顺序返回 approvalID 的投影，格式为 名称[行号.值序号]=值
+func formFieldRows(t *testing.T, db *gorm.DB, approvalID uint64) []string {
+	t.Helper()
+	var rows []*ApprovalFormFieldM
+	if err := db.Where("approval_id = ?", approvalID).Order("id").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range rows {
+		var values []string
+		if r.TextValue != nil {
+			values = append(values, *r.TextValue)
+		}
+		if r.NumberValue != nil {
+			values = append(values, strconv.FormatFloat(*r.NumberValue, 'f', -1, 64))
+		}
+		if r.TimeValue != nil {
+			values = append(values, r.TimeValue.UTC().Format(time.RFC3339))
+		}
+		got = append(got, fmt.Sprintf("%s[%d.%d]=%s", r.Name, r.RowIndex, r.ValueIndex, strings.Join(values, " ")))
+	}
+	return got
+}
+
+func TestFormProjection(t *testing.T) {
+	db := openFormDB(t, "amount", "items", "费用类型")
+	a := seedApproval(t, db, "i1", LarkApproval{ApprovalName: "报销", Status: ApprovalStatusPending, Form: reimbursementForm})
+
+	// 只投影配置的控件，明细中的控件全部投影
+	want := []string{"报销金额[0.0]=CNY 300.5", "费用类型[0.0]=交通", "项目[0.0]=机票", "金额[0.0]=200", "项目[1.0]=酒店", "金额[1.0]=100.5"}
+	if got := formFieldRows(t, db, a.ID); !slices.Equal(got, want) {
+		t.Errorf("create: rows = %v, want %v", got, want)
+	}
+
+	// Save 修改表单后重建投影
+	data, _ := a.LarkData.Get()
+	data.Form = `[{"id":"w4","custom_id":"amount","name":"报销金额","type":"amount","value":80}]`
+	a.LarkData = NewJSONColumn(data)
+	if err := db.Save(a).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := formFieldRows(t, db, a.ID); !slices.Equal(got, []string{"报销金额[0.0]=80"}) {
+		t.Errorf("save: rows = %v", got)
+	}
+
+	// 表达式更新和 upsert 同样重建
+	h := NewJSONUpdateHelper(db)
+	if err := h.UpdateJSONField("i1", "$.form", `[{"id":"w7","name":"费用类型","type":"radio","value":"住宿"}]`); err != nil {
+		t.Fatal(err)
+	}
+	if got := formFieldRows(t, db, a.ID); !slices.Equal(got, []string{"费用类型[0.0]=住宿"}) {
+		t.Errorf("expression update: rows = %v", got)
+	}
+	err := h.UpdateJSONFieldsInBatch("i1", map[string]any{"$.form": `[{"id":"w4","custom_id":"amount","name":"报销金额","type":"amount","value":"12"}]`},
+		UpsertDefaults{ApprovalCode: "code", Type: ApprovalTypeLark})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formFieldRows(t, db, a.ID); !slices.Equal(got, []string{"报销金额[0.0]=12"}) {
+		t.Errorf("upsert existing: rows = %v", got)
+	}
+	err = h.UpdateJSONFieldsInBatch("i2", map[string]any{"$.approval_name": "新建", "$.form": `[{"id":"w7","name":"费用类型","type":"radio","value":"交通"}]`},
+		UpsertDefaults{ApprovalCode: "code", Type: ApprovalTypeLark})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created ApprovalM
+	if err := db.Where("instance_id = ?", "i2").First(&created).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := formFieldRows(t, db, created.ID); !slices.Equal(got, []string{"费用类型[0.0]=交通"}) {
+		t.Errorf("upsert new: rows = %v", got)
+	}
+
+	// 表单不变的更新不重建
+	var before []*ApprovalFormFieldM
+	db.Where("approval_id = ?", a.ID).Find(&before)
+	if err := h.UpdateJSONField("i1", "$.status", ApprovalStatusApproved); err != nil {
+		t.Fatal(err)
+	}
+	var after []*ApprovalFormFieldM
+	db.Where("approval_id = ?", a.ID).Find(&after)
+	if len(before) != 1 || len(after) != 1 || before[0].ID != after[0].ID {
+		t.Errorf("projection rebuilt without form change: %v -> %v", before, after)
+	}
+}
+
+func TestFormProjectionQuery(t *testing.T) {
+	db := openFormDB(t)
+	a := seedApproval(t, db, "i1", LarkApproval{ApprovalName: "报销", Status: ApprovalStatusPending, Form: reimbursementForm})
+	seedApproval(t, db, "i2", LarkApproval{ApprovalName: "报销", Status: ApprovalStatusPending,
+		Form: `[{"id":"w4","custom_id":"amount","name":"报销金额","type":"amount","value":5000},{"id":"w8","name":"标签","type":"checkbox","value":["c"]}]`})
+	seedApproval(t, db, "i3", LarkApproval{ApprovalName: "请假", Status: ApprovalStatusPending})
+
+	// 没有配置字段时投影所有控件，多值控件每个值一行
+	rows := formFieldRows(t, db, a.ID)
+	for _, want := range []string{"天数[0.0]=1024.5", "日期[0.0]=2024-03-01T00:00:00Z", "行程[0.1]=2024-03-03T00:00:00Z",
+		"标签[0.1]=b", "附件[0.0]=发票.pdf", "同行人[0.0]=u1", "部门[0.0]=od1", "公式[0.1]=x"} {
+		if !slices.Contains(rows, want) {
+			t.Errorf("rows = %v, want %s", rows, want)
+		}
+	}
+
+	h := NewJSONQueryHelper(db)
+	minAmount, maxAmount := 200.0, 1000.0
+	tests := []struct {
+		name  string
+		match []FormFieldMatch
+		want  []string
+	}{
+		{"by custom_id", []FormFieldMatch{{Field: "amount", Min: &minAmount, Max: &maxAmount}}, []string{"i1"}},
+		{"by name", []FormFieldMatch{{Field: "报销金额", Min: &maxAmount}}, []string{"i2"}},
+		{"multi value", []FormFieldMatch{{Field: "标签", Equals: "b"}}, []string{"i1"}},
+		{"contains escapes wildcards", []FormFieldMatch{{Field: "附件", Contains: "_"}}, nil},
+		{"contains", []FormFieldMatch{{Field: "附件", Contains: "行程"}}, []string{"i1"}},
+		{"field list row", []FormFieldMatch{{Field: "金额", Min: &minAmount}}, []string{"i1"}},
+		{"time", []FormFieldMatch{{Field: "行程", Time: TimeRange{From: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)}}}, []string{"i1"}},
+		{"has value", []FormFieldMatch{{Field: "标签"}}, []string{"i1", "i2"}},
+		{"all conditions", []FormFieldMatch{{Field: "标签"}, {Field: "事由", Equals: "出差"}}, []string{"i1"}},
+	}
+	var all []*ApprovalM
+	if err := db.Order("id").Find(&all).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := ApprovalFilter{Form: tt.match}
+			if got := instanceIDs(t, db, h.Filter(filter)); !slices.Equal(got, tt.want) {
+				t.Errorf("Filter: got %v, want %v", got, tt.want)
+			}
+			var matched []string
+			for _, a := range all {
+				if ok, err := filter.Match(a); err != nil {
+					t.Fatal(err)
+				} else if ok {
+					matched = append(matched, a.InstanceID)
+				}
+			}
+			if !slices.Equal(matched, tt.want) {
+				t.Errorf("Match: got %v, want %v", matched, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormProjectionRebuildAndPurge(t *testing.T) {
+	db := openFormDB(t, "amount")
+	// 注册插件之前写入的记录没有投影
+	plain := db.Session(&gorm.Session{NewDB: true})
+	plain.Config = &gorm.Config{}
+	*plain.Config = *db.Config
+	plain.Config.Plugins = map[string]gorm.Plugin{}
+	a := seedApproval(t, plain, "i1", LarkApproval{ApprovalName: "报销", Status: ApprovalStatusPending, Form: reimbursementForm})
+	if got := formFieldRows(t, db, a.ID); len(got) != 0 {
+		t.Fatalf("rows = %v before rebuild", got)
+	}
+	n, err := RebuildApprovalFormFields(t.Context(), db, 1)
+	if err != nil || n != 1 {
+		t.Fatalf("rebuild = %d, %v", n, err)
+	}
+	if got := formFieldRows(t, db, a.ID); !slices.Equal(got, []string{"报销金额[0.0]=CNY 300.5"}) {
+		t.Errorf("rows = %v after rebuild", got)
+	}
+	if _, err := RebuildApprovalFormFields(t.Context(), openTestDB(t), 0); !errors.Is(err, ErrFormProjectionNotConfigured) {
+		t.Errorf("err = %v, want ErrFormProjectionNotConfigured", err)
+	}
+
+	// 清理软删除的记录时删除投影
+	if err := db.Delete(a).Error; err != nil {
+		t.Fatal(err)
+	}
+	l := NewApprovalLifecycle(db, 0, t.TempDir())
+	if result, err := l.Purge(t.Context()); err != nil || result.Purged != 1 {
+		t.Fatalf("purge = %+v, %v", result, err)
+	}
+	var left int64
+	if err := db.Model(&ApprovalFormFieldM{}).Count(&left).Error; err != nil || left != 0 {
+		t.Errorf("form fields left = %d, %v", left, err)
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// 审批历史的操作类型
+const (
+	HistoryOperationCreate = "create"
+	HistoryOperationUpdate = "update"
+)
+
+// ApprovalHistoryM 审批数据变更历史，每次 lark_data 发生变化时记录一条
+type ApprovalHistoryM struct {
+	ID         uint64         `gorm:"column:id;AUTO_INCREMENT;primary_key" json:"id"`
+	CreatedAt  time.Time      `gorm:"column:created_at;index:idx_instance_id_created_at,priority:2" json:"created_at"`                              // 变更时间
+	TenantID   string         `gorm:"column:tenant_id;type:varchar(64);NOT NULL;default:''" json:"tenant_id"`                                       // 租户ID，与审批记录一致
+	ApprovalID uint64         `gorm:"column:approval_id;NOT NULL" json:"approval_id"`                                                               // approval 表主键
+	InstanceID string         `gorm:"column:instance_id;type:varchar(255);NOT NULL;index:idx_instance_id_created_at,priority:1" json:"instance_id"` // 审批实例ID
+	Operation  string         `gorm:"column:operation;type:varchar(20);NOT NULL" json:"operation"`                                                  // 操作类型, 可选值: create, update
+	Actor      string         `gorm:"column:actor;type:varchar(255);NOT NULL" json:"actor"`                                                         // 操作人，通过 WithApprovalActor 设置
+	Version    uint64         `gorm:"column:version;type:bigint unsigned;NOT NULL" json:"version"`                                                  // 变更后的版本号
+	Diff       datatypes.JSON `gorm:"column:diff;type:json;NOT NULL" json:"diff"`                                                                   // 旧 lark_data -> 新 lark_data 的 JSON Patch
+	RevertDiff datatypes.JSON `gorm:"column:revert_diff;type:json;NOT NULL" json:"revert_diff"`                                                     // 新 lark_data -> 旧 lark_data 的 JSON Patch
+}
+
+// TableName 指定表名
+func (ApprovalHistoryM) TableName() string {
+	return "approval_history"
+}
+
+// AfterFind GORM钩子，注册了 FieldEncryptionPlugin 时解密 diff 和 revert_diff
+func (h *ApprovalHistoryM) AfterFind(tx *gorm.DB) error {
+	p := fieldEncryptionOf(tx)
+	if p == nil {
+		return nil
+	}
+	scope := historyEncryptionScope(h.TenantID, h.InstanceID)
+	diff, _, err := p.openPatch(tx.Statement.Context, h.Diff, scope)
+	if err != nil {
+		return fmt.Errorf("decrypt diff of approval history %d: %w", h.ID, err)
+	}
+	revert, _, err := p.openPatch(tx.Statement.Context, h.RevertDiff, scope)
+	if err != nil {
+		return fmt.Errorf("decrypt revert_diff of approval history %d: %w", h.ID, err)
+	}
+	h.Diff, h.RevertDiff = diff, revert
+	return nil
+}
+
+// historyEncryptionScope 变更历史中密文所属的记录，与审批记录的表名不同，两者之间的密文不能互换
+func historyEncryptionScope(tenantID, instanceID string) encryptionScope {
+	return encryptionScope{table: ApprovalHistoryM{}.TableName(), tenantID: tenantID, instanceID: instanceID}
+}
+
+// 通过 db.Set 为单次操作传递的设置
+const (
+	historyActorKey    = "approval:history_actor"
+	historyDisabledKey = "approval:history_disabled"
+)
+
+// historyBeforeKey 通过 setStatementValue 在 Before/After 钩子之间传递更新前的记录
+const historyBeforeKey = "approval:history_before"
+
+// WithApprovalActor 为本次操作记录操作人
+//
+//	WithApprovalActor(db, "sync-worker").Save(&approval)
+func WithApprovalActor(db *gorm.DB, actor string) *gorm.DB {
+	return db.Set(historyActorKey, actor)
+}
+
+// WithoutApprovalHistory 本次操作不记录历史，如批量导入历史数据
+func WithoutApprovalHistory(db *gorm.DB) *gorm.DB {
+	return db.Set(historyDisabledKey, true)
+}
+
+func historyDisabled(tx *gorm.DB) bool {
+	v, ok := tx.Get(historyDisabledKey)
+	disabled, _ := v.(bool)
+	return ok && disabled
+}
+
+func historyActor(tx *gorm.DB) string {
+	v, _ := tx.Get(historyActorKey)
+	actor, _ := v.(string)
+	return actor
+}
+
+// statementValueKey 钩子之间传递的值在 Statement.Settings 中的键
+//
+// 键中带上 Statement 本身：Statement 被克隆时 Settings 会被复制，派生出的 Statement 不会读到这些值。
+type statementValueKey struct {
+	stmt *gorm.Statement
+	name string
+}
+
+// setStatementValue 在当前 Statement 上保存钩子之间传递的值，值随 Statement 一起释放
+//
+// 钩子收到的 tx 是 NewDB 会话，tx.InstanceSet 会先创建新的 Statement，写入的值在 After 钩子中无法读到，
+// 因此直接写入当前 Statement 的 Settings，并使用自己的键类型，不依赖 GORM 内部的键格式。
+func setStatementValue(tx *gorm.DB, name string, value any) {
+	tx.Statement.Settings.Store(statementValueKey{stmt: tx.Statement, name: name}, value)
+}
+
+// statementValue 读取 setStatementValue 保存的值
+func statementValue(tx *gorm.DB, name string) (any, bool) {
+	return tx.Statement.Settings.Load(statementValueKey{stmt: tx.Statement, name: name})
+}
+
+// snapshotBeforeUpdate 在 BeforeUpdate 中读取将被更新的记录
+//
+// JSONUpdateHelper 等通过 SQL 表达式更新时，结构体中没有新旧数据，只能按本次更新的 WHERE 条件
+// （以及 Model 的主键）从数据库读取，写入后在 AfterUpdate 中再次读取并生成差异。
+// 状态转移检查同样依赖该快照，关闭历史时也会读取。
+func snapshotBeforeUpdate(tx *gorm.DB) error {
+	query := updateTargetQuery(tx)
+
+	var before []*ApprovalM
+	if err := query.Select("id", "tenant_id", "instance_id", "lark_data").Find(&before).Error; err != nil {
+		return fmt.Errorf("snapshot approval before update: %w", err)
+	}
+	snapshot := make(map[uint64]*ApprovalM, len(before))
+	for _, b := range before {
+		snapshot[b.ID] = b
+	}
+	setStatementValue(tx, historyBeforeKey, snapshot)
+	return nil
+}
+
+// recordUpdateHistory 在 AfterUpdate 中补写 version 等列后读取更新后的记录，检查状态转移、校验以表达式写入的数据、更新表单投影，
+// 并为 lark_data 发生变化的记录写入历史；model 为本次更新的模型，其 version 同步为数据库中的值
+func recordUpdateHistory(tx *gorm.DB, model *ApprovalM) error {
+	v, ok := statementValue(tx, historyBeforeKey)
+	snapshot, _ := v.(map[uint64]*ApprovalM)
+	if !ok || len(snapshot) == 0 {
+		return nil
+	}
+	ids := make([]uint64, 0, len(snapshot))
+	for id := range snapshot {
+		ids = append(ids, id)
+	}
+	if err := applyPendingUpdateColumns(tx, ids); err != nil {
+		return err
+	}
+
+	var after []*ApprovalM
+	if err := tx.Session(&gorm.Session{NewDB: true}).Unscoped().
+		Select("id", "created_at", "tenant_id", "instance_id", "lark_data", "version", "deleted_at").
+		Where("id IN ?", ids).Order("id").Find(&after).Error; err != nil {
+		return fmt.Errorf("load approval after update: %w", err)
+	}
+
+	histories := make([]*ApprovalHistoryM, 0, len(after))
+	for _, a := range after {
+		if model != nil && model.ID == a.ID {
+			model.Version = a.Version
+		}
+		if err := applyStatusTransitions(tx, a, snapshot[a.ID].LarkData); err != nil {
+			return err
+		}
+		if err := validateUpdatedLarkData(tx, a, snapshot[a.ID].LarkData); err != nil {
+			return err
+		}
+		if err := syncApprovalFormFields(tx, a, snapshot[a.ID].LarkData); err != nil {
+			return err
+		}
+		if err := softDeleteUpdatedByStatus(tx, a); err != nil {
+			return err
+		}
+		if historyDisabled(tx) {
+			continue
+		}
+		h, err := newApprovalHistory(tx, HistoryOperationUpdate, a, snapshot[a.ID].LarkData)
+		if err != nil {
+			return err
+		}
+		if h != nil {
+			histories = append(histories, h)
+		}
+	}
+	return saveApprovalHistories(tx, histories)
+}
+
+// snapshotBeforeUpsert 在 BeforeCreate 中读取 ON CONFLICT 可能更新的已有记录
+func snapshotBeforeUpsert(tx *gorm.DB, a *ApprovalM) error {
+	if _, ok := tx.Statement.Clauses["ON CONFLICT"]; !ok {
+		return nil
+	}
+	// ON CONFLICT 只会命中同一租户未删除的记录（见 uk_tenant_instance_id），已软删除的同名记录不参与
+	var existing ApprovalM
+	result := tx.Session(&gorm.Session{NewDB: true}).
+		Select("id", "tenant_id", "instance_id", "lark_data").
+		Where("tenant_id = ? AND instance_id = ?", a.TenantID, a.InstanceID).Limit(1).Find(&existing)
+	if result.Error != nil {
+		return fmt.Errorf("snapshot approval before upsert: %w", result.Error)
+	}
+	snapshot, _ := statementValue(tx, historyBeforeKey)
+	existingByInstance, _ := snapshot.(map[string]*ApprovalM)
+	if existingByInstance == nil {
+		existingByInstance = map[string]*ApprovalM{}
+	}
+	if result.RowsAffected > 0 {
+		existingByInstance[a.InstanceID] = &existing
+	} else {
+		existingByInstance[a.InstanceID] = nil
+	}
+	setStatementValue(tx, historyBeforeKey, existingByInstance)
+	return nil
+}
+
+// recordCreateHistory 在 AfterCreate 中检查状态转移、更新表单投影并写入历史；upsert 时先校验合并后的记录，命中已有记录时按更新处理
+func recordCreateHistory(tx *gorm.DB, a *ApprovalM) error {
+	snapshot, _ := statementValue(tx, historyBeforeKey)
+	existingByInstance, upsert := snapshot.(map[string]*ApprovalM)
+	if !upsert {
+		if err := applyStatusTransitions(tx, a, JSONColumn[LarkApproval]{}); err != nil {
+			return err
+		}
+		if err := syncApprovalFormFields(tx, a, JSONColumn[LarkApproval]{}); err != nil {
+			return err
+		}
+		if historyDisabled(tx) {
+			return nil
+		}
+		h, err := newApprovalHistory(tx, HistoryOperationCreate, a, JSONColumn[LarkApproval]{})
+		if err != nil {
+			return err
+		}
+		return saveApprovalHistories(tx, []*ApprovalHistoryM{h})
+	}
+
+	// ON CONFLICT 更新时结构体中不是最终数据，以数据库为准
+	var current ApprovalM
+	if err := tx.Session(&gorm.Session{NewDB: true}).
+		Select("id", "created_at", "tenant_id", "instance_id", "lark_data", "version", "deleted_at").
+		Where("tenant_id = ? AND instance_id = ?", a.TenantID, a.InstanceID).First(&current).Error; err != nil {
+		return fmt.Errorf("load approval after upsert: %w", err)
+	}
+	// BeforeCreate 跳过了 upsert 的校验，这里校验合并后的记录，失败时整个写入回滚
+	data, err := current.LarkData.Bytes()
+	if err != nil {
+		return err
+	}
+	if normalized, _, err := normalizeLarkStatuses(data); err == nil {
+		data = normalized
+	}
+	if err := validateLarkData(tx, current.InstanceID, data); err != nil {
+		return err
+	}
+	operation, before := HistoryOperationCreate, JSONColumn[LarkApproval]{}
+	if existing := existingByInstance[a.InstanceID]; existing != nil {
+		operation, before = HistoryOperationUpdate, existing.LarkData
+	}
+	if err := applyStatusTransitions(tx, &current, before); err != nil {
+		return err
+	}
+	if err := syncApprovalFormFields(tx, &current, before); err != nil {
+		return err
+	}
+	if historyDisabled(tx) {
+		return nil
+	}
+	h, err := newApprovalHistory(tx, operation, &current, before)
+	if err != nil || h == nil {
+		return err
+	}
+	return saveApprovalHistories(tx, []*ApprovalHistoryM{h})
+}
+
+// newApprovalHistory 生成一条历史，更新前后 lark_data 相同时返回 nil
+//
+// 新建记录的 diff 为 [{"op":"add","path":"","value":...}]，revert_diff 为空数组，created_at 与记录的 created_at 一致。
+func newApprovalHistory(tx *gorm.DB, operation string, a *ApprovalM, beforeData JSONColumn[LarkApproval]) (*ApprovalHistoryM, error) {
+	after, err := a.LarkData.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	before, err := beforeData.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var diff, revert JSONPatch
+	if operation == HistoryOperationCreate {
+		doc, err := decodeJSONDocument(after)
+		if err != nil {
+			return nil, err
+		}
+		if err := appendPatchValue(&diff, PatchOpAdd, "", doc); err != nil {
+			return nil, err
+		}
+		revert = JSONPatch{}
+	} else {
+		if diff, err = DiffJSON(before, after); err != nil {
+			return nil, fmt.Errorf("diff lark_data of %s: %w", a.InstanceID, err)
+		}
+		if len(diff) == 0 {
+			return nil, nil
+		}
+		if revert, err = DiffJSON(after, before); err != nil {
+			return nil, fmt.Errorf("diff lark_data of %s: %w", a.InstanceID, err)
+		}
+	}
+
+	// 加密路径上的值在历史中同样加密
+	if p := fieldEncryptionOf(tx); p != nil {
+		t, scope := reflect.TypeFor[LarkApproval](), historyEncryptionScope(a.TenantID, a.InstanceID)
+		if diff, err = p.sealPatch(tx.Statement.Context, t, diff, scope); err != nil {
+			return nil, err
+		}
+		if revert, err = p.sealPatch(tx.Statement.Context, t, revert, scope); err != nil {
+			return nil, err
+		}
+	}
+	diffJSON, err := json.Marshal(diff)
+	if err != nil {
+		return nil, err
+	}
+	revertJSON, err := json.Marshal(revert)
+	if err != nil {
+		return nil, err
+	}
+	h := &ApprovalHistoryM{
+		TenantID:   a.TenantID,
+		ApprovalID: a.ID,
+		InstanceID: a.InstanceID,
+		Operation:  operation,
+		Actor:      historyActor(tx),
+		Version:    a.Version,
+		Diff:       datatypes.JSON(diffJSON),
+		RevertDiff: datatypes.JSON(revertJSON),
+	}
+	if operation == HistoryOperationCreate {
+		h.CreatedAt = a.CreatedAt
+	}
+	return h, nil
+}
+
+func saveApprovalHistories(tx *gorm.DB, histories []*ApprovalHistoryM) error {
+	if len(histories) == 0 {
+		return nil
+	}
+	if err := tx.Session(&gorm.Session{NewDB: true}).Create(&histories).Error; err != nil {
+		return fmt.Errorf("save approval history: %w", err)
+	}
+	return nil
+}
+
+// FindApprovalHistory 按时间顺序返回 instanceID 的变更历史
+func FindApprovalHistory(db *gorm.DB, instanceID string) ([]*ApprovalHistoryM, error) {
+	var histories []*ApprovalHistoryM
+	err := db.Where("instance_id = ?", instanceID).Order("id").Find(&histories).Error
+	return histories, err
+}
+
+// ApprovalAsOf 重建主键为 approvalID 的审批记录在 at 时刻的状态
+//
+// 从当前记录出发，按时间倒序依次应用 at 之后每条历史的 revert_diff 得到当时的 LarkData；
+// 其他列（Version、UpdatedAt 等）保持当前值，在 at 之后才删除的记录 DeletedAt 会被清空。
+// 历史按 approval_id 查找，同一 instance_id 的软删除记录或其他租户的记录不会混入。
+// 记录的 created_at 晚于 at 时返回 gorm.ErrRecordNotFound，以记录的 created_at 而不是新建历史的时间为准
+// （旧数据中新建历史的时间可能略晚于记录）。未经过 GORM（如直接执行 SQL）的修改不会被记录，重建结果中也不会体现。
+func ApprovalAsOf(db *gorm.DB, approvalID uint64, at time.Time) (*ApprovalM, error) {
+	var a ApprovalM
+	if err := db.Unscoped().First(&a, approvalID).Error; err != nil {
+		return nil, err
+	}
+	if a.CreatedAt.After(at) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var later []*ApprovalHistoryM
+	if err := db.Where("approval_id = ? AND created_at > ?", a.ID, at).Order("id DESC").Find(&later).Error; err != nil {
+		return nil, err
+	}
+	doc, err := a.LarkData.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range later {
+		if h.Operation == HistoryOperationCreate {
+			break
+		}
+		revert, err := ParseJSONPatch(h.RevertDiff)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = revert.Apply(doc); err != nil {
+			return nil, fmt.Errorf("revert history %d of approval %d: %w", h.ID, a.ID, err)
+		}
+	}
+	a.LarkData = RawJSONColumn[LarkApproval](doc)
+	if a.DeletedAt.Valid && a.DeletedAt.Time.After(at) {
+		a.DeletedAt = gorm.DeletedAt{}
+	}
+	return &a, nil
+}
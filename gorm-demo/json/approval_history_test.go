@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// setApprovalStatus 通过 Save 修改审批状态并记录历史
+func setApprovalStatus(t *testing.T, db *gorm.DB, a *ApprovalM, status string) {
+	t.Helper()
+	data, err := a.LarkData.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.Status = status
+	a.LarkData = NewJSONColumn(data)
+	if err := db.Save(a).Error; err != nil {
+		t.Fatalf("set status of %d to %s: %v", a.ID, status, err)
+	}
+}
+
+// tick 返回一个严格晚于之前写入、早于之后写入的时刻
+func tick() time.Time {
+	time.Sleep(5 * time.Millisecond)
+	at := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	return at
+}
+
+func TestApprovalAsOfSameInstanceID(t *testing.T) {
+	db := openTestDB(t)
+
+	// 默认租户中已软删除的 i1
+	deleted := seedApproval(t, db, "i1", LarkApproval{ApprovalName: "old", Status: ApprovalStatusPending})
+	beforeDeletedUpdate := tick()
+	setApprovalStatus(t, db, deleted, ApprovalStatusApproved)
+	if err := db.Delete(deleted).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 默认租户中重新同步的 i1，以及其他租户的 i1
+	current := seedApproval(t, db, "i1", LarkApproval{ApprovalName: "new", Status: ApprovalStatusPending})
+	other := &ApprovalM{TenantID: "tenant-b", InstanceID: "i1", ApprovalCode: "code", Type: ApprovalTypeLark,
+		LarkData: NewJSONColumn(LarkApproval{ApprovalName: "other", Status: ApprovalStatusPending})}
+	if err := db.Create(other).Error; err != nil {
+		t.Fatal(err)
+	}
+	beforeUpdate := tick()
+	setApprovalStatus(t, db, current, ApprovalStatusRejected)
+	setApprovalStatus(t, db, other, ApprovalStatusCanceled)
+
+	tests := []struct {
+		name        string
+		id          uint64
+		at          time.Time
+		wantName    string
+		wantStatus  string
+		wantDeleted bool
+	}{
+		{"deleted before update", deleted.ID, beforeDeletedUpdate, "old", ApprovalStatusPending, false},
+		{"deleted after delete", deleted.ID, beforeUpdate, "old", ApprovalStatusApproved, true},
+		{"current before update", current.ID, beforeUpdate, "new", ApprovalStatusPending, false},
+		{"current now", current.ID, time.Now(), "new", ApprovalStatusRejected, false},
+		{"other tenant before update", other.ID, beforeUpdate, "other", ApprovalStatusPending, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := ApprovalAsOf(db, tt.id, tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := a.LarkData.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data.ApprovalName != tt.wantName || data.Status != tt.wantStatus {
+				t.Errorf("got %s/%s, want %s/%s", data.ApprovalName, data.Status, tt.wantName, tt.wantStatus)
+			}
+			if a.DeletedAt.Valid != tt.wantDeleted {
+				t.Errorf("deleted = %v, want %v", a.DeletedAt.Valid, tt.wantDeleted)
+			}
+		})
+	}
+
+	if _, err := ApprovalAsOf(db, current.ID, beforeDeletedUpdate); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("before create: err = %v, want ErrRecordNotFound", err)
+	}
+}
+
+func TestApprovalAsOfCreatedAt(t *testing.T) {
+	db := openTestDB(t)
+	a := seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	histories, err := FindApprovalHistory(db, "i1")
+	if err != nil || len(histories) != 1 {
+		t.Fatalf("histories = %d, %v", len(histories), err)
+	}
+	if !histories[0].CreatedAt.Equal(a.CreatedAt) {
+		t.Errorf("create history at %v, approval created at %v", histories[0].CreatedAt, a.CreatedAt)
+	}
+
+	check := func(name string) {
+		t.Helper()
+		if got, err := ApprovalAsOf(db, a.ID, a.CreatedAt); err != nil || got.ID != a.ID {
+			t.Errorf("%s: at created_at = %v, %v", name, got, err)
+		}
+		if _, err := ApprovalAsOf(db, a.ID, a.CreatedAt.Add(-time.Nanosecond)); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("%s: before created_at err = %v, want ErrRecordNotFound", name, err)
+		}
+	}
+	check("stamped")
+
+	// 旧数据中新建历史的时间晚于记录的 created_at
+	if err := db.Model(histories[0]).UpdateColumn("created_at", a.CreatedAt.Add(time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	check("late create history")
+}
+
+func TestStatementValueIsPerStatement(t *testing.T) {
+	db := openTestDB(t)
+	tx := db.Model(&ApprovalM{})
+	setStatementValue(tx, historyBeforeKey, "before")
+	if v, ok := statementValue(tx, historyBeforeKey); !ok || v != "before" {
+		t.Fatalf("statementValue = %v, %v", v, ok)
+	}
+	// 派生出的 Statement 复制了 Settings，但不应读到原 Statement 的值
+	if v, ok := statementValue(tx.Session(&gorm.Session{}).Where("id = ?", 1), historyBeforeKey); ok {
+		t.Errorf("derived statement reads %v", v)
+	}
+}
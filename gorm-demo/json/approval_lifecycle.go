@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultPurgeBatchSize ApprovalLifecycle 每批清理的记录数
+const DefaultPurgeBatchSize = 100
+
+// ApprovalDeletedStatuses 飞书审批实例处于这些状态时软删除对应记录
+var ApprovalDeletedStatuses = []string{ApprovalStatusDeleted, ApprovalStatusCanceled}
+
+// ErrApprovalActive 恢复软删除记录时，同一 instance_id 已存在未删除的记录
+var ErrApprovalActive = errors.New("approval with the same instance_id is active")
+
+// softDeletedIDsKey 通过 setStatementValue 在 BeforeDelete/AfterDelete 之间传递将被软删除的记录 id
+const softDeletedIDsKey = "approval:soft_deleted_ids"
+
+// ApprovalArchiveRecord 清理前写入归档文件的一条记录，每行一个 JSON
+type ApprovalArchiveRecord struct {
+	Approval  *ApprovalM          `json:"approval"`
+	DeletedAt time.Time           `json:"deleted_at"`
+	Histories []*ApprovalHistoryM `json:"histories,omitempty"`
+}
+
+// PurgeResult 一次清理的结果
+type PurgeResult struct {
+	Purged      int    // 硬删除的审批记录数
+	ArchiveFile string // 归档文件路径，没有需要清理的记录时为空
+}
+
+// ApprovalLifecycle 审批记录的软删除、恢复和过期清理
+//
+// 生命周期：
+//   - 飞书状态变为 DELETED/CANCELED 时软删除：通过结构体或 map 写入 lark_data 时由钩子设置 deleted_at，
+//     通过 JSONUpdateHelper 等 SQL 表达式写入时由 SoftDeleteByStatus 定期补齐
+//   - Undelete 恢复软删除的记录
+//   - 软删除超过 Retention 的记录先写入 ArchiveDir 下的归档文件，再连同变更历史一起硬删除；
+//     设置了 Indexer 时同时从 ES 索引中删除
+//
+// 软删除和恢复都会重置 is_written_es，ESIndexer 据此从索引中删除或重新写入文档。
+//
+// uk_tenant_instance_id 只约束未删除的记录（见 ddl.sql.tpl 的 0005、0006 迁移），已软删除的实例再次同步时会新建一条记录。
+type ApprovalLifecycle struct {
+	DB         *gorm.DB
+	Retention  time.Duration // 软删除后保留的时长
+	ArchiveDir string        // 归档文件目录
+	BatchSize  int
+	Indexer    *ESIndexer // 非空时清理的记录同时从 ES 中删除，删除失败时不清理
+}
+
+// NewApprovalLifecycle 创建审批生命周期管理
+func NewApprovalLifecycle(db *gorm.DB, retention time.Duration, archiveDir string) *ApprovalLifecycle {
+	return &ApprovalLifecycle{
+		DB:         db,
+		Retention:  retention,
+		ArchiveDir: archiveDir,
+		BatchSize:  DefaultPurgeBatchSize,
+	}
+}
+
+// Run 每隔 interval 执行一次 SoftDeleteByStatus 和 Purge，直到 ctx 结束
+func (l *ApprovalLifecycle) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := l.SoftDeleteByStatus(ctx); err != nil {
+			slog.Error("soft delete approvals by status failed", "error", err.Error())
+		} else if n > 0 {
+			slog.Info("soft delete approvals by status", "deleted", n)
+		}
+		if result, err := l.Purge(ctx); err != nil {
+			slog.Error("purge approvals failed", "purged", result.Purged, "archive", result.ArchiveFile, "error", err.Error())
+		} else if result.Purged > 0 {
+			slog.Info("purge approvals", "purged", result.Purged, "archive", result.ArchiveFile)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// SoftDeleteByStatus 软删除 lark_data.status 为 ApprovalDeletedStatuses 但尚未删除的记录，返回删除的条数
+func (l *ApprovalLifecycle) SoftDeleteByStatus(ctx context.Context) (int64, error) {
+	cond := NewJSONQueryHelper(l.DB).Filter(ApprovalFilter{Statuses: ApprovalDeletedStatuses})
+	result := l.DB.WithContext(ctx).Where(cond).Delete(&ApprovalM{})
+	return result.RowsAffected, result.Error
+}
+
+// Undelete 恢复 instanceID 最近一次软删除的记录
+//
+// 同一 instance_id 已有未删除的记录时返回 ErrApprovalActive；记录的飞书状态仍为 DELETED/CANCELED 时返回错误，
+// 否则会被 SoftDeleteByStatus 再次删除。状态机不允许离开这两个状态，修复状态时需要 WithoutStatusTransitionCheck。
+func (l *ApprovalLifecycle) Undelete(ctx context.Context, instanceID string) error {
+	return l.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var active int64
+		if err := tx.Model(&ApprovalM{}).Where("instance_id = ?", instanceID).Count(&active).Error; err != nil {
+			return err
+		}
+		if active > 0 {
+			return fmt.Errorf("undelete approval %s: %w", instanceID, ErrApprovalActive)
+		}
+
+		var approval ApprovalM
+		err := tx.Unscoped().
+			Where("instance_id = ? AND deleted_at IS NOT NULL", instanceID).
+			Order(clause.OrderByColumn{Column: clause.Column{Name: "deleted_at"}, Desc: true}).
+			First(&approval).Error
+		if err != nil {
+			return fmt.Errorf("undelete approval %s: %w", instanceID, err)
+		}
+		if lark, err := approval.LarkData.Get(); err == nil && slices.Contains(ApprovalDeletedStatuses, lark.Status) {
+			return fmt.Errorf("undelete approval %s: lark status is %s", instanceID, lark.Status)
+		}
+		return tx.Unscoped().Model(&approval).Updates(map[string]any{"deleted_at": nil, "is_written_es": false}).Error
+	})
+}
+
+// Purge 归档并硬删除软删除时间早于 now - Retention 的记录及其变更历史
+//
+// 每批记录先写入归档文件并刷盘，再在同一事务中删除。删除失败时已写入归档的记录仍在库中，
+// 下次清理会再次归档，归档文件中可能出现重复记录。
+func (l *ApprovalLifecycle) Purge(ctx context.Context) (PurgeResult, error) {
+	var result PurgeResult
+	if l.ArchiveDir == "" {
+		return result, fmt.Errorf("purge approvals: archive dir is not set")
+	}
+	batchSize := l.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultPurgeBatchSize
+	}
+	cutoff := time.Now().Add(-l.Retention)
+
+	var (
+		file *os.File
+		enc  *json.Encoder
+	)
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	for {
+		var batch []*ApprovalM
+		err := l.DB.WithContext(ctx).Unscoped().
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+			Order("id").
+			Limit(batchSize).
+			Find(&batch).Error
+		if err != nil {
+			return result, err
+		}
+		if len(batch) == 0 {
+			return result, nil
+		}
+
+		if file == nil {
+			if err := os.MkdirAll(l.ArchiveDir, 0o755); err != nil {
+				return result, fmt.Errorf("create archive dir: %w", err)
+			}
+			name := filepath.Join(l.ArchiveDir, "approval-"+time.Now().Format("20060102T150405")+".ndjson")
+			file, err = os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+			if err != nil {
+				return result, fmt.Errorf("open archive file: %w", err)
+			}
+			enc = json.NewEncoder(file)
+			result.ArchiveFile = name
+		}
+
+		n, err := l.purgeBatch(ctx, batch, file, enc)
+		result.Purged += n
+		if err != nil {
+			return result, err
+		}
+		if len(batch) < batchSize {
+			return result, nil
+		}
+	}
+}
+
+// purgeBatch 归档一批记录后删除
+func (l *ApprovalLifecycle) purgeBatch(ctx context.Context, batch []*ApprovalM, file *os.File, enc *json.Encoder) (int, error) {
+	ids := make([]uint64, 0, len(batch))
+	for _, a := range batch {
+		ids = append(ids, a.ID)
+	}
+	var histories []*ApprovalHistoryM
+	if err := l.DB.WithContext(ctx).Where("approval_id IN ?", ids).Order("id").Find(&histories).Error; err != nil {
+		return 0, fmt.Errorf("load approval histories: %w", err)
+	}
+	byApproval := map[uint64][]*ApprovalHistoryM{}
+	for _, h := range histories {
+		byApproval[h.ApprovalID] = append(byApproval[h.ApprovalID], h)
+	}
+
+	for _, a := range batch {
+		record := ApprovalArchiveRecord{Approval: a, DeletedAt: a.DeletedAt.Time, Histories: byApproval[a.ID]}
+		if err := enc.Encode(record); err != nil {
+			return 0, fmt.Errorf("write archive: %w", err)
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return 0, fmt.Errorf("sync archive: %w", err)
+	}
+
+	var purged []uint64
+	err := l.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 归档之后可能有记录被 Undelete，只删除仍处于软删除状态的记录
+		if err := tx.Unscoped().Model(&ApprovalM{}).Where("id IN ? AND deleted_at IS NOT NULL", ids).Pluck("id", &purged).Error; err != nil {
+			return err
+		}
+		if len(purged) == 0 {
+			return nil
+		}
+		if l.Indexer != nil {
+			docs := make([]*ApprovalM, 0, len(purged))
+			for _, a := range batch {
+				if slices.Contains(purged, a.ID) {
+					docs = append(docs, a)
+				}
+			}
+			if err := l.Indexer.Delete(ctx, docs); err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("approval_id IN ?", purged).Delete(&ApprovalHistoryM{}).Error; err != nil {
+			return err
+		}
+		if formProjectionOf(tx) != nil {
+			if err := tx.Where("approval_id IN ?", purged).Delete(&ApprovalFormFieldM{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Where("id IN ?", purged).Delete(&ApprovalM{}).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("purge approvals: %w", err)
+	}
+	return len(purged), nil
+}
+
+// snapshotBeforeSoftDelete 在 BeforeDelete 中读取将被软删除的记录，硬删除（Unscoped）时不读取
+func snapshotBeforeSoftDelete(tx *gorm.DB) error {
+	if tx.Statement.Unscoped {
+		return nil
+	}
+	var ids []uint64
+	if err := updateTargetQuery(tx).Pluck("id", &ids).Error; err != nil {
+		return fmt.Errorf("load approvals before soft delete: %w", err)
+	}
+	setStatementValue(tx, softDeletedIDsKey, ids)
+	return nil
+}
+
+// requeueSoftDeleted 在 AfterDelete 中重置软删除记录的 is_written_es 并把 version 加 1
+//
+// 软删除只写入 deleted_at，不经过更新钩子；version 加 1 后，删除前已读取该记录的 ESIndexer 不会把它标记为已写入。
+func requeueSoftDeleted(tx *gorm.DB) error {
+	v, _ := statementValue(tx, softDeletedIDsKey)
+	ids, _ := v.([]uint64)
+	if len(ids) == 0 {
+		return nil
+	}
+	err := tx.Session(&gorm.Session{NewDB: true}).Unscoped().Model(&ApprovalM{}).
+		Where("id IN ? AND deleted_at IS NOT NULL", ids).
+		UpdateColumns(map[string]any{"is_written_es": false, "version": incrementVersion()}).Error
+	if err != nil {
+		return fmt.Errorf("requeue soft deleted approvals: %w", err)
+	}
+	return nil
+}
+
+// softDeleteByStatus 在钩子中根据写入的 lark_data.status 设置 deleted_at
+//
+// upsert（ON CONFLICT）时不设置：新记录带着 deleted_at 插入不会与已有记录冲突，
+// 已有记录不会被更新，由 SoftDeleteByStatus 补齐。
+func softDeleteByStatus(tx *gorm.DB, a *ApprovalM) {
+	if _, ok := tx.Statement.Clauses["ON CONFLICT"]; ok {
+		return
+	}
+	data, ok := changedLarkData(tx, a)
+	if !ok || len(data) == 0 {
+		return
+	}
+	var doc struct {
+		Status string `json:"status"`
+	}
+	if json.Unmarshal(data, &doc) != nil {
+		return
+	}
+	// 更新时状态在 AfterUpdate 中才统一为大写
+	if status, _ := ApprovalStatusMachine.Normalize(doc.Status); !slices.Contains(ApprovalDeletedStatuses, status) {
+		return
+	}
+	if a.DeletedAt.Valid {
+		return
+	}
+	tx.Statement.SetColumn("deleted_at", gorm.DeletedAt{Time: time.Now(), Valid: true})
+}
+
+// softDeleteUpdatedByStatus 在 AfterUpdate 中软删除状态变为 DELETED/CANCELED 的记录，a 为更新后读取的记录
+//
+// JSONUpdateHelper 等以 SQL 表达式更新 lark_data 时，BeforeUpdate 中得不到新的状态，按更新后的数据补写 deleted_at，
+// 与 Save、Updates 写入完整文档时的行为一致。
+func softDeleteUpdatedByStatus(tx *gorm.DB, a *ApprovalM) error {
+	if a.DeletedAt.Valid {
+		return nil
+	}
+	data, err := a.LarkData.Bytes()
+	if err != nil || len(data) == 0 {
+		return err
+	}
+	var doc struct {
+		Status string `json:"status"`
+	}
+	if json.Unmarshal(data, &doc) != nil {
+		return nil
+	}
+	if status, _ := ApprovalStatusMachine.Normalize(doc.Status); !slices.Contains(ApprovalDeletedStatuses, status) {
+		return nil
+	}
+	err = tx.Session(&gorm.Session{NewDB: true}).Unscoped().Model(&ApprovalM{}).
+		Where("id = ?", a.ID).UpdateColumn("deleted_at", time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("soft delete approval %s: %w", a.InstanceID, err)
+	}
+	return nil
+}
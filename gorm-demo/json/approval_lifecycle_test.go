@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// deletedInstanceIDs 按 id 顺序返回已软删除的记录的 instance_id
+func deletedInstanceIDs(t *testing.T, db *gorm.DB) []string {
+	t.Helper()
+	var ids []string
+	if err := db.Unscoped().Model(&ApprovalM{}).Where("deleted_at IS NOT NULL").Order("id").Pluck("instance_id", &ids).Error; err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// readArchive 读取归档文件中的全部记录
+func readArchive(t *testing.T, name string) []ApprovalArchiveRecord {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []ApprovalArchiveRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var r ApprovalArchiveRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("decode %s: %v", scanner.Bytes(), err)
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestSoftDeleteByStatus(t *testing.T) {
+	db := openTestDB(t)
+	seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	seedApproval(t, db, "i2", LarkApproval{ApprovalName: "b", Status: ApprovalStatusPending})
+	seedApproval(t, db, "i3", LarkApproval{ApprovalName: "c", Status: ApprovalStatusPending})
+	// 绕过钩子写入状态，模拟没有经过钩子的历史数据
+	for id, status := range map[string]string{"i1": ApprovalStatusDeleted, "i2": ApprovalStatusCanceled} {
+		err := db.Model(&ApprovalM{}).Where("instance_id = ?", id).
+			UpdateColumn("lark_data", NewJSONColumn(LarkApproval{ApprovalName: "x", Status: status})).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := NewApprovalLifecycle(db, 0, t.TempDir())
+	if n, err := l.SoftDeleteByStatus(context.Background()); err != nil || n != 2 {
+		t.Fatalf("soft delete: %d, %v", n, err)
+	}
+	if got := deletedInstanceIDs(t, db); !slices.Equal(got, []string{"i1", "i2"}) {
+		t.Errorf("deleted = %v", got)
+	}
+	if n, err := l.SoftDeleteByStatus(context.Background()); err != nil || n != 0 {
+		t.Errorf("second run: %d, %v", n, err)
+	}
+
+	// 通过钩子写入的状态立即软删除
+	if err := NewJSONUpdateHelper(db).UpdateJSONField("i3", "$.status", ApprovalStatusDeleted); err != nil {
+		t.Fatal(err)
+	}
+	if got := instanceIDs(t, db); len(got) != 0 {
+		t.Errorf("active = %v", got)
+	}
+}
+
+func TestUndelete(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	l := NewApprovalLifecycle(db, 0, t.TempDir())
+
+	if err := l.Undelete(ctx, "i1"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("err = %v, want ErrRecordNotFound", err)
+	}
+
+	// 同一 instance_id 先后软删除两次，恢复最近一次删除的记录
+	first := seedApproval(t, db, "i1", LarkApproval{ApprovalName: "first", Status: ApprovalStatusPending})
+	if err := db.Delete(first).Error; err != nil {
+		t.Fatal(err)
+	}
+	tick()
+	second := seedApproval(t, db, "i1", LarkApproval{ApprovalName: "second", Status: ApprovalStatusPending})
+	if err := db.Delete(second).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Undelete(ctx, "i1"); err != nil {
+		t.Fatal(err)
+	}
+	if data := tenantLarkData(t, db, "", "i1"); data.ApprovalName != "second" {
+		t.Errorf("restored %s, want second", data.ApprovalName)
+	}
+	if err := l.Undelete(ctx, "i1"); !errors.Is(err, ErrApprovalActive) {
+		t.Errorf("err = %v, want ErrApprovalActive", err)
+	}
+
+	// 飞书状态仍为 DELETED 的记录恢复后会被再次删除，拒绝恢复
+	seedApproval(t, db, "i2", LarkApproval{ApprovalName: "c", Status: ApprovalStatusPending})
+	if err := NewJSONUpdateHelper(db).UpdateJSONField("i2", "$.status", ApprovalStatusDeleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Undelete(ctx, "i2"); err == nil {
+		t.Error("undeleted an approval whose lark status is DELETED")
+	}
+	if got := deletedInstanceIDs(t, db); !slices.Equal(got, []string{"i1", "i2"}) {
+		t.Errorf("deleted = %v", got)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	old := seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	setApprovalStatus(t, db, old, ApprovalStatusApproved)
+	recent := seedApproval(t, db, "i2", LarkApproval{ApprovalName: "b", Status: ApprovalStatusPending})
+	seedApproval(t, db, "i3", LarkApproval{ApprovalName: "c", Status: ApprovalStatusPending})
+	if err := db.Delete(old).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(recent).Error; err != nil {
+		t.Fatal(err)
+	}
+	deletedAt := time.Now().Add(-2 * time.Hour).UTC().Truncate(time.Second)
+	if err := db.Unscoped().Model(old).UpdateColumn("deleted_at", deletedAt).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	es := newFakeES(t, nil)
+	l := NewApprovalLifecycle(db, time.Hour, t.TempDir())
+	l.Indexer = NewESIndexer(db, es.URL, "approval")
+
+	// ES 删除失败时不清理
+	es.reject["i1"] = true
+	if result, err := l.Purge(ctx); err == nil || result.Purged != 0 {
+		t.Fatalf("purge = %+v, %v, want error", result, err)
+	}
+	if got := deletedInstanceIDs(t, db); !slices.Equal(got, []string{"i1", "i2"}) {
+		t.Fatalf("deleted = %v after failed purge", got)
+	}
+	delete(es.reject, "i1")
+	es.takeActions()
+	// 失败时已写入的归档不会撤回，换一个目录检查本次的归档
+	l.ArchiveDir = t.TempDir()
+
+	// 只清理软删除超过 Retention 的记录，文档不存在时删除视为成功
+	result, err := l.Purge(ctx)
+	if err != nil || result.Purged != 1 {
+		t.Fatalf("purge = %+v, %v", result, err)
+	}
+	if got := es.takeActions(); !slices.Equal(got, []string{"delete:i1"}) {
+		t.Errorf("es actions = %v", got)
+	}
+	if got := deletedInstanceIDs(t, db); !slices.Equal(got, []string{"i2"}) {
+		t.Errorf("deleted = %v", got)
+	}
+	if histories, err := FindApprovalHistory(db, "i1"); err != nil || len(histories) != 0 {
+		t.Errorf("histories of purged approval = %d, %v", len(histories), err)
+	}
+
+	records := readArchive(t, result.ArchiveFile)
+	if len(records) != 1 {
+		t.Fatalf("archive records = %d", len(records))
+	}
+	r := records[0]
+	if r.Approval.InstanceID != "i1" || !r.DeletedAt.Equal(deletedAt) {
+		t.Errorf("archived %s deleted at %v, want i1 at %v", r.Approval.InstanceID, r.DeletedAt, deletedAt)
+	}
+	if data, err := r.Approval.LarkData.Get(); err != nil || data.Status != ApprovalStatusApproved {
+		t.Errorf("archived lark_data = %+v, %v", data, err)
+	}
+	if len(r.Histories) != 2 || r.Histories[0].Operation != HistoryOperationCreate || r.Histories[1].Operation != HistoryOperationUpdate {
+		t.Errorf("archived histories = %+v", r.Histories)
+	}
+
+	if result, err := l.Purge(ctx); err != nil || result.Purged != 0 || result.ArchiveFile != "" {
+		t.Errorf("second purge = %+v, %v", result, err)
+	}
+	if _, err := NewApprovalLifecycle(db, 0, "").Purge(ctx); err == nil {
+		t.Error("purge without archive dir should fail")
+	}
+}
+
+func TestPurgeSkipsUndeletedAfterArchive(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	a := seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	if err := db.Delete(a).Error; err != nil {
+		t.Fatal(err)
+	}
+	l := NewApprovalLifecycle(db, 0, t.TempDir())
+
+	// 读取并归档之后、删除之前记录被恢复
+	var batch []*ApprovalM
+	if err := db.Unscoped().Where("deleted_at IS NOT NULL").Find(&batch).Error; err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.CreateTemp(l.ArchiveDir, "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := l.Undelete(ctx, "i1"); err != nil {
+		t.Fatal(err)
+	}
+	n, err := l.purgeBatch(ctx, batch, file, json.NewEncoder(file))
+	if err != nil || n != 0 {
+		t.Fatalf("purge batch = %d, %v, want 0", n, err)
+	}
+	if got := instanceIDs(t, db); !slices.Equal(got, []string{"i1"}) {
+		t.Errorf("active = %v", got)
+	}
+	if histories, err := FindApprovalHistory(db, "i1"); err != nil || len(histories) == 0 {
+		t.Errorf("histories = %d, %v", len(histories), err)
+	}
+	// 归档中仍有这条记录，下次清理时可能重复出现
+	if records := readArchive(t, file.Name()); len(records) != 1 {
+		t.Errorf("archive records = %d", len(records))
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ApprovalRepository 审批记录的存储接口，业务代码依赖该接口而不是 *gorm.DB
+//
+// GormApprovalRepository 基于 JSONQueryHelper/JSONUpdateHelper 读写数据库，
+// MemoryApprovalRepository 在内存中以相同的语义求值 ApprovalFilter 和 JSON Patch，用于不需要数据库的单元测试。
+// 两者都以 context 中的租户（见 WithTenant）隔离记录，记录不存在时返回 gorm.ErrRecordNotFound。
+type ApprovalRepository interface {
+	// Get 返回 instanceID 对应的未删除记录
+	Get(ctx context.Context, instanceID string) (*ApprovalM, error)
+	// Upsert 没有未删除的同名记录时创建，否则以 a 替换 approval_code、type、lark_data、dingtalk_data
+	//
+	// a.Version 非零时按乐观锁写入，与存储中的版本不一致（包括记录已不存在）时返回 *StaleApprovalError。
+	// 写入成功后 a 的 ID、TenantID、CreatedAt、UpdatedAt、Version 等与存储一致。
+	Upsert(ctx context.Context, a *ApprovalM) error
+	// Patch 将 RFC 6902 JSON Patch 应用到 lark_data，操作失败时返回 *JSONPatchError，所有操作要么全部生效要么全部不生效
+	Patch(ctx context.Context, instanceID string, patch JSONPatch) error
+	// Search 按 ApprovalQuery 查询未删除的记录
+	Search(ctx context.Context, q ApprovalQuery) ([]*ApprovalM, error)
+	// Delete 软删除 instanceID 对应的记录
+	Delete(ctx context.Context, instanceID string) error
+}
+
+var (
+	_ ApprovalRepository = (*GormApprovalRepository)(nil)
+	_ ApprovalRepository = (*MemoryApprovalRepository)(nil)
+)
+
+// GormApprovalRepository 基于 GORM 的 ApprovalRepository，写入经过 ApprovalM 的钩子和已注册的插件
+type GormApprovalRepository struct {
+	DB *gorm.DB
+}
+
+// NewGormApprovalRepository 创建基于 GORM 的审批存储
+func NewGormApprovalRepository(db *gorm.DB) *GormApprovalRepository {
+	return &GormApprovalRepository{DB: db}
+}
+
+// Get 实现 ApprovalRepository
+func (r *GormApprovalRepository) Get(ctx context.Context, instanceID string) (*ApprovalM, error) {
+	var approval ApprovalM
+	if err := r.DB.WithContext(ctx).Where("instance_id = ?", instanceID).First(&approval).Error; err != nil {
+		return nil, err
+	}
+	return &approval, nil
+}
+
+// Upsert 实现 ApprovalRepository
+//
+// context 中没有租户时按 a.TenantID 匹配已有记录。
+func (r *GormApprovalRepository) Upsert(ctx context.Context, a *ApprovalM) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("instance_id = ?", a.InstanceID)
+		if _, ok := TenantFromContext(ctx); !ok {
+			query = query.Where("tenant_id = ?", a.TenantID)
+		}
+		// SQLite 不支持 FOR UPDATE，写事务本身是串行的
+		if JSONDialectOf(tx).Name() != DialectSQLite {
+			query = query.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate})
+		}
+		var current ApprovalM
+		err := query.First(&current).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if a.Version != 0 {
+				return &StaleApprovalError{InstanceID: a.InstanceID, Version: a.Version}
+			}
+			a.ID = 0
+			return tx.Create(a).Error
+		case err != nil:
+			return err
+		}
+
+		if a.Version != 0 && a.Version != current.Version {
+			return &StaleApprovalError{InstanceID: a.InstanceID, Version: a.Version}
+		}
+		if err := overwriteApproval(tx, &current, a); err != nil {
+			return err
+		}
+		// 重新读取版本号、更新时间等由数据库生成的值，状态为 DELETED/CANCELED 时记录已被钩子软删除
+		var saved ApprovalM
+		if err := tx.Unscoped().First(&saved, current.ID).Error; err != nil {
+			return err
+		}
+		*a = saved
+		return nil
+	})
+}
+
+// Patch 实现 ApprovalRepository
+func (r *GormApprovalRepository) Patch(ctx context.Context, instanceID string, patch JSONPatch) error {
+	return NewJSONUpdateHelper(r.DB.WithContext(ctx)).ApplyJSONPatch(instanceID, patch)
+}
+
+// Search 实现 ApprovalRepository
+func (r *GormApprovalRepository) Search(ctx context.Context, q ApprovalQuery) ([]*ApprovalM, error) {
+	return NewJSONQueryHelper(r.DB).Search(ctx, q)
+}
+
+// Delete 实现 ApprovalRepository
+func (r *GormApprovalRepository) Delete(ctx context.Context, instanceID string) error {
+	result := r.DB.WithContext(ctx).Where("instance_id = ?", instanceID).Delete(&ApprovalM{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MemoryApprovalRepository 内存中的 ApprovalRepository，用于不需要数据库的单元测试
+//
+// 与 GormApprovalRepository 的行为保持一致：
+//   - 按 ApprovalColumnRules 检查 instance_id、approval_code、type 非空，按全局的校验器和校验模式校验 lark_data
+//   - lark_data.status 为 DELETED/CANCELED 的记录视为已删除，对应 ApprovalM 钩子和 SoftDeleteByStatus 的软删除
+//   - 状态统一为大写并按 ApprovalStatusMachine、TaskStatusMachine 检查转移，状态变化先交给 Veto 注册的检查，
+//     写入后通知 Subscribe 的订阅者，与 TransitionPlugin 相同（检查和订阅者中不能再调用同一个存储）
+//   - 以 ApprovalFilter.Match 求值查询条件，排序时缺失的值排在最前，与 MySQL、SQLite 对 NULL 的处理相同
+//   - context 中有租户时只访问该租户的记录，没有租户时 Get 返回 id 最小的同名记录，Patch、Delete 作用于所有租户的同名记录
+//
+// 不记录变更历史，软删除的记录直接丢弃。读写的都是副本，修改返回的记录不会影响存储。
+type MemoryApprovalRepository struct {
+	transitionSubscribers
+
+	mu     sync.RWMutex
+	nextID uint64
+	rows   map[uint64]*ApprovalM
+}
+
+// NewMemoryApprovalRepository 创建空的内存审批存储
+func NewMemoryApprovalRepository() *MemoryApprovalRepository {
+	return &MemoryApprovalRepository{rows: make(map[uint64]*ApprovalM)}
+}
+
+// Get 实现 ApprovalRepository
+func (r *MemoryApprovalRepository) Get(ctx context.Context, instanceID string) (*ApprovalM, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	matches := r.lookup(ctx, instanceID)
+	if len(matches) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return cloneApproval(matches[0])
+}
+
+// Upsert 实现 ApprovalRepository
+func (r *MemoryApprovalRepository) Upsert(ctx context.Context, a *ApprovalM) error {
+	tenantID := a.TenantID
+	if id, ok := TenantFromContext(ctx); ok {
+		if tenantID != "" && tenantID != id {
+			return fmt.Errorf("approval tenant %q: %w", tenantID, ErrTenantMismatch)
+		}
+		tenantID = id
+	}
+	if err := ApprovalColumnRules.checkValues(map[string]any{
+		"instance_id":   a.InstanceID,
+		"approval_code": a.ApprovalCode,
+		"type":          a.Type,
+	}); err != nil {
+		return err
+	}
+	lark, err := a.LarkData.Bytes()
+	if err != nil {
+		return err
+	}
+	if lark, _, err = normalizeLarkStatuses(lark); err != nil {
+		return fmt.Errorf("approval %s: %w", a.InstanceID, err)
+	}
+	if err := checkLarkData(a.InstanceID, lark, nil); err != nil {
+		return err
+	}
+	next, err := cloneApproval(a)
+	if err != nil {
+		return err
+	}
+	next.LarkData = RawJSONColumn[LarkApproval](lark)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var current *ApprovalM
+	for _, row := range r.rows {
+		if row.TenantID == tenantID && row.InstanceID == a.InstanceID {
+			current = row
+			break
+		}
+	}
+	if a.Version != 0 && (current == nil || current.Version != a.Version) {
+		return &StaleApprovalError{InstanceID: a.InstanceID, Version: a.Version}
+	}
+
+	now := time.Now()
+	next.TenantID, next.UpdatedAt, next.IsWrittenES, next.DeletedAt = tenantID, now, false, gorm.DeletedAt{}
+	if current == nil {
+		r.nextID++
+		next.ID, next.Version = r.nextID, 0
+		if next.CreatedAt.IsZero() {
+			next.CreatedAt = now
+		}
+		if !a.UpdatedAt.IsZero() {
+			next.UpdatedAt = a.UpdatedAt
+		}
+	} else {
+		next.ID, next.CreatedAt, next.Version = current.ID, current.CreatedAt, current.Version+1
+	}
+	transitions, err := r.checkTransitions(ctx, current, next, now)
+	if err != nil {
+		return err
+	}
+	r.store(next, now)
+	r.notify(ctx, transitions)
+	saved, err := cloneApproval(next)
+	if err != nil {
+		return err
+	}
+	*a = *saved
+	return nil
+}
+
+// Patch 实现 ApprovalRepository
+func (r *MemoryApprovalRepository) Patch(ctx context.Context, instanceID string, patch JSONPatch) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	matches := r.lookup(ctx, instanceID)
+	if len(matches) == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	if len(patch) == 0 {
+		return nil
+	}
+
+	// 先对所有记录完成修改和校验再写入，保证全部生效或全部不生效
+	patched := make([]*ApprovalM, 0, len(matches))
+	for _, row := range matches {
+		doc, err := row.LarkData.Bytes()
+		if err != nil {
+			return err
+		}
+		if doc, err = patch.Apply(doc); err != nil {
+			return err
+		}
+		if doc, _, err = normalizeLarkStatuses(doc); err != nil {
+			return fmt.Errorf("approval %s: %w", instanceID, err)
+		}
+		if err := checkLarkData(instanceID, doc, nil); err != nil {
+			return err
+		}
+		next := *row
+		next.LarkData = RawJSONColumn[LarkApproval](doc)
+		next.Version++
+		patched = append(patched, &next)
+	}
+	now := time.Now()
+	var transitions []ApprovalTransition
+	for i, next := range patched {
+		changed, err := r.checkTransitions(ctx, matches[i], next, now)
+		if err != nil {
+			return err
+		}
+		transitions = append(transitions, changed...)
+	}
+	for _, next := range patched {
+		next.UpdatedAt, next.IsWrittenES = now, false
+		r.store(next, now)
+	}
+	r.notify(ctx, transitions)
+	return nil
+}
+
+// Search 实现 ApprovalRepository
+func (r *MemoryApprovalRepository) Search(ctx context.Context, q ApprovalQuery) ([]*ApprovalM, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tenantID, scoped := TenantFromContext(ctx)
+
+	type sortable struct {
+		approval *ApprovalM
+		keys     []sortKey
+	}
+	var matched []sortable
+	for _, row := range r.rows {
+		if scoped && row.TenantID != tenantID {
+			continue
+		}
+		data, err := row.LarkData.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		doc, err := decodeJSONDocument(data)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := q.Filter.match(row, doc)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		keys, err := sortKeys(row, doc, q.Sort)
+		if err != nil {
+			return nil, err
+		}
+		matched = append(matched, sortable{approval: row, keys: keys})
+	}
+
+	slices.SortFunc(matched, func(a, b sortable) int {
+		for i, s := range q.Sort {
+			c := a.keys[i].compare(b.keys[i])
+			if s.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(a.approval.ID, b.approval.ID)
+	})
+
+	if q.Offset > 0 {
+		matched = matched[min(q.Offset, len(matched)):]
+	}
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[:q.Limit]
+	}
+	approvals := make([]*ApprovalM, 0, len(matched))
+	for _, m := range matched {
+		a, err := cloneApproval(m.approval)
+		if err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, a)
+	}
+	return approvals, nil
+}
+
+// Delete 实现 ApprovalRepository
+func (r *MemoryApprovalRepository) Delete(ctx context.Context, instanceID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	matches := r.lookup(ctx, instanceID)
+	if len(matches) == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	for _, row := range matches {
+		delete(r.rows, row.ID)
+	}
+	return nil
+}
+
+// lookup 返回 context 中租户可见的 instanceID 记录，按 id 升序
+func (r *MemoryApprovalRepository) lookup(ctx context.Context, instanceID string) []*ApprovalM {
+	tenantID, scoped := TenantFromContext(ctx)
+	var matches []*ApprovalM
+	for _, row := range r.rows {
+		if row.InstanceID == instanceID && (!scoped || row.TenantID == tenantID) {
+			matches = append(matches, row)
+		}
+	}
+	slices.SortFunc(matches, func(a, b *ApprovalM) int { return cmp.Compare(a.ID, b.ID) })
+	return matches
+}
+
+// checkTransitions 检查 current 到 next 的状态转移并调用 Veto 注册的检查，返回写入后需要通知的变化，current 为 nil 表示新建
+func (r *MemoryApprovalRepository) checkTransitions(ctx context.Context, current, next *ApprovalM, now time.Time) ([]ApprovalTransition, error) {
+	var before []byte
+	if current != nil {
+		var err error
+		if before, err = current.LarkData.Bytes(); err != nil {
+			return nil, err
+		}
+	}
+	after, err := next.LarkData.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	transitions, err := diffLarkStatuses(next.InstanceID, before, after, true)
+	if err != nil {
+		return nil, err
+	}
+	for i := range transitions {
+		transitions[i].TenantID, transitions[i].Version, transitions[i].At = next.TenantID, next.Version, now
+	}
+	if err := r.check(ctx, transitions); err != nil {
+		return nil, err
+	}
+	return transitions, nil
+}
+
+// store 保存记录，状态为 DELETED/CANCELED 时与钩子一样软删除，即从存储中移除
+func (r *MemoryApprovalRepository) store(a *ApprovalM, now time.Time) {
+	if lark, err := a.LarkData.Get(); err == nil && slices.Contains(ApprovalDeletedStatuses, lark.Status) {
+		a.DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+		delete(r.rows, a.ID)
+		return
+	}
+	r.rows[a.ID] = a
+}
+
+// cloneApproval 复制记录，JSON 列复制原始 JSON，避免与调用方共享底层数组
+func cloneApproval(a *ApprovalM) (*ApprovalM, error) {
+	lark, err := a.LarkData.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	c := *a
+	c.LarkData = RawJSONColumn[LarkApproval](lark)
+	c.DingTalkData = bytes.Clone(a.DingTalkData)
+	return &c, nil
+}
+
+// sortKey 一个排序字段的值，null 表示 SQL 中的 NULL
+type sortKey struct {
+	null   bool
+	number float64
+	text   string
+	time   time.Time
+}
+
+// compare NULL 小于任何值
+func (k sortKey) compare(o sortKey) int {
+	switch {
+	case k.null || o.null:
+		return cmp.Compare(boolRank(!k.null), boolRank(!o.null))
+	case !k.time.IsZero() || !o.time.IsZero():
+		return k.time.Compare(o.time)
+	case k.text != "" || o.text != "":
+		return cmp.Compare(k.text, o.text)
+	default:
+		return cmp.Compare(k.number, o.number)
+	}
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// sortKeys 按 orderBy 的规则取出记录的排序值
+func sortKeys(a *ApprovalM, doc any, sorts []ApprovalSort) ([]sortKey, error) {
+	keys := make([]sortKey, len(sorts))
+	for i, s := range sorts {
+		switch s.Field {
+		case SortByID:
+			keys[i] = sortKey{number: float64(a.ID)}
+		case SortByCreatedAt:
+			keys[i] = sortKey{time: a.CreatedAt}
+		case SortByUpdatedAt:
+			keys[i] = sortKey{time: a.UpdatedAt}
+		case SortByStartTime, SortByEndTime:
+			n, ok := jsonScalarNumber(lookupJSONPath(doc, Path(string(s.Field))))
+			keys[i] = sortKey{null: !ok, number: n}
+		case SortByApprovalName:
+			text, ok := jsonScalarText(lookupJSONPath(doc, Path("approval_name")))
+			keys[i] = sortKey{null: !ok, text: text}
+		default:
+			return nil, fmt.Errorf("unsupported sort field %q", s.Field)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestGormApprovalRepository(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Use(NewTenantPlugin(ApprovalTenantTables...)); err != nil {
+		t.Fatal(err)
+	}
+	runRepositoryConformance(t, NewGormApprovalRepository(db))
+}
+
+func TestMemoryApprovalRepository(t *testing.T) {
+	runRepositoryConformance(t, NewMemoryApprovalRepository())
+}
+
+// newRepositoryApproval 创建用于 Upsert 的飞书审批记录
+func newRepositoryApproval(instanceID string, data LarkApproval) *ApprovalM {
+	return &ApprovalM{InstanceID: instanceID, ApprovalCode: "code", Type: ApprovalTypeLark, LarkData: NewJSONColumn(data)}
+}
+
+// larkDataOf 读取 instanceID 的审批数据
+func larkDataOf(t *testing.T, ctx context.Context, repo ApprovalRepository, instanceID string) (*ApprovalM, LarkApproval) {
+	t.Helper()
+	a, err := repo.Get(ctx, instanceID)
+	if err != nil {
+		t.Fatalf("get %s: %v", instanceID, err)
+	}
+	data, err := a.LarkData.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, data
+}
+
+// runRepositoryConformance 检查 ApprovalRepository 实现的公共语义，GORM 和内存实现必须得到相同的结果
+func runRepositoryConformance(t *testing.T, repo ApprovalRepository) {
+	ctx := t.Context()
+
+	t.Run("missing", func(t *testing.T) {
+		if _, err := repo.Get(ctx, "missing"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("Get: err = %v", err)
+		}
+		if err := repo.Patch(ctx, "missing", JSONPatch{{Op: "remove", Path: "/form"}}); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("Patch: err = %v", err)
+		}
+		if err := repo.Delete(ctx, "missing"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("Delete: err = %v", err)
+		}
+		var stale *StaleApprovalError
+		a := newRepositoryApproval("missing", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+		a.Version = 3
+		if err := repo.Upsert(ctx, a); !errors.As(err, &stale) {
+			t.Errorf("Upsert with version: err = %v, want StaleApprovalError", err)
+		}
+	})
+
+	seeds := []*ApprovalM{
+		newRepositoryApproval("i1", LarkApproval{
+			ApprovalName: "差旅报销",
+			Status:       ApprovalStatusPending,
+			StartTime:    "1700000000000",
+			TaskList: []*InstanceTask{
+				{ID: "t1", UserID: "zhangsan", Status: ApprovalStatusApproved},
+				{ID: "t2", UserID: "lisi", Status: ApprovalStatusPending},
+			},
+		}),
+		newRepositoryApproval("i2", LarkApproval{
+			ApprovalName: "采购申请",
+			Status:       ApprovalStatusApproved,
+			StartTime:    "1700000200000",
+			TaskList:     []*InstanceTask{{ID: "t3", UserID: "lisi", Status: ApprovalStatusApproved}},
+		}),
+		newRepositoryApproval("i3", LarkApproval{ApprovalName: "请假", Status: ApprovalStatusRejected}),
+	}
+	t.Run("Upsert create", func(t *testing.T) {
+		for _, a := range seeds {
+			if err := repo.Upsert(ctx, a); err != nil {
+				t.Fatal(err)
+			}
+			if a.ID == 0 || a.Version != 0 || a.CreatedAt.IsZero() {
+				t.Errorf("%s: id=%d version=%d created_at=%v", a.InstanceID, a.ID, a.Version, a.CreatedAt)
+			}
+		}
+		// 状态统一为大写
+		lower := newRepositoryApproval("i4", LarkApproval{ApprovalName: "用车", Status: "pending"})
+		if err := repo.Upsert(ctx, lower); err != nil {
+			t.Fatal(err)
+		}
+		if _, data := larkDataOf(t, ctx, repo, "i4"); data.Status != ApprovalStatusPending {
+			t.Errorf("status = %s, want PENDING", data.Status)
+		}
+	})
+
+	t.Run("Upsert replace", func(t *testing.T) {
+		current, _ := larkDataOf(t, ctx, repo, "i2")
+		next := newRepositoryApproval("i2", LarkApproval{ApprovalName: "采购申请", Status: ApprovalStatusApproved, StartTime: "1700000200000",
+			TaskList: []*InstanceTask{{ID: "t3", UserID: "lisi", Status: ApprovalStatusApproved}}})
+		next.ApprovalCode, next.Version = "code2", current.Version
+		if err := repo.Upsert(ctx, next); err != nil {
+			t.Fatal(err)
+		}
+		if next.ID != current.ID || next.Version != current.Version+1 || next.ApprovalCode != "code2" {
+			t.Errorf("id=%d version=%d code=%s, want id=%d version=%d code2", next.ID, next.Version, next.ApprovalCode, current.ID, current.Version+1)
+		}
+
+		// 以当前版本再写入一次，旧版本的写入被拒绝
+		if err := repo.Upsert(ctx, next); err != nil {
+			t.Fatal(err)
+		}
+		var stale *StaleApprovalError
+		next.Version = current.Version + 1
+		if err := repo.Upsert(ctx, next); !errors.As(err, &stale) {
+			t.Errorf("stale upsert: err = %v, want StaleApprovalError", err)
+		}
+	})
+
+	t.Run("Upsert invalid", func(t *testing.T) {
+		var ruleErr *ColumnRuleError
+		noCode := newRepositoryApproval("i9", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+		noCode.ApprovalCode = ""
+		if err := repo.Upsert(ctx, noCode); !errors.As(err, &ruleErr) || ruleErr.Column != "approval_code" {
+			t.Errorf("empty approval_code: err = %v", err)
+		}
+		var schemaErr *SchemaValidationError
+		noName := newRepositoryApproval("i9", LarkApproval{})
+		noName.LarkData = RawJSONColumn[LarkApproval]([]byte(`{"status":"PENDING"}`))
+		if err := repo.Upsert(ctx, noName); !errors.As(err, &schemaErr) {
+			t.Errorf("missing approval_name: err = %v", err)
+		}
+		if _, err := repo.Get(ctx, "i9"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("invalid approval stored: %v", err)
+		}
+	})
+
+	t.Run("Patch", func(t *testing.T) {
+		before, _ := larkDataOf(t, ctx, repo, "i1")
+		if err := repo.Patch(ctx, "i1", JSONPatch{{Op: "replace", Path: "/approval_name", Value: []byte(`"出差报销"`)}}); err != nil {
+			t.Fatal(err)
+		}
+		after, data := larkDataOf(t, ctx, repo, "i1")
+		if data.ApprovalName != "出差报销" || after.Version != before.Version+1 {
+			t.Errorf("name=%s version=%d, want 出差报销 version=%d", data.ApprovalName, after.Version, before.Version+1)
+		}
+
+		// 任意一条操作失败时全部不生效
+		var patchErr *JSONPatchError
+		err := repo.Patch(ctx, "i1", JSONPatch{
+			{Op: "replace", Path: "/approval_name", Value: []byte(`"改名"`)},
+			{Op: "test", Path: "/status", Value: []byte(`"APPROVED"`)},
+		})
+		if !errors.As(err, &patchErr) || !errors.Is(err, ErrPatchTestFailed) {
+			t.Fatalf("err = %v, want test failed", err)
+		}
+		if _, data := larkDataOf(t, ctx, repo, "i1"); data.ApprovalName != "出差报销" {
+			t.Errorf("name = %s after failed patch", data.ApprovalName)
+		}
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		ms := func(n int64) TimeRange { return TimeRange{From: time.UnixMilli(n)} }
+		tests := []struct {
+			name string
+			q    ApprovalQuery
+			want []string
+		}{
+			{"all", ApprovalQuery{}, []string{"i1", "i2", "i3", "i4"}},
+			{"approval_code", ApprovalQuery{Filter: ApprovalFilter{ApprovalCode: "code2"}}, []string{"i2"}},
+			{"status IN", ApprovalQuery{Filter: ApprovalFilter{Statuses: []string{ApprovalStatusPending, ApprovalStatusRejected}}}, []string{"i1", "i3", "i4"}},
+			{"name LIKE", ApprovalQuery{Filter: ApprovalFilter{ApprovalNameLike: "%报销"}}, []string{"i1"}},
+			{"name LIKE underscore", ApprovalQuery{Filter: ApprovalFilter{ApprovalNameLike: "_假"}}, []string{"i3"}},
+			{"start_time", ApprovalQuery{Filter: ApprovalFilter{StartTime: ms(1700000100000)}}, []string{"i2"}},
+			{"task same element", ApprovalQuery{Filter: ApprovalFilter{Task: &TaskMatch{UserID: "lisi", Status: ApprovalStatusPending}}}, []string{"i1"}},
+			{"task across elements", ApprovalQuery{Filter: ApprovalFilter{Task: &TaskMatch{UserID: "zhangsan", Status: ApprovalStatusPending}}}, nil},
+			{"task any", ApprovalQuery{Filter: ApprovalFilter{Task: &TaskMatch{}}}, []string{"i1", "i2"}},
+			{"or", ApprovalQuery{Filter: ApprovalFilter{Or: []ApprovalFilter{
+				{Task: &TaskMatch{UserID: "zhangsan"}},
+				{ApprovalName: "请假"},
+			}}}, []string{"i1", "i3"}},
+			// 缺失的 start_time 排在最前
+			{"sort", ApprovalQuery{Sort: []ApprovalSort{{Field: SortByStartTime, Desc: true}}}, []string{"i2", "i1", "i3", "i4"}},
+			{"sort asc", ApprovalQuery{Sort: []ApprovalSort{{Field: SortByStartTime}}}, []string{"i3", "i4", "i1", "i2"}},
+			{"limit offset", ApprovalQuery{Sort: []ApprovalSort{{Field: SortByApprovalName}}, Limit: 2, Offset: 1}, []string{"i4", "i3"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				approvals, err := repo.Search(ctx, tt.q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, a := range approvals {
+					got = append(got, a.InstanceID)
+				}
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+			})
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := repo.Delete(ctx, "i3"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.Get(ctx, "i3"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("Get deleted: err = %v", err)
+		}
+		if err := repo.Delete(ctx, "i3"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("Delete twice: err = %v", err)
+		}
+
+		// 状态变为 CANCELED 时软删除，同名实例可以重新创建
+		if err := repo.Patch(ctx, "i4", JSONPatch{{Op: "replace", Path: "/status", Value: []byte(`"CANCELED"`)}}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.Get(ctx, "i4"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("Get canceled: err = %v", err)
+		}
+		again := newRepositoryApproval("i4", LarkApproval{ApprovalName: "用车", Status: ApprovalStatusPending})
+		if err := repo.Upsert(ctx, again); err != nil {
+			t.Fatal(err)
+		}
+		if again.Version != 0 {
+			t.Errorf("recreated version = %d, want 0", again.Version)
+		}
+	})
+
+	t.Run("tenant", func(t *testing.T) {
+		tenantCtx := WithTenant(ctx, "tenant-a")
+		a := newRepositoryApproval("i1", LarkApproval{ApprovalName: "租户报销", Status: ApprovalStatusPending})
+		if err := repo.Upsert(tenantCtx, a); err != nil {
+			t.Fatal(err)
+		}
+		if a.TenantID != "tenant-a" || a.Version != 0 {
+			t.Errorf("tenant=%q version=%d, want a new record of tenant-a", a.TenantID, a.Version)
+		}
+		if _, data := larkDataOf(t, tenantCtx, repo, "i1"); data.ApprovalName != "租户报销" {
+			t.Errorf("tenant-a i1 = %s", data.ApprovalName)
+		}
+		approvals, err := repo.Search(tenantCtx, ApprovalQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(approvals) != 1 || approvals[0].TenantID != "tenant-a" {
+			t.Errorf("tenant-a search = %v", tenantsOf(approvals))
+		}
+		other := newRepositoryApproval("i5", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+		other.TenantID = "tenant-b"
+		if err := repo.Upsert(tenantCtx, other); !errors.Is(err, ErrTenantMismatch) {
+			t.Errorf("other tenant: err = %v, want ErrTenantMismatch", err)
+		}
+
+		if err := repo.Delete(tenantCtx, "i1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, data := larkDataOf(t, ctx, repo, "i1"); data.ApprovalName != "出差报销" {
+			t.Errorf("default tenant i1 = %s after tenant-a delete", data.ApprovalName)
+		}
+	})
+}
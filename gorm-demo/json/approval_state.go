@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// StatusMachine 状态机，Transitions 为每个状态允许转移到的状态
+//
+// 状态不区分大小写，写入时统一为大写。状态不变、从空状态（新建或缺失）转移到任意已知状态总是允许的，
+// 写入空状态不视为状态变化。
+type StatusMachine struct {
+	Name        string
+	Transitions map[string][]string
+}
+
+// ApprovalStatusMachine 飞书审批实例状态：审批中的实例可以通过、拒绝、撤回或删除，已通过的实例可以被撤销，结束的实例可以删除
+var ApprovalStatusMachine = StatusMachine{
+	Name: "approval",
+	Transitions: map[string][]string{
+		ApprovalStatusPending:  {ApprovalStatusApproved, ApprovalStatusRejected, ApprovalStatusCanceled, ApprovalStatusDeleted},
+		ApprovalStatusApproved: {ApprovalStatusCanceled, ApprovalStatusDeleted},
+		ApprovalStatusRejected: {ApprovalStatusDeleted},
+		ApprovalStatusCanceled: {ApprovalStatusDeleted},
+		ApprovalStatusDeleted:  nil,
+	},
+}
+
+// TaskStatusMachine 飞书审批任务状态：进行中的任务可以通过、拒绝、转交或完成，其余状态为终态
+var TaskStatusMachine = StatusMachine{
+	Name: "task",
+	Transitions: map[string][]string{
+		TaskStatusPending:     {TaskStatusApproved, TaskStatusRejected, TaskStatusTransferred, TaskStatusDone},
+		TaskStatusApproved:    nil,
+		TaskStatusRejected:    nil,
+		TaskStatusTransferred: nil,
+		TaskStatusDone:        nil,
+	},
+}
+
+var (
+	// ErrUnknownStatus 状态不在状态机中
+	ErrUnknownStatus = errors.New("unknown status")
+	// ErrIllegalTransition 状态机不允许的状态转移
+	ErrIllegalTransition = errors.New("illegal status transition")
+)
+
+// IllegalTransitionError 非法状态转移的详细信息，可通过 errors.Is(err, ErrIllegalTransition) 判断
+type IllegalTransitionError struct {
+	Machine    string // approval 或 task
+	InstanceID string
+	TaskID     string // 任务状态转移时为任务 ID
+	From       string
+	To         string
+}
+
+func (e *IllegalTransitionError) Error() string {
+	target := e.InstanceID
+	if e.TaskID != "" {
+		target += " task " + e.TaskID
+	}
+	return fmt.Sprintf("approval %s: illegal %s status transition %s -> %s", target, e.Machine, e.From, e.To)
+}
+
+// Is 使 errors.Is(err, ErrIllegalTransition) 成立
+func (e *IllegalTransitionError) Is(target error) bool {
+	return target == ErrIllegalTransition
+}
+
+// Normalize 返回大写的状态，空状态原样返回，状态不在状态机中时返回 ErrUnknownStatus
+func (m StatusMachine) Normalize(status string) (string, error) {
+	if status == "" {
+		return "", nil
+	}
+	normalized := strings.ToUpper(strings.TrimSpace(status))
+	if _, ok := m.Transitions[normalized]; !ok {
+		return status, fmt.Errorf("%s status %q: %w", m.Name, status, ErrUnknownStatus)
+	}
+	return normalized, nil
+}
+
+// CanTransition from 是否可以转移到 to，两者都应是 Normalize 之后的状态
+func (m StatusMachine) CanTransition(from, to string) bool {
+	if from == to || to == "" {
+		return true
+	}
+	if _, ok := m.Transitions[to]; !ok {
+		return false
+	}
+	return from == "" || slices.Contains(m.Transitions[from], to)
+}
+
+// ApprovalTransition 一次状态变化，TaskID 为空时是审批实例的状态，否则是该任务的状态
+type ApprovalTransition struct {
+	TenantID   string
+	InstanceID string
+	TaskID     string
+	From       string // 新建时为空
+	To         string
+	Version    uint64 // 变化后的版本号
+	Actor      string // 操作人，见 WithApprovalActor
+	At         time.Time
+}
+
+// transitionCheckDisabledKey 通过 db.Set 为单次操作关闭状态转移检查
+const transitionCheckDisabledKey = "approval:transition_check_disabled"
+
+// WithoutStatusTransitionCheck 本次操作不检查状态转移是否合法，如导入或修复历史数据；状态仍会统一为大写，订阅者仍会收到通知
+func WithoutStatusTransitionCheck(db *gorm.DB) *gorm.DB {
+	return db.Set(transitionCheckDisabledKey, true)
+}
+
+func transitionCheckDisabled(tx *gorm.DB) bool {
+	v, ok := tx.Get(transitionCheckDisabledKey)
+	disabled, _ := v.(bool)
+	return ok && disabled
+}
+
+// larkStatuses lark_data 中审批实例和各任务的状态
+type larkStatuses struct {
+	status string
+	tasks  map[string]string // 任务 ID（没有 ID 时为 #下标）-> 状态
+	order  []string
+}
+
+// normalizeLarkStatuses 将 lark_data 中审批实例和任务的状态统一为大写，返回是否有修改
+//
+// 文档按通用 JSON 解码，LarkApproval 未声明的字段会原样保留；状态机中没有的状态保持原样，由 diffLarkStatuses 检查。
+func normalizeLarkStatuses(data []byte) ([]byte, bool, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return data, false, nil
+	}
+	doc, err := decodeJSONDocument(data)
+	if err != nil {
+		return nil, false, err
+	}
+	root, ok := doc.(map[string]any)
+	if !ok {
+		return data, false, nil
+	}
+
+	changed := false
+	normalize := func(obj map[string]any, m StatusMachine) {
+		status, ok := obj["status"].(string)
+		if !ok {
+			return
+		}
+		if normalized, err := m.Normalize(status); err == nil && normalized != status {
+			obj["status"], changed = normalized, true
+		}
+	}
+	normalize(root, ApprovalStatusMachine)
+	tasks, _ := root["task_list"].([]any)
+	for _, task := range tasks {
+		if obj, ok := task.(map[string]any); ok {
+			normalize(obj, TaskStatusMachine)
+		}
+	}
+	if !changed {
+		return data, false, nil
+	}
+	normalized, err := json.Marshal(root)
+	return normalized, err == nil, err
+}
+
+// readLarkStatuses 读取 lark_data 中的状态，已知状态统一为大写，写入前的旧数据可能尚未统一
+func readLarkStatuses(data []byte) (larkStatuses, error) {
+	var doc struct {
+		Status   string `json:"status"`
+		TaskList []*struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+		} `json:"task_list"`
+	}
+	statuses := larkStatuses{tasks: map[string]string{}}
+	if len(data) == 0 {
+		return statuses, nil
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return statuses, fmt.Errorf("decode lark_data statuses: %w", err)
+	}
+	statuses.status = normalizeStatus(ApprovalStatusMachine, doc.Status)
+	for i, task := range doc.TaskList {
+		if task == nil {
+			continue
+		}
+		key := task.ID
+		if key == "" {
+			key = fmt.Sprintf("#%d", i)
+		}
+		if _, ok := statuses.tasks[key]; !ok {
+			statuses.order = append(statuses.order, key)
+		}
+		statuses.tasks[key] = normalizeStatus(TaskStatusMachine, task.Status)
+	}
+	return statuses, nil
+}
+
+// normalizeStatus 同 Normalize，未知状态原样返回
+func normalizeStatus(m StatusMachine, status string) string {
+	normalized, _ := m.Normalize(status)
+	return normalized
+}
+
+// diffLarkStatuses 比较写入前后的状态，返回发生的状态变化
+//
+// check 为 true 时，写入状态机中没有的状态返回 ErrUnknownStatus，非法转移返回 *IllegalTransitionError；
+// 状态没有变化时不检查，已有的未知状态不影响其他字段的更新。
+func diffLarkStatuses(instanceID string, before, after []byte, check bool) ([]ApprovalTransition, error) {
+	from, err := readLarkStatuses(before)
+	if err != nil {
+		return nil, err
+	}
+	to, err := readLarkStatuses(after)
+	if err != nil {
+		return nil, err
+	}
+
+	var transitions []ApprovalTransition
+	add := func(m StatusMachine, taskID, from, to string) error {
+		if from == to || to == "" {
+			return nil
+		}
+		if _, known := m.Transitions[to]; check && !known {
+			if taskID != "" {
+				return fmt.Errorf("approval %s task %s: %s status %q: %w", instanceID, taskID, m.Name, to, ErrUnknownStatus)
+			}
+			return fmt.Errorf("approval %s: %s status %q: %w", instanceID, m.Name, to, ErrUnknownStatus)
+		}
+		if check && !m.CanTransition(from, to) {
+			return &IllegalTransitionError{Machine: m.Name, InstanceID: instanceID, TaskID: taskID, From: from, To: to}
+		}
+		transitions = append(transitions, ApprovalTransition{InstanceID: instanceID, TaskID: taskID, From: from, To: to})
+		return nil
+	}
+	if err := add(ApprovalStatusMachine, "", from.status, to.status); err != nil {
+		return nil, err
+	}
+	for _, key := range to.order {
+		if err := add(TaskStatusMachine, key, from.tasks[key], to.tasks[key]); err != nil {
+			return nil, err
+		}
+	}
+	return transitions, nil
+}
+
+// normalizeApprovalStatuses 在 BeforeCreate 中将新记录的状态统一为大写，状态是否合法在 AfterCreate 中检查
+func normalizeApprovalStatuses(a *ApprovalM) error {
+	data, err := a.LarkData.Bytes()
+	if err != nil {
+		return err
+	}
+	normalized, changed, err := normalizeLarkStatuses(data)
+	if err != nil {
+		return fmt.Errorf("approval %s: %w", a.InstanceID, err)
+	}
+	if changed {
+		a.LarkData = RawJSONColumn[LarkApproval](normalized)
+	}
+	return nil
+}
+
+// applyStatusTransitions 在 AfterCreate/AfterUpdate 中处理一条记录的状态变化
+//
+// JSONUpdateHelper 等通过 SQL 表达式写入时写入前无法得到新值，因此以写入后从数据库读取的 after 为准：
+// 状态大小写不统一、或表达式在加密路径上写入了明文（见 FieldEncryptionPlugin）时就地改写（不增加版本号），
+// 再与写入前的 before 比较，非法转移返回错误使写入回滚，合法的变化交给 TransitionPlugin（见 enqueue）。after.LarkData 会被替换为统一后的文档。
+func applyStatusTransitions(tx *gorm.DB, after *ApprovalM, before JSONColumn[LarkApproval]) error {
+	data, err := after.LarkData.Bytes()
+	if err != nil {
+		return err
+	}
+	normalized, changed, err := normalizeLarkStatuses(data)
+	if err != nil {
+		return fmt.Errorf("approval %s: %w", after.InstanceID, err)
+	}
+	if changed || after.LarkData.needsReencryption() {
+		// Model 带上 tenant_id、instance_id，重新加密时密文绑定到该记录
+		model := &ApprovalM{ID: after.ID, TenantID: after.TenantID, InstanceID: after.InstanceID}
+		if err := tx.Session(&gorm.Session{NewDB: true}).Unscoped().Model(model).
+			UpdateColumn("lark_data", RawJSONColumn[LarkApproval](normalized)).Error; err != nil {
+			return fmt.Errorf("rewrite lark_data of approval %s: %w", after.InstanceID, err)
+		}
+		after.LarkData = RawJSONColumn[LarkApproval](normalized)
+	}
+
+	beforeData, err := before.Bytes()
+	if err != nil {
+		return err
+	}
+	transitions, err := diffLarkStatuses(after.InstanceID, beforeData, normalized, !transitionCheckDisabled(tx))
+	if err != nil {
+		return err
+	}
+	now, actor := time.Now(), historyActor(tx)
+	for i := range transitions {
+		transitions[i].TenantID, transitions[i].Version = after.TenantID, after.Version
+		transitions[i].Actor, transitions[i].At = actor, now
+	}
+	if p := transitionPluginOf(tx); p != nil {
+		return p.enqueue(tx, transitions)
+	}
+	return nil
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// DefaultTransferBatchSize 导入导出时每批读写的记录数
+	DefaultTransferBatchSize = 500
+	// ApprovalImportActor 导入写入的审批历史的操作人
+	ApprovalImportActor = "import"
+)
+
+// ConflictPolicy 导入的记录与库中未删除记录的 instance_id 相同时的处理方式
+type ConflictPolicy string
+
+const (
+	ConflictSkip      ConflictPolicy = "skip"      // 保留库中的记录（默认）
+	ConflictOverwrite ConflictPolicy = "overwrite" // 以导入的记录整体替换 approval_code、type、lark_data 和 dingtalk_data
+	ConflictMerge     ConflictPolicy = "merge"     // 以 JSON Merge Patch 合并 lark_data 和 dingtalk_data，导入中的非空 approval_code、type 覆盖库中的值
+)
+
+// ParseConflictPolicy 解析命令行等处传入的冲突策略，空字符串为 ConflictSkip
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case "":
+		return ConflictSkip, nil
+	case ConflictSkip, ConflictOverwrite, ConflictMerge:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy %q", s)
+	}
+}
+
+// ImportOptions 导入参数
+type ImportOptions struct {
+	Policy    ConflictPolicy
+	BatchSize int  // 每批插入的记录数，为 0 时使用 DefaultTransferBatchSize
+	DryRun    bool // 只解析、校验并统计将要新建、更新和跳过的记录数，不写入数据库
+}
+
+// ImportError 一行导入数据无法解析或未通过校验
+type ImportError struct {
+	Line       int // 从 1 开始的行号
+	InstanceID string
+	Err        error
+}
+
+func (e *ImportError) Error() string {
+	if e.InstanceID == "" {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d (%s): %v", e.Line, e.InstanceID, e.Err)
+}
+
+func (e *ImportError) Unwrap() error { return e.Err }
+
+// ImportResult 一次导入的结果，DryRun 时为将要执行的操作
+type ImportResult struct {
+	Read    int // 读取的记录数，不含空行
+	Created int
+	Updated int
+	Skipped int            // 因 ConflictSkip 跳过的记录数
+	Invalid []*ImportError // 无法解析或未通过校验而未导入的记录
+}
+
+// ApprovalTransfer 审批记录的批量导入导出
+//
+// NDJSON 每行一个 ApprovalM 的 JSON，包含完整的 lark_data，可原样导入；CSV 把选定的 lark_data 路径展开为列，只用于导出。
+// context 中有租户（见 WithTenant）时只导出该租户的记录，导入的记录写入该租户。
+// 导出不包含已软删除的记录。导入时 id、version、is_written_es 不会沿用，created_at、updated_at 非零时保留。
+type ApprovalTransfer struct {
+	DB        *gorm.DB
+	BatchSize int // 导出时每次从数据库读取的记录数
+}
+
+// NewApprovalTransfer 创建导入导出工具
+func NewApprovalTransfer(db *gorm.DB) *ApprovalTransfer {
+	return &ApprovalTransfer{DB: db, BatchSize: DefaultTransferBatchSize}
+}
+
+// ExportNDJSON 按 id 顺序把满足条件的记录逐行写入 w，返回写入的条数
+func (t *ApprovalTransfer) ExportNDJSON(ctx context.Context, w io.Writer, conds ...clause.Expression) (int, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	n := 0
+	for approval, err := range NewJSONQueryHelper(t.DB).Stream(ctx, t.BatchSize, conds...) {
+		if err != nil {
+			return n, err
+		}
+		if err := enc.Encode(approval); err != nil {
+			return n, fmt.Errorf("encode approval %s: %w", approval.InstanceID, err)
+		}
+		n++
+	}
+	return n, bw.Flush()
+}
+
+// ExportCSV 按 id 顺序把满足条件的记录写入 CSV，返回写入的记录数
+//
+// 前五列为 tenant_id、instance_id、approval_code、type、created_at（RFC 3339），之后每个 path 一列，表头为路径字符串。
+// 字符串和数字写入原值，对象和数组写入紧凑的 JSON，路径不存在或值为 null 时为空。
+func (t *ApprovalTransfer) ExportCSV(ctx context.Context, w io.Writer, paths []JSONPath, conds ...clause.Expression) (int, error) {
+	for _, p := range paths {
+		if err := p.Err(); err != nil {
+			return 0, err
+		}
+	}
+	cw := csv.NewWriter(w)
+	header := []string{"tenant_id", "instance_id", "approval_code", "type", "created_at"}
+	for _, p := range paths {
+		header = append(header, p.String())
+	}
+	if err := cw.Write(header); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for approval, err := range NewJSONQueryHelper(t.DB).Stream(ctx, t.BatchSize, conds...) {
+		if err != nil {
+			return n, err
+		}
+		data, err := approval.LarkData.Bytes()
+		if err != nil {
+			return n, err
+		}
+		doc, err := decodeJSONDocument(data)
+		if err != nil {
+			return n, fmt.Errorf("decode lark_data of %s: %w", approval.InstanceID, err)
+		}
+		record := []string{approval.TenantID, approval.InstanceID, approval.ApprovalCode, approval.Type, approval.CreatedAt.Format(time.RFC3339)}
+		for _, p := range paths {
+			cell, err := csvCell(lookupJSONPath(doc, p))
+			if err != nil {
+				return n, fmt.Errorf("export %s of %s: %w", p, approval.InstanceID, err)
+			}
+			record = append(record, cell)
+		}
+		if err := cw.Write(record); err != nil {
+			return n, err
+		}
+		n++
+	}
+	cw.Flush()
+	return n, cw.Error()
+}
+
+// importRecord 一条待导入的记录
+type importRecord struct {
+	line     int
+	approval *ApprovalM
+}
+
+// ImportNDJSON 逐行读取 ExportNDJSON 格式的数据并分批写入
+//
+// 每条记录按列规则和 LarkData 校验器（遵循 WithLarkDataValidationMode 设置的校验模式）校验，ConflictMerge 时校验合并后的 lark_data，
+// 未通过的记录计入 ImportResult.Invalid 并跳过，其余记录继续导入。每批在一个事务中执行，
+// 数据库错误时中止导入并返回错误，此前的批次已经提交。
+//
+// 写入经过 ApprovalM 的钩子，变更历史的操作人为 ApprovalImportActor；同一文件中 instance_id 重复时，
+// 后出现的记录按 Policy 处理前面的记录。
+func (t *ApprovalTransfer) ImportNDJSON(ctx context.Context, r io.Reader, opts ImportOptions) (ImportResult, error) {
+	var result ImportResult
+	policy, err := ParseConflictPolicy(string(opts.Policy))
+	if err != nil {
+		return result, err
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultTransferBatchSize
+	}
+	db := WithApprovalActor(t.DB.WithContext(ctx), ApprovalImportActor).Session(&gorm.Session{})
+
+	// DryRun 时不写入数据库，记录此前各行计划写入的内容以模拟同一文件中的重复记录
+	planned := map[string]*ApprovalM{}
+	var batch []importRecord
+	inBatch := map[string]bool{}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		var err error
+		if opts.DryRun {
+			err = t.planBatch(db, batch, policy, planned, &result)
+		} else {
+			err = t.importBatch(db, batch, policy, &result)
+		}
+		batch = batch[:0]
+		clear(inBatch)
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, readErr := br.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return result, readErr
+		}
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			result.Read++
+			approval, err := decodeImportRecord(data)
+			if err != nil {
+				result.Invalid = append(result.Invalid, &ImportError{Line: line, InstanceID: approval.InstanceID, Err: err})
+			} else {
+				// 同一批中 instance_id 重复时先写入前面的记录，使后面的记录按冲突处理
+				// context 中有租户时导入到该租户，否则沿用数据中的 tenant_id
+				if tenantID, ok := TenantFromContext(ctx); ok {
+					approval.TenantID = tenantID
+				}
+				key := importKey(approval)
+				if inBatch[key] {
+					if err := flush(); err != nil {
+						return result, err
+					}
+				}
+				batch = append(batch, importRecord{line: line, approval: approval})
+				inBatch[key] = true
+				if len(batch) >= batchSize {
+					if err := flush(); err != nil {
+						return result, err
+					}
+				}
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	return result, flush()
+}
+
+// decodeImportRecord 解析一行数据并按列规则校验，返回的记录只保留需要导入的字段
+//
+// lark_data 在确定写入的内容之后由 planImport 校验，合并时校验的是合并后的文档。
+func decodeImportRecord(data []byte) (*ApprovalM, error) {
+	var in ApprovalM
+	if err := json.Unmarshal(data, &in); err != nil {
+		return &in, fmt.Errorf("decode approval: %w", err)
+	}
+	approval := &ApprovalM{
+		CreatedAt:    in.CreatedAt,
+		UpdatedAt:    in.UpdatedAt,
+		TenantID:     in.TenantID,
+		InstanceID:   in.InstanceID,
+		ApprovalCode: in.ApprovalCode,
+		Type:         in.Type,
+		LarkData:     in.LarkData,
+		DingTalkData: in.DingTalkData,
+	}
+	return approval, ApprovalColumnRules.checkValues(map[string]any{
+		"instance_id":   approval.InstanceID,
+		"approval_code": approval.ApprovalCode,
+		"type":          approval.Type,
+	})
+}
+
+// importAction 一条记录的导入方式
+type importAction int
+
+const (
+	importCreate importAction = iota
+	importUpdate
+	importSkip
+)
+
+// planImport 按冲突策略确定一条记录的导入方式和写入的内容，current 为 nil 表示库中没有未删除的记录
+func planImport(db *gorm.DB, current, incoming *ApprovalM, policy ConflictPolicy) (importAction, *ApprovalM, error) {
+	action, next := importUpdate, incoming
+	switch {
+	case current == nil:
+		action = importCreate
+	case policy == ConflictSkip:
+		return importSkip, nil, nil
+	case policy == ConflictMerge:
+		var err error
+		if next, err = mergeApproval(current, incoming); err != nil {
+			return action, nil, err
+		}
+	}
+	lark, err := next.LarkData.Bytes()
+	if err != nil {
+		return action, nil, err
+	}
+	if err := validateLarkData(db, next.InstanceID, lark); err != nil {
+		return action, nil, err
+	}
+	return action, next, nil
+}
+
+// importKey 记录在库中的唯一键，与 uk_tenant_instance_id 一致
+func importKey(a *ApprovalM) string {
+	return a.TenantID + "\x00" + a.InstanceID
+}
+
+// existingApprovals 按 tenant_id、instance_id 查询一批记录对应的未删除记录
+func existingApprovals(db *gorm.DB, batch []importRecord) (map[string]*ApprovalM, error) {
+	ids := make([]string, 0, len(batch))
+	for _, rec := range batch {
+		ids = append(ids, rec.approval.InstanceID)
+	}
+	var rows []*ApprovalM
+	if err := db.Where("instance_id IN ?", ids).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	existing := make(map[string]*ApprovalM, len(rows))
+	for _, a := range rows {
+		existing[importKey(a)] = a
+	}
+	return existing, nil
+}
+
+// importBatch 在一个事务中写入一批记录
+func (t *ApprovalTransfer) importBatch(db *gorm.DB, batch []importRecord, policy ConflictPolicy, result *ImportResult) error {
+	var (
+		created, updated, skipped int
+		invalid                   []*ImportError
+	)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		existing, err := existingApprovals(tx, batch)
+		if err != nil {
+			return err
+		}
+		var inserts []*ApprovalM
+		for _, rec := range batch {
+			current := existing[importKey(rec.approval)]
+			action, next, err := planImport(tx, current, rec.approval, policy)
+			if err != nil {
+				// 校验失败时跳过这条记录，而不是中止整批
+				invalid = append(invalid, &ImportError{Line: rec.line, InstanceID: rec.approval.InstanceID, Err: err})
+				continue
+			}
+			switch action {
+			case importCreate:
+				inserts = append(inserts, next)
+			case importSkip:
+				skipped++
+			case importUpdate:
+				if err := overwriteApproval(tx, current, next); err != nil {
+					return fmt.Errorf("line %d: %w", rec.line, err)
+				}
+				updated++
+			}
+		}
+		if len(inserts) > 0 {
+			if err := tx.Create(&inserts).Error; err != nil {
+				return fmt.Errorf("insert approvals from line %d: %w", batch[0].line, err)
+			}
+			created = len(inserts)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	result.Created += created
+	result.Updated += updated
+	result.Skipped += skipped
+	result.Invalid = append(result.Invalid, invalid...)
+	return nil
+}
+
+// planBatch DryRun 时统计一批记录将要执行的操作，planned 保存此前各行计划写入的内容，代替库中的记录
+func (t *ApprovalTransfer) planBatch(db *gorm.DB, batch []importRecord, policy ConflictPolicy, planned map[string]*ApprovalM, result *ImportResult) error {
+	existing, err := existingApprovals(db, batch)
+	if err != nil {
+		return err
+	}
+	for _, rec := range batch {
+		id, key := rec.approval.InstanceID, importKey(rec.approval)
+		current, ok := planned[key]
+		if !ok {
+			current = existing[key]
+		}
+		action, next, err := planImport(db, current, rec.approval, policy)
+		if err != nil {
+			result.Invalid = append(result.Invalid, &ImportError{Line: rec.line, InstanceID: id, Err: err})
+			continue
+		}
+		switch action {
+		case importCreate:
+			result.Created++
+			planned[key] = next
+		case importSkip:
+			result.Skipped++
+		case importUpdate:
+			result.Updated++
+			planned[key] = next
+		}
+	}
+	return nil
+}
+
+// overwriteApproval 以 version 为条件用 next 替换已有记录
+func overwriteApproval(tx *gorm.DB, current, next *ApprovalM) error {
+	result := tx.Model(current).Where("version = ?", current.Version).Updates(map[string]any{
+		"approval_code": next.ApprovalCode,
+		"type":          next.Type,
+		"lark_data":     next.LarkData,
+		"dingtalk_data": next.DingTalkData,
+		"version":       incrementVersion(),
+	})
+	if result.Error != nil {
+		return fmt.Errorf("update approval %s: %w", current.InstanceID, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return &StaleApprovalError{InstanceID: current.InstanceID, Version: current.Version}
+	}
+	return nil
+}
+
+// mergeApproval 以 JSON Merge Patch 把导入的记录合并到已有记录，导入中值为 null 的键会被删除
+func mergeApproval(current, incoming *ApprovalM) (*ApprovalM, error) {
+	merged := &ApprovalM{
+		TenantID:     current.TenantID,
+		InstanceID:   current.InstanceID,
+		ApprovalCode: current.ApprovalCode,
+		Type:         current.Type,
+		LarkData:     current.LarkData,
+		DingTalkData: current.DingTalkData,
+	}
+	if incoming.ApprovalCode != "" {
+		merged.ApprovalCode = incoming.ApprovalCode
+	}
+	if incoming.Type != "" {
+		merged.Type = incoming.Type
+	}
+
+	patch, err := incoming.LarkData.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if len(patch) > 0 {
+		doc, err := current.LarkData.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		lark, err := MergePatch(doc, patch)
+		if err != nil {
+			return nil, fmt.Errorf("merge lark_data of %s: %w", current.InstanceID, err)
+		}
+		merged.LarkData = RawJSONColumn[LarkApproval](lark)
+	}
+	if len(incoming.DingTalkData) > 0 {
+		dingtalk, err := MergePatch(current.DingTalkData, incoming.DingTalkData)
+		if err != nil {
+			return nil, fmt.Errorf("merge dingtalk_data of %s: %w", current.InstanceID, err)
+		}
+		merged.DingTalkData = datatypes.JSON(dingtalk)
+	}
+	return merged, nil
+}
+
+// csvCell 把 JSON 值转换为 CSV 单元格
+func csvCell(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// importLines 以换行连接的 NDJSON 导入数据
+func importLines(lines ...string) *strings.Reader {
+	return strings.NewReader(strings.Join(lines, "\n"))
+}
+
+// invalidLines 按顺序返回导入失败的行号，解析失败的行先于校验失败的行计入 Invalid
+func invalidLines(result ImportResult) []int {
+	var lines []int
+	for _, e := range result.Invalid {
+		lines = append(lines, e.Line)
+	}
+	slices.Sort(lines)
+	return lines
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src := openTestDB(t)
+	seedApproval(t, src, "i1", LarkApproval{ApprovalName: "差旅报销", Status: ApprovalStatusPending,
+		TaskList: []*InstanceTask{{ID: "t1", UserID: "zhangsan", Status: TaskStatusPending}}})
+	seedApproval(t, src, "i2", LarkApproval{ApprovalName: "采购申请", Status: ApprovalStatusPending})
+	// 软删除的记录不导出
+	deleted := seedApproval(t, src, "i3", LarkApproval{ApprovalName: "请假", Status: ApprovalStatusPending})
+	if err := src.Delete(deleted).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	transfer := NewApprovalTransfer(src)
+	transfer.BatchSize = 1
+	var buf bytes.Buffer
+	if n, err := transfer.ExportNDJSON(ctx, &buf); err != nil || n != 2 {
+		t.Fatalf("export = %d, %v", n, err)
+	}
+
+	dst := openTestDB(t)
+	result, err := NewApprovalTransfer(dst).ImportNDJSON(ctx, &buf, ImportOptions{})
+	if err != nil || result.Read != 2 || result.Created != 2 || len(result.Invalid) != 0 {
+		t.Fatalf("import = %+v, %v", result, err)
+	}
+	if got := instanceIDs(t, dst); !slices.Equal(got, []string{"i1", "i2"}) {
+		t.Errorf("imported = %v", got)
+	}
+	if data := tenantLarkData(t, dst, "", "i1"); data.ApprovalName != "差旅报销" || len(data.TaskList) != 1 || data.TaskList[0].UserID != "zhangsan" {
+		t.Errorf("lark_data = %+v", data)
+	}
+	histories, err := FindApprovalHistory(dst, "i1")
+	if err != nil || len(histories) != 1 || histories[0].Actor != ApprovalImportActor {
+		t.Errorf("histories = %+v, %v", histories, err)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	db := openTestDB(t)
+	seedApproval(t, db, "i1", LarkApproval{ApprovalName: "差旅报销", Status: ApprovalStatusPending,
+		TaskList: []*InstanceTask{{ID: "t1", UserID: "zhangsan", Status: TaskStatusPending}}})
+	seedApproval(t, db, "i2", LarkApproval{ApprovalName: "采购申请", Status: ApprovalStatusApproved})
+
+	paths := []JSONPath{Path("status"), Path("task_list").Index(0).Field("user_id"), Path("task_list").Index(0), Path("missing")}
+	var buf bytes.Buffer
+	h := NewJSONQueryHelper(db)
+	if n, err := NewApprovalTransfer(db).ExportCSV(context.Background(), &buf, paths, h.ApprovalNameIs("差旅报销")); err != nil || n != 1 {
+		t.Fatalf("export = %d, %v", n, err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("records = %q", records)
+	}
+	wantHeader := []string{"tenant_id", "instance_id", "approval_code", "type", "created_at", "$.status", "$.task_list[0].user_id", "$.task_list[0]", "$.missing"}
+	if !slices.Equal(records[0], wantHeader) {
+		t.Errorf("header = %q", records[0])
+	}
+	row := records[1]
+	if row[1] != "i1" || row[5] != ApprovalStatusPending || row[6] != "zhangsan" || !strings.Contains(row[7], `"id":"t1"`) || row[8] != "" {
+		t.Errorf("row = %q", row)
+	}
+}
+
+func TestImportConflictPolicies(t *testing.T) {
+	input := []string{
+		`{"instance_id":"i1","approval_code":"code2","type":"lark","lark_data":{"approval_name":"b","status":"APPROVED"}}`,
+		`{"instance_id":"i2","approval_code":"code","type":"lark","lark_data":{"approval_name":"c","status":"PENDING"}}`,
+		``,
+		`{"instance_id":"i3",`,
+		`{"instance_id":"i4","approval_code":"code","type":"lark","lark_data":{"status":"PENDING"}}`,
+		`{"instance_id":"","approval_code":"code","type":"lark","lark_data":{"approval_name":"d"}}`,
+		// 同一文件中重复的 instance_id 按策略处理前面的记录
+		`{"instance_id":"i2","approval_code":"code","type":"lark","lark_data":{"approval_name":"e"}}`,
+	}
+	for _, tc := range []struct {
+		policy                    ConflictPolicy
+		created, updated, skipped int
+		i1, i2                    LarkApproval
+		i1Code                    string
+	}{
+		{
+			policy: ConflictSkip, created: 1, skipped: 2, i1Code: "code",
+			i1: LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending, UserID: "u1"},
+			i2: LarkApproval{ApprovalName: "c", Status: ApprovalStatusPending},
+		},
+		{
+			policy: ConflictOverwrite, created: 1, updated: 2, i1Code: "code2",
+			i1: LarkApproval{ApprovalName: "b", Status: ApprovalStatusApproved},
+			i2: LarkApproval{ApprovalName: "e"},
+		},
+		{
+			policy: ConflictMerge, created: 1, updated: 2, i1Code: "code2",
+			i1: LarkApproval{ApprovalName: "b", Status: ApprovalStatusApproved, UserID: "u1"},
+			i2: LarkApproval{ApprovalName: "e", Status: ApprovalStatusPending},
+		},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			db := openTestDB(t)
+			seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending, UserID: "u1"})
+			transfer := NewApprovalTransfer(db)
+			ctx := context.Background()
+
+			// DryRun 的统计与实际导入一致，且不写入数据库
+			for _, dryRun := range []bool{true, false} {
+				result, err := transfer.ImportNDJSON(ctx, importLines(input...), ImportOptions{Policy: tc.policy, BatchSize: 2, DryRun: dryRun})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Read != 6 || result.Created != tc.created || result.Updated != tc.updated || result.Skipped != tc.skipped {
+					t.Errorf("dry run %t: result = %+v", dryRun, result)
+				}
+				if got := invalidLines(result); !slices.Equal(got, []int{4, 5, 6}) {
+					t.Errorf("dry run %t: invalid lines = %v", dryRun, got)
+				}
+				if dryRun {
+					if got := instanceIDs(t, db); !slices.Equal(got, []string{"i1"}) {
+						t.Fatalf("dry run wrote %v", got)
+					}
+				}
+			}
+
+			if got := instanceIDs(t, db); !slices.Equal(got, []string{"i1", "i2"}) {
+				t.Errorf("approvals = %v", got)
+			}
+			var i1 ApprovalM
+			if err := db.Where("instance_id = ?", "i1").First(&i1).Error; err != nil {
+				t.Fatal(err)
+			}
+			if i1.ApprovalCode != tc.i1Code {
+				t.Errorf("approval_code = %s, want %s", i1.ApprovalCode, tc.i1Code)
+			}
+			for id, want := range map[string]LarkApproval{"i1": tc.i1, "i2": tc.i2} {
+				if got := tenantLarkData(t, db, "", id); got.ApprovalName != want.ApprovalName || got.Status != want.Status || got.UserID != want.UserID {
+					t.Errorf("%s lark_data = %+v, want %+v", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	db := openTestDB(t)
+	transfer := NewApprovalTransfer(db)
+	ctx := context.Background()
+	if _, err := transfer.ImportNDJSON(ctx, importLines(), ImportOptions{Policy: "replace"}); err == nil {
+		t.Error("unknown policy should fail")
+	}
+
+	// 未通过 schema 校验的记录带有违规详情
+	result, err := transfer.ImportNDJSON(ctx, importLines(`{"instance_id":"i1","approval_code":"code","type":"lark","lark_data":{"approval_name":1}}`), ImportOptions{})
+	if err != nil || len(result.Invalid) != 1 {
+		t.Fatalf("import = %+v, %v", result, err)
+	}
+	var verr *SchemaValidationError
+	if e := result.Invalid[0]; e.InstanceID != "i1" || !errors.As(e, &verr) || !strings.HasPrefix(e.Error(), "line 1 (i1): ") {
+		t.Errorf("invalid = %v", e)
+	}
+
+	// 数据库错误中止导入，该批次回滚
+	seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	if err := db.Migrator().DropTable(&ApprovalHistoryM{}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = transfer.ImportNDJSON(ctx, importLines(
+		`{"instance_id":"i2","approval_code":"code","type":"lark","lark_data":{"approval_name":"b"}}`,
+	), ImportOptions{})
+	if err == nil {
+		t.Error("import without history table should fail")
+	}
+	if got := instanceIDs(t, db); !slices.Equal(got, []string{"i1"}) {
+		t.Errorf("approvals = %v", got)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// TransitionHandler 状态变化的处理函数，ctx 为写入时的 context
+type TransitionHandler func(ctx context.Context, t ApprovalTransition) error
+
+// transitionSubscribers 状态变化的检查和订阅者，由 TransitionPlugin 和 MemoryApprovalRepository 各自持有
+type transitionSubscribers struct {
+	mu        sync.RWMutex
+	next      int
+	vetoes    map[int]TransitionHandler
+	listeners map[int]TransitionHandler
+}
+
+// Veto 注册状态变化的检查，返回取消注册的函数
+//
+// 检查在写入所在的事务中同步调用，返回错误时整个写入回滚；此时事务尚未提交，检查中不应产生外部副作用。
+func (s *transitionSubscribers) Veto(fn TransitionHandler) (unregister func()) {
+	return s.add(&s.vetoes, fn)
+}
+
+// Subscribe 订阅审批实例和任务的状态变化，返回取消订阅的函数
+//
+// 订阅者在写入提交之后调用，写入回滚时不会收到通知；订阅者返回的错误只记录日志，不影响已经提交的写入。
+func (s *transitionSubscribers) Subscribe(fn TransitionHandler) (unsubscribe func()) {
+	return s.add(&s.listeners, fn)
+}
+
+func (s *transitionSubscribers) add(handlers *map[int]TransitionHandler, fn TransitionHandler) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if *handlers == nil {
+		*handlers = make(map[int]TransitionHandler)
+	}
+	id := s.next
+	s.next++
+	(*handlers)[id] = fn
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(*handlers, id)
+	}
+}
+
+// ordered 按注册顺序返回 handlers 的副本，调用时不持有锁
+func (s *transitionSubscribers) ordered(handlers map[int]TransitionHandler) []TransitionHandler {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]int, 0, len(handlers))
+	for id := range handlers {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	fns := make([]TransitionHandler, 0, len(ids))
+	for _, id := range ids {
+		fns = append(fns, handlers[id])
+	}
+	return fns
+}
+
+// check 按注册顺序调用所有检查，任一检查返回错误时停止
+func (s *transitionSubscribers) check(ctx context.Context, transitions []ApprovalTransition) error {
+	if len(transitions) == 0 {
+		return nil
+	}
+	vetoes := s.ordered(s.vetoes)
+	for _, t := range transitions {
+		for _, fn := range vetoes {
+			if err := fn(ctx, t); err != nil {
+				return fmt.Errorf("approval %s transition %s -> %s: %w", t.InstanceID, t.From, t.To, err)
+			}
+		}
+	}
+	return nil
+}
+
+// notify 按注册顺序通知所有订阅者，订阅者返回的错误记录日志后继续
+func (s *transitionSubscribers) notify(ctx context.Context, transitions []ApprovalTransition) {
+	if len(transitions) == 0 {
+		return
+	}
+	listeners := s.ordered(s.listeners)
+	for _, t := range transitions {
+		for _, fn := range listeners {
+			if err := fn(ctx, t); err != nil {
+				slog.Warn("approval transition subscriber failed", "instance_id", t.InstanceID, "task_id", t.TaskID,
+					"from", t.From, "to", t.To, "error", err.Error())
+			}
+		}
+	}
+}
+
+// TransitionPlugin 审批实例和任务状态变化的检查和订阅，只对注册了该插件的 db 生效
+//
+// ApprovalM 的钩子在 AfterCreate/AfterUpdate 中得到状态变化后立即调用 Veto 注册的检查，检查失败时写入回滚；
+// 通过检查的变化暂存在所在的事务上，事务提交后才通知 Subscribe 的订阅者，事务回滚时丢弃。
+// 显式事务（db.Transaction、db.Begin）中的变化在最外层事务提交后统一通知，嵌套的 Transaction 回滚到保存点时
+// 丢弃保存点之后的变化（依据执行的 SAVEPOINT 语句识别，PrepareStmt 模式下无法识别）。
+//
+//	transitions := NewTransitionPlugin()
+//	db.Use(transitions)
+//	unsubscribe := transitions.Subscribe(func(ctx context.Context, t ApprovalTransition) error {
+//		slog.Info("status changed", "instance_id", t.InstanceID, "from", t.From, "to", t.To)
+//		return nil
+//	})
+//	defer unsubscribe()
+//
+// 插件包装了 db 的连接池以得知事务何时提交，需要在 gorm.Open 之后、开始任何事务之前注册。
+type TransitionPlugin struct {
+	transitionSubscribers
+}
+
+// NewTransitionPlugin 创建状态变化插件
+func NewTransitionPlugin() *TransitionPlugin {
+	return &TransitionPlugin{}
+}
+
+// transitionPendingKey 通过 setStatementValue 暂存不在事务中执行的写入产生的状态变化
+const transitionPendingKey = "approval:pending_transitions"
+
+// pendingTransitions 一次写入产生的待通知状态变化
+type pendingTransitions struct {
+	ctx         context.Context
+	transitions []ApprovalTransition
+}
+
+// Name 实现 gorm.Plugin
+func (p *TransitionPlugin) Name() string {
+	return "approval_transitions"
+}
+
+// Initialize 实现 gorm.Plugin
+func (p *TransitionPlugin) Initialize(db *gorm.DB) error {
+	pool := &transitionConnPool{ConnPool: db.ConnPool, plugin: p}
+	db.ConnPool, db.Statement.ConnPool = pool, pool
+
+	// SkipDefaultTransaction 且不在事务中时，写入在语句执行时已经生效，语句结束后通知
+	if err := db.Callback().Create().After("gorm:commit_or_rollback_transaction").Register("transitions:create", p.notifyStatement); err != nil {
+		return err
+	}
+	return db.Callback().Update().After("gorm:commit_or_rollback_transaction").Register("transitions:update", p.notifyStatement)
+}
+
+// transitionPluginOf 返回 db 上注册的状态变化插件，没有注册时返回 nil
+func transitionPluginOf(db *gorm.DB) *TransitionPlugin {
+	if db == nil || db.Config == nil {
+		return nil
+	}
+	p, _ := db.Config.Plugins[(*TransitionPlugin)(nil).Name()].(*TransitionPlugin)
+	return p
+}
+
+// enqueue 在钩子中调用：检查 transitions，通过后暂存到所在的事务，不在事务中时暂存到语句上
+func (p *TransitionPlugin) enqueue(tx *gorm.DB, transitions []ApprovalTransition) error {
+	if len(transitions) == 0 {
+		return nil
+	}
+	ctx := tx.Statement.Context
+	if err := p.check(ctx, transitions); err != nil {
+		return err
+	}
+	batch := pendingTransitions{ctx: ctx, transitions: transitions}
+	if t := transitionTxOf(tx.Statement.ConnPool); t != nil {
+		t.add(batch)
+		return nil
+	}
+	pending, _ := statementValue(tx, transitionPendingKey)
+	batches, _ := pending.([]pendingTransitions)
+	setStatementValue(tx, transitionPendingKey, append(batches, batch))
+	return nil
+}
+
+// notifyStatement 通知暂存在语句上的状态变化，语句出错时丢弃
+func (p *TransitionPlugin) notifyStatement(db *gorm.DB) {
+	pending, ok := statementValue(db, transitionPendingKey)
+	if !ok {
+		return
+	}
+	setStatementValue(db, transitionPendingKey, nil)
+	if db.Error != nil {
+		return
+	}
+	batches, _ := pending.([]pendingTransitions)
+	for _, b := range batches {
+		p.notify(b.ctx, b.transitions)
+	}
+}
+
+// transitionConnPool 包装 db 的连接池，开启的事务提交后通知暂存的状态变化
+type transitionConnPool struct {
+	gorm.ConnPool
+	plugin *TransitionPlugin
+}
+
+// BeginTx 实现 gorm.ConnPoolBeginner
+func (p *transitionConnPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	var conn gorm.ConnPool
+	switch beginner := p.ConnPool.(type) {
+	case gorm.TxBeginner:
+		tx, err := beginner.BeginTx(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		conn = tx
+	case gorm.ConnPoolBeginner:
+		tx, err := beginner.BeginTx(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		conn = tx
+	default:
+		return nil, gorm.ErrInvalidTransaction
+	}
+	return &transitionTx{ConnPool: conn, pool: p, savepoints: make(map[string]int)}, nil
+}
+
+// GetDBConn 实现 gorm.GetDBConnector，使 db.DB() 仍能取得 *sql.DB
+func (p *transitionConnPool) GetDBConn() (*sql.DB, error) {
+	switch conn := p.ConnPool.(type) {
+	case *sql.DB:
+		return conn, nil
+	case gorm.GetDBConnector:
+		return conn.GetDBConn()
+	}
+	return nil, gorm.ErrInvalidDB
+}
+
+// transitionTx 包装事务，记录事务中暂存的状态变化，提交后通知、回滚时丢弃
+type transitionTx struct {
+	gorm.ConnPool
+	pool *transitionConnPool
+
+	mu         sync.Mutex
+	pending    []pendingTransitions
+	savepoints map[string]int // 保存点名称 -> 创建保存点时 pending 的长度
+}
+
+// transitionTxOf 返回 conn 对应的 transitionTx，conn 不是插件开启的事务时返回 nil
+func transitionTxOf(conn gorm.ConnPool) *transitionTx {
+	if p, ok := conn.(*gorm.PreparedStmtTX); ok {
+		conn = p.Tx
+	}
+	t, _ := conn.(*transitionTx)
+	return t
+}
+
+func (t *transitionTx) add(batch pendingTransitions) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, batch)
+}
+
+// take 取出并清空暂存的状态变化
+func (t *transitionTx) take() []pendingTransitions {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := t.pending
+	t.pending = nil
+	return pending
+}
+
+// Commit 实现 gorm.TxCommitter，提交成功后通知订阅者
+func (t *transitionTx) Commit() error {
+	committer, ok := t.ConnPool.(gorm.TxCommitter)
+	if !ok {
+		return gorm.ErrInvalidTransaction
+	}
+	if err := committer.Commit(); err != nil {
+		t.take()
+		return err
+	}
+	for _, b := range t.take() {
+		t.pool.plugin.notify(b.ctx, b.transitions)
+	}
+	return nil
+}
+
+// Rollback 实现 gorm.TxCommitter，丢弃暂存的状态变化
+func (t *transitionTx) Rollback() error {
+	t.take()
+	committer, ok := t.ConnPool.(gorm.TxCommitter)
+	if !ok {
+		return gorm.ErrInvalidTransaction
+	}
+	return committer.Rollback()
+}
+
+// StmtContext 实现 gorm.Tx，使 PrepareStmt 会话仍在事务中执行
+func (t *transitionTx) StmtContext(ctx context.Context, stmt *sql.Stmt) *sql.Stmt {
+	if tx, ok := t.ConnPool.(gorm.Tx); ok {
+		return tx.StmtContext(ctx, stmt)
+	}
+	return stmt
+}
+
+// GetDBConn 实现 gorm.GetDBConnector
+func (t *transitionTx) GetDBConn() (*sql.DB, error) {
+	return t.pool.GetDBConn()
+}
+
+// ExecContext 实现 gorm.ConnPool，记录保存点，回滚到保存点时丢弃保存点之后暂存的状态变化
+func (t *transitionTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	result, err := t.ConnPool.ExecContext(ctx, query, args...)
+	if err != nil {
+		return result, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if name, ok := strings.CutPrefix(query, "ROLLBACK TO SAVEPOINT "); ok {
+		if n, ok := t.savepoints[name]; ok {
+			t.pending = t.pending[:min(n, len(t.pending))]
+		}
+	} else if name, ok := strings.CutPrefix(query, "SAVEPOINT "); ok {
+		t.savepoints[name] = len(t.pending)
+	}
+	return result, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// openTransitionDB 返回注册了 TransitionPlugin 的测试库
+func openTransitionDB(t *testing.T) (*gorm.DB, *TransitionPlugin) {
+	t.Helper()
+	db := openTestDB(t)
+	p := NewTransitionPlugin()
+	if err := db.Use(p); err != nil {
+		t.Fatal(err)
+	}
+	return db, p
+}
+
+// recordTransitions 订阅审批实例的状态变化，返回已收到的 instance_id:from->to
+func recordTransitions(t *testing.T, subscribe func(TransitionHandler) func()) func() []string {
+	t.Helper()
+	var got []string
+	t.Cleanup(subscribe(func(_ context.Context, tr ApprovalTransition) error {
+		if tr.TaskID == "" {
+			got = append(got, tr.InstanceID+":"+tr.From+"->"+tr.To)
+		}
+		return nil
+	}))
+	return func() []string { return got }
+}
+
+func TestTransitionsNotifiedAfterCommit(t *testing.T) {
+	db, p := openTransitionDB(t)
+	// 测试库只有一个连接，订阅者在事务提交前被调用时这里的查询会阻塞
+	var seen []string
+	p.Subscribe(func(ctx context.Context, tr ApprovalTransition) error {
+		var a ApprovalM
+		if err := db.WithContext(ctx).Unscoped().Where("instance_id = ?", tr.InstanceID).First(&a).Error; err != nil {
+			return err
+		}
+		data, err := a.LarkData.Get()
+		if err != nil {
+			return err
+		}
+		seen = append(seen, data.Status)
+		return nil
+	})
+
+	a := seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	setApprovalStatus(t, db, a, ApprovalStatusApproved)
+	if err := NewJSONUpdateHelper(db).UpdateJSONField("i1", "$.status", "canceled"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{ApprovalStatusPending, ApprovalStatusApproved, ApprovalStatusCanceled}; !slices.Equal(seen, want) {
+		t.Errorf("seen = %v, want %v", seen, want)
+	}
+}
+
+func TestTransitionsDiscardedOnRollback(t *testing.T) {
+	db, p := openTransitionDB(t)
+	a := seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	b := seedApproval(t, db, "i2", LarkApproval{ApprovalName: "b", Status: ApprovalStatusPending})
+	got := recordTransitions(t, p.Subscribe)
+
+	// 显式事务回滚
+	errRollback := errors.New("rollback")
+	err := db.Transaction(func(tx *gorm.DB) error {
+		setApprovalStatus(t, tx, a, ApprovalStatusApproved)
+		if len(got()) != 0 {
+			t.Errorf("notified before commit: %v", got())
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) || len(got()) != 0 {
+		t.Fatalf("err = %v, notified = %v", err, got())
+	}
+
+	// Veto 拒绝时整个写入回滚，同一事务中已通过的变化也不通知
+	errVeto := errors.New("veto")
+	unregister := p.Veto(func(_ context.Context, tr ApprovalTransition) error {
+		if tr.InstanceID == "i2" {
+			return errVeto
+		}
+		return nil
+	})
+	err = db.Transaction(func(tx *gorm.DB) error {
+		a.LarkData = NewJSONColumn(LarkApproval{ApprovalName: "a", Status: ApprovalStatusApproved})
+		if err := tx.Save(a).Error; err != nil {
+			return err
+		}
+		b.LarkData = NewJSONColumn(LarkApproval{ApprovalName: "b", Status: ApprovalStatusApproved})
+		return tx.Save(b).Error
+	})
+	unregister()
+	if !errors.Is(err, errVeto) || len(got()) != 0 {
+		t.Fatalf("err = %v, notified = %v", err, got())
+	}
+	if data := tenantLarkData(t, db, "", "i1"); data.Status != ApprovalStatusPending {
+		t.Errorf("i1 status = %s, want PENDING", data.Status)
+	}
+
+	// 嵌套事务回滚到保存点，只通知外层提交的变化
+	err = db.Transaction(func(tx *gorm.DB) error {
+		setApprovalStatus(t, tx, a, ApprovalStatusApproved)
+		if err := tx.Transaction(func(tx *gorm.DB) error {
+			setApprovalStatus(t, tx, b, ApprovalStatusRejected)
+			return errRollback
+		}); !errors.Is(err, errRollback) {
+			t.Errorf("nested err = %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"i1:PENDING->APPROVED"}; !slices.Equal(got(), want) {
+		t.Errorf("notified = %v, want %v", got(), want)
+	}
+}
+
+func TestTransitionsWithoutTransaction(t *testing.T) {
+	db, p := openTransitionDB(t)
+	got := recordTransitions(t, p.Subscribe)
+	skip := db.Session(&gorm.Session{SkipDefaultTransaction: true})
+
+	a := seedApproval(t, skip, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	setApprovalStatus(t, skip, a, ApprovalStatusApproved)
+	// 非法转移的语句出错，不通知
+	a.LarkData = NewJSONColumn(LarkApproval{ApprovalName: "a", Status: ApprovalStatusRejected})
+	if err := skip.Save(a).Error; !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("err = %v, want ErrIllegalTransition", err)
+	}
+	if want := []string{"i1:->PENDING", "i1:PENDING->APPROVED"}; !slices.Equal(got(), want) {
+		t.Errorf("notified = %v, want %v", got(), want)
+	}
+}
+
+func TestTransitionsScopedToDB(t *testing.T) {
+	db1, p1 := openTransitionDB(t)
+	db2, p2 := openTransitionDB(t)
+	plain := openTestDB(t)
+	got1 := recordTransitions(t, p1.Subscribe)
+	got2 := recordTransitions(t, p2.Subscribe)
+
+	seedApproval(t, db1, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	seedApproval(t, db2, "i2", LarkApproval{ApprovalName: "b", Status: ApprovalStatusPending})
+	seedApproval(t, plain, "i3", LarkApproval{ApprovalName: "c", Status: ApprovalStatusPending})
+	if !slices.Equal(got1(), []string{"i1:->PENDING"}) || !slices.Equal(got2(), []string{"i2:->PENDING"}) {
+		t.Errorf("db1 = %v, db2 = %v", got1(), got2())
+	}
+}
+
+func TestMemoryRepositoryTransitions(t *testing.T) {
+	ctx := t.Context()
+	repo := NewMemoryApprovalRepository()
+	got := recordTransitions(t, repo.Subscribe)
+	errVeto := errors.New("veto")
+	repo.Veto(func(_ context.Context, tr ApprovalTransition) error {
+		if tr.To == ApprovalStatusRejected {
+			return errVeto
+		}
+		return nil
+	})
+
+	if err := repo.Upsert(ctx, newRepositoryApproval("i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Patch(ctx, "i1", JSONPatch{{Op: "replace", Path: "/status", Value: []byte(`"REJECTED"`)}}); !errors.Is(err, errVeto) {
+		t.Fatalf("err = %v, want veto", err)
+	}
+	if err := repo.Patch(ctx, "i1", JSONPatch{{Op: "replace", Path: "/status", Value: []byte(`"APPROVED"`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"i1:->PENDING", "i1:PENDING->APPROVED"}; !slices.Equal(got(), want) {
+		t.Errorf("notified = %v, want %v", got(), want)
+	}
+	if _, data := larkDataOf(t, ctx, repo, "i1"); data.Status != ApprovalStatusApproved {
+		t.Errorf("status = %s, want APPROVED", data.Status)
+	}
+}
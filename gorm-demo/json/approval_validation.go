@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"sync"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// LarkDataValidator 校验 LarkData 的接口，默认使用由 LarkApproval 结构体生成的 LarkApprovalSchema
+type LarkDataValidator interface {
+	Validate(data []byte) []SchemaViolation
+}
+
+// ValidationMode LarkData 校验失败时的处理方式
+type ValidationMode int
+
+const (
+	ValidationStrict ValidationMode = iota // 校验失败时拒绝写入
+	ValidationWarn                         // 仅记录警告日志，用于历史数据
+	ValidationOff                          // 不校验
+)
+
+// validationModeKey 通过 db.Set 为单次操作覆盖校验模式
+const validationModeKey = "approval:lark_data_validation_mode"
+
+// LarkApprovalSchema 由 LarkApproval/InstanceTask/InstanceTimeline 等结构体生成的 JSON Schema
+var LarkApprovalSchema = SchemaFor(LarkApproval{})
+
+var larkDataValidation = struct {
+	sync.RWMutex
+	validator LarkDataValidator
+	mode      ValidationMode
+}{validator: LarkApprovalSchema}
+
+// SetLarkDataValidator 替换全局使用的 LarkData 校验器，传入 nil 恢复默认
+func SetLarkDataValidator(v LarkDataValidator) {
+	if v == nil {
+		v = LarkApprovalSchema
+	}
+	larkDataValidation.Lock()
+	larkDataValidation.validator = v
+	larkDataValidation.Unlock()
+}
+
+// SetLarkDataValidationMode 设置全局的 LarkData 校验模式
+func SetLarkDataValidationMode(mode ValidationMode) {
+	larkDataValidation.Lock()
+	larkDataValidation.mode = mode
+	larkDataValidation.Unlock()
+}
+
+// WithLarkDataValidationMode 仅为本次操作设置校验模式，如同步历史数据时使用 ValidationWarn
+//
+//	WithLarkDataValidationMode(db, ValidationWarn).Create(&legacy)
+func WithLarkDataValidationMode(db *gorm.DB, mode ValidationMode) *gorm.DB {
+	return db.Set(validationModeKey, mode)
+}
+
+// larkDataValidatedKey 通过 setStatementValue 标记 BeforeUpdate 已经校验了写入的 lark_data
+const larkDataValidatedKey = "approval:lark_data_validated"
+
+// validateUpdatedLarkData 在 AfterUpdate 中校验写入后从数据库读取的 lark_data
+//
+// gorm.Expr 等 SQL 表达式（JSONUpdateHelper、ApplyJSONPatch）写入时 BeforeUpdate 无法得到新值，
+// 这里按最终数据校验，失败时整个更新回滚；BeforeUpdate 已校验或 lark_data 没有变化时跳过。
+func validateUpdatedLarkData(tx *gorm.DB, after *ApprovalM, before JSONColumn[LarkApproval]) error {
+	if validated, _ := statementValue(tx, larkDataValidatedKey); validated == true {
+		return nil
+	}
+	data, err := after.LarkData.Bytes()
+	if err != nil {
+		return err
+	}
+	old, err := before.Bytes()
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, old) {
+		return nil
+	}
+	return validateLarkData(tx, after.InstanceID, data)
+}
+
+// validateLarkData 在钩子中校验 LarkData，空值和 JSON null 不校验
+func validateLarkData(tx *gorm.DB, instanceID string, data []byte) error {
+	if v, ok := tx.Get(validationModeKey); ok {
+		if m, ok := v.(ValidationMode); ok {
+			return checkLarkData(instanceID, data, &m)
+		}
+	}
+	return checkLarkData(instanceID, data, nil)
+}
+
+// checkLarkData 以全局校验器校验 LarkData，mode 为 nil 时使用全局校验模式
+func checkLarkData(instanceID string, data []byte, mode *ValidationMode) error {
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+
+	larkDataValidation.RLock()
+	validator, m := larkDataValidation.validator, larkDataValidation.mode
+	larkDataValidation.RUnlock()
+	if mode != nil {
+		m = *mode
+	}
+	if m == ValidationOff {
+		return nil
+	}
+
+	violations := validator.Validate(data)
+	if len(violations) == 0 {
+		return nil
+	}
+	err := &SchemaValidationError{Violations: violations}
+	if m == ValidationWarn {
+		slog.Warn("lark_data schema validation failed", "instance_id", instanceID, "error", err.Error())
+		return nil
+	}
+	return err
+}
+
+// changedLarkData 取出本次更新写入的 lark_data 原始 JSON
+//
+// Updates(map) 时值位于 map 中，Updates(struct) 时位于 Dest 结构体中；Save 时 Dest 与 Model 为同一对象，
+// 无法判断是否修改，总是返回当前值。值为 gorm.Expr 等 SQL 表达式时无法在写入前得到结果，返回 false。
+func changedLarkData(tx *gorm.DB, a *ApprovalM) ([]byte, bool) {
+	switch dest := tx.Statement.Dest.(type) {
+	case map[string]interface{}:
+		v, ok := dest["lark_data"]
+		if !ok {
+			v, ok = dest["LarkData"]
+		}
+		if !ok {
+			return nil, false
+		}
+		switch v := v.(type) {
+		case datatypes.JSON:
+			return v, true
+		case JSONColumn[LarkApproval]:
+			data, err := v.Bytes()
+			return data, err == nil
+		case *JSONColumn[LarkApproval]:
+			data, err := v.Bytes()
+			return data, err == nil
+		case json.RawMessage:
+			return v, true
+		case []byte:
+			return v, true
+		case string:
+			return []byte(v), true
+		default:
+			return nil, false
+		}
+	case *ApprovalM:
+		if dest == a {
+			data, err := a.LarkData.Bytes()
+			return data, err == nil
+		}
+		data, err := dest.LarkData.Bytes()
+		return data, err == nil && tx.Statement.Changed("lark_data")
+	default:
+		data, err := a.LarkData.Bytes()
+		// 批量创建时 Dest 为切片，每条记录的 lark_data 都会写入，Changed 只适用于单个结构体
+		if k := tx.Statement.ReflectValue.Kind(); k == reflect.Slice || k == reflect.Array {
+			return data, err == nil
+		}
+		return data, err == nil && tx.Statement.Changed("lark_data")
+	}
+}
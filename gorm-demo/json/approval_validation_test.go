@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// wantSchemaViolations 断言 err 为 *SchemaValidationError 且包含 pointers 对应的全部违规
+func wantSchemaViolations(t *testing.T, err error, pointers ...string) {
+	t.Helper()
+	var verr *SchemaValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("err = %v, want SchemaValidationError", err)
+	}
+	var got []string
+	for _, v := range verr.Violations {
+		got = append(got, v.Pointer)
+	}
+	if !slices.Equal(got, pointers) {
+		t.Errorf("violations = %v, want %v", verr.Violations, pointers)
+	}
+}
+
+func TestValidationOnCreateAndUpdate(t *testing.T) {
+	db := openTestDB(t)
+
+	invalid := &ApprovalM{InstanceID: "i1", ApprovalCode: "code", Type: ApprovalTypeLark,
+		LarkData: RawJSONColumn[LarkApproval]([]byte(`{"status":"PENDING"}`))}
+	wantSchemaViolations(t, db.Create(invalid).Error, "/approval_name")
+	if ids := instanceIDs(t, db); len(ids) != 0 {
+		t.Fatalf("invalid create was written: %v", ids)
+	}
+
+	a := seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	// Save
+	a.LarkData = RawJSONColumn[LarkApproval]([]byte(`{"approval_name":"a","task_list":[{"status":"PENDING"}]}`))
+	wantSchemaViolations(t, db.Save(a).Error, "/task_list/0/id")
+	// Updates(map)
+	err := db.Model(a).Updates(map[string]any{"lark_data": RawJSONColumn[LarkApproval]([]byte(`{"approval_name":1}`))}).Error
+	wantSchemaViolations(t, err, "/approval_name")
+	// 小写的状态按统一为大写后校验
+	if err := db.Model(a).Updates(map[string]any{"lark_data": NewJSONColumn(LarkApproval{ApprovalName: "b", Status: "approved"})}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if data := tenantLarkData(t, db, "", "i1"); data.ApprovalName != "b" || data.Status != ApprovalStatusApproved {
+		t.Errorf("lark_data = %+v", data)
+	}
+}
+
+func TestValidationOfExpressionUpdates(t *testing.T) {
+	db := openTestDB(t)
+	seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	h := NewJSONUpdateHelper(db)
+
+	// 表达式写入时 BeforeUpdate 得不到新值，按写入后的记录校验并回滚
+	wantSchemaViolations(t, h.UpdateJSONField("i1", "$.approval_name", 123), "/approval_name")
+	wantSchemaViolations(t, h.UpdateJSONField("i1", "$.task_list", []map[string]string{{"status": "PENDING"}}), "/task_list/0/id")
+	wantSchemaViolations(t, h.UpdateJSONFieldsWithVersion("i1", 0, map[string]any{"$.department_id": 7}), "/department_id")
+	if data := tenantLarkData(t, db, "", "i1"); data.ApprovalName != "a" || data.TaskList != nil {
+		t.Errorf("invalid update was written: %+v", data)
+	}
+	histories, err := FindApprovalHistory(db, "i1")
+	if err != nil || len(histories) != 1 {
+		t.Errorf("histories = %d, %v, want only the create", len(histories), err)
+	}
+
+	// 合法的表达式写入不受影响
+	if err := h.UpdateJSONField("i1", "$.task_list", []map[string]string{{"id": "t1", "status": "pending"}}); err != nil {
+		t.Fatal(err)
+	}
+	if data := tenantLarkData(t, db, "", "i1"); len(data.TaskList) != 1 || data.TaskList[0].Status != TaskStatusPending {
+		t.Errorf("lark_data = %+v", data)
+	}
+}
+
+func TestValidationModes(t *testing.T) {
+	db := openTestDB(t)
+	a := seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	h := NewJSONUpdateHelper(WithLarkDataValidationMode(db, ValidationWarn))
+
+	// ValidationWarn 只记录日志
+	if err := h.UpdateJSONField("i1", "$.approval_name", 123); err != nil {
+		t.Fatalf("warn mode: %v", err)
+	}
+	legacy := &ApprovalM{InstanceID: "i2", ApprovalCode: "code", Type: ApprovalTypeLark, LarkData: NewJSONColumn(LarkApproval{})}
+	if err := WithLarkDataValidationMode(db, ValidationOff).Create(legacy).Error; err != nil {
+		t.Fatalf("off mode: %v", err)
+	}
+
+	// 不修改 lark_data 的更新不校验已有的历史数据
+	if err := db.Model(a).Update("approval_code", "code2").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 全局模式，单次操作的设置优先
+	SetLarkDataValidationMode(ValidationOff)
+	t.Cleanup(func() { SetLarkDataValidationMode(ValidationStrict) })
+	if err := NewJSONUpdateHelper(db).UpdateJSONField("i2", "$.status", "WAITING"); !errors.Is(err, ErrUnknownStatus) {
+		t.Errorf("err = %v, want ErrUnknownStatus from the state machine", err)
+	}
+	err := NewJSONUpdateHelper(WithLarkDataValidationMode(db, ValidationStrict)).UpdateJSONField("i2", "$.serial_number", 1)
+	wantSchemaViolations(t, err, "/serial_number")
+}
+
+func TestCustomValidator(t *testing.T) {
+	db := openTestDB(t)
+	SetLarkDataValidator(validatorFunc(func(data []byte) []SchemaViolation {
+		return []SchemaViolation{{Pointer: "/custom", Message: "rejected"}}
+	}))
+	t.Cleanup(func() { SetLarkDataValidator(nil) })
+
+	err := db.Create(&ApprovalM{InstanceID: "i1", ApprovalCode: "code", Type: ApprovalTypeLark,
+		LarkData: NewJSONColumn(LarkApproval{ApprovalName: "a"})}).Error
+	wantSchemaViolations(t, err, "/custom")
+	if err := db.Session(&gorm.Session{}).Create(&ApprovalM{InstanceID: "i2", ApprovalCode: "code", Type: ApprovalTypeLark}).Error; err != nil {
+		t.Errorf("empty lark_data: %v", err)
+	}
+}
+
+type validatorFunc func(data []byte) []SchemaViolation
+
+func (f validatorFunc) Validate(data []byte) []SchemaViolation { return f(data) }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// ErrStaleApproval 乐观锁冲突：记录在读取之后已被其他写入方修改
+var ErrStaleApproval = errors.New("approval is stale")
+
+// StaleApprovalError 乐观锁冲突的详细信息，可通过 errors.Is(err, ErrStaleApproval) 判断
+type StaleApprovalError struct {
+	InstanceID string // 审批实例ID
+	Version    uint64 // 写入方期望的版本号
+}
+
+func (e *StaleApprovalError) Error() string {
+	return fmt.Sprintf("approval %s is stale: expected version %d", e.InstanceID, e.Version)
+}
+
+// Is 使 errors.Is(err, ErrStaleApproval) 成立
+func (e *StaleApprovalError) Is(target error) bool {
+	return target == ErrStaleApproval
+}
+
+// DefaultMaxStaleRetries RetryOnStale 默认的最大尝试次数
+const DefaultMaxStaleRetries = 3
+
+// incrementVersion 版本号加 1 的更新表达式
+func incrementVersion() interface{} {
+	return gorm.Expr("version + 1")
+}
+
+// 通过 db.Set / setStatementValue 传递的设置
+const (
+	versionManagedKey       = "approval:version_managed"        // 本次更新自行写入 version，钩子不再加 1
+	pendingUpdateColumnsKey = "approval:pending_update_columns" // map[string]any，AfterUpdate 中补写的列
+)
+
+// bumpApprovalVersion 在 BeforeUpdate 中调用：经过钩子的每次更新都把 version 加 1
+//
+// Updates(map)/Update 已包含 version 时保持不变；SaveApprovalWithVersion 自行写入 version。
+// UpdateColumn(s) 跳过钩子，不修改版本号。
+func bumpApprovalVersion(tx *gorm.DB) {
+	if v, ok := tx.Get(versionManagedKey); ok && v == true {
+		return
+	}
+	if dest, ok := tx.Statement.Dest.(map[string]interface{}); ok {
+		if _, ok := dest["version"]; ok {
+			return
+		}
+		if _, ok := dest["Version"]; ok {
+			return
+		}
+	}
+	setUpdateColumn(tx, "version", incrementVersion())
+}
+
+// setUpdateColumn 为本次更新追加一列
+//
+// Updates(map)/Update 直接写入本次 UPDATE；Save、Updates(struct) 无法写入 SQL 表达式，
+// Updates(struct) 还会忽略零值字段，因此在 AfterUpdate 中以 UpdateColumns 按同样的条件补写，仍在同一事务中。
+func setUpdateColumn(tx *gorm.DB, column string, value any) {
+	if dest, ok := tx.Statement.Dest.(map[string]interface{}); ok {
+		dest[column] = value
+		return
+	}
+	pending, _ := statementValue(tx, pendingUpdateColumnsKey)
+	columns, _ := pending.(map[string]any)
+	if columns == nil {
+		columns = map[string]any{}
+		setStatementValue(tx, pendingUpdateColumnsKey, columns)
+	}
+	columns[column] = value
+}
+
+// applyPendingUpdateColumns 在 AfterUpdate 中补写 setUpdateColumn 记录的列，ids 为本次更新的记录
+func applyPendingUpdateColumns(tx *gorm.DB, ids []uint64) error {
+	pending, _ := statementValue(tx, pendingUpdateColumnsKey)
+	columns, _ := pending.(map[string]any)
+	if len(columns) == 0 || len(ids) == 0 {
+		return nil
+	}
+	if err := tx.Session(&gorm.Session{NewDB: true}).Unscoped().Model(&ApprovalM{}).
+		Where("id IN ?", ids).UpdateColumns(columns).Error; err != nil {
+		return fmt.Errorf("update approval columns %v: %w", sortedKeys(columns), err)
+	}
+	return nil
+}
+
+// SaveApprovalWithVersion 以乐观锁方式保存整条记录
+//
+// 仅当数据库中的 version 与 approval.Version 一致时才会写入，写入成功后 approval.Version 加 1；
+// 否则返回 *StaleApprovalError，approval 保持不变。tenant_id、instance_id、created_at 和 deleted_at 不会被更新。
+// approval.ID 为 0 时返回 gorm.ErrPrimaryKeyRequired：只有 version 条件会更新所有处于该版本的记录。
+func SaveApprovalWithVersion(db *gorm.DB, approval *ApprovalM) error {
+	if approval.ID == 0 {
+		return fmt.Errorf("save approval %s with version: %w", approval.InstanceID, gorm.ErrPrimaryKeyRequired)
+	}
+	expected := approval.Version
+	approval.Version = expected + 1
+
+	result := db.Set(versionManagedKey, true).Model(approval).
+		Select("*").
+		Omit("id", "created_at", "deleted_at", "tenant_id", "instance_id").
+		Where("id = ? AND version = ?", approval.ID, expected).
+		Updates(approval)
+	if result.Error != nil {
+		approval.Version = expected
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		approval.Version = expected
+		return &StaleApprovalError{InstanceID: approval.InstanceID, Version: expected}
+	}
+	return nil
+}
+
+// RetryOnStale 读取 instanceID 对应的记录，调用 mutate 修改后以乐观锁方式保存
+//
+// 遇到版本冲突时重新读取并再次调用 mutate，最多尝试 maxAttempts 次（<= 0 时使用 DefaultMaxStaleRetries），
+// 因此 mutate 必须只依赖传入的最新记录。mutate 返回的错误会直接返回且不再重试。
+func RetryOnStale(db *gorm.DB, instanceID string, maxAttempts int, mutate func(approval *ApprovalM) error) error {
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxStaleRetries
+	}
+
+	var err error
+	for range maxAttempts {
+		var approval ApprovalM
+		if err = db.Where("instance_id = ?", instanceID).First(&approval).Error; err != nil {
+			return err
+		}
+		if err = mutate(&approval); err != nil {
+			return err
+		}
+		if err = SaveApprovalWithVersion(db, &approval); !errors.Is(err, ErrStaleApproval) {
+			return err
+		}
+	}
+	return err
+}
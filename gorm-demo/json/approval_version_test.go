@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestSaveApprovalWithVersion(t *testing.T) {
+	db := openTestDB(t)
+	a := seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	seedApproval(t, db, "i2", LarkApproval{ApprovalName: "b", Status: ApprovalStatusPending})
+
+	stale := *a
+	a.ApprovalCode = "code2"
+	if err := SaveApprovalWithVersion(db, a); err != nil {
+		t.Fatal(err)
+	}
+	stale.ApprovalCode = "code3"
+	if err := SaveApprovalWithVersion(db, &stale); !errors.Is(err, ErrStaleApproval) {
+		t.Fatalf("err = %v, want ErrStaleApproval", err)
+	}
+	if got := instanceIDs(t, db, "approval_code = ?", "code2"); !slices.Equal(got, []string{"i1"}) {
+		t.Errorf("approval_code = code2: %v, want [i1]", got)
+	}
+}
+
+func TestSaveApprovalWithVersionZeroID(t *testing.T) {
+	db := openTestDB(t)
+	seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	seedApproval(t, db, "i2", LarkApproval{ApprovalName: "b", Status: ApprovalStatusPending})
+
+	// 没有主键时不能只按 version 更新，否则会覆盖所有同版本的记录
+	err := SaveApprovalWithVersion(db, &ApprovalM{InstanceID: "i1", ApprovalCode: "overwritten", Type: ApprovalTypeLark})
+	if !errors.Is(err, gorm.ErrPrimaryKeyRequired) {
+		t.Fatalf("err = %v, want ErrPrimaryKeyRequired", err)
+	}
+	if got := instanceIDs(t, db, "approval_code = ?", "overwritten"); len(got) != 0 {
+		t.Errorf("rows overwritten: %v", got)
+	}
+}
+
+func TestSaveSyncsModelVersion(t *testing.T) {
+	db := openTestDB(t)
+	seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	var a ApprovalM
+	if err := db.Where("instance_id = ?", "i1").First(&a).Error; err != nil {
+		t.Fatal(err)
+	}
+	for want := uint64(1); want <= 2; want++ {
+		a.ApprovalCode = fmt.Sprintf("code%d", want)
+		if err := db.Save(&a).Error; err != nil {
+			t.Fatal(err)
+		}
+		if a.Version != want {
+			t.Errorf("model version = %d, want %d", a.Version, want)
+		}
+	}
+	// SaveApprovalWithVersion 自行写入 version，钩子不会再加 1
+	if err := SaveApprovalWithVersion(db, &a); err != nil {
+		t.Fatal(err)
+	}
+	var stored ApprovalM
+	db.First(&stored, a.ID)
+	if stored.Version != 3 || a.Version != 3 {
+		t.Errorf("stored version = %d, model version = %d, want 3", stored.Version, a.Version)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 统一后的审批实例状态，沿用飞书的取值
+const (
+	ApprovalStatusPending  = "PENDING"
+	ApprovalStatusApproved = "APPROVED"
+	ApprovalStatusRejected = "REJECTED"
+	ApprovalStatusCanceled = "CANCELED"
+	ApprovalStatusDeleted  = "DELETED"
+)
+
+// 统一后的审批任务状态，沿用飞书的取值
+const (
+	TaskStatusPending     = "PENDING"
+	TaskStatusApproved    = "APPROVED"
+	TaskStatusRejected    = "REJECTED"
+	TaskStatusTransferred = "TRANSFERRED"
+	TaskStatusDone        = "DONE"
+)
+
+// ApprovalView 与审批平台无关的审批实例视图，由飞书或钉钉的原始数据归一化得到
+type ApprovalView struct {
+	Provider     string              `json:"provider"`      // 审批平台, 可选值: lark, dingtalk
+	InstanceID   string              `json:"instance_id"`   // 审批实例ID
+	ApprovalCode string              `json:"approval_code"` // 审批定义 Code
+	Title        string              `json:"title"`         // 审批名称/标题
+	Status       string              `json:"status"`        // 审批状态，见 ApprovalStatus* 常量
+	Originator   string              `json:"originator"`    // 发起人 user_id
+	Approvers    []string            `json:"approvers"`     // 审批人 user_id，按首次出现的顺序去重
+	CcUsers      []string            `json:"cc_users"`      // 抄送人 user_id
+	StartTime    time.Time           `json:"start_time"`    // 发起时间
+	EndTime      time.Time           `json:"end_time"`      // 完成时间，未完成时为零值
+	Tasks        []*ApprovalViewTask `json:"tasks"`         // 审批任务
+	Timeline     []*ApprovalViewStep `json:"timeline"`      // 审批动态
+}
+
+// ApprovalViewTask 归一化后的审批任务
+type ApprovalViewTask struct {
+	ID        string    `json:"id"`         // 任务 ID
+	UserID    string    `json:"user_id"`    // 审批人 user_id
+	NodeID    string    `json:"node_id"`    // 审批节点 ID
+	NodeName  string    `json:"node_name"`  // 审批节点名称
+	Status    string    `json:"status"`     // 任务状态，见 TaskStatus* 常量
+	StartTime time.Time `json:"start_time"` // 开始时间
+	EndTime   time.Time `json:"end_time"`   // 完成时间，未完成时为零值
+}
+
+// ApprovalViewStep 归一化后的审批动态
+type ApprovalViewStep struct {
+	Type    string    `json:"type"`    // 平台原始的动态类型，如飞书 PASS、钉钉 EXECUTE_TASK_NORMAL
+	UserID  string    `json:"user_id"` // 操作人 user_id
+	Time    time.Time `json:"time"`    // 发生时间
+	Comment string    `json:"comment"` // 理由/评论
+}
+
+// View 按 Type 解析 LarkData 或 DingTalkData 并归一化为 ApprovalView
+func (a *ApprovalM) View() (*ApprovalView, error) {
+	var (
+		view *ApprovalView
+		err  error
+	)
+	switch a.Type {
+	case ApprovalTypeLark:
+		lark, err := a.LarkData.Get()
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal lark_data of %s: %w", a.InstanceID, err)
+		}
+		view = NewApprovalViewFromLark(&lark)
+	case ApprovalTypeDingTalk:
+		var dingTalk DingTalkApproval
+		if err = unmarshalProviderData(a.DingTalkData, &dingTalk); err != nil {
+			return nil, fmt.Errorf("unmarshal dingtalk_data of %s: %w", a.InstanceID, err)
+		}
+		view = NewApprovalViewFromDingTalk(&dingTalk)
+	default:
+		return nil, fmt.Errorf("unsupported approval type %q", a.Type)
+	}
+	view.InstanceID = a.InstanceID
+	view.ApprovalCode = a.ApprovalCode
+	return view, nil
+}
+
+// unmarshalProviderData 空数据视为空对象
+func unmarshalProviderData(data []byte, v any) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// NewApprovalViewFromLark 由飞书审批实例生成视图
+func NewApprovalViewFromLark(l *LarkApproval) *ApprovalView {
+	view := &ApprovalView{
+		Provider:     ApprovalTypeLark,
+		InstanceID:   l.InstanceCode,
+		ApprovalCode: l.ApprovalCode,
+		Title:        l.ApprovalName,
+		Status:       strings.ToUpper(l.Status),
+		Originator:   l.UserID,
+		StartTime:    parseLarkMillis(l.StartTime),
+		EndTime:      parseLarkMillis(l.EndTime),
+	}
+	for _, t := range l.TaskList {
+		if t == nil {
+			continue
+		}
+		view.Tasks = append(view.Tasks, &ApprovalViewTask{
+			ID:        t.ID,
+			UserID:    t.UserID,
+			NodeID:    t.NodeID,
+			NodeName:  t.NodeName,
+			Status:    strings.ToUpper(t.Status),
+			StartTime: parseLarkMillis(t.StartTime),
+			EndTime:   parseLarkMillis(t.EndTime),
+		})
+		view.Approvers = appendUnique(view.Approvers, t.UserID)
+	}
+	for _, tl := range l.Timeline {
+		if tl == nil {
+			continue
+		}
+		view.Timeline = append(view.Timeline, &ApprovalViewStep{
+			Type:    tl.Type,
+			UserID:  tl.UserID,
+			Time:    parseLarkMillis(tl.CreateTime),
+			Comment: tl.Comment,
+		})
+		if tl.Type == "CC" {
+			for _, u := range tl.UserIDList {
+				view.CcUsers = appendUnique(view.CcUsers, u)
+			}
+			for _, cc := range tl.CcUserList {
+				if cc != nil {
+					view.CcUsers = appendUnique(view.CcUsers, cc.UserID)
+				}
+			}
+		}
+	}
+	return view
+}
+
+// NewApprovalViewFromDingTalk 由钉钉审批实例生成视图
+func NewApprovalViewFromDingTalk(d *DingTalkApproval) *ApprovalView {
+	view := &ApprovalView{
+		Provider:   ApprovalTypeDingTalk,
+		Title:      d.Title,
+		Status:     dingTalkInstanceStatus(d.Status, d.Result),
+		Originator: d.OriginatorUserId,
+		StartTime:  parseDingTalkTime(d.CreateTime),
+		EndTime:    parseDingTalkTime(d.FinishTime),
+	}
+	for _, t := range d.Tasks {
+		if t == nil {
+			continue
+		}
+		view.Tasks = append(view.Tasks, &ApprovalViewTask{
+			ID:        strconv.Itoa(t.TaskId),
+			UserID:    t.UserId,
+			NodeID:    t.ActivityId,
+			Status:    dingTalkTaskStatus(t.Status, t.Result),
+			StartTime: parseDingTalkTime(t.CreateTime),
+			EndTime:   parseDingTalkTime(t.FinishTime),
+		})
+	}
+	for _, u := range d.ApproverUserIds {
+		view.Approvers = appendUnique(view.Approvers, u)
+	}
+	for _, t := range view.Tasks {
+		view.Approvers = appendUnique(view.Approvers, t.UserID)
+	}
+	for _, u := range d.CcUserIds {
+		view.CcUsers = appendUnique(view.CcUsers, u)
+	}
+	for _, r := range d.OperationRecords {
+		if r == nil {
+			continue
+		}
+		view.Timeline = append(view.Timeline, &ApprovalViewStep{
+			Type:    r.Type,
+			UserID:  r.UserId,
+			Time:    parseDingTalkTime(r.Date),
+			Comment: r.Remark,
+		})
+		// 节点名称只出现在操作记录中
+		for _, t := range view.Tasks {
+			if t.NodeID == r.ActivityId && t.NodeName == "" {
+				t.NodeName = r.ShowName
+			}
+		}
+	}
+	return view
+}
+
+// dingTalkInstanceStatus 钉钉实例状态 + 审批结果 -> 统一状态
+func dingTalkInstanceStatus(status, result string) string {
+	switch strings.ToUpper(status) {
+	case "RUNNING", "NEW":
+		return ApprovalStatusPending
+	case "TERMINATED":
+		return ApprovalStatusCanceled
+	case "COMPLETED":
+		if strings.EqualFold(result, "refuse") {
+			return ApprovalStatusRejected
+		}
+		return ApprovalStatusApproved
+	default:
+		return strings.ToUpper(status)
+	}
+}
+
+// dingTalkTaskStatus 钉钉任务状态 + 任务结果 -> 统一任务状态
+func dingTalkTaskStatus(status, result string) string {
+	switch strings.ToUpper(result) {
+	case "AGREE":
+		return TaskStatusApproved
+	case "REFUSE":
+		return TaskStatusRejected
+	case "REDIRECTED":
+		return TaskStatusTransferred
+	}
+	switch strings.ToUpper(status) {
+	case "NEW", "RUNNING", "PAUSED":
+		return TaskStatusPending
+	default:
+		return TaskStatusDone
+	}
+}
+
+// parseLarkMillis 解析飞书的毫秒级时间戳字符串，"0" 或空字符串返回零值
+func parseLarkMillis(s string) time.Time {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// dingTalkTimeLayouts 钉钉接口返回的时间格式，如 2021-05-12T10:55Z
+var dingTalkTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02 15:04:05"}
+
+// parseDingTalkTime 解析钉钉时间，无法解析时返回零值
+func parseDingTalkTime(s string) time.Time {
+	for _, layout := range dingTalkTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func appendUnique(list []string, v string) []string {
+	if v == "" {
+		return list
+	}
+	for _, item := range list {
+		if item == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
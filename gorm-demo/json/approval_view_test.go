@@ -0,0 +1,227 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// viewTask 便于比较的任务摘要
+type viewTask struct {
+	ID, UserID, NodeID, NodeName, Status string
+	StartTime, EndTime                   time.Time
+}
+
+func viewTasks(view *ApprovalView) []viewTask {
+	var tasks []viewTask
+	for _, t := range view.Tasks {
+		tasks = append(tasks, viewTask{t.ID, t.UserID, t.NodeID, t.NodeName, t.Status, t.StartTime, t.EndTime})
+	}
+	return tasks
+}
+
+func viewSteps(view *ApprovalView) []ApprovalViewStep {
+	var steps []ApprovalViewStep
+	for _, s := range view.Timeline {
+		steps = append(steps, *s)
+	}
+	return steps
+}
+
+func TestLarkApprovalView(t *testing.T) {
+	db := openTestDB(t)
+	base := time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) string { return millis(base.Add(d)) }
+	seedApproval(t, db, "i1", LarkApproval{
+		ApprovalName: "差旅报销", Status: ApprovalStatusApproved, UserID: "u0", StartTime: at(0), EndTime: at(2 * time.Hour),
+		TaskList: []*InstanceTask{
+			{ID: "t1", NodeID: "n1", NodeName: "经理", UserID: "u1", Status: TaskStatusApproved, StartTime: at(0), EndTime: at(time.Hour)},
+			{ID: "t2", NodeID: "n2", NodeName: "财务", UserID: "u2", Status: TaskStatusPending, StartTime: at(time.Hour), EndTime: "0"},
+			{ID: "t3", NodeID: "n2", NodeName: "财务", UserID: "u1", Status: TaskStatusTransferred, StartTime: at(time.Hour)},
+		},
+		Timeline: []*InstanceTimeline{
+			{Type: "START", UserID: "u0", CreateTime: at(0)},
+			{Type: "PASS", UserID: "u1", CreateTime: at(time.Hour), Comment: "同意"},
+			{Type: "CC", UserID: "u1", CreateTime: at(time.Hour), UserIDList: []string{"u5", "u6"},
+				CcUserList: []*InstanceCcUser{{UserID: "u6"}, {UserID: "u7"}}},
+		},
+	})
+	var a ApprovalM
+	if err := db.Where("instance_id = ?", "i1").First(&a).Error; err != nil {
+		t.Fatal(err)
+	}
+	view, err := a.View()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// InstanceID、ApprovalCode 取自记录而不是 lark_data
+	if view.Provider != ApprovalTypeLark || view.InstanceID != "i1" || view.ApprovalCode != "code" || view.Title != "差旅报销" ||
+		view.Status != ApprovalStatusApproved || view.Originator != "u0" {
+		t.Errorf("view = %+v", view)
+	}
+	if !view.StartTime.Equal(base) || !view.EndTime.Equal(base.Add(2*time.Hour)) {
+		t.Errorf("start %v end %v", view.StartTime, view.EndTime)
+	}
+	if !slices.Equal(view.Approvers, []string{"u1", "u2"}) || !slices.Equal(view.CcUsers, []string{"u5", "u6", "u7"}) {
+		t.Errorf("approvers %v cc %v", view.Approvers, view.CcUsers)
+	}
+	wantTasks := []viewTask{
+		{"t1", "u1", "n1", "经理", TaskStatusApproved, base, base.Add(time.Hour)},
+		{"t2", "u2", "n2", "财务", TaskStatusPending, base.Add(time.Hour), time.Time{}},
+		{"t3", "u1", "n2", "财务", TaskStatusTransferred, base.Add(time.Hour), time.Time{}},
+	}
+	if got := viewTasks(view); !slices.EqualFunc(got, wantTasks, viewTaskEqual) {
+		t.Errorf("tasks = %+v", got)
+	}
+	wantSteps := []ApprovalViewStep{
+		{Type: "START", UserID: "u0", Time: base},
+		{Type: "PASS", UserID: "u1", Time: base.Add(time.Hour), Comment: "同意"},
+		{Type: "CC", UserID: "u1", Time: base.Add(time.Hour)},
+	}
+	if got := viewSteps(view); !slices.EqualFunc(got, wantSteps, viewStepEqual) {
+		t.Errorf("timeline = %+v", got)
+	}
+}
+
+func TestLarkApprovalViewNormalizes(t *testing.T) {
+	// 未经过钩子的原始数据：小写状态、nil 元素、空时间戳
+	view := NewApprovalViewFromLark(&LarkApproval{
+		ApprovalName: "a", Status: "pending", InstanceCode: "ic", ApprovalCode: "ac", StartTime: "abc",
+		TaskList: []*InstanceTask{nil, {ID: "t1", Status: "rejected"}},
+		Timeline: []*InstanceTimeline{nil, {Type: "CC", CcUserList: []*InstanceCcUser{nil, {UserID: ""}}}},
+	})
+	if view.InstanceID != "ic" || view.ApprovalCode != "ac" || view.Status != ApprovalStatusPending || !view.StartTime.IsZero() {
+		t.Errorf("view = %+v", view)
+	}
+	if len(view.Tasks) != 1 || view.Tasks[0].Status != TaskStatusRejected || view.Approvers != nil {
+		t.Errorf("tasks = %+v, approvers = %v", viewTasks(view), view.Approvers)
+	}
+	if len(view.Timeline) != 1 || view.CcUsers != nil {
+		t.Errorf("timeline = %+v, cc = %v", viewSteps(view), view.CcUsers)
+	}
+}
+
+func TestDingTalkApprovalView(t *testing.T) {
+	db := openTestDB(t)
+	data := `{
+		"title": "采购申请", "status": "COMPLETED", "result": "refuse", "originatorUserId": "d0",
+		"createTime": "2021-05-12T10:55Z", "finishTime": "2021-05-12 12:00:00",
+		"approverUserIds": ["d2", "d1"], "ccUserIds": ["d5", "d5", "d6"],
+		"tasks": [
+			{"taskId": 11, "userId": "d1", "status": "COMPLETED", "result": "AGREE", "activityId": "a1",
+				"createTime": "2021-05-12T10:55Z", "finishTime": "2021-05-12T11:00:00+08:00"},
+			{"taskId": 12, "userId": "d2", "status": "COMPLETED", "result": "REFUSE", "activityId": "a2", "createTime": "2021-05-12T11:00Z"},
+			{"taskId": 13, "userId": "d3", "status": "CANCELED", "result": "REDIRECTED", "activityId": "a2"},
+			{"taskId": 14, "userId": "d4", "status": "RUNNING", "result": "NONE", "activityId": "a3"},
+			{"taskId": 15, "userId": "d4", "status": "CANCELED", "result": "NONE", "activityId": "a3"},
+			null
+		],
+		"operationRecords": [
+			{"userId": "d0", "type": "START_PROCESS_INSTANCE", "date": "2021-05-12T10:55Z", "showName": "发起"},
+			{"userId": "d1", "type": "EXECUTE_TASK_NORMAL", "date": "2021-05-12 11:00:00", "remark": "同意", "activityId": "a1", "showName": "经理"},
+			{"userId": "d2", "type": "EXECUTE_TASK_NORMAL", "date": "bad", "activityId": "a2", "showName": "财务"},
+			{"userId": "d2", "type": "EXECUTE_TASK_NORMAL", "activityId": "a2", "showName": "财务复核"},
+			null
+		]
+	}`
+	a := &ApprovalM{InstanceID: "d-1", ApprovalCode: "PROC-1", Type: ApprovalTypeDingTalk, DingTalkData: datatypes.JSON(data)}
+	if err := db.Create(a).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.First(a, a.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	view, err := a.View()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2021, 5, 12, 10, 55, 0, 0, time.UTC)
+	if view.Provider != ApprovalTypeDingTalk || view.InstanceID != "d-1" || view.ApprovalCode != "PROC-1" || view.Title != "采购申请" ||
+		view.Status != ApprovalStatusRejected || view.Originator != "d0" {
+		t.Errorf("view = %+v", view)
+	}
+	if !view.StartTime.Equal(created) || !view.EndTime.Equal(time.Date(2021, 5, 12, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("start %v end %v", view.StartTime, view.EndTime)
+	}
+	// approverUserIds 在前，其后是只出现在任务中的审批人
+	if !slices.Equal(view.Approvers, []string{"d2", "d1", "d3", "d4"}) || !slices.Equal(view.CcUsers, []string{"d5", "d6"}) {
+		t.Errorf("approvers %v cc %v", view.Approvers, view.CcUsers)
+	}
+	// 节点名称取自同一 activityId 的第一条操作记录
+	wantTasks := []viewTask{
+		{"11", "d1", "a1", "经理", TaskStatusApproved, created, time.Date(2021, 5, 12, 3, 0, 0, 0, time.UTC)},
+		{"12", "d2", "a2", "财务", TaskStatusRejected, created.Add(5 * time.Minute), time.Time{}},
+		{"13", "d3", "a2", "财务", TaskStatusTransferred, time.Time{}, time.Time{}},
+		{"14", "d4", "a3", "", TaskStatusPending, time.Time{}, time.Time{}},
+		{"15", "d4", "a3", "", TaskStatusDone, time.Time{}, time.Time{}},
+	}
+	if got := viewTasks(view); !slices.EqualFunc(got, wantTasks, viewTaskEqual) {
+		t.Errorf("tasks = %+v", got)
+	}
+	wantSteps := []ApprovalViewStep{
+		{Type: "START_PROCESS_INSTANCE", UserID: "d0", Time: created},
+		{Type: "EXECUTE_TASK_NORMAL", UserID: "d1", Time: created.Add(5 * time.Minute), Comment: "同意"},
+		{Type: "EXECUTE_TASK_NORMAL", UserID: "d2"},
+		{Type: "EXECUTE_TASK_NORMAL", UserID: "d2"},
+	}
+	if got := viewSteps(view); !slices.EqualFunc(got, wantSteps, viewStepEqual) {
+		t.Errorf("timeline = %+v", got)
+	}
+}
+
+func TestDingTalkStatuses(t *testing.T) {
+	for _, tt := range []struct{ status, result, want string }{
+		{"NEW", "", ApprovalStatusPending},
+		{"running", "", ApprovalStatusPending},
+		{"TERMINATED", "", ApprovalStatusCanceled},
+		{"COMPLETED", "agree", ApprovalStatusApproved},
+		{"COMPLETED", "REFUSE", ApprovalStatusRejected},
+		{"canceled", "", "CANCELED"},
+	} {
+		if got := dingTalkInstanceStatus(tt.status, tt.result); got != tt.want {
+			t.Errorf("instance %s/%s = %s, want %s", tt.status, tt.result, got, tt.want)
+		}
+	}
+	for _, tt := range []struct{ status, result, want string }{
+		{"COMPLETED", "agree", TaskStatusApproved},
+		{"COMPLETED", "refuse", TaskStatusRejected},
+		{"CANCELED", "redirected", TaskStatusTransferred},
+		{"NEW", "NONE", TaskStatusPending},
+		{"PAUSED", "", TaskStatusPending},
+		{"CANCELED", "NONE", TaskStatusDone},
+	} {
+		if got := dingTalkTaskStatus(tt.status, tt.result); got != tt.want {
+			t.Errorf("task %s/%s = %s, want %s", tt.status, tt.result, got, tt.want)
+		}
+	}
+}
+
+func TestApprovalViewErrors(t *testing.T) {
+	// 钉钉数据为空时得到只有记录字段的视图
+	view, err := (&ApprovalM{InstanceID: "d-1", Type: ApprovalTypeDingTalk}).View()
+	if err != nil || view.Provider != ApprovalTypeDingTalk || view.InstanceID != "d-1" || view.Tasks != nil {
+		t.Errorf("empty dingtalk view = %+v, %v", view, err)
+	}
+	for _, a := range []*ApprovalM{
+		{InstanceID: "d-2", Type: ApprovalTypeDingTalk, DingTalkData: datatypes.JSON(`{"tasks": {}}`)},
+		{InstanceID: "l-1", Type: ApprovalTypeLark, LarkData: RawJSONColumn[LarkApproval]([]byte(`[]`))},
+		{InstanceID: "w-1", Type: "wecom"},
+	} {
+		if view, err := a.View(); err == nil {
+			t.Errorf("%s: view = %+v, want error", a.InstanceID, view)
+		}
+	}
+}
+
+func viewTaskEqual(a, b viewTask) bool {
+	return a.ID == b.ID && a.UserID == b.UserID && a.NodeID == b.NodeID && a.NodeName == b.NodeName && a.Status == b.Status &&
+		a.StartTime.Equal(b.StartTime) && a.EndTime.Equal(b.EndTime)
+}
+
+func viewStepEqual(a, b ApprovalViewStep) bool {
+	return a.Type == b.Type && a.UserID == b.UserID && a.Time.Equal(b.Time) && a.Comment == b.Comment
+}
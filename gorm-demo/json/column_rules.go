@@ -0,0 +1,405 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// 列规则名称
+const (
+	RuleImmutable = "immutable" // 创建后不能修改
+	RuleNotEmpty  = "not_empty" // 不能写入空字符串、零值或 NULL
+	RuleNotNull   = "not_null"  // 不在 Nullable 中的列不能写入 NULL
+)
+
+// ColumnRules 一张表的列级写入规则
+type ColumnRules struct {
+	Table     string
+	Immutable []string // 创建后不能修改的列，更新时只允许写入与当前值相同的值
+	NotEmpty  []string // 创建和更新时不能为空的列
+	Nullable  []string // 允许写入 NULL 的列，其他列（主键除外）写入 NULL 时报错
+}
+
+// ApprovalColumnRules approval 表的列规则，与 ddl.sql.tpl 保持一致
+var ApprovalColumnRules = ColumnRules{
+	Table:     "approval",
+	Immutable: []string{"tenant_id", "instance_id"},
+	NotEmpty:  []string{"instance_id", "approval_code", "type"},
+	Nullable:  []string{"created_at", "updated_at", "deleted_at", "lark_data", "dingtalk_data"},
+}
+
+// ColumnRuleError 违反列规则
+type ColumnRuleError struct {
+	Table  string
+	Column string
+	Rule   string
+}
+
+func (e *ColumnRuleError) Error() string {
+	switch e.Rule {
+	case RuleImmutable:
+		return fmt.Sprintf("%s should not be modified", e.Column)
+	case RuleNotEmpty:
+		return fmt.Sprintf("%s cannot be empty", e.Column)
+	default:
+		return fmt.Sprintf("%s cannot be null", e.Column)
+	}
+}
+
+// ColumnRulesPlugin 在执行 INSERT/UPDATE 之前按 ColumnRules 校验本次写入的值
+//
+// 规则按表名匹配，校验的是 GORM 最终会写入的列，而不是 Statement.Changed 的结果，因此
+// Create、Save、Updates(struct)、Updates(map)、Update、UpdateColumn(s) 以及 gorm.Expr 表达式更新的行为一致：
+//   - Save/Updates(struct) 按 GORM 的规则取出会写入的字段（Select 的字段或非零值字段）
+//   - Updates(map)/Update/UpdateColumn(s) 取 map 中的列，db.Table("approval") 这样没有模型的更新同样生效
+//   - 值为 SQL 表达式时无法在写入前得到结果：不可变列直接拒绝，其他规则不校验
+//   - 不可变列以与本次更新相同的条件查询，只要有一行的当前值与新值不同就拒绝
+//
+// 回调在 BeforeCreate/BeforeUpdate 钩子之后执行。模型的钩子通过 checkCreateColumnRules/checkUpdateColumnRules
+// 自行校验时（如 ApprovalM），插件跳过该语句，只为 UpdateColumn(s)、db.Table("approval") 等不经过钩子的写入补充校验。
+// Exec/Raw 不经过校验。
+//
+//	db.Use(NewColumnRulesPlugin(ApprovalColumnRules))
+type ColumnRulesPlugin struct {
+	rules map[string]ColumnRules
+}
+
+// NewColumnRulesPlugin 创建列规则插件
+func NewColumnRulesPlugin(rules ...ColumnRules) *ColumnRulesPlugin {
+	p := &ColumnRulesPlugin{rules: make(map[string]ColumnRules, len(rules))}
+	for _, r := range rules {
+		p.rules[r.Table] = r
+	}
+	return p
+}
+
+// Name 实现 gorm.Plugin
+func (p *ColumnRulesPlugin) Name() string {
+	return "column_rules"
+}
+
+// Initialize 实现 gorm.Plugin
+func (p *ColumnRulesPlugin) Initialize(db *gorm.DB) error {
+	if err := db.Callback().Create().After("gorm:before_create").Before("gorm:create").Register("column_rules:create", p.checkCreate); err != nil {
+		return err
+	}
+	return db.Callback().Update().After("gorm:before_update").Before("gorm:update").Register("column_rules:update", p.checkUpdate)
+}
+
+func (p *ColumnRulesPlugin) rulesOf(db *gorm.DB) (ColumnRules, bool) {
+	table := db.Statement.Table
+	if table == "" && db.Statement.Schema != nil {
+		table = db.Statement.Schema.Table
+	}
+	r, ok := p.rules[table]
+	return r, ok
+}
+
+// columnRulesCheckedKey 通过 setStatementValue 标记钩子已校验本次写入
+const columnRulesCheckedKey = "column_rules:checked"
+
+// checkCreate 校验新建记录的全部列
+func (p *ColumnRulesPlugin) checkCreate(db *gorm.DB) {
+	if db.Error != nil || columnRulesChecked(db) {
+		return
+	}
+	rules, ok := p.rulesOf(db)
+	if !ok {
+		return
+	}
+	for _, row := range createdRows(db.Statement) {
+		if err := rules.checkRow(row); err != nil {
+			db.AddError(err)
+			return
+		}
+	}
+}
+
+// checkUpdate 校验本次更新会写入的列
+func (p *ColumnRulesPlugin) checkUpdate(db *gorm.DB) {
+	if db.Error != nil || columnRulesChecked(db) {
+		return
+	}
+	rules, ok := p.rulesOf(db)
+	if !ok {
+		return
+	}
+	if err := rules.checkUpdate(db); err != nil {
+		db.AddError(err)
+	}
+}
+
+// checkCreateColumnRules 在 BeforeCreate 钩子中按 rules 校验新建的记录 model
+func checkCreateColumnRules(tx *gorm.DB, rules ColumnRules, model any) error {
+	rv := reflect.Indirect(reflect.ValueOf(model))
+	if tx.Statement.Schema == nil || rv.Type() != tx.Statement.Schema.ModelType {
+		return nil
+	}
+	if err := rules.checkRow(structRow(tx.Statement, rv)); err != nil {
+		return err
+	}
+	setStatementValue(tx, columnRulesCheckedKey, true)
+	return nil
+}
+
+// checkUpdateColumnRules 在 BeforeUpdate 钩子中按 rules 校验本次更新，应在钩子修改 Dest 之前调用
+func checkUpdateColumnRules(tx *gorm.DB, rules ColumnRules) error {
+	if err := rules.checkUpdate(tx); err != nil {
+		return err
+	}
+	setStatementValue(tx, columnRulesCheckedKey, true)
+	return nil
+}
+
+// columnRulesChecked 本次写入是否已在钩子中校验
+func columnRulesChecked(db *gorm.DB) bool {
+	_, ok := statementValue(db, columnRulesCheckedKey)
+	return ok
+}
+
+// checkRow 校验新建的一行，map 中缺少的 NotEmpty 列同样视为空
+func (r ColumnRules) checkRow(row map[string]any) error {
+	for _, column := range r.NotEmpty {
+		if _, ok := row[column]; !ok {
+			row[column] = nil
+		}
+	}
+	return r.checkValues(row)
+}
+
+// checkUpdate 校验本次更新会写入的列
+func (r ColumnRules) checkUpdate(db *gorm.DB) error {
+	assignments := updateAssignments(db.Statement)
+	if err := r.checkValues(assignments); err != nil {
+		return err
+	}
+	return r.checkImmutable(db, assignments)
+}
+
+// checkValues 校验 NotEmpty 和 Nullable 规则，表达式不校验
+func (r ColumnRules) checkValues(values map[string]any) error {
+	columns := make([]string, 0, len(values))
+	for column := range values {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	for _, column := range columns {
+		value, isExpr, err := resolveColumnValue(values[column])
+		if err != nil {
+			return fmt.Errorf("resolve value of %s: %w", column, err)
+		}
+		if isExpr {
+			continue
+		}
+		if slices.Contains(r.NotEmpty, column) && (value == nil || reflect.ValueOf(value).IsZero()) {
+			return &ColumnRuleError{Table: r.Table, Column: column, Rule: RuleNotEmpty}
+		}
+		if value == nil && !slices.Contains(r.Nullable, column) {
+			return &ColumnRuleError{Table: r.Table, Column: column, Rule: RuleNotNull}
+		}
+	}
+	return nil
+}
+
+// checkImmutable 不可变列的新值必须与所有待更新行的当前值相同
+func (r ColumnRules) checkImmutable(db *gorm.DB, assignments map[string]any) error {
+	for _, column := range r.Immutable {
+		v, ok := assignments[column]
+		if !ok {
+			continue
+		}
+		value, isExpr, err := resolveColumnValue(v)
+		if err != nil {
+			return err
+		}
+		if isExpr {
+			return &ColumnRuleError{Table: r.Table, Column: column, Rule: RuleImmutable}
+		}
+
+		var changed int64
+		err = updateTargetQuery(db).
+			Where(clause.Or(
+				clause.Neq{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Value: value},
+				clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Value: nil},
+			)).
+			Count(&changed).Error
+		if err != nil {
+			return fmt.Errorf("check immutable column %s: %w", column, err)
+		}
+		if changed > 0 {
+			return &ColumnRuleError{Table: r.Table, Column: column, Rule: RuleImmutable}
+		}
+	}
+	return nil
+}
+
+// updateTargetQuery 以与本次更新相同的条件查询待更新的行：复制 WHERE 子句，并补上 GORM 会根据模型主键添加的条件
+func updateTargetQuery(db *gorm.DB) *gorm.DB {
+	stmt := db.Statement
+	query := db.Session(&gorm.Session{NewDB: true})
+	if stmt.Schema != nil {
+		query = query.Model(reflect.New(stmt.Schema.ModelType).Interface())
+	}
+	if stmt.Table != "" {
+		query = query.Table(stmt.Table)
+	}
+	if stmt.Unscoped {
+		query = query.Unscoped()
+	}
+	if where, ok := stmt.Clauses["WHERE"]; ok && where.Expression != nil {
+		query = query.Clauses(where.Expression)
+	}
+	if stmt.Schema != nil && stmt.ReflectValue.Kind() == reflect.Struct {
+		for _, field := range stmt.Schema.PrimaryFields {
+			if value, isZero := field.ValueOf(stmt.Context, stmt.ReflectValue); !isZero {
+				query = query.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: value})
+			}
+		}
+	}
+	return query
+}
+
+// updateAssignments 按 GORM 生成 SET 子句的规则，取出本次更新会写入的列和值
+func updateAssignments(stmt *gorm.Statement) map[string]any {
+	values := map[string]any{}
+	if c, ok := stmt.Clauses["SET"]; ok {
+		if set, ok := c.Expression.(clause.Set); ok {
+			for _, a := range set {
+				values[a.Column.Name] = a.Value
+			}
+		}
+	}
+
+	selectColumns, restricted := map[string]bool{}, false
+	if stmt.Schema != nil {
+		selectColumns, restricted = stmt.SelectAndOmitColumns(false, true)
+	}
+	selected := func(column string, isZero bool) bool {
+		v, ok := selectColumns[column]
+		return (ok && v) || (!ok && !restricted && !isZero)
+	}
+
+	switch dest := stmt.Dest.(type) {
+	case map[string]interface{}:
+		for k, v := range dest {
+			column := k
+			if stmt.Schema != nil {
+				field := stmt.Schema.LookUpField(k)
+				if field == nil || field.DBName == "" || !field.Updatable {
+					continue
+				}
+				column = field.DBName
+			}
+			if selected(column, false) {
+				values[column] = v
+			}
+		}
+	default:
+		if stmt.Schema == nil {
+			break
+		}
+		rv := reflect.ValueOf(dest)
+		for rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				return values
+			}
+			rv = rv.Elem()
+		}
+		if rv.Kind() != reflect.Struct || rv.Type() != stmt.Schema.ModelType {
+			break
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" || field.PrimaryKey || !field.Updatable {
+				continue
+			}
+			value, isZero := field.ValueOf(stmt.Context, rv)
+			if selected(field.DBName, isZero) {
+				values[field.DBName] = value
+			}
+		}
+	}
+	return values
+}
+
+// createdRows 取出新建的每一行，列名为数据库列名
+func createdRows(stmt *gorm.Statement) []map[string]any {
+	var rows []map[string]any
+	switch dest := stmt.Dest.(type) {
+	case map[string]interface{}:
+		rows = append(rows, mapColumns(stmt.Schema, dest))
+	case []map[string]interface{}:
+		for _, m := range dest {
+			rows = append(rows, mapColumns(stmt.Schema, m))
+		}
+	default:
+		if stmt.Schema == nil {
+			break
+		}
+		eachStruct(stmt.ReflectValue, func(rv reflect.Value) {
+			if rv.Type() == stmt.Schema.ModelType {
+				rows = append(rows, structRow(stmt, rv))
+			}
+		})
+	}
+	return rows
+}
+
+// structRow 取出结构体 rv 新建时写入的列
+func structRow(stmt *gorm.Statement, rv reflect.Value) map[string]any {
+	row := map[string]any{}
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName == "" || field.PrimaryKey || !field.Creatable {
+			continue
+		}
+		value, _ := field.ValueOf(stmt.Context, rv)
+		row[field.DBName] = value
+	}
+	return row
+}
+
+func mapColumns(s *schema.Schema, m map[string]any) map[string]any {
+	row := make(map[string]any, len(m))
+	for k, v := range m {
+		if s != nil {
+			if field := s.LookUpField(k); field != nil && field.DBName != "" {
+				k = field.DBName
+			}
+		}
+		row[k] = v
+	}
+	return row
+}
+
+// resolveColumnValue 取出写入数据库的值：SQL 表达式返回 isExpr，driver.Valuer 调用 Value，nil 指针视为 NULL
+func resolveColumnValue(v any) (value any, isExpr bool, err error) {
+	if v == nil {
+		return nil, false, nil
+	}
+	switch v.(type) {
+	case interface{ documentType() reflect.Type }:
+		// JSONColumn 实现 gorm.Valuer 只是为了写入时加密，按 driver.Valuer 取值
+	case clause.Expression, *clause.Expr, gorm.Valuer:
+		return nil, true, nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, false, nil
+		}
+		if _, ok := v.(driver.Valuer); !ok {
+			return resolveColumnValue(rv.Elem().Interface())
+		}
+	}
+	if valuer, ok := v.(driver.Valuer); ok {
+		value, err := valuer.Value()
+		return value, false, err
+	}
+	return v, false, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// instanceIDChanges 修改 instance_id 的各种更新方式，hooked 为 false 的方式不经过钩子，只有 ColumnRulesPlugin 能拦截
+var instanceIDChanges = []struct {
+	name   string
+	hooked bool
+	update func(db *gorm.DB, a *ApprovalM) error
+}{
+	{"Save", true, func(db *gorm.DB, a *ApprovalM) error {
+		a.InstanceID = "changed"
+		return db.Save(a).Error
+	}},
+	{"Updates struct", true, func(db *gorm.DB, a *ApprovalM) error {
+		return db.Model(a).Updates(ApprovalM{InstanceID: "changed"}).Error
+	}},
+	{"Updates map", true, func(db *gorm.DB, a *ApprovalM) error {
+		return db.Model(a).Updates(map[string]any{"instance_id": "changed"}).Error
+	}},
+	{"Update", true, func(db *gorm.DB, a *ApprovalM) error {
+		return db.Model(a).Update("instance_id", "changed").Error
+	}},
+	{"Update expr", true, func(db *gorm.DB, a *ApprovalM) error {
+		return db.Model(a).Update("instance_id", gorm.Expr("instance_id || ?", "-changed")).Error
+	}},
+	{"UpdateColumn", false, func(db *gorm.DB, a *ApprovalM) error {
+		return db.Model(a).UpdateColumn("instance_id", "changed").Error
+	}},
+	{"UpdateColumns expr", false, func(db *gorm.DB, a *ApprovalM) error {
+		return db.Model(a).UpdateColumns(map[string]any{"instance_id": gorm.Expr("instance_id || ?", "-changed")}).Error
+	}},
+	{"Table", false, func(db *gorm.DB, a *ApprovalM) error {
+		return db.Table("approval").Where("id = ?", a.ID).Updates(map[string]any{"instance_id": "changed"}).Error
+	}},
+}
+
+func TestInstanceIDImmutable(t *testing.T) {
+	for _, plugin := range []bool{false, true} {
+		for _, tt := range instanceIDChanges {
+			if !tt.hooked && !plugin {
+				continue
+			}
+			name := tt.name
+			if plugin {
+				name += " with plugin"
+			}
+			t.Run(name, func(t *testing.T) {
+				db := openTestDB(t)
+				if plugin {
+					if err := db.Use(NewColumnRulesPlugin(ApprovalColumnRules)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				a := seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+
+				err := tt.update(db, a)
+				var ruleErr *ColumnRuleError
+				if !errors.As(err, &ruleErr) || ruleErr.Column != "instance_id" || ruleErr.Rule != RuleImmutable {
+					t.Fatalf("err = %v, want instance_id immutable", err)
+				}
+				if got := instanceIDs(t, db); !slices.Equal(got, []string{"i1"}) {
+					t.Errorf("instance_ids = %v, want [i1]", got)
+				}
+			})
+		}
+	}
+}
+
+func TestInstanceIDUnchangedAllowed(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Use(NewColumnRulesPlugin(ApprovalColumnRules)); err != nil {
+		t.Fatal(err)
+	}
+	a := seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	a.ApprovalCode = "code2"
+	if err := db.Save(a).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(a).Updates(map[string]any{"instance_id": "i1", "approval_code": "code3"}).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestColumnRulesNotEmpty(t *testing.T) {
+	db := openTestDB(t)
+	a := seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+
+	tests := []struct {
+		name   string
+		write  func() error
+		column string
+	}{
+		{"Create", func() error {
+			return db.Create(&ApprovalM{InstanceID: "i2", Type: ApprovalTypeLark}).Error
+		}, "approval_code"},
+		{"Updates map", func() error {
+			return db.Model(a).Updates(map[string]any{"type": ""}).Error
+		}, "type"},
+		{"Update", func() error {
+			return db.Model(a).Update("approval_code", "").Error
+		}, "approval_code"},
+		{"Save", func() error {
+			b := *a
+			b.ApprovalCode = ""
+			return db.Save(&b).Error
+		}, "approval_code"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ruleErr *ColumnRuleError
+			if err := tt.write(); !errors.As(err, &ruleErr) || ruleErr.Column != tt.column || ruleErr.Rule != RuleNotEmpty {
+				t.Errorf("err = %v, want %s not empty", err, tt.column)
+			}
+		})
+	}
+}
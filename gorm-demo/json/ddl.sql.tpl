@@ -1,14 +1,150 @@
-create table approval (
-  id bigint unsigned auto_increment primary key,
-  created_at datetime null,
-  updated_at datetime null,
-  deleted_at datetime null,
-  instance_id varchar(255) not null comment '审批实例 ID, 飞书: uuid',
-  approval_code varchar(255) not null comment '审批实例 Code, 即表单定义的唯一 ID, 飞书: approval_code',
-  type varchar(20) not null comment '审批实例类型, 可选值: lark, dingtalk',
-  is_written_es tinyint(1) not null default 0 comment '是否已写入ES：0-未写入，1-已写入',
-  -- 这里把分号改成逗号
-  lark_data json not null comment '单个飞书审批实例数据',
-  -- 为 instance_id 创建唯一索引
-  constraint uk_instance_id unique (instance_id)
+{{/*
+  数据库结构的版本化迁移，由 SchemaMigrator 按数据库方言渲染后执行，见 schema_migration.go。
+
+  - 每个版本定义 "NNNN_名称.up" 和 "NNNN_名称.down" 两个模板，已发布的版本不要再修改，结构变更请新增版本
+  - 每条语句以分号结尾
+  - 方言相关的写法：{{.PK}} {{.BigInt}} {{.Bool}} {{.False}} {{.JSON}} {{.Datetime}} {{.Comment "注释"}}，
+    以及 {{if .Is "sqlite"}} ... {{end}}
+*/}}
+
+{{define "approval.table"}}create table {{.Var "table"}} (
+  id {{.PK}},
+  created_at {{.Datetime}} null,
+  updated_at {{.Datetime}} null,
+  deleted_at {{.Datetime}} null,
+  instance_id varchar(255) not null{{.Comment "审批实例 ID, 飞书: uuid"}},
+  approval_code varchar(255) not null{{.Comment "审批实例 Code, 即表单定义的唯一 ID, 飞书: approval_code"}},
+  type varchar(20) not null{{.Comment "审批实例类型, 可选值: lark, dingtalk"}},
+  is_written_es {{.Bool}} not null default {{.False}}{{.Comment "是否已写入ES：0-未写入，1-已写入"}},
+  lark_data {{.JSON}} {{.Var "lark_data"}}{{.Comment "单个飞书审批实例数据"}},
+  dingtalk_data {{.JSON}} null{{.Comment "单个钉钉审批实例数据"}},
+  version {{.BigInt}} not null default 0{{.Comment "乐观锁版本号，每次更新加 1"}}
+);{{end}}
+
+{{define "approval.rebuild"}}{{/* SQLite 不支持修改列，通过重建表修改 lark_data 的可空性 */}}
+{{template "approval.table" (.With "table" "approval_new" "lark_data" (.Var "lark_data"))}}
+insert into approval_new (id, created_at, updated_at, deleted_at, instance_id, approval_code, type, is_written_es, lark_data, dingtalk_data, version)
+select id, created_at, updated_at, deleted_at, instance_id, approval_code, type, is_written_es, lark_data, dingtalk_data, version from approval;
+drop table approval;
+alter table approval_new rename to approval;
+create unique index uk_instance_id on approval (instance_id);
+{{end}}
+
+{{define "0001_create_approval.up"}}
+{{template "approval.table" (.With "table" "approval" "lark_data" "not null")}}
+-- 为 instance_id 创建唯一索引
+create unique index uk_instance_id on approval (instance_id);
+{{end}}
+
+{{define "0001_create_approval.down"}}
+drop table approval;
+{{end}}
+
+{{define "0002_create_approval_history.up"}}
+create table approval_history (
+  id {{.PK}},
+  created_at {{.Datetime}} null{{.Comment "变更时间"}},
+  approval_id {{.BigInt}} not null{{.Comment "approval 表主键"}},
+  instance_id varchar(255) not null{{.Comment "审批实例 ID"}},
+  operation varchar(20) not null{{.Comment "操作类型, 可选值: create, update"}},
+  actor varchar(255) not null{{.Comment "操作人"}},
+  version {{.BigInt}} not null{{.Comment "变更后的版本号"}},
+  diff {{.JSON}} not null{{.Comment "旧 lark_data -> 新 lark_data 的 JSON Patch"}},
+  revert_diff {{.JSON}} not null{{.Comment "新 lark_data -> 旧 lark_data 的 JSON Patch"}}
 );
+-- 按实例查询历史以及按时间重建
+create index idx_instance_id_created_at on approval_history (instance_id, created_at);
+{{end}}
+
+{{define "0002_create_approval_history.down"}}
+drop table approval_history;
+{{end}}
+
+{{define "0003_approval_lark_data_nullable.up"}}
+{{- /* ApprovalM.LarkData 为 JSONColumn，零值写入 NULL，钉钉审批实例没有 lark_data */ -}}
+{{if .Is "sqlite"}}{{template "approval.rebuild" (.With "lark_data" "null")}}
+{{else if .Is "postgres"}}alter table approval alter column lark_data drop not null;
+{{else}}alter table approval modify lark_data {{.JSON}} null{{.Comment "单个飞书审批实例数据"}};
+{{end}}
+{{end}}
+
+{{define "0003_approval_lark_data_nullable.down"}}
+{{if .Is "sqlite"}}{{template "approval.rebuild" (.With "lark_data" "not null")}}
+{{else if .Is "postgres"}}alter table approval alter column lark_data set not null;
+{{else}}alter table approval modify lark_data {{.JSON}} not null{{.Comment "单个飞书审批实例数据"}};
+{{end}}
+{{end}}
+
+{{define "0004_approval_deleted_at_index.up"}}
+-- 软删除查询条件 deleted_at IS NULL 使用的索引，与 ApprovalM.DeletedAt 的 index 标签一致
+create index idx_approval_deleted_at on approval (deleted_at);
+{{end}}
+
+{{define "0004_approval_deleted_at_index.down"}}
+{{if .Is "mysql"}}drop index idx_approval_deleted_at on approval;{{else}}drop index idx_approval_deleted_at;{{end}}
+{{end}}
+
+{{define "0005_approval_instance_id_alive_unique.up"}}
+{{- /* uk_instance_id 只约束未删除的记录，已软删除的实例再次同步时可以新建记录 */ -}}
+{{if .Is "mysql"}}-- MySQL 不支持部分索引，使用函数索引（8.0.13+）：已删除记录的第二列为 NULL，不参与唯一约束
+drop index uk_instance_id on approval;
+create unique index uk_instance_id on approval (instance_id, (if(deleted_at is null, 1, null)));
+{{else}}drop index uk_instance_id;
+create unique index uk_instance_id on approval (instance_id) where deleted_at is null;
+{{end}}
+{{end}}
+
+{{define "0005_approval_instance_id_alive_unique.down"}}
+{{- /* 存在同一 instance_id 的多条记录时回滚会失败，需要先清理已删除的重复记录 */ -}}
+{{if .Is "mysql"}}drop index uk_instance_id on approval;{{else}}drop index uk_instance_id;{{end}}
+create unique index uk_instance_id on approval (instance_id);
+{{end}}
+
+{{define "0006_approval_tenant.up"}}
+{{- /* 多租户：instance_id 只在租户内唯一，已有记录属于默认租户 '' */ -}}
+alter table approval add column tenant_id varchar(64) not null default ''{{.Comment "租户 ID"}};
+alter table approval_history add column tenant_id varchar(64) not null default ''{{.Comment "租户 ID"}};
+{{if .Is "mysql"}}drop index uk_instance_id on approval;
+create unique index uk_tenant_instance_id on approval (tenant_id, instance_id, (if(deleted_at is null, 1, null)));
+{{else}}drop index uk_instance_id;
+create unique index uk_tenant_instance_id on approval (tenant_id, instance_id) where deleted_at is null;
+{{end}}
+{{end}}
+
+{{define "0006_approval_tenant.down"}}
+{{- /* 不同租户存在相同 instance_id 的未删除记录时回滚会失败 */ -}}
+{{if .Is "mysql"}}drop index uk_tenant_instance_id on approval;
+create unique index uk_instance_id on approval (instance_id, (if(deleted_at is null, 1, null)));
+{{else}}drop index uk_tenant_instance_id;
+create unique index uk_instance_id on approval (instance_id) where deleted_at is null;
+{{end}}
+alter table approval drop column tenant_id;
+alter table approval_history drop column tenant_id;
+{{end}}
+
+{{define "0007_create_approval_form_field.up"}}
+{{- /* 表单控件值的投影，由 FormProjectionPlugin 维护，供 ApprovalFilter.Form 按表单字段过滤 */ -}}
+create table approval_form_field (
+  id {{.PK}},
+  tenant_id varchar(64) not null default ''{{.Comment "租户 ID，与审批记录一致"}},
+  approval_id {{.BigInt}} not null{{.Comment "approval 表主键"}},
+  control_id varchar(255) not null{{.Comment "控件 ID"}},
+  custom_id varchar(255) not null default ''{{.Comment "控件自定义 ID"}},
+  name varchar(255) not null{{.Comment "控件名称"}},
+  type varchar(32) not null{{.Comment "控件类型"}},
+  parent_id varchar(255) not null default ''{{.Comment "所在明细控件的 ID，顶层控件为空"}},
+  row_index int not null default 0{{.Comment "在明细中的行号"}},
+  value_index int not null default 0{{.Comment "多值控件中值的序号"}},
+  text_value varchar(512) null{{.Comment "文本值，超过 512 个字符时截断"}},
+  number_value decimal(20,6) null{{.Comment "数字、金额"}},
+  time_value {{.Datetime}} null{{.Comment "日期，UTC"}}
+);
+create index idx_form_field_approval_id on approval_form_field (approval_id);
+-- MySQL utf8mb4 下索引长度为 (255 + 512) * 4 = 3068 字节，不超过 3072 字节的限制
+create index idx_form_field_name_text on approval_form_field (name, text_value);
+create index idx_form_field_custom_id_text on approval_form_field (custom_id, text_value);
+{{end}}
+
+{{define "0007_create_approval_form_field.down"}}
+drop table approval_form_field;
+{{end}}
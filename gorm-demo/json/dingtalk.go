@@ -0,0 +1,146 @@
+package main
+
+// DingTalkApproval 钉钉审批实例数据，与 resty/retry 示例中获取单个流程实例详情接口返回的 Result 一致
+//   - https://open.dingtalk.com/document/orgapp/obtains-the-details-of-a-single-approval-instance-pop
+type DingTalkApproval struct {
+	// Title 审批实例标题
+	Title string `json:"title"`
+	// FinishTime 结束时间
+	FinishTime string `json:"finishTime"`
+	// OriginatorUserId 发起人的用户 Id
+	OriginatorUserId string `json:"originatorUserId"`
+	// OriginatorDeptId 发起人的部门 Id，-1表示根部门
+	OriginatorDeptId string `json:"originatorDeptId"`
+	// OriginatorDeptName 发起人的部门名称
+	OriginatorDeptName string `json:"originatorDeptName"`
+	// Status 审批状态：RUNNING：审批中，TERMINATED：已撤销，COMPLETED：审批完成
+	Status string `json:"status"`
+	// ApproverUserIds 审批人用户 Id 列表
+	ApproverUserIds []string `json:"approverUserIds"`
+	// CcUserIds 抄送人用户 Id 列表
+	CcUserIds []string `json:"ccUserIds"`
+	// Result 审批结果：agree：同意,refuse：拒绝
+	Result string `json:"result"`
+	// BusinessId 审批实例业务编号
+	BusinessId string `json:"businessId"`
+	// OperationRecords 操作记录列表
+	OperationRecords []*DingTalkOperationRecord `json:"operationRecords"`
+	// Tasks 任务列表
+	Tasks []*DingTalkTask `json:"tasks"`
+	// BizAction 审批实例业务动作
+	// 				MODIFY：表示该审批实例是基于原来的实例修改而来
+	// 				REVOKE：表示该审批实例是由原来的实例撤销后重新发起的
+	// 				NONE：表示正常发起
+	BizAction string `json:"bizAction"`
+	// BizData 用户自定义业务参数透出
+	BizData string `json:"bizData"`
+	// AttachedProcessInstanceIds 审批附属实例
+	AttachedProcessInstanceIds []string `json:"attachedProcessInstanceIds"`
+	// MainProcessInstanceId 主流程实例标识
+	MainProcessInstanceId string `json:"mainProcessInstanceId"`
+	// FormComponentValues 表单组件详情列表
+	FormComponentValues []*DingTalkFormComponentValue `json:"formComponentValues"`
+	// CreateTime 创建时间
+	CreateTime string `json:"createTime"`
+}
+
+// DingTalkOperationRecord 操作记录
+type DingTalkOperationRecord struct {
+	// UserId 操作人用户 Id
+	UserId string `json:"userId"`
+	// Date 操作时间
+	Date string `json:"date"`
+	// Type 操作类型
+	// 			EXECUTE_TASK_NORMAL：正常执行任务
+	// 			EXECUTE_TASK_AGENT：代理人执行任务
+	// 			APPEND_TASK_BEFORE：前加签任务
+	// 			APPEND_TASK_AFTER：后加签任务
+	// 			REDIRECT_TASK：转交任务
+	// 			START_PROCESS_INSTANCE：发起流程实例
+	// 			TERMINATE_PROCESS_INSTANCE：终止(撤销)流程实例
+	// 			FINISH_PROCESS_INSTANCE：结束流程实例
+	// 			ADD_REMARK：添加评论
+	// 			REDIRECT_PROCESS：审批退回
+	// 			PROCESS_CC：抄送
+	Type string `json:"type"`
+	// Result 操作结果
+	// 			AGREE：同意
+	// 			REFUSE：拒绝
+	// 			NONE：未处理
+	Result string `json:"result"`
+	// Remark 评论内容，审批操作附带评论时才返回该字段
+	Remark string `json:"remark"`
+	// Attachments 评论附件列表
+	Attachments []*DingTalkAttachment `json:"attachments"`
+	// CcUserIds 抄送人用户 Id 列表
+	CcUserIds []string `json:"ccUserIds"`
+	// ActivityId 任务节点ID
+	ActivityId string `json:"activityId"`
+	// ShowName 任务节点名称
+	ShowName string `json:"showName"`
+	// Images 单个图片链接
+	Images []string `json:"images"`
+}
+
+// DingTalkAttachment 评论附件
+type DingTalkAttachment struct {
+	// FileName 附件名称
+	FileName string `json:"fileName"`
+	// FileSize 附件大小
+	FileSize string `json:"fileSize"`
+	// FileId 附件ID
+	FileId string `json:"fileId"`
+	// FileType 附件类型
+	FileType string `json:"fileType"`
+	// SpaceId 附件的钉盘空间ID
+	SpaceId string `json:"spaceId"`
+}
+
+// DingTalkTask 任务
+type DingTalkTask struct {
+	// TaskId 任务ID
+	TaskId int `json:"taskId"`
+	// UserId 任务处理人
+	UserId string `json:"userId"`
+	// Status 任务状态
+	//			NEW：未启动
+	//			RUNNING：处理中
+	//			PAUSED：暂停
+	//			CANCELED：取消
+	//			COMPLETED：完成
+	//			TERMINATED：终止
+	Status string `json:"status"`
+	// Result 结果
+	//			AGREE：同意
+	//			REFUSE：拒绝
+	//			REDIRECTED：转交
+	Result string `json:"result"`
+	// CreateTime 开始时间
+	CreateTime string `json:"createTime"`
+	// FinishTime 结束时间
+	FinishTime string `json:"finishTime"`
+	// MobileUrl 移动端任务URL
+	MobileUrl string `json:"mobileUrl"`
+	// PcUrl PC端任务URL
+	PcUrl string `json:"pcUrl"`
+	// ProcessInstanceId 实例ID
+	ProcessInstanceId string `json:"processInstanceId"`
+	// ActivityId 任务节点ID
+	ActivityId string `json:"activityId"`
+}
+
+// DingTalkFormComponentValue 表单组件详情
+type DingTalkFormComponentValue struct {
+	// Id 组件ID
+	Id string `json:"id"`
+	// Name 组件名称
+	Name string `json:"name"`
+	// Value 标签值
+	Value string `json:"value"`
+	// ExtValue 标签扩展值
+	ExtValue string `json:"extValue"`
+	// ComponentType 组件类型
+	ComponentType string `json:"componentType"`
+	// BizAlias 组件别名
+	BizAlias string `json:"bizAlias"`
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultESBatchSize ESIndexer 每批处理的记录数
+const DefaultESBatchSize = 100
+
+// SearchDocument 写入 ES 的审批文档
+type SearchDocument struct {
+	*ApprovalView
+	ID        uint64    `json:"id"`         // approval 表主键
+	TenantID  string    `json:"tenant_id"`  // 租户ID
+	Type      string    `json:"type"`       // 审批实例类型, 可选值: lark, dingtalk
+	Version   uint64    `json:"version"`    // 写入时的乐观锁版本号
+	UpdatedAt time.Time `json:"updated_at"` // 记录更新时间
+}
+
+// NewSearchDocument 将审批记录转换为 ES 文档
+func NewSearchDocument(a *ApprovalM) (any, error) {
+	view, err := a.View()
+	if err != nil {
+		return nil, err
+	}
+	return &SearchDocument{
+		ApprovalView: view,
+		ID:           a.ID,
+		TenantID:     a.TenantID,
+		Type:         a.Type,
+		Version:      a.Version,
+		UpdatedAt:    a.UpdatedAt,
+	}, nil
+}
+
+// ESIndexer 将 is_written_es = false 的审批记录批量写入 Elasticsearch 兼容的 _bulk 接口
+//
+// 写入成功后以 id + version 为条件把记录标记为已写入：若记录在读取之后又被更新（version 已变化），
+// 标记不会生效，记录会在下一轮被重新写入。通过 GORM 钩子或 JSONUpdateHelper 修改
+// lark_data/dingtalk_data 时 is_written_es 会被重置为 false，从而重新进入队列；
+// 软删除和恢复同样会重置 is_written_es，软删除的记录以 delete 操作从索引中删除。
+type ESIndexer struct {
+	DB        *gorm.DB
+	Endpoint  string // ES 地址，如 http://127.0.0.1:9200
+	Index     string // 索引名
+	BatchSize int
+	Client    *http.Client
+	// Transform 将审批记录转换为 ES 文档，默认使用 NewSearchDocument
+	Transform func(a *ApprovalM) (any, error)
+}
+
+// NewESIndexer 创建 ES 索引器
+func NewESIndexer(db *gorm.DB, endpoint, index string) *ESIndexer {
+	return &ESIndexer{
+		DB:        db,
+		Endpoint:  strings.TrimRight(endpoint, "/"),
+		Index:     index,
+		BatchSize: DefaultESBatchSize,
+		Client:    &http.Client{Timeout: 30 * time.Second},
+		Transform: NewSearchDocument,
+	}
+}
+
+// Run 每隔 interval 执行一次 IndexPending，直到 ctx 结束
+func (x *ESIndexer) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := x.IndexPending(ctx); err != nil {
+			slog.Error("index approvals to es failed", "indexed", n, "error", err.Error())
+		} else if n > 0 {
+			slog.Info("index approvals to es", "indexed", n)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// IndexPending 按 id 顺序扫描全部未写入的记录（包括软删除的记录）并分批写入，返回成功写入的条数
+//
+// 单条记录转换失败或被 ES 拒绝时跳过该记录，保持未写入状态，不影响同批其他记录。
+func (x *ESIndexer) IndexPending(ctx context.Context) (int, error) {
+	batchSize := x.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultESBatchSize
+	}
+
+	var (
+		total  int
+		lastID uint64
+	)
+	for {
+		var batch []*ApprovalM
+		err := x.DB.WithContext(ctx).Unscoped().
+			Where("is_written_es = ? AND id > ?", false, lastID).
+			Order("id").
+			Limit(batchSize).
+			Find(&batch).Error
+		if err != nil {
+			return total, err
+		}
+		if len(batch) == 0 {
+			return total, nil
+		}
+		lastID = batch[len(batch)-1].ID
+
+		n, err := x.indexBatch(ctx, batch)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if len(batch) < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// indexBatch 写入一批记录并标记成功的记录
+func (x *ESIndexer) indexBatch(ctx context.Context, batch []*ApprovalM) (int, error) {
+	var (
+		body    bytes.Buffer
+		pending = make(map[string]*ApprovalM, len(batch))
+	)
+	for _, a := range batch {
+		id := searchDocumentID(a)
+		if a.DeletedAt.Valid {
+			if err := writeBulkDeleteAction(&body, x.Index, id); err != nil {
+				slog.Warn("marshal search delete action failed", "instance_id", a.InstanceID, "error", err.Error())
+				continue
+			}
+			pending[id] = a
+			continue
+		}
+		doc, err := x.Transform(a)
+		if err != nil {
+			slog.Warn("transform approval to search document failed", "instance_id", a.InstanceID, "error", err.Error())
+			continue
+		}
+		if err := writeBulkIndexAction(&body, x.Index, id, doc); err != nil {
+			slog.Warn("marshal search document failed", "instance_id", a.InstanceID, "error", err.Error())
+			continue
+		}
+		pending[id] = a
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	succeeded, err := x.bulk(ctx, &body)
+	if err != nil {
+		return 0, err
+	}
+
+	var marked int
+	err = x.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, id := range succeeded {
+			a, ok := pending[id]
+			if !ok {
+				continue
+			}
+			// 经过钩子的更新都会把 version 加 1，索引期间被修改的记录不会被标记为已写入
+			// UpdateColumn 不触发钩子也不修改 updated_at/version，避免把自身的标记当作数据变更
+			result := tx.Unscoped().Model(&ApprovalM{}).
+				Where("id = ? AND version = ?", a.ID, a.Version).
+				UpdateColumn("is_written_es", true)
+			if result.Error != nil {
+				return result.Error
+			}
+			marked += int(result.RowsAffected)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return marked, nil
+}
+
+// Delete 从索引中删除 approvals 对应的文档，文档不存在视为成功；有文档删除失败时返回错误
+func (x *ESIndexer) Delete(ctx context.Context, approvals []*ApprovalM) error {
+	if len(approvals) == 0 {
+		return nil
+	}
+	var body bytes.Buffer
+	ids := make([]string, 0, len(approvals))
+	for _, a := range approvals {
+		id := searchDocumentID(a)
+		if err := writeBulkDeleteAction(&body, x.Index, id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	succeeded, err := x.bulk(ctx, &body)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, id := range ids {
+		if !slices.Contains(succeeded, id) {
+			failed = append(failed, id)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("delete search documents %v failed", failed)
+	}
+	return nil
+}
+
+// searchDocumentID ES 文档 _id，默认租户沿用 instance_id，其他租户加上租户前缀以免不同租户的同名实例互相覆盖
+func searchDocumentID(a *ApprovalM) string {
+	if a.TenantID == "" {
+		return a.InstanceID
+	}
+	return a.TenantID + "/" + a.InstanceID
+}
+
+// writeBulkIndexAction 写入一条 _bulk index 操作（两行 NDJSON）
+func writeBulkIndexAction(buf *bytes.Buffer, index, id string, doc any) error {
+	action := map[string]any{"index": map[string]any{"_index": index, "_id": id}}
+	actionLine, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+	docLine, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	buf.Write(actionLine)
+	buf.WriteByte('\n')
+	buf.Write(docLine)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// writeBulkDeleteAction 写入一条 _bulk delete 操作（一行 NDJSON）
+func writeBulkDeleteAction(buf *bytes.Buffer, index, id string) error {
+	action := map[string]any{"delete": map[string]any{"_index": index, "_id": id}}
+	actionLine, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+	buf.Write(actionLine)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// bulkResponse _bulk 接口的响应
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string          `json:"_id"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// bulk 调用 _bulk 接口，返回写入成功的文档 _id，delete 操作的文档不存在时同样视为成功
+func (x *ESIndexer) bulk(ctx context.Context, body io.Reader) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, x.Endpoint+"/_bulk", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := x.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("es bulk request failed: statusCode=%d,body=%s", resp.StatusCode, respBody)
+	}
+
+	var result bulkResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("decode es bulk response: %w", err)
+	}
+
+	succeeded := make([]string, 0, len(result.Items))
+	for _, item := range result.Items {
+		for action, r := range item {
+			if r.Status >= 200 && r.Status < 300 || action == "delete" && r.Status == http.StatusNotFound {
+				succeeded = append(succeeded, r.ID)
+			} else {
+				slog.Warn("es rejected document", "id", r.ID, "status", r.Status, "error", string(r.Error))
+			}
+		}
+	}
+	return succeeded, nil
+}
+
+// requeueSearchIndex 在 BeforeUpdate 中调用：lark_data/dingtalk_data 可能被修改时重置 is_written_es
+//
+// Save 时 Dest 与 Model 为同一对象，无法判断数据是否变化，按已变化处理。
+// Save、Updates(struct) 无法通过本次 UPDATE 把 is_written_es 写回 false，由 setUpdateColumn 在 AfterUpdate 中补写。
+func requeueSearchIndex(tx *gorm.DB, a *ApprovalM) {
+	var changed bool
+	switch dest := tx.Statement.Dest.(type) {
+	case map[string]interface{}:
+		for _, key := range []string{"lark_data", "LarkData", "dingtalk_data", "DingTalkData"} {
+			if _, ok := dest[key]; ok {
+				changed = true
+				break
+			}
+		}
+	case *ApprovalM:
+		changed = dest == a || tx.Statement.Changed("lark_data", "dingtalk_data")
+	default:
+		changed = tx.Statement.Changed("lark_data", "dingtalk_data")
+	}
+	if changed {
+		setUpdateColumn(tx, "is_written_es", false)
+	}
+}
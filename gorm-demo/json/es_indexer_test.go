@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// fakeES 模拟 _bulk 接口，记录收到的操作和当前索引中的文档
+type fakeES struct {
+	*httptest.Server
+	mu      sync.Mutex
+	actions []string        // 收到的操作，如 index:i1、delete:i1
+	docs    map[string]bool // 索引中的文档 _id
+	reject  map[string]bool // 这些 _id 的操作返回 500
+}
+
+// newFakeES 返回接受所有文档的 _bulk 接口，onBulk 在响应之前调用
+//
+// 删除不存在的文档时与 ES 一致返回 404。
+func newFakeES(t *testing.T, onBulk func()) *fakeES {
+	t.Helper()
+	es := &fakeES{docs: map[string]bool{}, reject: map[string]bool{}}
+	es.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		var items []string
+		es.mu.Lock()
+		for i := 0; i < len(lines); i++ {
+			var action map[string]struct {
+				ID string `json:"_id"`
+			}
+			if err := json.Unmarshal([]byte(lines[i]), &action); err != nil {
+				t.Error(err)
+			}
+			for name, meta := range action {
+				es.actions = append(es.actions, name+":"+meta.ID)
+				status := 200
+				switch {
+				case es.reject[meta.ID]:
+					status = 500
+				case name == "index":
+					es.docs[meta.ID] = true
+					i++ // 跳过文档行
+				case name == "delete" && !es.docs[meta.ID]:
+					status = 404
+				default:
+					delete(es.docs, meta.ID)
+				}
+				items = append(items, fmt.Sprintf(`{%q:{"_id":%q,"status":%d}}`, name, meta.ID, status))
+			}
+		}
+		es.mu.Unlock()
+		if onBulk != nil {
+			onBulk()
+		}
+		fmt.Fprintf(w, `{"errors":false,"items":[%s]}`, strings.Join(items, ","))
+	}))
+	t.Cleanup(es.Close)
+	return es
+}
+
+// takeActions 返回并清空已收到的操作
+func (es *fakeES) takeActions() []string {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	actions := es.actions
+	es.actions = nil
+	return actions
+}
+
+func loadApproval(t *testing.T, db *gorm.DB, instanceID string) *ApprovalM {
+	t.Helper()
+	var a ApprovalM
+	if err := db.Where("instance_id = ?", instanceID).First(&a).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &a
+}
+
+func TestUpdatesRequeueSearchIndex(t *testing.T) {
+	changed := NewJSONColumn(LarkApproval{ApprovalName: "changed", Status: ApprovalStatusPending})
+	tests := []struct {
+		name   string
+		update func(db *gorm.DB, a *ApprovalM) error
+	}{
+		{"Save", func(db *gorm.DB, a *ApprovalM) error {
+			a.LarkData = changed
+			return db.Save(a).Error
+		}},
+		{"Updates struct", func(db *gorm.DB, a *ApprovalM) error {
+			return db.Model(a).Updates(ApprovalM{LarkData: changed}).Error
+		}},
+		{"Updates map", func(db *gorm.DB, a *ApprovalM) error {
+			return db.Model(a).Updates(map[string]any{"lark_data": changed}).Error
+		}},
+		{"Update", func(db *gorm.DB, a *ApprovalM) error {
+			return db.Model(a).Update("lark_data", changed).Error
+		}},
+		{"JSONUpdateHelper", func(db *gorm.DB, a *ApprovalM) error {
+			return NewJSONUpdateHelper(db).UpdateJSONField(a.InstanceID, "$.approval_name", "changed")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+			x := NewESIndexer(db, newFakeES(t, nil).URL, "approval")
+			if n, err := x.IndexPending(context.Background()); err != nil || n != 1 {
+				t.Fatalf("index: %d, %v", n, err)
+			}
+
+			a := loadApproval(t, db, "i1")
+			if !a.IsWrittenES {
+				t.Fatal("not marked as written")
+			}
+			if err := tt.update(db, a); err != nil {
+				t.Fatal(err)
+			}
+			got := loadApproval(t, db, "i1")
+			if got.IsWrittenES {
+				t.Error("is_written_es was not reset")
+			}
+			if got.Version != 1 {
+				t.Errorf("version = %d, want 1", got.Version)
+			}
+		})
+	}
+}
+
+func TestIndexPendingSkipsConcurrentSave(t *testing.T) {
+	db := openTestDB(t)
+	seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	// 写入 ES 期间记录被 Save 修改，不能被标记为已写入
+	x := NewESIndexer(db, newFakeES(t, func() {
+		a := loadApproval(t, db, "i1")
+		a.ApprovalCode = "changed"
+		if err := db.Save(a).Error; err != nil {
+			t.Error(err)
+		}
+	}).URL, "approval")
+	if n, err := x.IndexPending(context.Background()); err != nil || n != 0 {
+		t.Fatalf("index: %d, %v, want 0 marked", n, err)
+	}
+	if a := loadApproval(t, db, "i1"); a.IsWrittenES || a.Version != 1 {
+		t.Errorf("is_written_es = %t, version = %d", a.IsWrittenES, a.Version)
+	}
+}
+
+func TestIndexPendingDeletesSoftDeleted(t *testing.T) {
+	db := openTestDB(t)
+	seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	seedApproval(t, db, "i2", LarkApproval{ApprovalName: "b", Status: ApprovalStatusPending})
+	es := newFakeES(t, nil)
+	x := NewESIndexer(db, es.URL, "approval")
+	if n, err := x.IndexPending(context.Background()); err != nil || n != 2 {
+		t.Fatalf("index: %d, %v", n, err)
+	}
+	es.takeActions()
+
+	// 直接软删除、按状态软删除都会重新进入队列，以 delete 操作从索引中删除
+	if err := db.Where("instance_id = ?", "i1").Delete(&ApprovalM{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := NewJSONUpdateHelper(db).UpdateJSONField("i2", "$.status", ApprovalStatusCanceled); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := x.IndexPending(context.Background()); err != nil || n != 2 {
+		t.Fatalf("index: %d, %v", n, err)
+	}
+	if got := es.takeActions(); !slices.Equal(got, []string{"delete:i1", "delete:i2"}) {
+		t.Errorf("actions = %v", got)
+	}
+	if n, err := x.IndexPending(context.Background()); err != nil || n != 0 {
+		t.Errorf("second run: %d, %v", n, err)
+	}
+
+	// 恢复后重新写入；文档已不存在时删除视为成功
+	l := NewApprovalLifecycle(db, 0, t.TempDir())
+	if err := l.Undelete(context.Background(), "i1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Where("instance_id = ?", "i1").Delete(&ApprovalM{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Undelete(context.Background(), "i1"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := x.IndexPending(context.Background()); err != nil || n != 1 {
+		t.Fatalf("index: %d, %v", n, err)
+	}
+	if got := es.takeActions(); !slices.Equal(got, []string{"index:i1"}) {
+		t.Errorf("actions = %v", got)
+	}
+}
+
+func TestIndexPendingSkipsConcurrentDelete(t *testing.T) {
+	db := openTestDB(t)
+	seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	// 写入 ES 期间记录被软删除，不能被标记为已写入，否则删除永远不会同步到 ES
+	x := NewESIndexer(db, newFakeES(t, func() {
+		if err := db.Where("instance_id = ?", "i1").Delete(&ApprovalM{}).Error; err != nil {
+			t.Error(err)
+		}
+	}).URL, "approval")
+	if n, err := x.IndexPending(context.Background()); err != nil || n != 0 {
+		t.Fatalf("index: %d, %v, want 0 marked", n, err)
+	}
+	var a ApprovalM
+	if err := db.Unscoped().Where("instance_id = ?", "i1").First(&a).Error; err != nil {
+		t.Fatal(err)
+	}
+	if a.IsWrittenES || a.Version != 1 {
+		t.Errorf("is_written_es = %t, version = %d", a.IsWrittenES, a.Version)
+	}
+}
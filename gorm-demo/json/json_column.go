@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// JSONColumn 带类型的 JSON 列，如 JSONColumn[LarkApproval]
+//
+//   - 从数据库读取时只保存原始 JSON，第一次调用 Get/Mutate 时才解码
+//   - 只有通过 Set/Mutate 修改过（dirty）才会重新序列化，否则原样写回
+//   - 重新序列化时，原始 JSON 中 T 未声明的顶层键会被保留，避免新版本接口增加的字段在读写一次后丢失
+//
+// 零值表示 SQL NULL。Get 返回的是深拷贝，直接修改不会被写回，请使用 Set 或 Mutate。
+// 注册了 FieldEncryptionPlugin 时，写入数据库前加密配置的路径，查询后解密，内存中始终是明文。
+type JSONColumn[T any] struct {
+	raw       []byte
+	value     *T
+	dirty     bool
+	reencrypt bool             // 读取时加密路径上有明文、v1 格式或使用了旧主密钥，见 FieldEncryptionPlugin
+	scope     *encryptionScope // 写入时绑定的记录，加密的附加数据，见 FieldEncryptionPlugin
+}
+
+// NewJSONColumn 由值创建，写入时序列化
+func NewJSONColumn[T any](v T) JSONColumn[T] {
+	return JSONColumn[T]{value: &v, dirty: true}
+}
+
+// RawJSONColumn 由原始 JSON 创建，写入时原样写回；data 为空时表示 NULL
+func RawJSONColumn[T any](data []byte) JSONColumn[T] {
+	if len(data) == 0 {
+		return JSONColumn[T]{}
+	}
+	return JSONColumn[T]{raw: bytes.Clone(data)}
+}
+
+// IsNull 是否为 SQL NULL
+func (c JSONColumn[T]) IsNull() bool {
+	return c.raw == nil && c.value == nil
+}
+
+// Dirty 是否通过 Set/Mutate 修改过
+func (c JSONColumn[T]) Dirty() bool {
+	return c.dirty
+}
+
+// Get 解码并返回值的深拷贝，NULL 和 JSON null 返回 T 的零值
+//
+// 每次都从 JSON 重新解码（Set/Mutate 修改过时先序列化当前值），返回值中的切片、map 不与列共享。
+func (c *JSONColumn[T]) Get() (T, error) {
+	var v T
+	data := c.raw
+	if c.dirty {
+		var err error
+		if data, err = json.Marshal(c.value); err != nil {
+			return v, fmt.Errorf("encode json column %T: %w", v, err)
+		}
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &v); err != nil {
+			return v, fmt.Errorf("decode json column into %T: %w", v, err)
+		}
+	}
+	return v, nil
+}
+
+// Set 替换整个值
+func (c *JSONColumn[T]) Set(v T) {
+	c.value, c.dirty = &v, true
+}
+
+// Mutate 解码后调用 fn 修改值，并标记为已修改
+func (c *JSONColumn[T]) Mutate(fn func(v *T)) error {
+	if err := c.decode(); err != nil {
+		return err
+	}
+	fn(c.value)
+	c.dirty = true
+	return nil
+}
+
+func (c *JSONColumn[T]) decode() error {
+	if c.value != nil {
+		return nil
+	}
+	var v T
+	if len(c.raw) > 0 {
+		if err := json.Unmarshal(c.raw, &v); err != nil {
+			return fmt.Errorf("decode json column into %T: %w", v, err)
+		}
+	}
+	c.value = &v
+	return nil
+}
+
+// Bytes 返回将写入数据库的 JSON，NULL 时返回 nil
+func (c JSONColumn[T]) Bytes() ([]byte, error) {
+	if !c.dirty {
+		return c.raw, nil
+	}
+	data, err := json.Marshal(c.value)
+	if err != nil {
+		return nil, err
+	}
+	return mergeUnknownJSONKeys(c.raw, data, reflect.TypeFor[T]())
+}
+
+// Scan 实现 sql.Scanner
+func (c *JSONColumn[T]) Scan(src any) error {
+	*c = JSONColumn[T]{}
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		c.raw = bytes.Clone(v)
+	case string:
+		c.raw = []byte(v)
+	default:
+		return fmt.Errorf("scan %T into json column", src)
+	}
+	return nil
+}
+
+// Value 实现 driver.Valuer，以字符串写入，避免 MySQL 将 []byte 视为 binary 字符集
+func (c JSONColumn[T]) Value() (driver.Value, error) {
+	data, err := c.Bytes()
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// GormValue 实现 gorm.Valuer，注册了 FieldEncryptionPlugin 时在绑定参数前加密
+func (c JSONColumn[T]) GormValue(ctx context.Context, db *gorm.DB) clause.Expr {
+	data, err := c.Bytes()
+	if err == nil && data != nil {
+		data, err = sealJSONColumn(ctx, db, reflect.TypeFor[T](), data, c.scope)
+	}
+	if err != nil {
+		db.AddError(fmt.Errorf("encrypt json column %T: %w", c.value, err))
+		return clause.Expr{SQL: "NULL"}
+	}
+	if data == nil {
+		return clause.Expr{SQL: "NULL"}
+	}
+	return clause.Expr{SQL: "?", Vars: []any{string(data)}}
+}
+
+func (JSONColumn[T]) documentType() reflect.Type {
+	return reflect.TypeFor[T]()
+}
+
+func (c *JSONColumn[T]) rawJSON() []byte {
+	return c.raw
+}
+
+// setDecrypted 以解密后的 JSON 替换从数据库读取的原始 JSON
+func (c *JSONColumn[T]) setDecrypted(plain []byte, reencrypt bool) {
+	c.raw, c.value, c.dirty, c.reencrypt = plain, nil, false, reencrypt
+}
+
+// bindScope 绑定到写入的记录
+func (c *JSONColumn[T]) bindScope(scope encryptionScope) {
+	c.scope = &scope
+}
+
+// needsReencryption 写回数据库时是否会改变加密状态（加密明文或换用当前主密钥）
+func (c JSONColumn[T]) needsReencryption() bool {
+	return c.reencrypt
+}
+
+// GormDataType 实现 schema.GormDataTypeInterface
+func (JSONColumn[T]) GormDataType() string {
+	return "json"
+}
+
+// GormDBDataType 与 datatypes.JSON 一致，PostgreSQL 使用 jsonb
+func (JSONColumn[T]) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	switch db.Dialector.Name() {
+	case DialectPostgres:
+		return "JSONB"
+	default:
+		return "JSON"
+	}
+}
+
+// MarshalJSON 输出原始 JSON，NULL 输出 null
+func (c JSONColumn[T]) MarshalJSON() ([]byte, error) {
+	data, err := c.Bytes()
+	if err != nil || data == nil {
+		return []byte("null"), err
+	}
+	return data, nil
+}
+
+// UnmarshalJSON 保存原始 JSON，null 视为 NULL
+func (c *JSONColumn[T]) UnmarshalJSON(data []byte) error {
+	if !json.Valid(data) {
+		return errors.New("invalid json for json column")
+	}
+	if string(data) == "null" {
+		*c = JSONColumn[T]{}
+		return nil
+	}
+	*c = RawJSONColumn[T](data)
+	return nil
+}
+
+// mergeUnknownJSONKeys 把 original 中 t 未声明的顶层键合并到 encoded 中
+//
+// 只处理顶层对象，嵌套对象中的未知键不会保留；original 或 encoded 不是对象时直接返回 encoded。
+func mergeUnknownJSONKeys(original, encoded []byte, t reflect.Type) ([]byte, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if len(original) == 0 || t.Kind() != reflect.Struct {
+		return encoded, nil
+	}
+	var orig, enc map[string]json.RawMessage
+	if json.Unmarshal(original, &orig) != nil || json.Unmarshal(encoded, &enc) != nil || enc == nil {
+		return encoded, nil
+	}
+
+	known := jsonFieldNames(t)
+	var merged bool
+	for k, v := range orig {
+		if _, ok := known[k]; ok {
+			continue
+		}
+		if _, ok := enc[k]; !ok {
+			enc[k], merged = v, true
+		}
+	}
+	if !merged {
+		return encoded, nil
+	}
+	return json.Marshal(enc)
+}
+
+// jsonFieldNames 结构体序列化时使用的键名
+func jsonFieldNames(t reflect.Type) map[string]struct{} {
+	names := make(map[string]struct{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names[name] = struct{}{}
+	}
+	return names
+}
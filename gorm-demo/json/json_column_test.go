@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestJSONColumnGetReturnsDeepCopy(t *testing.T) {
+	columns := map[string]JSONColumn[LarkApproval]{
+		"raw": RawJSONColumn[LarkApproval]([]byte(`{"status":"PENDING","task_list":[{"id":"t1","user_id":"zhangsan"}]}`)),
+		"set": NewJSONColumn(LarkApproval{Status: ApprovalStatusPending, TaskList: []*InstanceTask{{ID: "t1", UserID: "zhangsan"}}}),
+	}
+	for name, c := range columns {
+		t.Run(name, func(t *testing.T) {
+			got, err := c.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got.TaskList[0].UserID = "lisi"
+			got.TaskList = append(got.TaskList, &InstanceTask{ID: "t2"})
+
+			again, err := c.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(again.TaskList) != 1 || again.TaskList[0].UserID != "zhangsan" {
+				t.Errorf("column changed through Get: %+v", again.TaskList)
+			}
+		})
+	}
+}
+
+func TestJSONColumnGetAfterMutate(t *testing.T) {
+	c := RawJSONColumn[LarkApproval]([]byte(`{"status":"PENDING"}`))
+	if err := c.Mutate(func(v *LarkApproval) { v.Status = ApprovalStatusApproved }); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != ApprovalStatusApproved {
+		t.Errorf("status = %s, want %s", got.Status, ApprovalStatusApproved)
+	}
+
+	var null JSONColumn[LarkApproval]
+	if got, err := null.Get(); err != nil || got.Status != "" {
+		t.Errorf("null column: %+v, %v", got, err)
+	}
+}
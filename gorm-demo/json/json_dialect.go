@@ -0,0 +1,592 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 数据库方言名称，与 gorm.Dialector.Name() 的返回值保持一致
+const (
+	DialectMySQL    = "mysql"
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+// JSONDialect 将逻辑上相同的 JSON 查询渲染为不同数据库的 SQL 表达式
+//
+// 路径使用类型安全的 JSONPath，由各方言自行转换为目标数据库能识别的形式。
+// 路径和值均以绑定参数传入。修改类方法接收 doc 表达式并返回修改后的文档，可以互相嵌套，
+// 通常以 Document(column) 作为最内层。
+type JSONDialect interface {
+	// Name 方言名称
+	Name() string
+	// Extract 取出 path 对应的标量值（字符串去除引号）
+	Extract(column string, path JSONPath) clause.Expression
+	// PathEquals path 对应的值等于 value
+	PathEquals(column string, path JSONPath, value any) clause.Expression
+	// PathLike path 对应的值模糊匹配 pattern
+	PathLike(column string, path JSONPath, pattern string) clause.Expression
+	// ArrayContainsObject path 对应的数组中存在 key 等于 value 的对象
+	ArrayContainsObject(column string, path JSONPath, key string, value any) clause.Expression
+	// ArrayAnyMatch path 对应的数组中存在满足全部 conds 的元素，conds 为空时表示数组非空
+	ArrayAnyMatch(column string, path JSONPath, conds []JSONElementCondition) clause.Expression
+	// ArrayElements 把 path 对应的数组展开为别名为 alias 的表，每个元素一行，非数组的值视为空数组，
+	// 用于 FROM/CROSS JOIN，可以引用同一 FROM 子句中前面的表
+	ArrayElements(column string, path JSONPath, alias string) clause.Expression
+	// ElementColumn ArrayElements 展开后元素所在的列，可以作为其他方法的 column 参数，如 Extract(ElementColumn("t"), Path("user_id"))
+	ElementColumn(alias string) string
+	// ExtractNumber 取出 path 对应的值并转换为数字，空字符串视为 NULL，用于毫秒时间戳等数值的比较和排序
+	ExtractNumber(column string, path JSONPath) clause.Expression
+	// PathExists path 在 JSON 文档中存在
+	PathExists(column string, path JSONPath) clause.Expression
+	// PathHasType path 对应的值为 jsonType 类型，jsonType 可选值: object, array
+	PathHasType(column string, path JSONPath, jsonType string) clause.Expression
+	// Document 列的 JSON 文档，列为 NULL 时视为空对象
+	Document(column string) clause.Expression
+	// Set 依次设置 assignments 中的路径，返回修改后的 JSON 文档
+	Set(doc clause.Expression, assignments []JSONAssignment) clause.Expression
+	// Remove 删除 paths，路径不存在时忽略
+	Remove(doc clause.Expression, paths []JSONPath) clause.Expression
+	// ArrayAppend 向 path 对应的数组末尾追加 value
+	ArrayAppend(doc clause.Expression, path JSONPath, value json.RawMessage) clause.Expression
+	// MergePatch 按 RFC 7396 合并 patch，数据库不支持时返回 nil
+	MergePatch(doc clause.Expression, patch json.RawMessage) clause.Expression
+}
+
+// JSONElementCondition 数组元素上的一个条件
+type JSONElementCondition struct {
+	Path    JSONPath // 相对于数组元素的路径，如 Path("user_id")
+	Op      string   // 比较运算符，可选值: =, <>, <, <=, >, >=, LIKE, IN（Value 为切片）
+	Value   any
+	Numeric bool // 是否按数字比较，同 ExtractNumber
+}
+
+// build 以 extract 取出的元素值渲染条件
+func (c JSONElementCondition) build(extract clause.Expression) clause.Expression {
+	switch c.Op {
+	case "=", "<>", "<", "<=", ">", ">=", "LIKE", "IN":
+		return clause.Expr{SQL: "? " + c.Op + " ?", Vars: []any{extract, c.Value}}
+	default:
+		return jsonExprFunc{err: fmt.Errorf("unsupported json element operator %q", c.Op)}
+	}
+}
+
+// match 在应用层以解码后的数组元素求值，与 build 渲染的条件一致；路径不存在时相当于 SQL 中的 NULL，不满足任何条件
+func (c JSONElementCondition) match(elem any) (bool, error) {
+	v := lookupJSONPath(elem, c.Path)
+	switch c.Op {
+	case "=", "<>", "<", "<=", ">", ">=":
+		n, ok := compareJSONScalar(v, c.Value, c.Numeric)
+		return ok && compareResult(c.Op, n), nil
+	case "LIKE":
+		s, ok := jsonScalarText(v)
+		pattern, _ := jsonScalarText(c.Value)
+		return ok && likeMatch(s, pattern), nil
+	case "IN":
+		values := reflect.ValueOf(c.Value)
+		if values.Kind() != reflect.Slice && values.Kind() != reflect.Array {
+			return false, fmt.Errorf("json element operator IN requires a slice, got %T", c.Value)
+		}
+		for i := 0; i < values.Len(); i++ {
+			if n, ok := compareJSONScalar(v, values.Index(i).Interface(), c.Numeric); ok && n == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("unsupported json element operator %q", c.Op)
+	}
+}
+
+// arrayAnyMatchValue 在应用层判断 array 中是否存在满足全部 conds 的元素，与 arrayAnyMatch 一致，非数组视为空数组
+func arrayAnyMatchValue(array any, conds []JSONElementCondition) (bool, error) {
+	elems, _ := array.([]any)
+	for _, elem := range elems {
+		matched := true
+		for _, c := range conds {
+			ok, err := c.match(elem)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// jsonScalarText 与 Extract 一致，取出去除引号后的文本，值为 null 或不存在时返回 false
+func jsonScalarText(v any) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case map[string]any, []any:
+		data, err := json.Marshal(v)
+		return string(data), err == nil
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+// jsonScalarNumber 与 ExtractNumber 一致，把数字或数字字符串转换为 float64，空字符串、非数字视为 NULL
+func jsonScalarNumber(v any) (float64, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		if v == "" {
+			return 0, false
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// compareJSONScalar 比较元素值 v 与条件值 value，任一方为 NULL 时返回 false
+func compareJSONScalar(v, value any, numeric bool) (int, bool) {
+	if numeric {
+		a, ok := jsonScalarNumber(v)
+		if !ok {
+			return 0, false
+		}
+		b, ok := jsonScalarNumber(value)
+		if !ok {
+			return 0, false
+		}
+		return cmp.Compare(a, b), true
+	}
+	a, ok := jsonScalarText(v)
+	if !ok {
+		return 0, false
+	}
+	b, ok := jsonScalarText(value)
+	if !ok {
+		return 0, false
+	}
+	return strings.Compare(a, b), true
+}
+
+// compareResult 按比较运算符解释 cmp.Compare 的结果
+func compareResult(op string, c int) bool {
+	switch op {
+	case "=":
+		return c == 0
+	case "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	default:
+		return false
+	}
+}
+
+// likeMatch SQL LIKE 匹配，% 匹配任意个字符，_ 匹配一个字符，不支持转义，区分大小写
+func likeMatch(s, pattern string) bool {
+	str, pat := []rune(s), []rune(pattern)
+	// 回溯到最近一个 % 重新匹配，与 glob 匹配的常见实现相同
+	si, pi, star, mark := 0, 0, -1, 0
+	for si < len(str) {
+		switch {
+		case pi < len(pat) && (pat[pi] == '_' || pat[pi] == str[si]):
+			si++
+			pi++
+		case pi < len(pat) && pat[pi] == '%':
+			star, mark = pi, si
+			pi++
+		case star >= 0:
+			mark++
+			si, pi = mark, star+1
+		default:
+			return false
+		}
+	}
+	for pi < len(pat) && pat[pi] == '%' {
+		pi++
+	}
+	return pi == len(pat)
+}
+
+// arrayAnyMatch 生成 EXISTS (SELECT 1 FROM ArrayElements(...) WHERE cond1 AND cond2 ...)
+func arrayAnyMatch(d JSONDialect, column string, path JSONPath, conds []JSONElementCondition) clause.Expression {
+	elem := d.ElementColumn("je")
+	extract := func(c JSONElementCondition) clause.Expression {
+		if c.Numeric {
+			return d.ExtractNumber(elem, c.Path)
+		}
+		return d.Extract(elem, c.Path)
+	}
+	var b strings.Builder
+	b.WriteString("EXISTS (SELECT 1 FROM ?")
+	vars := []any{d.ArrayElements(column, path, "je")}
+	for i, c := range conds {
+		if err := c.Path.Err(); err != nil {
+			return jsonExprFunc{err: err}
+		}
+		if i == 0 {
+			b.WriteString(" WHERE ?")
+		} else {
+			b.WriteString(" AND ?")
+		}
+		vars = append(vars, c.build(extract(c)))
+	}
+	b.WriteString(")")
+	return clause.Expr{SQL: b.String(), Vars: vars}
+}
+
+// JSONAssignment 一次 JSON 路径赋值，Value 为已序列化的 JSON
+type JSONAssignment struct {
+	Path  JSONPath
+	Value json.RawMessage
+}
+
+// NewJSONAssignment 序列化 value 并生成赋值
+func NewJSONAssignment(path JSONPath, value any) (JSONAssignment, error) {
+	if err := path.Err(); err != nil {
+		return JSONAssignment{}, err
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return JSONAssignment{}, fmt.Errorf("marshal value for %s: %w", path, err)
+	}
+	return JSONAssignment{Path: path, Value: raw}, nil
+}
+
+// JSONDialectOf 根据 gorm.DB 的 Dialector 选择 JSON 方言，未知方言按 MySQL 处理
+func JSONDialectOf(db *gorm.DB) JSONDialect {
+	if db == nil || db.Dialector == nil {
+		return mysqlJSONDialect{}
+	}
+	switch db.Dialector.Name() {
+	case DialectPostgres:
+		return postgresJSONDialect{}
+	case DialectSQLite:
+		return sqliteJSONDialect{}
+	default:
+		return mysqlJSONDialect{}
+	}
+}
+
+// mysqlJSONDialect MySQL 5.7+ 的 JSON 函数
+type mysqlJSONDialect struct{}
+
+func (mysqlJSONDialect) Name() string { return DialectMySQL }
+
+func (mysqlJSONDialect) Extract(column string, path JSONPath) clause.Expression {
+	return clause.Expr{SQL: "JSON_UNQUOTE(JSON_EXTRACT(?, ?))", Vars: []any{clause.Column{Name: column}, path.String()}}
+}
+
+func (d mysqlJSONDialect) PathEquals(column string, path JSONPath, value any) clause.Expression {
+	return clause.Expr{SQL: "? = ?", Vars: []any{d.Extract(column, path), value}}
+}
+
+func (d mysqlJSONDialect) PathLike(column string, path JSONPath, pattern string) clause.Expression {
+	return clause.Expr{SQL: "? LIKE ?", Vars: []any{d.Extract(column, path), pattern}}
+}
+
+func (mysqlJSONDialect) ArrayContainsObject(column string, path JSONPath, key string, value any) clause.Expression {
+	return clause.Expr{
+		SQL:  "JSON_CONTAINS(JSON_EXTRACT(?, ?), JSON_OBJECT(?, ?))",
+		Vars: []any{clause.Column{Name: column}, path.String(), key, value},
+	}
+}
+
+func (d mysqlJSONDialect) ArrayAnyMatch(column string, path JSONPath, conds []JSONElementCondition) clause.Expression {
+	return arrayAnyMatch(d, column, path, conds)
+}
+
+func (mysqlJSONDialect) ArrayElements(column string, path JSONPath, alias string) clause.Expression {
+	// JSON_TABLE 需要 MySQL 8.0.4+，其路径参数必须是字面量
+	arr := clause.Expr{SQL: "JSON_EXTRACT(?, ?)", Vars: []any{clause.Column{Name: column}, path.String()}}
+	return clause.Expr{
+		SQL:  "JSON_TABLE(CASE JSON_TYPE(?) WHEN 'ARRAY' THEN ? ELSE JSON_ARRAY() END, '$[*]' COLUMNS (v JSON PATH '$')) AS ?",
+		Vars: []any{arr, arr, clause.Table{Name: alias}},
+	}
+}
+
+func (mysqlJSONDialect) ElementColumn(alias string) string {
+	return alias + ".v"
+}
+
+func (d mysqlJSONDialect) ExtractNumber(column string, path JSONPath) clause.Expression {
+	return clause.Expr{SQL: "CAST(NULLIF(?, '') AS DECIMAL(30, 6))", Vars: []any{d.Extract(column, path)}}
+}
+
+func (mysqlJSONDialect) PathExists(column string, path JSONPath) clause.Expression {
+	return clause.Expr{SQL: "JSON_CONTAINS_PATH(?, 'one', ?)", Vars: []any{clause.Column{Name: column}, path.String()}}
+}
+
+func (mysqlJSONDialect) PathHasType(column string, path JSONPath, jsonType string) clause.Expression {
+	// JSON_TYPE 返回大写的类型名
+	return clause.Expr{SQL: "JSON_TYPE(JSON_EXTRACT(?, ?)) = ?", Vars: []any{clause.Column{Name: column}, path.String(), strings.ToUpper(jsonType)}}
+}
+
+func (mysqlJSONDialect) Document(column string) clause.Expression {
+	return clause.Expr{SQL: "COALESCE(?, JSON_OBJECT())", Vars: []any{clause.Column{Name: column}}}
+}
+
+func (mysqlJSONDialect) Set(doc clause.Expression, assignments []JSONAssignment) clause.Expression {
+	return variadicJSONSet("JSON_SET", "CAST(? AS JSON)", doc, assignments)
+}
+
+func (mysqlJSONDialect) Remove(doc clause.Expression, paths []JSONPath) clause.Expression {
+	return variadicJSONRemove("JSON_REMOVE", doc, paths)
+}
+
+func (mysqlJSONDialect) ArrayAppend(doc clause.Expression, path JSONPath, value json.RawMessage) clause.Expression {
+	return clause.Expr{SQL: "JSON_ARRAY_APPEND(?, ?, CAST(? AS JSON))", Vars: []any{doc, path.String(), string(value)}}
+}
+
+func (mysqlJSONDialect) MergePatch(doc clause.Expression, patch json.RawMessage) clause.Expression {
+	// MySQL 5.7.22+
+	return clause.Expr{SQL: "JSON_MERGE_PATCH(?, CAST(? AS JSON))", Vars: []any{doc, string(patch)}}
+}
+
+// sqliteJSONDialect SQLite json1 扩展
+type sqliteJSONDialect struct{}
+
+func (sqliteJSONDialect) Name() string { return DialectSQLite }
+
+func (sqliteJSONDialect) Extract(column string, path JSONPath) clause.Expression {
+	// json_extract 对字符串直接返回 SQL 文本，无需再去引号
+	return clause.Expr{SQL: "json_extract(?, ?)", Vars: []any{clause.Column{Name: column}, path.String()}}
+}
+
+func (d sqliteJSONDialect) PathEquals(column string, path JSONPath, value any) clause.Expression {
+	return clause.Expr{SQL: "? = ?", Vars: []any{d.Extract(column, path), value}}
+}
+
+func (d sqliteJSONDialect) PathLike(column string, path JSONPath, pattern string) clause.Expression {
+	return clause.Expr{SQL: "? LIKE ?", Vars: []any{d.Extract(column, path), pattern}}
+}
+
+func (sqliteJSONDialect) ArrayContainsObject(column string, path JSONPath, key string, value any) clause.Expression {
+	return clause.Expr{
+		SQL:  "EXISTS (SELECT 1 FROM json_each(?, ?) AS je WHERE json_extract(je.value, ?) = ?)",
+		Vars: []any{clause.Column{Name: column}, path.String(), Path(key).String(), value},
+	}
+}
+
+func (d sqliteJSONDialect) ArrayAnyMatch(column string, path JSONPath, conds []JSONElementCondition) clause.Expression {
+	return arrayAnyMatch(d, column, path, conds)
+}
+
+func (sqliteJSONDialect) ArrayElements(column string, path JSONPath, alias string) clause.Expression {
+	// json_each 遇到标量（包括 JSON null）会返回一行，先转换为空数组
+	return clause.Expr{
+		SQL:  "json_each(CASE json_type(?, ?) WHEN 'array' THEN json_extract(?, ?) ELSE '[]' END) AS ?",
+		Vars: []any{clause.Column{Name: column}, path.String(), clause.Column{Name: column}, path.String(), clause.Table{Name: alias}},
+	}
+}
+
+func (sqliteJSONDialect) ElementColumn(alias string) string {
+	return alias + ".value"
+}
+
+func (d sqliteJSONDialect) ExtractNumber(column string, path JSONPath) clause.Expression {
+	return clause.Expr{SQL: "CAST(NULLIF(?, '') AS REAL)", Vars: []any{d.Extract(column, path)}}
+}
+
+func (sqliteJSONDialect) PathExists(column string, path JSONPath) clause.Expression {
+	return clause.Expr{SQL: "json_type(?, ?) IS NOT NULL", Vars: []any{clause.Column{Name: column}, path.String()}}
+}
+
+func (sqliteJSONDialect) PathHasType(column string, path JSONPath, jsonType string) clause.Expression {
+	return clause.Expr{SQL: "json_type(?, ?) = ?", Vars: []any{clause.Column{Name: column}, path.String(), jsonType}}
+}
+
+func (sqliteJSONDialect) Document(column string) clause.Expression {
+	return clause.Expr{SQL: "COALESCE(?, '{}')", Vars: []any{clause.Column{Name: column}}}
+}
+
+func (sqliteJSONDialect) Set(doc clause.Expression, assignments []JSONAssignment) clause.Expression {
+	return variadicJSONSet("json_set", "json(?)", doc, assignments)
+}
+
+func (sqliteJSONDialect) Remove(doc clause.Expression, paths []JSONPath) clause.Expression {
+	return variadicJSONRemove("json_remove", doc, paths)
+}
+
+func (sqliteJSONDialect) ArrayAppend(doc clause.Expression, path JSONPath, value json.RawMessage) clause.Expression {
+	// [#] 表示数组末尾之后的位置，需要 SQLite 3.31+
+	return clause.Expr{SQL: "json_insert(?, ?, json(?))", Vars: []any{doc, path.String() + "[#]", string(value)}}
+}
+
+func (sqliteJSONDialect) MergePatch(doc clause.Expression, patch json.RawMessage) clause.Expression {
+	return clause.Expr{SQL: "json_patch(?, json(?))", Vars: []any{doc, string(patch)}}
+}
+
+// postgresJSONDialect PostgreSQL jsonb，路径被拆分为 jsonb_extract_path 的参数
+type postgresJSONDialect struct{}
+
+func (postgresJSONDialect) Name() string { return DialectPostgres }
+
+func (postgresJSONDialect) Extract(column string, path JSONPath) clause.Expression {
+	return pgExtractPath("jsonb_extract_path_text", column, path)
+}
+
+func (d postgresJSONDialect) PathEquals(column string, path JSONPath, value any) clause.Expression {
+	return clause.Expr{SQL: "? = ?", Vars: []any{d.Extract(column, path), value}}
+}
+
+func (d postgresJSONDialect) PathLike(column string, path JSONPath, pattern string) clause.Expression {
+	return clause.Expr{SQL: "? LIKE ?", Vars: []any{d.Extract(column, path), pattern}}
+}
+
+func (postgresJSONDialect) ArrayContainsObject(column string, path JSONPath, key string, value any) clause.Expression {
+	// jsonb 的 @> 支持数组包含部分对象：[{"id":"1","user_id":"a"}] @> [{"id":"1"}]
+	doc, _ := json.Marshal([]map[string]any{{key: value}})
+	return clause.Expr{SQL: "? @> ?::jsonb", Vars: []any{pgExtractPath("jsonb_extract_path", column, path), string(doc)}}
+}
+
+func (d postgresJSONDialect) ArrayAnyMatch(column string, path JSONPath, conds []JSONElementCondition) clause.Expression {
+	return arrayAnyMatch(d, column, path, conds)
+}
+
+func (postgresJSONDialect) ArrayElements(column string, path JSONPath, alias string) clause.Expression {
+	// jsonb_array_elements 遇到非数组会报错，先转换为空数组
+	arr := pgExtractPath("jsonb_extract_path", column, path)
+	return clause.Expr{
+		SQL:  "jsonb_array_elements(CASE jsonb_typeof(?) WHEN 'array' THEN ? ELSE '[]'::jsonb END) AS ?(v)",
+		Vars: []any{arr, arr, clause.Table{Name: alias}},
+	}
+}
+
+func (postgresJSONDialect) ElementColumn(alias string) string {
+	return alias + ".v"
+}
+
+func (d postgresJSONDialect) ExtractNumber(column string, path JSONPath) clause.Expression {
+	return clause.Expr{SQL: "NULLIF(?, '')::numeric", Vars: []any{d.Extract(column, path)}}
+}
+
+func (postgresJSONDialect) PathExists(column string, path JSONPath) clause.Expression {
+	return clause.Expr{SQL: "? IS NOT NULL", Vars: []any{pgExtractPath("jsonb_extract_path", column, path)}}
+}
+
+func (postgresJSONDialect) PathHasType(column string, path JSONPath, jsonType string) clause.Expression {
+	return clause.Expr{SQL: "jsonb_typeof(?) = ?", Vars: []any{pgExtractPath("jsonb_extract_path", column, path), jsonType}}
+}
+
+func (postgresJSONDialect) Document(column string) clause.Expression {
+	return clause.Expr{SQL: "COALESCE(?, '{}'::jsonb)", Vars: []any{clause.Column{Name: column}}}
+}
+
+func (postgresJSONDialect) Set(doc clause.Expression, assignments []JSONAssignment) clause.Expression {
+	// jsonb_set 一次只能设置一个路径，逐层嵌套
+	expr := doc
+	for _, a := range assignments {
+		expr = clause.Expr{SQL: "jsonb_set(?, ?::text[], ?::jsonb)", Vars: []any{expr, pgTextArray(a.Path.Segments()), string(a.Value)}}
+	}
+	return expr
+}
+
+func (postgresJSONDialect) Remove(doc clause.Expression, paths []JSONPath) clause.Expression {
+	expr := doc
+	for _, path := range paths {
+		expr = clause.Expr{SQL: "(? #- ?::text[])", Vars: []any{expr, pgTextArray(path.Segments())}}
+	}
+	return expr
+}
+
+func (postgresJSONDialect) ArrayAppend(doc clause.Expression, path JSONPath, value json.RawMessage) clause.Expression {
+	// 插入到最后一个元素（下标 -1）之后，空数组时成为唯一的元素；doc 只出现一次，多次追加嵌套时表达式线性增长
+	return clause.Expr{
+		SQL:  "jsonb_insert(?, ?::text[], ?::jsonb, true)",
+		Vars: []any{doc, pgTextArray(append(path.Segments(), "-1")), string(value)},
+	}
+}
+
+func (postgresJSONDialect) MergePatch(doc clause.Expression, patch json.RawMessage) clause.Expression {
+	// jsonb 的 || 只合并顶层键，不符合 RFC 7396，由调用方在应用层合并
+	return nil
+}
+
+// variadicJSONSet 生成 JSON_SET(doc, path1, value1, path2, value2, ...) 形式的表达式
+func variadicJSONSet(fn, valueSQL string, doc clause.Expression, assignments []JSONAssignment) clause.Expression {
+	var b strings.Builder
+	b.WriteString(fn + "(?")
+	vars := []any{doc}
+	for _, a := range assignments {
+		b.WriteString(", ?, " + valueSQL)
+		vars = append(vars, a.Path.String(), string(a.Value))
+	}
+	b.WriteString(")")
+	return clause.Expr{SQL: b.String(), Vars: vars}
+}
+
+// variadicJSONRemove 生成 JSON_REMOVE(doc, path1, path2, ...) 形式的表达式
+func variadicJSONRemove(fn string, doc clause.Expression, paths []JSONPath) clause.Expression {
+	var b strings.Builder
+	b.WriteString(fn + "(?")
+	vars := []any{doc}
+	for _, path := range paths {
+		b.WriteString(", ?")
+		vars = append(vars, path.String())
+	}
+	b.WriteString(")")
+	return clause.Expr{SQL: b.String(), Vars: vars}
+}
+
+// pgTextArray 将路径段渲染为 text[] 字面量，路径键已校验过不含双引号和反斜杠
+func pgTextArray(segments []string) string {
+	if len(segments) == 0 {
+		return "{}"
+	}
+	return `{"` + strings.Join(segments, `","`) + `"}`
+}
+
+// pgExtractPath 生成 fn(column, 'seg1', 'seg2', ...) 形式的表达式
+func pgExtractPath(fn, column string, path JSONPath) clause.Expression {
+	return pgExtractPathOf(fn, clause.Column{Name: column}, path)
+}
+
+// pgExtractPathOf 同 pgExtractPath，target 为任意 jsonb 表达式
+func pgExtractPathOf(fn string, target any, path JSONPath) clause.Expression {
+	segments := path.Segments()
+	placeholders := make([]string, 0, len(segments)+1)
+	vars := make([]any, 0, len(segments)+1)
+	placeholders = append(placeholders, "?")
+	vars = append(vars, target)
+	for _, seg := range segments {
+		placeholders = append(placeholders, "?")
+		vars = append(vars, seg)
+	}
+	return clause.Expr{SQL: fn + "(" + strings.Join(placeholders, ", ") + ")", Vars: vars}
+}
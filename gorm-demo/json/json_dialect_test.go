@@ -0,0 +1,125 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// seedDialectApprovals 写入 JSONDialect 测试使用的记录
+func seedDialectApprovals(t *testing.T) *JSONQueryHelper {
+	t.Helper()
+	db := openTestDB(t)
+	seedApproval(t, db, "i1", LarkApproval{
+		ApprovalName: "差旅报销",
+		Status:       ApprovalStatusPending,
+		StartTime:    "1700000000000",
+		EndTime:      "",
+		TaskList: []*InstanceTask{
+			{ID: "t1", UserID: "zhangsan", Status: ApprovalStatusApproved, StartTime: "1700000000000"},
+			{ID: "t2", UserID: "lisi", Status: ApprovalStatusPending, StartTime: "1700000100000"},
+		},
+	})
+	seedApproval(t, db, "i2", LarkApproval{
+		ApprovalName: "采购申请",
+		Status:       ApprovalStatusApproved,
+		StartTime:    "1700000200000",
+		EndTime:      "1700000300000",
+		TaskList:     []*InstanceTask{{ID: "t3", UserID: "lisi", Status: ApprovalStatusApproved, StartTime: "1700000200000"}},
+	})
+	seedApproval(t, db, "i3", LarkApproval{ApprovalName: "请假", Status: ApprovalStatusRejected})
+	return NewJSONQueryHelper(db)
+}
+
+func TestSQLiteJSONDialect(t *testing.T) {
+	h := seedDialectApprovals(t)
+	d := h.Dialect
+	if d.Name() != DialectSQLite {
+		t.Fatalf("dialect = %s, want sqlite", d.Name())
+	}
+
+	tests := []struct {
+		name string
+		cond any
+		want []string
+	}{
+		{"PathEquals", d.PathEquals("lark_data", Path("status"), ApprovalStatusApproved), []string{"i2"}},
+		{"PathEquals nested", d.PathEquals("lark_data", Path("task_list").Index(1).Field("user_id"), "lisi"), []string{"i1"}},
+		{"PathLike", d.PathLike("lark_data", Path("approval_name"), "%报销%"), []string{"i1"}},
+		{"ArrayContainsObject", d.ArrayContainsObject("lark_data", Path("task_list"), "user_id", "lisi"), []string{"i1", "i2"}},
+		{"ArrayContainsObject none", d.ArrayContainsObject("lark_data", Path("task_list"), "user_id", "wangwu"), nil},
+		{"PathExists", d.PathExists("lark_data", Path("task_list").Index(1)), []string{"i1"}},
+		{"PathExists missing", d.PathExists("lark_data", Path("no_such_key")), nil},
+		{"ArrayAnyMatch empty conds", d.ArrayAnyMatch("lark_data", Path("task_list"), nil), []string{"i1", "i2"}},
+		{"ArrayAnyMatch same element", d.ArrayAnyMatch("lark_data", Path("task_list"), []JSONElementCondition{
+			{Path: Path("user_id"), Op: "=", Value: "zhangsan"},
+			{Path: Path("status"), Op: "=", Value: ApprovalStatusApproved},
+		}), []string{"i1"}},
+		{"ArrayAnyMatch across elements", d.ArrayAnyMatch("lark_data", Path("task_list"), []JSONElementCondition{
+			{Path: Path("user_id"), Op: "=", Value: "zhangsan"},
+			{Path: Path("status"), Op: "=", Value: ApprovalStatusPending},
+		}), nil},
+		{"ArrayAnyMatch numeric", d.ArrayAnyMatch("lark_data", Path("task_list"), []JSONElementCondition{
+			{Path: Path("start_time"), Op: ">=", Value: 1700000100000, Numeric: true},
+		}), []string{"i1", "i2"}},
+		{"ArrayAnyMatch LIKE", d.ArrayAnyMatch("lark_data", Path("task_list"), []JSONElementCondition{
+			{Path: Path("user_id"), Op: "LIKE", Value: "zhang%"},
+		}), []string{"i1"}},
+		{"ArrayAnyMatch IN", d.ArrayAnyMatch("lark_data", Path("task_list"), []JSONElementCondition{
+			{Path: Path("id"), Op: "IN", Value: []string{"t2", "t3"}},
+		}), []string{"i1", "i2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := instanceIDs(t, h.DB, tt.cond)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLiteExtractNumberEmptyString(t *testing.T) {
+	h := seedDialectApprovals(t)
+	// end_time 为空字符串的 i1 视为 NULL，不满足任何比较；没有 end_time 的 i3 同样如此
+	got := instanceIDs(t, h.DB, "? >= ?", h.Dialect.ExtractNumber("lark_data", Path("end_time")), 0)
+	if !slices.Equal(got, []string{"i2"}) {
+		t.Errorf("got %v, want [i2]", got)
+	}
+	got = instanceIDs(t, h.DB, "? IS NULL", h.Dialect.ExtractNumber("lark_data", Path("end_time")))
+	if !slices.Equal(got, []string{"i1", "i3"}) {
+		t.Errorf("got %v, want [i1 i3]", got)
+	}
+}
+
+func TestArrayAppendBindsDocumentOnce(t *testing.T) {
+	db := openTestDB(t)
+	var patch JSONPatch
+	for _, v := range []string{`1`, `[2]`, `{"id":"t3"}`, `"4"`} {
+		patch = append(patch, JSONPatchOperation{Op: PatchOpAdd, Path: "/task_list/-", Value: []byte(v)})
+	}
+	// 连续追加时文档表达式只出现一次，而不是每次追加翻倍
+	for _, d := range []JSONDialect{mysqlJSONDialect{}, postgresJSONDialect{}, sqliteJSONDialect{}} {
+		t.Run(d.Name(), func(t *testing.T) {
+			translated, ok := translateJSONPatch(d, "lark_data", patch)
+			if !ok {
+				t.Fatal("patch not translated")
+			}
+			stmt := &gorm.Statement{DB: db}
+			stmt.AddVar(stmt, translated.Expr)
+			if sql := stmt.SQL.String(); strings.Count(sql, "lark_data") != 1 {
+				t.Errorf("sql = %s", sql)
+			}
+		})
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	stmt.AddVar(stmt, postgresJSONDialect{}.ArrayAppend(clause.Expr{SQL: "?", Vars: []any{clause.Column{Name: "lark_data"}}}, Path("task_list"), []byte(`[2]`)))
+	if sql := stmt.SQL.String(); sql != "jsonb_insert(`lark_data`, ?::text[], ?::jsonb, true)" ||
+		!slices.Equal(stmt.Vars, []any{`{"task_list","-1"}`, `[2]`}) {
+		t.Errorf("postgres append = %s %v", sql, stmt.Vars)
+	}
+}
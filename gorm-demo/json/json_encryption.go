@@ -0,0 +1,949 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// 加密后的值是以前缀开头的字符串
+const (
+	// encryptedValuePrefix $enc:v2:<主密钥ID>:<被包裹的数据密钥>:<nonce+密文>:<加密路径>
+	//
+	// 加密路径为配置路径的 JSON Pointer 形式（如 /comment_list/*/comment），必须与值在文档中的位置匹配；
+	// 它与表名、记录的 tenant_id 和 instance_id 一起作为 AES-GCM 的附加数据（AAD），密文被复制到其他记录、
+	// 其他路径或变更历史中时无法解密。
+	encryptedValuePrefix = "$enc:v2:"
+	// legacyEncryptedValuePrefix $enc:v1:<主密钥ID>:<被包裹的数据密钥>:<nonce+密文>，没有附加数据；
+	// 仍可解密，读取时视为需要重新加密，由 ReencryptApprovals 迁移为 v2
+	legacyEncryptedValuePrefix = "$enc:v1:"
+)
+
+var (
+	// ErrEncryptionKeyNotFound KeyProvider 中没有密文记录的主密钥
+	ErrEncryptionKeyNotFound = errors.New("encryption key not found")
+	// ErrInvalidCiphertext 密文格式错误或校验失败（密钥不匹配、数据被篡改）
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+	// ErrEncryptionNotConfigured 没有注册 FieldEncryptionPlugin
+	ErrEncryptionNotConfigured = errors.New("field encryption plugin is not registered")
+	// ErrEncryptionScopeUnknown 无法确定密文所属的记录：写入时 Model 没有 instance_id，或查询时没有选择 tenant_id、instance_id
+	ErrEncryptionScopeUnknown = errors.New("encryption scope unknown")
+)
+
+// KeyProvider 提供主密钥（KEK），可对接 KMS
+//
+// 每次写入文档时生成随机的数据密钥（DEK），以 AES-GCM 加密文档中的敏感值，DEK 由当前主密钥包裹后与密文保存在一起。
+// 解密时按密文中记录的主密钥 ID 调用 Key，因此轮换后旧密钥需要保留到 ReencryptApprovals 完成为止。
+// ctx 为本次数据库操作的 context，可据此按租户（TenantFromContext）选择密钥。
+type KeyProvider interface {
+	// CurrentKeyID 返回加密新数据使用的主密钥 ID，不能为空或包含冒号
+	CurrentKeyID(ctx context.Context) (string, error)
+	// Key 返回主密钥，长度为 16、24 或 32 字节（AES-128/192/256），不存在时返回 ErrEncryptionKeyNotFound
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// StaticKeyProvider 保存在内存中的主密钥，用于测试或从配置文件、环境变量加载密钥
+type StaticKeyProvider struct {
+	mu        sync.RWMutex
+	currentID string
+	keys      map[string][]byte
+}
+
+// NewStaticKeyProvider 创建内存主密钥，currentID 为加密新数据使用的密钥
+func NewStaticKeyProvider(currentID string, key []byte) *StaticKeyProvider {
+	return &StaticKeyProvider{currentID: currentID, keys: map[string][]byte{currentID: key}}
+}
+
+// Rotate 加入新的主密钥并用于之后的加密，旧密钥仍可用于解密
+func (p *StaticKeyProvider) Rotate(id string, key []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys[id], p.currentID = key, id
+}
+
+// Remove 删除不再使用的主密钥，应在 ReencryptApprovals 完成之后调用
+func (p *StaticKeyProvider) Remove(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.keys, id)
+}
+
+// CurrentKeyID 实现 KeyProvider
+func (p *StaticKeyProvider) CurrentKeyID(context.Context) (string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.currentID, nil
+}
+
+// Key 实现 KeyProvider
+func (p *StaticKeyProvider) Key(_ context.Context, id string) ([]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("key %q: %w", id, ErrEncryptionKeyNotFound)
+	}
+	return key, nil
+}
+
+// EncryptedFields 一种 JSON 文档类型中需要加密的路径，作用于该类型的 JSONColumn
+//
+// 路径格式与 ParseJSONPath 相同，数组元素可以用 [*] 匹配全部下标，如 $.comment_list[*].comment。
+// 路径上的值（包括对象和数组）整体序列化后加密为字符串，null 和不存在的值不加密。
+type EncryptedFields struct {
+	Type  reflect.Type
+	Paths []string
+}
+
+// EncryptedFieldsOf 声明 JSONColumn[T] 中需要加密的路径
+func EncryptedFieldsOf[T any](paths ...string) EncryptedFields {
+	return EncryptedFields{Type: reflect.TypeFor[T](), Paths: paths}
+}
+
+// LarkDataEncryptedFields lark_data 中默认加密的路径：表单（可能包含薪资、证件号等）和评论、审批意见
+var LarkDataEncryptedFields = EncryptedFieldsOf[LarkApproval](
+	"$.form",
+	"$.comment_list[*].comment",
+	"$.timeline[*].comment",
+)
+
+// FieldEncryptionPlugin 对 JSONColumn 中配置的路径做字段级加密
+//
+// 注册后：
+//   - 写入时（Create、Save、Updates、Update、upsert 等绑定 JSONColumn 参数的语句）加密配置的路径，
+//     钩子、校验器、状态机和变更历史看到的仍是明文
+//   - 密文绑定到所在的表、记录的 tenant_id/instance_id 和加密路径（见 encryptionScope）。Create、Save 绑定到各行，
+//     Updates/Update/UpdateColumn 绑定到 Model 指向的记录；Model 没有 instance_id 时（如 Model(&ApprovalM{}).Where(...)）
+//     无法加密，返回 ErrEncryptionScopeUnknown
+//   - 查询到模型结构体后、AfterFind 和 jsonpath 虚拟字段填充之前解密，查询需要选择 tenant_id 和 instance_id；
+//     Row/Scan/Pluck 以及 datatypes.JSON 等其他类型读到的是密文
+//   - ApprovalM 经 JSONUpdateHelper 等 SQL 表达式写入的明文，在 AfterUpdate/AfterCreate 中读取后于同一事务内重新加密
+//   - approval_history 的 diff/revert_diff 中落在加密路径上的值同样加密，读取 ApprovalHistoryM 时解密
+//
+// 加密后的值是随机的，数据库中无法再按这些路径查询、排序或建立索引。需要在 JSONPathPlugin 之前注册。
+//
+//	db.Use(NewFieldEncryptionPlugin(keys, LarkDataEncryptedFields))
+type FieldEncryptionPlugin struct {
+	keys   KeyProvider
+	fields []EncryptedFields
+	paths  map[reflect.Type][]encryptedPath
+}
+
+// encryptedPath 解析后的加密路径，pointer 为其 JSON Pointer 形式，写入密文并参与附加数据
+type encryptedPath struct {
+	segments []string
+	pointer  string
+}
+
+// encryptionScope 密文所属的记录，与加密路径一起作为 AES-GCM 的附加数据
+type encryptionScope struct {
+	table      string
+	tenantID   string
+	instanceID string
+}
+
+// aad 返回 pointer 处的值的附加数据，各部分以 NUL 分隔
+func (s encryptionScope) aad(pointer string) []byte {
+	return []byte(s.table + "\x00" + s.tenantID + "\x00" + s.instanceID + "\x00" + pointer)
+}
+
+// NewFieldEncryptionPlugin 创建字段加密插件，路径在注册（db.Use）时解析
+func NewFieldEncryptionPlugin(keys KeyProvider, fields ...EncryptedFields) *FieldEncryptionPlugin {
+	return &FieldEncryptionPlugin{keys: keys, fields: fields}
+}
+
+// Name 实现 gorm.Plugin
+func (p *FieldEncryptionPlugin) Name() string {
+	return "field_encryption"
+}
+
+// Initialize 实现 gorm.Plugin
+func (p *FieldEncryptionPlugin) Initialize(db *gorm.DB) error {
+	if p.keys == nil {
+		return errors.New("field encryption: key provider is required")
+	}
+	p.paths = make(map[reflect.Type][]encryptedPath, len(p.fields))
+	for _, f := range p.fields {
+		for _, s := range f.Paths {
+			segments, err := parseEncryptedPath(s)
+			if err != nil {
+				return err
+			}
+			var pointer strings.Builder
+			for _, seg := range segments {
+				pointer.WriteString("/" + escapeJSONPointer(seg))
+			}
+			p.paths[f.Type] = append(p.paths[f.Type], encryptedPath{segments: segments, pointer: pointer.String()})
+		}
+	}
+	// 解密需要在 jsonpath:populate 之前，两者都位于 gorm:query 与 gorm:after_query 之间
+	// 解密需要在 jsonpath:populate 填充虚拟字段之前，同一位置的回调按注册顺序执行
+	if db.Callback().Query().Get("jsonpath:populate") != nil {
+		return errors.New("field encryption: register FieldEncryptionPlugin before JSONPathPlugin")
+	}
+	if err := db.Callback().Query().After("gorm:query").Before("gorm:after_query").Register("field_encryption:decrypt", p.decryptQuery); err != nil {
+		return err
+	}
+	// 绑定在钩子之后进行，钩子中替换的值和 TenantPlugin 写入的租户同样生效
+	if err := db.Callback().Create().After("gorm:before_create").Before("gorm:create").Register("field_encryption:bind_create", p.bindScopes); err != nil {
+		return err
+	}
+	return db.Callback().Update().After("gorm:before_update").Before("gorm:update").Register("field_encryption:bind_update", p.bindScopes)
+}
+
+// fieldEncryptionOf 返回 db 上注册的字段加密插件，没有注册时返回 nil
+func fieldEncryptionOf(db *gorm.DB) *FieldEncryptionPlugin {
+	if db == nil || db.Config == nil {
+		return nil
+	}
+	p, _ := db.Config.Plugins[(*FieldEncryptionPlugin)(nil).Name()].(*FieldEncryptionPlugin)
+	return p
+}
+
+// sealJSONColumn 由 JSONColumn.GormValue 调用，没有注册插件或类型没有配置加密路径时原样返回
+func sealJSONColumn(ctx context.Context, db *gorm.DB, t reflect.Type, data []byte, scope *encryptionScope) ([]byte, error) {
+	p := fieldEncryptionOf(db)
+	if p == nil || len(p.paths[t]) == 0 {
+		return data, nil
+	}
+	return p.seal(ctx, t, data, scope)
+}
+
+// encryptedJSONColumn 由 *JSONColumn 实现，查询后由插件替换为解密后的 JSON，写入前由插件绑定到所在记录
+type encryptedJSONColumn interface {
+	documentType() reflect.Type
+	rawJSON() []byte
+	setDecrypted(plain []byte, reencrypt bool)
+	bindScope(scope encryptionScope)
+}
+
+// encryptedFields 返回 s 中配置了加密路径的 JSONColumn 字段
+func (p *FieldEncryptionPlugin) encryptedFields(s *schema.Schema) []*schema.Field {
+	var fields []*schema.Field
+	for _, field := range s.Fields {
+		if col, ok := reflect.New(field.FieldType).Interface().(encryptedJSONColumn); ok && len(p.paths[col.documentType()]) > 0 {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// scopeOf 返回 rv 所在记录的加密范围，模型有 instance_id 列但值为空时 ok 为 false
+func scopeOf(stmt *gorm.Statement, rv reflect.Value) (scope encryptionScope, ok bool) {
+	scope.table = stmt.Schema.Table
+	if f := stmt.Schema.LookUpField("tenant_id"); f != nil {
+		v, _ := f.ValueOf(stmt.Context, rv)
+		scope.tenantID = fmt.Sprint(v)
+	}
+	if f := stmt.Schema.LookUpField("instance_id"); f != nil {
+		v, zero := f.ValueOf(stmt.Context, rv)
+		if zero {
+			return scope, false
+		}
+		scope.instanceID = fmt.Sprint(v)
+	}
+	return scope, true
+}
+
+// bindScopes 在写入前把 JSONColumn 绑定到所在的记录
+//
+// Create、Save 中的值绑定到各行；Updates(map/struct) 中的值绑定到 Model 指向的记录，struct 自身有 instance_id 时以它为准。
+// Model 没有 instance_id 时保留值上已有的绑定（如钩子中显式绑定的），没有绑定的值在需要加密时返回 ErrEncryptionScopeUnknown。
+func (p *FieldEncryptionPlugin) bindScopes(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil {
+		return
+	}
+	fields := p.encryptedFields(stmt.Schema)
+	if len(fields) == 0 {
+		return
+	}
+	bind := func(rv reflect.Value, scope encryptionScope) {
+		for _, field := range fields {
+			field.ReflectValueOf(stmt.Context, rv).Addr().Interface().(encryptedJSONColumn).bindScope(scope)
+		}
+	}
+	var model *encryptionScope
+	eachStruct(stmt.ReflectValue, func(rv reflect.Value) {
+		if rv.Type() != stmt.Schema.ModelType || !rv.CanAddr() {
+			return
+		}
+		if scope, ok := scopeOf(stmt, rv); ok {
+			model = &scope
+			bind(rv, scope)
+		}
+	})
+	if stmt.Dest == stmt.Model || model == nil || reflect.Indirect(stmt.ReflectValue).Kind() != reflect.Struct {
+		return
+	}
+
+	switch dest := stmt.Dest.(type) {
+	case map[string]any:
+		for _, field := range fields {
+			for _, key := range []string{field.DBName, field.Name} {
+				if v, ok := dest[key]; ok {
+					dest[key] = bindScopeValue(v, *model)
+				}
+			}
+		}
+	default:
+		rv := reflect.ValueOf(stmt.Dest)
+		if rv.Kind() == reflect.Struct && rv.Type() == stmt.Schema.ModelType {
+			// Updates(ApprovalM{...}) 传入的结构体不可寻址，换成指向副本的指针
+			ptr := reflect.New(rv.Type())
+			ptr.Elem().Set(rv)
+			stmt.Dest, rv = ptr.Interface(), ptr
+		}
+		eachStruct(rv, func(rv reflect.Value) {
+			if rv.Type() != stmt.Schema.ModelType || !rv.CanAddr() {
+				return
+			}
+			scope, ok := scopeOf(stmt, rv)
+			if !ok {
+				scope = *model
+			}
+			bind(rv, scope)
+		})
+	}
+}
+
+// bindScopeValue 返回绑定到 scope 的 v，v 不是 JSONColumn 时原样返回
+func bindScopeValue(v any, scope encryptionScope) any {
+	if col, ok := v.(encryptedJSONColumn); ok {
+		col.bindScope(scope)
+		return v
+	}
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return v
+	}
+	ptr := reflect.New(rv.Type())
+	ptr.Elem().Set(rv)
+	col, ok := ptr.Interface().(encryptedJSONColumn)
+	if !ok {
+		return v
+	}
+	col.bindScope(scope)
+	return ptr.Elem().Interface()
+}
+
+// selectsScope 查询是否读取了加密范围所需的 tenant_id 和 instance_id
+func selectsScope(stmt *gorm.Statement) bool {
+	if len(stmt.Selects) == 0 || slices.Contains(stmt.Selects, "*") {
+		return true
+	}
+	for _, column := range []string{"tenant_id", "instance_id"} {
+		if stmt.Schema.LookUpField(column) != nil && !slices.Contains(stmt.Selects, column) {
+			return false
+		}
+	}
+	return true
+}
+
+// decryptQuery 解密查询结果中配置了加密路径的 JSONColumn
+func (p *FieldEncryptionPlugin) decryptQuery(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil {
+		return
+	}
+	fields := p.encryptedFields(stmt.Schema)
+	if len(fields) == 0 {
+		return
+	}
+	selected := selectsScope(stmt)
+	eachStruct(stmt.ReflectValue, func(rv reflect.Value) {
+		if db.Error != nil || rv.Type() != stmt.Schema.ModelType {
+			return
+		}
+		for _, field := range fields {
+			col := field.ReflectValueOf(stmt.Context, rv).Addr().Interface().(encryptedJSONColumn)
+			raw := col.rawJSON()
+			if len(raw) == 0 {
+				continue
+			}
+			var scope *encryptionScope
+			if s, ok := scopeOf(stmt, rv); ok && selected {
+				scope = &s
+			}
+			plain, reencrypt, err := p.open(stmt.Context, col.documentType(), raw, scope)
+			if err != nil {
+				db.AddError(fmt.Errorf("decrypt %s.%s: %w", stmt.Schema.Table, field.DBName, err))
+				return
+			}
+			col.setDecrypted(plain, reencrypt)
+		}
+	})
+}
+
+// seal 加密文档中配置的路径，已经是密文的值保持不变；scope 为 nil 且有需要加密的值时返回 ErrEncryptionScopeUnknown
+func (p *FieldEncryptionPlugin) seal(ctx context.Context, t reflect.Type, data []byte, scope *encryptionScope) ([]byte, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return data, nil
+	}
+	root, err := decodeJSONValue(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode json document: %w", err)
+	}
+	s := &valueSealer{ctx: ctx, keys: p.keys, scope: scope}
+	if root, err = walkEncryptedPaths(root, nil, p.paths[t], s.seal); err != nil {
+		return nil, err
+	}
+	if !s.used {
+		return data, nil
+	}
+	return json.Marshal(root)
+}
+
+// open 解密文档中的所有密文，reencrypt 表示文档中有未加密的敏感值、v1 格式或使用了非当前主密钥的密文
+func (p *FieldEncryptionPlugin) open(ctx context.Context, t reflect.Type, data []byte, scope *encryptionScope) (plain []byte, reencrypt bool, err error) {
+	if string(data) == "null" {
+		return data, false, nil
+	}
+	root, err := decodeJSONValue(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("decode json document: %w", err)
+	}
+	var plaintext bool
+	if _, err := walkEncryptedPaths(root, nil, p.paths[t], func(_ encryptedPath, v any) (any, error) {
+		plaintext = plaintext || !isEncryptedValue(v)
+		return v, nil
+	}); err != nil {
+		return nil, false, err
+	}
+	if !containsEncryptedValue(data) {
+		return data, plaintext, nil
+	}
+	o := &valueOpener{ctx: ctx, keys: p.keys, scope: scope}
+	if root, err = o.openAll(root, nil); err != nil {
+		return nil, false, err
+	}
+	if plain, err = json.Marshal(root); err != nil {
+		return nil, false, err
+	}
+	return plain, plaintext || o.stale, nil
+}
+
+// sealPatch 加密 JSON Patch 中落在加密路径上的值，用于变更历史
+func (p *FieldEncryptionPlugin) sealPatch(ctx context.Context, t reflect.Type, patch JSONPatch, scope encryptionScope) (JSONPatch, error) {
+	patterns := p.paths[t]
+	if len(patterns) == 0 {
+		return patch, nil
+	}
+	s := &valueSealer{ctx: ctx, keys: p.keys, scope: &scope}
+	sealed := make(JSONPatch, len(patch))
+	for i, op := range patch {
+		sealed[i] = op
+		if len(op.Value) == 0 {
+			continue
+		}
+		pointer, err := parseJSONPointer(op.Path)
+		if err != nil {
+			return nil, err
+		}
+		v, err := decodeJSONValue(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		if v, err = walkEncryptedPaths(v, pointer, patterns, s.seal); err != nil {
+			return nil, err
+		}
+		if sealed[i].Value, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	return sealed, nil
+}
+
+// openPatch 解密 JSON Patch 中的密文，stale 表示其中有 v1 格式或非当前主密钥的密文
+func (p *FieldEncryptionPlugin) openPatch(ctx context.Context, data []byte, scope encryptionScope) (plain []byte, stale bool, err error) {
+	if !containsEncryptedValue(data) {
+		return data, false, nil
+	}
+	patch, err := ParseJSONPatch(data)
+	if err != nil {
+		return nil, false, err
+	}
+	o := &valueOpener{ctx: ctx, keys: p.keys, scope: &scope}
+	for i, op := range patch {
+		if len(op.Value) == 0 {
+			continue
+		}
+		pointer, err := parseJSONPointer(op.Path)
+		if err != nil {
+			return nil, false, err
+		}
+		v, err := decodeJSONValue(op.Value)
+		if err != nil {
+			return nil, false, err
+		}
+		if v, err = o.openAll(v, pointer); err != nil {
+			return nil, false, err
+		}
+		if patch[i].Value, err = json.Marshal(v); err != nil {
+			return nil, false, err
+		}
+	}
+	plain, err = json.Marshal(patch)
+	return plain, o.stale, err
+}
+
+// parseEncryptedPath 解析加密路径为段列表，[*] 解析为 "*"
+func parseEncryptedPath(s string) ([]string, error) {
+	var segments []string
+	for i, part := range strings.Split(strings.TrimSpace(s), "[*]") {
+		if i > 0 {
+			segments = append(segments, "*")
+			part = "$" + part
+		}
+		p, err := ParseJSONPath(part)
+		if err != nil {
+			return nil, fmt.Errorf("encrypted path %q: %w", s, err)
+		}
+		segments = append(segments, p.Segments()...)
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("encrypted path %q must not be the document root", s)
+	}
+	return segments, nil
+}
+
+// walkEncryptedPaths 对 v 中匹配 paths 的每个非 null 值调用 fn 并以返回值替换，fn 的第一个参数为匹配的加密路径
+//
+// prefix 为 v 在文档中的位置（JSON Pointer 的各段），用于 JSON Patch 的操作值；v 位于某个加密路径之内时整体调用 fn。
+func walkEncryptedPaths(v any, prefix []string, paths []encryptedPath, fn func(encryptedPath, any) (any, error)) (any, error) {
+	var err error
+	for _, path := range paths {
+		pattern := path.segments
+		switch {
+		case len(prefix) >= len(pattern) && matchEncryptedPath(pattern, prefix[:len(pattern)]):
+			if v != nil {
+				return fn(path, v)
+			}
+			return v, nil
+		case len(prefix) < len(pattern) && matchEncryptedPath(pattern[:len(prefix)], prefix):
+			if v, err = walkEncryptedPath(v, pattern[len(prefix):], func(v any) (any, error) { return fn(path, v) }); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+func walkEncryptedPath(v any, pattern []string, fn func(any) (any, error)) (any, error) {
+	if len(pattern) == 0 {
+		if v == nil {
+			return v, nil
+		}
+		return fn(v)
+	}
+	var err error
+	switch node := v.(type) {
+	case map[string]any:
+		for k, child := range node {
+			if pattern[0] == "*" || pattern[0] == k {
+				if node[k], err = walkEncryptedPath(child, pattern[1:], fn); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case []any:
+		for i, child := range node {
+			if pattern[0] == "*" || pattern[0] == strconv.Itoa(i) {
+				if node[i], err = walkEncryptedPath(child, pattern[1:], fn); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return v, nil
+}
+
+func matchEncryptedPath(pattern, segments []string) bool {
+	for i, seg := range pattern {
+		if seg != "*" && seg != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isEncryptedValue(v any) bool {
+	s, ok := v.(string)
+	return ok && (strings.HasPrefix(s, encryptedValuePrefix) || strings.HasPrefix(s, legacyEncryptedValuePrefix))
+}
+
+func containsEncryptedValue(data []byte) bool {
+	return bytes.Contains(data, []byte(encryptedValuePrefix)) || bytes.Contains(data, []byte(legacyEncryptedValuePrefix))
+}
+
+// valueSealer 加密一个文档（或一条历史）中的值，第一次使用时生成数据密钥，同一文档中的值共用
+type valueSealer struct {
+	ctx     context.Context
+	keys    KeyProvider
+	scope   *encryptionScope
+	used    bool
+	keyID   string
+	wrapped string
+	aead    cipher.AEAD
+}
+
+func (s *valueSealer) seal(path encryptedPath, v any) (any, error) {
+	if isEncryptedValue(v) {
+		return v, nil
+	}
+	if s.scope == nil {
+		return nil, ErrEncryptionScopeUnknown
+	}
+	if s.aead == nil {
+		if err := s.init(); err != nil {
+			return nil, err
+		}
+	}
+	plain, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := gcmSeal(s.aead, plain, s.scope.aad(path.pointer))
+	if err != nil {
+		return nil, err
+	}
+	s.used = true
+	return encryptedValuePrefix + s.keyID + ":" + s.wrapped + ":" + base64.RawURLEncoding.EncodeToString(sealed) + ":" + path.pointer, nil
+}
+
+func (s *valueSealer) init() error {
+	id, err := s.keys.CurrentKeyID(s.ctx)
+	if err != nil {
+		return err
+	}
+	if id == "" || strings.Contains(id, ":") {
+		return fmt.Errorf("encryption key id %q must be non-empty and must not contain ':'", id)
+	}
+	kek, err := s.keys.Key(s.ctx, id)
+	if err != nil {
+		return err
+	}
+	wrapper, err := newGCM(kek)
+	if err != nil {
+		return fmt.Errorf("encryption key %q: %w", id, err)
+	}
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return err
+	}
+	wrapped, err := gcmSeal(wrapper, dek, []byte(encryptedValuePrefix+id))
+	if err != nil {
+		return err
+	}
+	if s.aead, err = newGCM(dek); err != nil {
+		return err
+	}
+	s.keyID, s.wrapped = id, base64.RawURLEncoding.EncodeToString(wrapped)
+	return nil
+}
+
+// valueOpener 解密一个文档中的值，按被包裹的数据密钥缓存解包结果；scope 为 nil 时无法解密 v2 格式的密文
+type valueOpener struct {
+	ctx       context.Context
+	keys      KeyProvider
+	scope     *encryptionScope
+	currentID string
+	stale     bool
+	aeads     map[string]cipher.AEAD
+}
+
+// openAll 解密 v 中所有的密文，location 为 v 在文档中的位置
+//
+// 加密路径取自密文本身而不是当前配置，配置变化后已有的密文仍可读取；但密文所在的位置必须匹配该路径。
+func (o *valueOpener) openAll(v any, location []string) (any, error) {
+	var err error
+	switch node := v.(type) {
+	case map[string]any:
+		for k, child := range node {
+			if node[k], err = o.openAll(child, append(slices.Clip(location), k)); err != nil {
+				return nil, err
+			}
+		}
+	case []any:
+		for i, child := range node {
+			if node[i], err = o.openAll(child, append(slices.Clip(location), strconv.Itoa(i))); err != nil {
+				return nil, err
+			}
+		}
+	case string:
+		if isEncryptedValue(node) {
+			return o.open(node, location)
+		}
+	}
+	return v, nil
+}
+
+func (o *valueOpener) open(s string, location []string) (any, error) {
+	var id, wrapped, data, prefix string
+	var aad []byte
+	if rest, ok := strings.CutPrefix(s, legacyEncryptedValuePrefix); ok {
+		parts := strings.SplitN(rest, ":", 3)
+		if len(parts) != 3 {
+			return nil, ErrInvalidCiphertext
+		}
+		id, wrapped, data, prefix = parts[0], parts[1], parts[2], legacyEncryptedValuePrefix
+		o.stale = true
+	} else {
+		parts := strings.SplitN(strings.TrimPrefix(s, encryptedValuePrefix), ":", 4)
+		if len(parts) != 4 {
+			return nil, ErrInvalidCiphertext
+		}
+		if o.scope == nil {
+			return nil, ErrEncryptionScopeUnknown
+		}
+		pattern, err := parseJSONPointer(parts[3])
+		if err != nil || len(location) < len(pattern) || !matchEncryptedPath(pattern, location[:len(pattern)]) {
+			return nil, ErrInvalidCiphertext
+		}
+		id, wrapped, data, prefix = parts[0], parts[1], parts[2], encryptedValuePrefix
+		aad = o.scope.aad(parts[3])
+	}
+	if o.currentID == "" {
+		current, err := o.keys.CurrentKeyID(o.ctx)
+		if err != nil {
+			return nil, err
+		}
+		o.currentID = current
+	}
+	o.stale = o.stale || id != o.currentID
+
+	aead, ok := o.aeads[prefix+id+":"+wrapped]
+	if !ok {
+		kek, err := o.keys.Key(o.ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		unwrapper, err := newGCM(kek)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", id, err)
+		}
+		sealedKey, err := base64.RawURLEncoding.DecodeString(wrapped)
+		if err != nil {
+			return nil, ErrInvalidCiphertext
+		}
+		dek, err := gcmOpen(unwrapper, sealedKey, []byte(prefix+id))
+		if err != nil {
+			return nil, err
+		}
+		if aead, err = newGCM(dek); err != nil {
+			return nil, err
+		}
+		if o.aeads == nil {
+			o.aeads = map[string]cipher.AEAD{}
+		}
+		o.aeads[prefix+id+":"+wrapped] = aead
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	plain, err := gcmOpen(aead, sealed, aad)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSONValue(plain)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// gcmSeal 以随机 nonce 加密，nonce 放在密文之前
+func gcmSeal(aead cipher.AEAD, plain, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, aad), nil
+}
+
+func gcmOpen(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return plain, nil
+}
+
+// DefaultReencryptBatchSize ReencryptApprovals 默认每批处理的记录数
+const DefaultReencryptBatchSize = 200
+
+// ReencryptResult 重新加密的统计
+type ReencryptResult struct {
+	Scanned   int // 检查的审批记录数
+	Approvals int // 重新加密的审批记录数
+	Histories int // 重新加密的变更历史数
+}
+
+// ReencryptApprovals 以当前主密钥重新加密已有数据，用于密钥轮换或开启加密后加密存量明文
+//
+// 按 id 分批处理 approval（包括已软删除的记录）和 approval_history：使用旧主密钥的密文、加密路径上的明文会被重新加密，
+// 其余记录不做修改。审批记录在事务中加锁后写回，不增加版本号、不记录历史、不触发 ES 重新索引。
+// batchSize <= 0 时使用 DefaultReencryptBatchSize。context 中有租户时只处理该租户，跨租户处理时使用 WithAllTenants。
+// 完成后旧主密钥就可以从 KeyProvider 中移除。
+func ReencryptApprovals(ctx context.Context, db *gorm.DB, batchSize int) (ReencryptResult, error) {
+	var result ReencryptResult
+	p := fieldEncryptionOf(db)
+	if p == nil {
+		return result, ErrEncryptionNotConfigured
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultReencryptBatchSize
+	}
+	db = db.WithContext(ctx)
+
+	for lastID := uint64(0); ; {
+		var batch []*ApprovalM
+		err := db.Transaction(func(tx *gorm.DB) error {
+			query := tx.Unscoped().Select("id", "tenant_id", "instance_id", "lark_data").Where("id > ?", lastID).Order("id").Limit(batchSize)
+			// SQLite 不支持 FOR UPDATE，写事务本身是串行的
+			if JSONDialectOf(tx).Name() != DialectSQLite {
+				query = query.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate})
+			}
+			if err := query.Find(&batch).Error; err != nil {
+				return err
+			}
+			for _, a := range batch {
+				if !a.LarkData.needsReencryption() {
+					continue
+				}
+				// UpdateColumn 跳过钩子，写入时由 JSONColumn.GormValue 以当前主密钥、v2 格式加密并绑定到 Model 指向的记录
+				if err := tx.Unscoped().Model(a).UpdateColumn("lark_data", a.LarkData).Error; err != nil {
+					return fmt.Errorf("reencrypt approval %s: %w", a.InstanceID, err)
+				}
+				result.Approvals++
+			}
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Scanned += len(batch)
+		if len(batch) < batchSize {
+			break
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+
+	for lastID := uint64(0); ; {
+		var batch []*approvalHistoryPatches
+		if err := db.Where("id > ?", lastID).Order("id").Limit(batchSize).Find(&batch).Error; err != nil {
+			return result, err
+		}
+		for _, h := range batch {
+			scope := historyEncryptionScope(h.TenantID, h.InstanceID)
+			diff, changed, err := p.resealPatch(ctx, h.Diff, scope)
+			if err != nil {
+				return result, fmt.Errorf("reencrypt approval history %d: %w", h.ID, err)
+			}
+			revert, revertChanged, err := p.resealPatch(ctx, h.RevertDiff, scope)
+			if err != nil {
+				return result, fmt.Errorf("reencrypt approval history %d: %w", h.ID, err)
+			}
+			if !changed && !revertChanged {
+				continue
+			}
+			// 历史记录写入后不再修改，不需要加锁
+			if err := db.Model(&approvalHistoryPatches{}).Where("id = ?", h.ID).UpdateColumns(map[string]any{
+				"diff":        datatypes.JSON(diff),
+				"revert_diff": datatypes.JSON(revert),
+			}).Error; err != nil {
+				return result, fmt.Errorf("reencrypt approval history %d: %w", h.ID, err)
+			}
+			result.Histories++
+		}
+		if len(batch) < batchSize {
+			break
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+	return result, nil
+}
+
+// approvalHistoryPatches approval_history 中保存 JSON Patch 的列，读取时不经过 ApprovalHistoryM 的解密钩子
+type approvalHistoryPatches struct {
+	ID         uint64
+	TenantID   string
+	InstanceID string
+	Diff       datatypes.JSON
+	RevertDiff datatypes.JSON
+}
+
+// TableName 指定表名
+func (approvalHistoryPatches) TableName() string {
+	return "approval_history"
+}
+
+// resealPatch 解密后以当前主密钥重新加密一条历史中的 JSON Patch，没有 v1 格式或旧密钥的密文、加密路径上也没有明文时 changed 为 false
+func (p *FieldEncryptionPlugin) resealPatch(ctx context.Context, data []byte, scope encryptionScope) (resealed []byte, changed bool, err error) {
+	t := reflect.TypeFor[LarkApproval]()
+	raw, err := ParseJSONPatch(data)
+	if err != nil {
+		return nil, false, err
+	}
+	// 开启加密之前记录的历史中，加密路径上是明文
+	var plaintext bool
+	for _, op := range raw {
+		if len(op.Value) == 0 {
+			continue
+		}
+		pointer, err := parseJSONPointer(op.Path)
+		if err != nil {
+			return nil, false, err
+		}
+		v, err := decodeJSONValue(op.Value)
+		if err != nil {
+			return nil, false, err
+		}
+		if _, err := walkEncryptedPaths(v, pointer, p.paths[t], func(_ encryptedPath, v any) (any, error) {
+			plaintext = plaintext || !isEncryptedValue(v)
+			return v, nil
+		}); err != nil {
+			return nil, false, err
+		}
+	}
+	plain, stale, err := p.openPatch(ctx, data, scope)
+	if err != nil {
+		return nil, false, err
+	}
+	if !plaintext && !stale {
+		return data, false, nil
+	}
+	patch, err := ParseJSONPatch(plain)
+	if err != nil {
+		return nil, false, err
+	}
+	if patch, err = p.sealPatch(ctx, t, patch, scope); err != nil {
+		return nil, false, err
+	}
+	resealed, err = json.Marshal(patch)
+	return resealed, true, err
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// openEncryptedDB 返回注册了 FieldEncryptionPlugin 和 TenantPlugin 的测试库
+func openEncryptedDB(t *testing.T) (*gorm.DB, *StaticKeyProvider) {
+	t.Helper()
+	db := openTestDB(t)
+	keys := NewStaticKeyProvider("k1", randomKey(t))
+	if err := db.Use(NewFieldEncryptionPlugin(keys, LarkDataEncryptedFields)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(NewTenantPlugin(ApprovalTenantTables...)); err != nil {
+		t.Fatal(err)
+	}
+	return db, keys
+}
+
+func randomKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// sensitiveApproval 带表单和评论的审批数据
+func sensitiveApproval(form string) LarkApproval {
+	return LarkApproval{
+		ApprovalName: "报销",
+		Status:       ApprovalStatusPending,
+		Form:         form,
+		CommentList:  []*InstanceComment{{ID: "c1", Comment: "comment of " + form}},
+	}
+}
+
+// rawLarkData 不经过解密读取 lark_data
+func rawLarkData(t *testing.T, db *gorm.DB, tenant, instanceID string) string {
+	t.Helper()
+	var raw string
+	if err := db.Table("approval").Where("tenant_id = ? AND instance_id = ?", tenant, instanceID).
+		Pluck("lark_data", &raw).Error; err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// findLarkData 以 tenant 读取并解密 instanceID 的审批数据
+func findLarkData(db *gorm.DB, tenant, instanceID string) (LarkApproval, error) {
+	var a ApprovalM
+	if err := db.WithContext(WithTenant(context.Background(), tenant)).Where("instance_id = ?", instanceID).First(&a).Error; err != nil {
+		return LarkApproval{}, err
+	}
+	return a.LarkData.Get()
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	db, _ := openEncryptedDB(t)
+	seedApproval(t, db.WithContext(WithTenant(t.Context(), "tenant-a")), "i1", sensitiveApproval("salary"))
+
+	raw := rawLarkData(t, db, "tenant-a", "i1")
+	if strings.Contains(raw, "salary") || strings.Count(raw, encryptedValuePrefix) != 2 {
+		t.Errorf("raw lark_data = %s, want form and comment encrypted with v2", raw)
+	}
+	if !strings.Contains(raw, ":/form\"") || !strings.Contains(raw, ":/comment_list/*/comment\"") {
+		t.Errorf("raw lark_data = %s, want encrypted paths", raw)
+	}
+	data, err := findLarkData(db, "tenant-a", "i1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Form != "salary" || data.CommentList[0].Comment != "comment of salary" {
+		t.Errorf("decrypted = %+v", data)
+	}
+
+	// JSONUpdateHelper 以表达式写入的明文在 AfterUpdate 中重新加密
+	h := NewJSONUpdateHelper(db.WithContext(WithTenant(t.Context(), "tenant-a")))
+	if err := h.UpdateJSONField("i1", "$.form", "bonus"); err != nil {
+		t.Fatal(err)
+	}
+	if raw := rawLarkData(t, db, "tenant-a", "i1"); strings.Contains(raw, "bonus") {
+		t.Errorf("raw lark_data = %s, want form encrypted", raw)
+	}
+	if data, err := findLarkData(db, "tenant-a", "i1"); err != nil || data.Form != "bonus" {
+		t.Errorf("form = %q, err = %v", data.Form, err)
+	}
+
+	histories, err := FindApprovalHistory(db.WithContext(WithTenant(t.Context(), "tenant-a")), "i1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(histories) != 2 || !bytes.Contains(histories[1].Diff, []byte("bonus")) {
+		t.Errorf("histories = %d, want decrypted update diff", len(histories))
+	}
+}
+
+func TestEncryptionBoundToRecord(t *testing.T) {
+	tests := []struct {
+		name string
+		swap string // 把 tenant-a/i1 的密文复制到其他位置的 SQL
+		// 读取被复制到的记录
+		tenant, instanceID string
+	}{
+		{"other instance",
+			`UPDATE approval SET lark_data = (SELECT lark_data FROM approval WHERE tenant_id = 'tenant-a' AND instance_id = 'i1')
+			WHERE tenant_id = 'tenant-a' AND instance_id = 'i2'`,
+			"tenant-a", "i2"},
+		{"other tenant",
+			`UPDATE approval SET lark_data = (SELECT lark_data FROM approval WHERE tenant_id = 'tenant-a' AND instance_id = 'i1')
+			WHERE tenant_id = 'tenant-b' AND instance_id = 'i1'`,
+			"tenant-b", "i1"},
+		{"other path",
+			`UPDATE approval SET lark_data = json_set(lark_data, '$.comment_list[0].comment', json_extract(lark_data, '$.form'))
+			WHERE tenant_id = 'tenant-a' AND instance_id = 'i1'`,
+			"tenant-a", "i1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := openEncryptedDB(t)
+			seedApproval(t, db.WithContext(WithTenant(t.Context(), "tenant-a")), "i1", sensitiveApproval("a1"))
+			seedApproval(t, db.WithContext(WithTenant(t.Context(), "tenant-a")), "i2", sensitiveApproval("a2"))
+			seedApproval(t, db.WithContext(WithTenant(t.Context(), "tenant-b")), "i1", sensitiveApproval("b1"))
+
+			if err := db.Exec(tt.swap).Error; err != nil {
+				t.Fatal(err)
+			}
+			if _, err := findLarkData(db, tt.tenant, tt.instanceID); !errors.Is(err, ErrInvalidCiphertext) {
+				t.Errorf("err = %v, want ErrInvalidCiphertext", err)
+			}
+		})
+	}
+
+	// 审批记录中的密文不能放进变更历史
+	db, _ := openEncryptedDB(t)
+	ctx := WithTenant(t.Context(), "tenant-a")
+	seedApproval(t, db.WithContext(ctx), "i1", sensitiveApproval("a1"))
+	diff := `[{"op":"add","path":"","value":` + rawLarkData(t, db, "tenant-a", "i1") + `}]`
+	if err := db.Table("approval_history").Where("instance_id = ?", "i1").Update("diff", diff).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindApprovalHistory(db.WithContext(ctx), "i1"); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("history err = %v, want ErrInvalidCiphertext", err)
+	}
+}
+
+func TestEncryptionScopeUnknown(t *testing.T) {
+	db, _ := openEncryptedDB(t)
+	a := seedApproval(t, db, "i1", sensitiveApproval("a1"))
+
+	// 没有指向记录的 Model 无法确定附加数据
+	err := db.Model(&ApprovalM{}).Where("instance_id = ?", "i1").
+		Updates(map[string]any{"lark_data": NewJSONColumn(sensitiveApproval("a2"))}).Error
+	if !errors.Is(err, ErrEncryptionScopeUnknown) {
+		t.Errorf("update err = %v, want ErrEncryptionScopeUnknown", err)
+	}
+	var partial ApprovalM
+	if err := db.Select("id", "lark_data").First(&partial, a.ID).Error; !errors.Is(err, ErrEncryptionScopeUnknown) {
+		t.Errorf("select err = %v, want ErrEncryptionScopeUnknown", err)
+	}
+
+	// Model 指向记录时，map 和 struct 中的值都绑定到该记录
+	if err := db.Model(a).Updates(map[string]any{"lark_data": NewJSONColumn(sensitiveApproval("a3"))}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(a).Updates(ApprovalM{LarkData: NewJSONColumn(sensitiveApproval("a4"))}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if data, err := findLarkData(db, "", "i1"); err != nil || data.Form != "a4" {
+		t.Errorf("form = %q, err = %v", data.Form, err)
+	}
+}
+
+// sealV1 以没有附加数据的 v1 格式加密 v
+func sealV1(t *testing.T, keys KeyProvider, v any) string {
+	t.Helper()
+	kek, err := keys.Key(t.Context(), "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapper, err := newGCM(kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dek := randomKey(t)
+	wrapped, err := gcmSeal(wrapper, dek, []byte(legacyEncryptedValuePrefix+"k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := newGCM(dek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := gcmSeal(aead, plain, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := base64.RawURLEncoding.EncodeToString
+	return legacyEncryptedValuePrefix + "k1:" + enc(wrapped) + ":" + enc(sealed)
+}
+
+func TestReencryptMigratesV1(t *testing.T) {
+	db, keys := openEncryptedDB(t)
+	ctx := WithTenant(t.Context(), "tenant-a")
+	seedApproval(t, db.WithContext(ctx), "i1", sensitiveApproval("a1"))
+
+	v1 := sealV1(t, keys, "legacy form")
+	if err := db.Exec(`UPDATE approval SET lark_data = json_set(lark_data, '$.form', ?)`, v1).Error; err != nil {
+		t.Fatal(err)
+	}
+	diff := `[{"op":"replace","path":"/form","value":"` + v1 + `"}]`
+	if err := db.Table("approval_history").Where("instance_id = ?", "i1").Update("diff", diff).Error; err != nil {
+		t.Fatal(err)
+	}
+	// v1 仍可读取
+	if data, err := findLarkData(db, "tenant-a", "i1"); err != nil || data.Form != "legacy form" {
+		t.Fatalf("form = %q, err = %v", data.Form, err)
+	}
+
+	result, err := ReencryptApprovals(WithAllTenants(t.Context()), db, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Approvals != 1 || result.Histories != 1 {
+		t.Errorf("result = %+v, want 1 approval and 1 history", result)
+	}
+	if raw := rawLarkData(t, db, "tenant-a", "i1"); strings.Contains(raw, legacyEncryptedValuePrefix) || !strings.Contains(raw, encryptedValuePrefix) {
+		t.Errorf("raw lark_data = %s, want v2 only", raw)
+	}
+	if data, err := findLarkData(db, "tenant-a", "i1"); err != nil || data.Form != "legacy form" {
+		t.Errorf("form = %q, err = %v", data.Form, err)
+	}
+	histories, err := FindApprovalHistory(db.WithContext(ctx), "i1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(histories[0].Diff, []byte("legacy form")) {
+		t.Errorf("history diff = %s", histories[0].Diff)
+	}
+
+	// 已经是 v2 时不再重新加密
+	if result, err := ReencryptApprovals(WithAllTenants(t.Context()), db, 0); err != nil || result.Approvals != 0 || result.Histories != 0 {
+		t.Errorf("second run = %+v, %v", result, err)
+	}
+}
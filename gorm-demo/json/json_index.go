@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HotLarkApprovalPaths 频繁查询的 LarkApproval JSON 路径
+var HotLarkApprovalPaths = []JSONPath{
+	Path("approval_name"),
+	Path("status"),
+	Path("user_id"),
+}
+
+// JSONIndexM 记录已创建的 JSON 路径索引
+type JSONIndexM struct {
+	ID              uint64    `gorm:"column:id;AUTO_INCREMENT;primary_key" json:"id"`
+	CreatedAt       time.Time `gorm:"column:created_at" json:"created_at"`
+	Table           string    `gorm:"column:table_name;type:varchar(64);NOT NULL;uniqueIndex:uk_table_column_path" json:"table_name"`   // 业务表名
+	Column          string    `gorm:"column:json_column;type:varchar(64);NOT NULL;uniqueIndex:uk_table_column_path" json:"json_column"` // JSON 列名
+	Path            string    `gorm:"column:json_path;type:varchar(255);NOT NULL;uniqueIndex:uk_table_column_path" json:"json_path"`    // JSON 路径，如 $.status
+	GeneratedColumn string    `gorm:"column:generated_column;type:varchar(64);NOT NULL" json:"generated_column"`                        // 生成列名，仅 MySQL 使用
+	IndexName       string    `gorm:"column:index_name;type:varchar(64);NOT NULL" json:"index_name"`                                    // 索引名
+	Dialect         string    `gorm:"column:dialect;type:varchar(20);NOT NULL" json:"dialect"`                                          // 创建索引时的数据库方言
+	Expression      string    `gorm:"column:expression;type:varchar(512);NOT NULL" json:"expression"`                                   // 被索引的 SQL 表达式
+}
+
+// TableName 指定表名
+func (JSONIndexM) TableName() string {
+	return "json_index"
+}
+
+// JSONIndex 一个已建索引的 JSON 路径
+type JSONIndex struct {
+	Column          string
+	Path            JSONPath
+	GeneratedColumn string
+	IndexName       string
+	Dialect         string
+	Expression      string
+}
+
+// Expr 查询时用于替代 JSON 提取的表达式
+//
+// MySQL 直接使用虚拟生成列；PostgreSQL/SQLite 的表达式索引只有在查询表达式与建索引时完全一致时才会被使用，
+// 因此这里原样输出建索引时的 SQL，而不是使用绑定参数。
+func (i *JSONIndex) Expr() any {
+	if i.Dialect == DialectMySQL {
+		return clause.Column{Name: i.GeneratedColumn}
+	}
+	return clause.Expr{SQL: i.Expression}
+}
+
+// JSONIndexManager 为热点 JSON 路径创建生成列和索引，并记录在 json_index 表中
+type JSONIndexManager struct {
+	DB      *gorm.DB
+	Table   string
+	Dialect JSONDialect
+
+	mu      sync.RWMutex
+	indexes map[string]*JSONIndex
+}
+
+// NewJSONIndexManager 创建 approval 表的 JSON 索引管理器
+func NewJSONIndexManager(db *gorm.DB) *JSONIndexManager {
+	return &JSONIndexManager{
+		DB:      db,
+		Table:   ApprovalM{}.TableName(),
+		Dialect: JSONDialectOf(db),
+		indexes: make(map[string]*JSONIndex),
+	}
+}
+
+// Load 创建 json_index 表（如不存在）并加载已记录的索引
+func (m *JSONIndexManager) Load() error {
+	if err := m.DB.AutoMigrate(&JSONIndexM{}); err != nil {
+		return err
+	}
+
+	var records []*JSONIndexM
+	if err := m.DB.Where("table_name = ? AND dialect = ?", m.Table, m.Dialect.Name()).Find(&records).Error; err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range records {
+		path, err := ParseJSONPath(r.Path)
+		if err != nil {
+			return fmt.Errorf("load json index %s: %w", r.IndexName, err)
+		}
+		m.indexes[jsonIndexKey(r.Column, path)] = &JSONIndex{
+			Column:          r.Column,
+			Path:            path,
+			GeneratedColumn: r.GeneratedColumn,
+			IndexName:       r.IndexName,
+			Dialect:         r.Dialect,
+			Expression:      r.Expression,
+		}
+	}
+	return nil
+}
+
+// Ensure 为 column 中的每个路径创建索引，已存在的会被跳过
+func (m *JSONIndexManager) Ensure(column string, paths ...JSONPath) error {
+	if err := m.DB.AutoMigrate(&JSONIndexM{}); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := m.ensure(column, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lookup 返回 column 中 path 对应的索引
+func (m *JSONIndexManager) Lookup(column string, path JSONPath) (*JSONIndex, bool) {
+	if m == nil {
+		return nil, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	idx, ok := m.indexes[jsonIndexKey(column, path)]
+	return idx, ok
+}
+
+// Indexes 返回当前已加载的所有索引
+func (m *JSONIndexManager) Indexes() []*JSONIndex {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	indexes := make([]*JSONIndex, 0, len(m.indexes))
+	for _, idx := range m.indexes {
+		indexes = append(indexes, idx)
+	}
+	return indexes
+}
+
+func (m *JSONIndexManager) ensure(column string, path JSONPath) error {
+	if err := path.Err(); err != nil {
+		return err
+	}
+	if len(path.segments) == 0 {
+		return fmt.Errorf("json index path must not be the document root")
+	}
+
+	idx := m.define(column, path)
+	migrator := m.DB.Migrator()
+
+	switch idx.Dialect {
+	case DialectMySQL:
+		if !migrator.HasColumn(m.Table, idx.GeneratedColumn) {
+			ddl := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s VARCHAR(255) GENERATED ALWAYS AS (%s) VIRTUAL",
+				m.quote(m.Table), m.quote(idx.GeneratedColumn), idx.Expression)
+			if err := m.DB.Exec(ddl).Error; err != nil {
+				return fmt.Errorf("add generated column %s: %w", idx.GeneratedColumn, err)
+			}
+		}
+		if !migrator.HasIndex(m.Table, idx.IndexName) {
+			ddl := fmt.Sprintf("CREATE INDEX %s ON %s (%s)", m.quote(idx.IndexName), m.quote(m.Table), m.quote(idx.GeneratedColumn))
+			if err := m.DB.Exec(ddl).Error; err != nil {
+				return fmt.Errorf("create index %s: %w", idx.IndexName, err)
+			}
+		}
+	default:
+		// PostgreSQL 和 SQLite 直接在表达式上建索引
+		ddl := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s ((%s))", m.quote(idx.IndexName), m.quote(m.Table), idx.Expression)
+		if err := m.DB.Exec(ddl).Error; err != nil {
+			return fmt.Errorf("create index %s: %w", idx.IndexName, err)
+		}
+	}
+
+	record := &JSONIndexM{
+		Table:           m.Table,
+		Column:          column,
+		Path:            path.String(),
+		GeneratedColumn: idx.GeneratedColumn,
+		IndexName:       idx.IndexName,
+		Dialect:         idx.Dialect,
+		Expression:      idx.Expression,
+	}
+	if err := m.DB.Where(&JSONIndexM{Table: m.Table, Column: column, Path: path.String()}).FirstOrCreate(record).Error; err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.indexes[jsonIndexKey(column, path)] = idx
+	m.mu.Unlock()
+	return nil
+}
+
+// define 计算生成列名、索引名以及被索引的表达式
+func (m *JSONIndexManager) define(column string, path JSONPath) *JSONIndex {
+	name := sanitizeIdentifier(column + "_" + strings.Join(path.Segments(), "_"))
+	idx := &JSONIndex{
+		Column:    column,
+		Path:      path,
+		IndexName: "idx_" + m.Table + "_" + name,
+		Dialect:   m.Dialect.Name(),
+	}
+
+	// DDL 中无法使用绑定参数，路径以字面量写入，单引号需要转义
+	literal := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+	col := m.quote(column)
+	switch idx.Dialect {
+	case DialectMySQL:
+		idx.GeneratedColumn = name
+		idx.Expression = fmt.Sprintf("JSON_UNQUOTE(JSON_EXTRACT(%s, %s))", col, literal(path.String()))
+	case DialectPostgres:
+		idx.Expression = fmt.Sprintf("(%s #>> %s)", col, literal(pgTextArray(path.Segments())))
+	default:
+		idx.Expression = fmt.Sprintf("json_extract(%s, %s)", col, literal(path.String()))
+	}
+	return idx
+}
+
+func (m *JSONIndexManager) quote(name string) string {
+	return m.DB.Statement.Quote(name)
+}
+
+func jsonIndexKey(column string, path JSONPath) string {
+	return column + "|" + path.String()
+}
+
+// sanitizeIdentifier 将路径转换为合法的列名/索引名
+func sanitizeIdentifier(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r == '_' || (r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))) {
+			b.WriteRune(unicode.ToLower(r))
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
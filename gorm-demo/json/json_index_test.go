@@ -0,0 +1,164 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// querySQL 返回按 cond 查询 approval 的 SQL，参数已内联
+func querySQL(db *gorm.DB, cond any) string {
+	return db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		var approvals []*ApprovalM
+		return tx.Model(&ApprovalM{}).Where(cond).Find(&approvals)
+	})
+}
+
+// queryPlan 返回 SQLite 查询计划的描述
+func queryPlan(t *testing.T, db *gorm.DB, sql string) string {
+	t.Helper()
+	var rows []struct {
+		ID      int
+		Parent  int
+		Notused int
+		Detail  string
+	}
+	if err := db.Raw("EXPLAIN QUERY PLAN " + sql).Scan(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	var details []string
+	for _, r := range rows {
+		details = append(details, r.Detail)
+	}
+	return strings.Join(details, "; ")
+}
+
+func TestJSONIndexManagerDefine(t *testing.T) {
+	m := NewJSONIndexManager(openTestDB(t))
+	path := Path("task_list").Index(0).Field("user's id")
+	tests := []struct {
+		dialect   JSONDialect
+		generated string
+		expr      string
+	}{
+		{mysqlJSONDialect{}, "lark_data_task_list_0_user_s_id", "JSON_UNQUOTE(JSON_EXTRACT(`lark_data`, '$.task_list[0].\"user''s id\"'))"},
+		{postgresJSONDialect{}, "", "(`lark_data` #>> '{\"task_list\",\"0\",\"user''s id\"}')"},
+		{sqliteJSONDialect{}, "", "json_extract(`lark_data`, '$.task_list[0].\"user''s id\"')"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dialect.Name(), func(t *testing.T) {
+			m.Dialect = tt.dialect
+			idx := m.define("lark_data", path)
+			if idx.IndexName != "idx_approval_lark_data_task_list_0_user_s_id" || idx.GeneratedColumn != tt.generated || idx.Expression != tt.expr {
+				t.Errorf("index = %+v", idx)
+			}
+		})
+	}
+}
+
+func TestJSONIndexManagerEnsure(t *testing.T) {
+	db := openTestDB(t)
+	m := NewJSONIndexManager(db)
+	if err := m.Ensure("lark_data", Path()); err == nil {
+		t.Error("indexing the document root should fail")
+	}
+	// 重复执行时跳过已存在的索引
+	for range 2 {
+		if err := m.Ensure("lark_data", HotLarkApprovalPaths...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var records []*JSONIndexM
+	if err := db.Order("json_path").Find(&records).Error; err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, r := range records {
+		paths = append(paths, r.Path)
+		if r.Table != "approval" || r.Column != "lark_data" || r.Dialect != DialectSQLite {
+			t.Errorf("record = %+v", r)
+		}
+	}
+	if !slices.Equal(paths, []string{"$.approval_name", "$.status", "$.user_id"}) {
+		t.Errorf("records = %v", paths)
+	}
+	for _, name := range []string{"idx_approval_lark_data_approval_name", "idx_approval_lark_data_status", "idx_approval_lark_data_user_id"} {
+		if !db.Migrator().HasIndex("approval", name) {
+			t.Errorf("index %s not created", name)
+		}
+	}
+
+	// 新的管理器从 json_index 表加载
+	loaded := NewJSONIndexManager(db)
+	if _, ok := loaded.Lookup("lark_data", Path("status")); ok {
+		t.Error("lookup before Load")
+	}
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	idx, ok := loaded.Lookup("lark_data", Path("status"))
+	if !ok || idx.Expression != "json_extract(`lark_data`, '$.status')" {
+		t.Errorf("loaded index = %+v, %v", idx, ok)
+	}
+	if len(loaded.Indexes()) != 3 {
+		t.Errorf("indexes = %d", len(loaded.Indexes()))
+	}
+	if _, ok := loaded.Lookup("dingtalk_data", Path("status")); ok {
+		t.Error("lookup of another column")
+	}
+	var none *JSONIndexManager
+	if _, ok := none.Lookup("lark_data", Path("status")); ok {
+		t.Error("lookup on nil manager")
+	}
+}
+
+func TestJSONQueryHelperUsesIndexes(t *testing.T) {
+	h := seedDialectApprovals(t)
+	plain := NewJSONQueryHelper(h.DB)
+	m := NewJSONIndexManager(h.DB)
+	if err := m.Ensure("lark_data", Path("approval_name"), Path("status")); err != nil {
+		t.Fatal(err)
+	}
+	h.Indexes = m
+
+	tests := []struct {
+		name         string
+		indexed, raw any
+		sql          string
+		want         []string
+	}{
+		{"StatusIs", h.StatusIs(ApprovalStatusApproved), plain.StatusIs(ApprovalStatusApproved),
+			"json_extract(`lark_data`, '$.status') = \"APPROVED\"", []string{"i2"}},
+		{"ApprovalNameIs", h.ApprovalNameIs("请假"), plain.ApprovalNameIs("请假"),
+			"json_extract(`lark_data`, '$.approval_name') = \"请假\"", []string{"i3"}},
+		{"ApprovalNameLike", h.ApprovalNameLike("%报销"), plain.ApprovalNameLike("%报销"),
+			"json_extract(`lark_data`, '$.approval_name') LIKE \"%报销\"", []string{"i1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 使用建索引时的表达式原文，而不是以绑定参数传入路径
+			sql := querySQL(h.DB, tt.indexed)
+			if !strings.Contains(sql, tt.sql) {
+				t.Errorf("sql = %s, want %s", sql, tt.sql)
+			}
+			if strings.Contains(querySQL(h.DB, tt.raw), tt.sql) {
+				t.Errorf("query without indexes uses the index expression")
+			}
+			if got := instanceIDs(t, h.DB, tt.indexed); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if plan := queryPlan(t, h.DB, querySQL(h.DB, h.StatusIs(ApprovalStatusApproved))); !strings.Contains(plan, "idx_approval_lark_data_status") {
+		t.Errorf("plan = %s", plan)
+	}
+
+	// 未建索引的路径不受影响
+	sql := querySQL(h.DB, h.Filter(ApprovalFilter{UserID: "u1"}))
+	if strings.Contains(sql, "json_extract(`lark_data`, '$.user_id')") {
+		t.Errorf("sql = %s", sql)
+	}
+}
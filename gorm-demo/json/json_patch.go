@@ -0,0 +1,634 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm/clause"
+)
+
+// JSON Patch 操作类型，见 RFC 6902
+const (
+	PatchOpAdd     = "add"
+	PatchOpRemove  = "remove"
+	PatchOpReplace = "replace"
+	PatchOpMove    = "move"
+	PatchOpCopy    = "copy"
+	PatchOpTest    = "test"
+)
+
+// ErrPatchTestFailed test 操作比较的值不相等
+var ErrPatchTestFailed = errors.New("json patch test failed")
+
+// JSONPatchOperation 一条 RFC 6902 操作，Path/From 为 RFC 6901 JSON Pointer，如 /task_list/0/status
+type JSONPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatch RFC 6902 JSON Patch 文档，操作按顺序执行，任意一条失败则整体失败
+type JSONPatch []JSONPatchOperation
+
+// JSONPatchError 第 Index 条操作执行失败
+type JSONPatchError struct {
+	Index int
+	Op    string
+	Path  string
+	Err   error
+}
+
+func (e *JSONPatchError) Error() string {
+	return fmt.Sprintf("json patch operation %d (%s %s): %v", e.Index, e.Op, e.Path, e.Err)
+}
+
+func (e *JSONPatchError) Unwrap() error { return e.Err }
+
+// ParseJSONPatch 解析 JSON Patch 文档，如 webhook 推送的差异
+func ParseJSONPatch(data []byte) (JSONPatch, error) {
+	var patch JSONPatch
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return nil, fmt.Errorf("decode json patch: %w", err)
+	}
+	return patch, nil
+}
+
+// Apply 将 patch 应用于 doc 并返回新文档，doc 为空或 null 时视为空对象
+func (p JSONPatch) Apply(doc []byte) ([]byte, error) {
+	root, err := decodeJSONDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range p {
+		if root, err = op.apply(root); err != nil {
+			return nil, &JSONPatchError{Index: i, Op: op.Op, Path: op.Path, Err: err}
+		}
+	}
+	return json.Marshal(root)
+}
+
+func (op JSONPatchOperation) apply(root any) (any, error) {
+	path, err := parseJSONPointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case PatchOpAdd, PatchOpReplace, PatchOpTest:
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case PatchOpAdd:
+			return pointerAdd(root, path, value)
+		case PatchOpReplace:
+			return pointerReplace(root, path, value)
+		default:
+			current, err := pointerGet(root, path)
+			if err != nil {
+				return nil, err
+			}
+			if !jsonValueEqual(current, value) {
+				return nil, ErrPatchTestFailed
+			}
+			return root, nil
+		}
+	case PatchOpRemove:
+		return pointerRemove(root, path)
+	case PatchOpMove, PatchOpCopy:
+		from, err := parseJSONPointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := pointerGet(root, from)
+		if err != nil {
+			return nil, fmt.Errorf("from %s: %w", op.From, err)
+		}
+		if op.Op == PatchOpCopy {
+			// 深拷贝，避免两处共享同一个 map/slice
+			if value, err = cloneJSONValue(value); err != nil {
+				return nil, err
+			}
+			return pointerAdd(root, path, value)
+		}
+		if op.From == op.Path {
+			return root, nil
+		}
+		if isPointerPrefix(from, path) {
+			return nil, fmt.Errorf("cannot move %s into its own child", op.From)
+		}
+		if root, err = pointerRemove(root, from); err != nil {
+			return nil, err
+		}
+		return pointerAdd(root, path, value)
+	default:
+		return nil, fmt.Errorf("unsupported op %q", op.Op)
+	}
+}
+
+// value 解码 Value，add/replace/test 必须提供 value（可以为 null）
+func (op JSONPatchOperation) value() (any, error) {
+	if len(op.Value) == 0 {
+		return nil, errors.New("missing value")
+	}
+	return decodeJSONValue(op.Value)
+}
+
+// MergePatch 按 RFC 7396 将 patch 合并到 doc 并返回新文档，doc 为空或 null 时视为空对象
+//
+// patch 中值为 null 的键会被删除，对象递归合并，其他值（包括数组）整体替换。
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	target, err := decodeJSONDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decodeJSONValue(patch)
+	if err != nil {
+		return nil, fmt.Errorf("decode merge patch: %w", err)
+	}
+	return json.Marshal(mergePatchValue(target, p))
+}
+
+func mergePatchValue(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatchValue(t[k], v)
+		}
+	}
+	return t
+}
+
+// patchSQL JSON Patch 转换为 SQL 后的结果
+type patchSQL struct {
+	Expr       clause.Expression   // 修改后的文档
+	Conditions []clause.Expression // 与原文档对应的前置条件，任意一个不满足时不更新
+}
+
+// translateJSONPatch 尝试将 patch 转换为单条 UPDATE 使用的表达式，无法等价转换时返回 false
+//
+// 仅支持以下情况，其余（move/copy/test、数组下标等）由调用方回退到读取-修改-写入：
+//   - add：路径全部为对象键（JSON_SET），或以 /- 结尾追加到数组末尾（JSON_ARRAY_APPEND）
+//   - replace：路径全部为对象键，且路径已存在（JSON_SET）
+//   - remove：路径全部为对象键，且路径已存在（JSON_REMOVE）
+//
+// JSON Pointer 中的纯数字段既可能是数组下标也可能是对象键，无法在写入前确定，因此不转换。
+// 前置条件是针对原文档判断的，为保证与顺序执行等价，各操作的路径之间不能存在前缀关系
+// （追加到同一个数组的多个 add 除外）。
+func translateJSONPatch(d JSONDialect, column string, patch JSONPatch) (patchSQL, bool) {
+	var (
+		sets       []JSONAssignment
+		removes    []JSONPath
+		appends    []JSONAssignment
+		conditions []clause.Expression
+		targets    [][]string
+		appendTo   = map[string]bool{}
+	)
+	for _, op := range patch {
+		tokens, err := parseJSONPointer(op.Path)
+		if err != nil || len(tokens) == 0 {
+			return patchSQL{}, false
+		}
+		appending := op.Op == PatchOpAdd && tokens[len(tokens)-1] == "-"
+		if appending {
+			tokens = tokens[:len(tokens)-1]
+			if len(tokens) == 0 {
+				return patchSQL{}, false
+			}
+		}
+		for _, t := range tokens {
+			if isPointerIndex(t) || t == "-" {
+				return patchSQL{}, false
+			}
+		}
+		path := Path(tokens...)
+		if path.Err() != nil {
+			return patchSQL{}, false
+		}
+
+		// 检查与之前操作的路径是否存在前缀关系
+		key := op.Path
+		if appending {
+			key = strings.TrimSuffix(op.Path, "/-")
+		}
+		for _, prev := range targets {
+			if isPointerPrefix(prev, tokens) || isPointerPrefix(tokens, prev) {
+				if !(appending && appendTo[key] && len(prev) == len(tokens)) {
+					return patchSQL{}, false
+				}
+			}
+		}
+		targets = append(targets, tokens)
+
+		switch op.Op {
+		case PatchOpAdd:
+			if len(op.Value) == 0 {
+				return patchSQL{}, false
+			}
+			assignment := JSONAssignment{Path: path, Value: op.Value}
+			if appending {
+				if !appendTo[key] {
+					conditions = append(conditions, d.PathHasType(column, path, "array"))
+					appendTo[key] = true
+				}
+				appends = append(appends, assignment)
+				continue
+			}
+			if len(tokens) > 1 {
+				conditions = append(conditions, d.PathHasType(column, Path(tokens[:len(tokens)-1]...), "object"))
+			}
+			sets = append(sets, assignment)
+		case PatchOpReplace:
+			if len(op.Value) == 0 {
+				return patchSQL{}, false
+			}
+			conditions = append(conditions, d.PathExists(column, path))
+			sets = append(sets, JSONAssignment{Path: path, Value: op.Value})
+		case PatchOpRemove:
+			conditions = append(conditions, d.PathExists(column, path))
+			removes = append(removes, path)
+		default:
+			return patchSQL{}, false
+		}
+	}
+
+	expr := d.Document(column)
+	if len(removes) > 0 {
+		expr = d.Remove(expr, removes)
+	}
+	if len(sets) > 0 {
+		expr = d.Set(expr, sets)
+	}
+	for _, a := range appends {
+		expr = d.ArrayAppend(expr, a.Path, a.Value)
+	}
+	return patchSQL{Expr: expr, Conditions: conditions}, true
+}
+
+// parseJSONPointer 按 RFC 6901 解析 JSON Pointer，"" 表示整个文档
+func parseJSONPointer(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("json pointer %q must start with /", s)
+	}
+	tokens := strings.Split(s[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// isPointerPrefix prefix 等于 path 或是 path 的祖先
+func isPointerPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isPointerIndex token 是否为合法的数组下标（不允许前导 0）
+func isPointerIndex(token string) bool {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return false
+	}
+	for _, c := range token {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// pointerArrayIndex 解析数组下标，allowEnd 时允许 "-" 和等于长度的下标（表示末尾之后）
+func pointerArrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+	if !isPointerIndex(token) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if i > length || (i == length && !allowEnd) {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+// pointerGet 取出 path 指向的值，路径不存在时返回错误
+func pointerGet(node any, path []string) (any, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]any:
+			child, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("key %q not found", token)
+			}
+			node = child
+		case []any:
+			i, err := pointerArrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("cannot reference %q in %s", token, jsonTypeName(node))
+		}
+	}
+	return node, nil
+}
+
+// pointerUpdate 找到 path 的父容器并调用 fn 修改，返回修改后的根节点
+//
+// 数组插入/删除会生成新的切片，因此沿路径逐层写回。
+func pointerUpdate(node any, path []string, fn func(container any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(node, path[0])
+	}
+	token := path[0]
+	switch n := node.(type) {
+	case map[string]any:
+		child, ok := n[token]
+		if !ok {
+			return nil, fmt.Errorf("key %q not found", token)
+		}
+		updated, err := pointerUpdate(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = updated
+		return n, nil
+	case []any:
+		i, err := pointerArrayIndex(token, len(n), false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := pointerUpdate(n[i], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = updated
+		return n, nil
+	default:
+		return nil, fmt.Errorf("cannot reference %q in %s", token, jsonTypeName(node))
+	}
+}
+
+func pointerAdd(root any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return pointerUpdate(root, path, func(container any, token string) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			c[token] = value
+			return c, nil
+		case []any:
+			i, err := pointerArrayIndex(token, len(c), true)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		default:
+			return nil, fmt.Errorf("cannot add %q to %s", token, jsonTypeName(container))
+		}
+	})
+}
+
+func pointerRemove(root any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+	return pointerUpdate(root, path, func(container any, token string) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			if _, ok := c[token]; !ok {
+				return nil, fmt.Errorf("key %q not found", token)
+			}
+			delete(c, token)
+			return c, nil
+		case []any:
+			i, err := pointerArrayIndex(token, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], c[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("cannot remove %q from %s", token, jsonTypeName(container))
+		}
+	})
+}
+
+func pointerReplace(root any, path []string, value any) (any, error) {
+	if _, err := pointerGet(root, path); err != nil {
+		return nil, err
+	}
+	if len(path) == 0 {
+		return value, nil
+	}
+	return pointerUpdate(root, path, func(container any, token string) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			c[token] = value
+			return c, nil
+		case []any:
+			i, _ := pointerArrayIndex(token, len(c), false)
+			c[i] = value
+			return c, nil
+		default:
+			return nil, fmt.Errorf("cannot replace %q in %s", token, jsonTypeName(container))
+		}
+	})
+}
+
+// decodeJSONDocument 解码整个文档，空文档和 null 视为空对象，与 JSONDialect.Document 一致
+func decodeJSONDocument(doc []byte) (any, error) {
+	if len(bytes.TrimSpace(doc)) == 0 {
+		return map[string]any{}, nil
+	}
+	v, err := decodeJSONValue(doc)
+	if err != nil {
+		return nil, fmt.Errorf("decode json document: %w", err)
+	}
+	if v == nil {
+		return map[string]any{}, nil
+	}
+	return v, nil
+}
+
+// decodeJSONValue 解码时保留数字原文，避免大整数丢失精度
+func decodeJSONValue(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func cloneJSONValue(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSONValue(data)
+}
+
+// jsonValueEqual 按 RFC 6902 test 的语义比较两个值，数字按数值比较
+func jsonValueEqual(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		bm, ok := b.(map[string]any)
+		if !ok || len(a) != len(bm) {
+			return false
+		}
+		for k, v := range a {
+			bv, ok := bm[k]
+			if !ok || !jsonValueEqual(v, bv) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		ba, ok := b.([]any)
+		if !ok || len(a) != len(ba) {
+			return false
+		}
+		for i := range a {
+			if !jsonValueEqual(a[i], ba[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		bn, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if a == bn {
+			return true
+		}
+		af, errA := a.Float64()
+		bf, errB := bn.Float64()
+		return errA == nil && errB == nil && af == bf
+	default:
+		return a == b
+	}
+}
+
+// DiffJSON 生成将 from 变为 to 的 JSON Patch，from/to 为空或 null 时视为空对象
+//
+// 对象按键递归比较，数组按下标逐个比较并在末尾增删元素，其余值不相等时整体替换。
+func DiffJSON(from, to []byte) (JSONPatch, error) {
+	a, err := decodeJSONDocument(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := decodeJSONDocument(to)
+	if err != nil {
+		return nil, err
+	}
+	var patch JSONPatch
+	if err := diffJSONValue("", a, b, &patch); err != nil {
+		return nil, err
+	}
+	return patch, nil
+}
+
+func diffJSONValue(pointer string, from, to any, patch *JSONPatch) error {
+	switch f := from.(type) {
+	case map[string]any:
+		t, ok := to.(map[string]any)
+		if !ok {
+			break
+		}
+		// 按键排序，保证生成的 patch 稳定
+		for _, k := range sortedKeys(f) {
+			if _, ok := t[k]; !ok {
+				*patch = append(*patch, JSONPatchOperation{Op: PatchOpRemove, Path: pointer + "/" + escapeJSONPointer(k)})
+			}
+		}
+		for _, k := range sortedKeys(t) {
+			child := pointer + "/" + escapeJSONPointer(k)
+			if fv, ok := f[k]; ok {
+				if err := diffJSONValue(child, fv, t[k], patch); err != nil {
+					return err
+				}
+			} else if err := appendPatchValue(patch, PatchOpAdd, child, t[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []any:
+		t, ok := to.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < len(f) && i < len(t); i++ {
+			if err := diffJSONValue(pointer+"/"+strconv.Itoa(i), f[i], t[i], patch); err != nil {
+				return err
+			}
+		}
+		// 从后往前删除，避免下标变化
+		for i := len(f) - 1; i >= len(t); i-- {
+			*patch = append(*patch, JSONPatchOperation{Op: PatchOpRemove, Path: pointer + "/" + strconv.Itoa(i)})
+		}
+		for i := len(f); i < len(t); i++ {
+			if err := appendPatchValue(patch, PatchOpAdd, pointer+"/"+strconv.Itoa(i), t[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if jsonValueEqual(from, to) {
+		return nil
+	}
+	return appendPatchValue(patch, PatchOpReplace, pointer, to)
+}
+
+func appendPatchValue(patch *JSONPatch, op, pointer string, value any) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	*patch = append(*patch, JSONPatchOperation{Op: op, Path: pointer, Value: raw})
+	return nil
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
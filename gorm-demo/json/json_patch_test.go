@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// sameJSON 按 JSON 语义比较两个文档，忽略键的顺序
+func sameJSON(t *testing.T, got, want []byte) bool {
+	t.Helper()
+	a, err := decodeJSONValue(got)
+	if err != nil {
+		t.Fatalf("decode %s: %v", got, err)
+	}
+	b, err := decodeJSONValue(want)
+	if err != nil {
+		t.Fatalf("decode %s: %v", want, err)
+	}
+	return jsonValueEqual(a, b)
+}
+
+func TestJSONPatchApply(t *testing.T) {
+	const doc = `{"a":{"b":1},"arr":[1,2,3],"x/y":"slash","m~n":"tilde"}`
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{"add key", `[{"op":"add","path":"/a/c","value":2}]`, `{"a":{"b":1,"c":2},"arr":[1,2,3],"x/y":"slash","m~n":"tilde"}`},
+		{"add replaces existing key", `[{"op":"add","path":"/a/b","value":null}]`, `{"a":{"b":null},"arr":[1,2,3],"x/y":"slash","m~n":"tilde"}`},
+		{"add inserts into array", `[{"op":"add","path":"/arr/1","value":9}]`, `{"a":{"b":1},"arr":[1,9,2,3],"x/y":"slash","m~n":"tilde"}`},
+		{"add at array length", `[{"op":"add","path":"/arr/3","value":9}]`, `{"a":{"b":1},"arr":[1,2,3,9],"x/y":"slash","m~n":"tilde"}`},
+		{"add dash appends", `[{"op":"add","path":"/arr/-","value":4},{"op":"add","path":"/arr/-","value":5}]`, `{"a":{"b":1},"arr":[1,2,3,4,5],"x/y":"slash","m~n":"tilde"}`},
+		{"add whole document", `[{"op":"add","path":"","value":{"z":1}}]`, `{"z":1}`},
+		{"remove key", `[{"op":"remove","path":"/a/b"}]`, `{"a":{},"arr":[1,2,3],"x/y":"slash","m~n":"tilde"}`},
+		{"remove array element", `[{"op":"remove","path":"/arr/0"}]`, `{"a":{"b":1},"arr":[2,3],"x/y":"slash","m~n":"tilde"}`},
+		{"replace", `[{"op":"replace","path":"/arr/2","value":"c"}]`, `{"a":{"b":1},"arr":[1,2,"c"],"x/y":"slash","m~n":"tilde"}`},
+		{"escaped ~1", `[{"op":"replace","path":"/x~1y","value":"s"}]`, `{"a":{"b":1},"arr":[1,2,3],"x/y":"s","m~n":"tilde"}`},
+		{"escaped ~0", `[{"op":"remove","path":"/m~0n"}]`, `{"a":{"b":1},"arr":[1,2,3],"x/y":"slash"}`},
+		{"move", `[{"op":"move","from":"/a/b","path":"/arr/0"}]`, `{"a":{},"arr":[1,1,2,3],"x/y":"slash","m~n":"tilde"}`},
+		{"move to itself", `[{"op":"move","from":"/a","path":"/a"}]`, doc},
+		{"copy is deep", `[{"op":"copy","from":"/a","path":"/c"},{"op":"add","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2},"arr":[1,2,3],"x/y":"slash","m~n":"tilde"}`},
+		{"test passes", `[{"op":"test","path":"/a","value":{"b":1.0}},{"op":"test","path":"/arr","value":[1,2,3]}]`, doc},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := ParseJSONPatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := patch.Apply([]byte(doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameJSON(t, got, []byte(tt.want)) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONPatchApplyErrors(t *testing.T) {
+	const doc = `{"a":{"b":1},"arr":[1,2,3]}`
+	tests := []struct {
+		name  string
+		patch string
+		index int
+	}{
+		{"index out of range", `[{"op":"add","path":"/arr/4","value":0}]`, 0},
+		{"replace past end", `[{"op":"replace","path":"/arr/3","value":0}]`, 0},
+		{"leading zero", `[{"op":"remove","path":"/arr/01"}]`, 0},
+		{"dash outside add", `[{"op":"remove","path":"/arr/-"}]`, 0},
+		{"missing parent", `[{"op":"add","path":"/x/y","value":0}]`, 0},
+		{"remove missing key", `[{"op":"add","path":"/c","value":0},{"op":"remove","path":"/d"}]`, 1},
+		{"replace missing key", `[{"op":"replace","path":"/c","value":0}]`, 0},
+		{"missing value", `[{"op":"add","path":"/c"}]`, 0},
+		{"move into own child", `[{"op":"move","from":"/a","path":"/a/b/c"}]`, 0},
+		{"move missing from", `[{"op":"move","from":"/x","path":"/y"}]`, 0},
+		{"key into scalar", `[{"op":"add","path":"/a/b/c","value":0}]`, 0},
+		{"pointer without slash", `[{"op":"remove","path":"a"}]`, 0},
+		{"unknown op", `[{"op":"merge","path":"/a"}]`, 0},
+		{"remove document", `[{"op":"remove","path":""}]`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := ParseJSONPatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = patch.Apply([]byte(doc))
+			var perr *JSONPatchError
+			if !errors.As(err, &perr) || perr.Index != tt.index {
+				t.Errorf("err = %v, want JSONPatchError at %d", err, tt.index)
+			}
+		})
+	}
+
+	// test 失败
+	_, err := JSONPatch{{Op: PatchOpTest, Path: "/a/b", Value: []byte(`"1"`)}}.Apply([]byte(doc))
+	if !errors.Is(err, ErrPatchTestFailed) {
+		t.Errorf("err = %v, want ErrPatchTestFailed", err)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name, doc, patch, want string
+	}{
+		{"null deletes key", `{"a":1,"b":{"c":2,"d":3}}`, `{"a":null,"b":{"c":null}}`, `{"b":{"d":3}}`},
+		{"nested merge", `{"b":{"c":2}}`, `{"b":{"e":{"f":1}}}`, `{"b":{"c":2,"e":{"f":1}}}`},
+		{"array replaced", `{"arr":[1,2]}`, `{"arr":[3]}`, `{"arr":[3]}`},
+		{"scalar replaced by object", `{"a":1}`, `{"a":{"b":null,"c":1}}`, `{"a":{"c":1}}`},
+		{"empty document", ``, `{"a":1}`, `{"a":1}`},
+		{"non-object patch replaces document", `{"a":1}`, `[1]`, `[1]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameJSON(t, got, []byte(tt.want)) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	from := []byte(`{"a":1,"b":{"c":[1,2,3]},"x/y":true,"n":10}`)
+	to := []byte(`{"b":{"c":[1,5]},"x/y":false,"n":10.0,"d":[{"e":null}]}`)
+	diff, err := DiffJSON(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"op":"remove","path":"/a"},{"op":"replace","path":"/b/c/1","value":5},{"op":"remove","path":"/b/c/2"},` +
+		`{"op":"add","path":"/d","value":[{"e":null}]},{"op":"replace","path":"/x~1y","value":false}]`
+	if got, _ := json.Marshal(diff); !sameJSON(t, got, []byte(want)) {
+		t.Errorf("diff = %s, want %s", got, want)
+	}
+
+	// 正向和反向的差异应用后互相还原
+	forward, err := diff.Apply(from)
+	if err != nil || !sameJSON(t, forward, to) {
+		t.Errorf("apply diff = %s, %v", forward, err)
+	}
+	revert, err := DiffJSON(to, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := revert.Apply(forward)
+	if err != nil || !sameJSON(t, back, from) {
+		t.Errorf("apply revert = %s, %v", back, err)
+	}
+	if same, err := DiffJSON(from, from); err != nil || len(same) != 0 {
+		t.Errorf("diff of equal documents = %v, %v", same, err)
+	}
+}
+
+func TestTranslateJSONPatch(t *testing.T) {
+	d := sqliteJSONDialect{}
+	tests := []struct {
+		name  string
+		patch JSONPatch
+		ok    bool
+	}{
+		{"set, replace and remove keys", JSONPatch{
+			{Op: PatchOpAdd, Path: "/a/b", Value: []byte(`1`)},
+			{Op: PatchOpReplace, Path: "/c", Value: []byte(`2`)},
+			{Op: PatchOpRemove, Path: "/d"},
+		}, true},
+		{"append twice to one array", JSONPatch{
+			{Op: PatchOpAdd, Path: "/arr/-", Value: []byte(`1`)},
+			{Op: PatchOpAdd, Path: "/arr/-", Value: []byte(`2`)},
+		}, true},
+		{"array index", JSONPatch{{Op: PatchOpReplace, Path: "/arr/0", Value: []byte(`1`)}}, false},
+		{"numeric key", JSONPatch{{Op: PatchOpAdd, Path: "/a/0", Value: []byte(`1`)}}, false},
+		{"nested paths", JSONPatch{
+			{Op: PatchOpAdd, Path: "/a", Value: []byte(`{}`)},
+			{Op: PatchOpAdd, Path: "/a/b", Value: []byte(`1`)},
+		}, false},
+		{"whole document", JSONPatch{{Op: PatchOpAdd, Path: "", Value: []byte(`{}`)}}, false},
+		{"move", JSONPatch{{Op: PatchOpMove, From: "/a", Path: "/b"}}, false},
+		{"copy", JSONPatch{{Op: PatchOpCopy, From: "/a", Path: "/b"}}, false},
+		{"test", JSONPatch{{Op: PatchOpTest, Path: "/a", Value: []byte(`1`)}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := translateJSONPatch(d, "lark_data", tt.patch); ok != tt.ok {
+				t.Errorf("translated = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JSONPath 类型安全的 JSON 路径，如 Path("task_list").Index(0).Field("user_id") 即 $.task_list[0].user_id
+//
+// 路径在构建时校验每一段，渲染 SQL 时整体作为绑定参数传入，不会拼接进 SQL 字符串。
+// 构建过程中的第一个错误会被保留，并在生成 SQL 时通过 AddError 报告给 GORM。
+type JSONPath struct {
+	segments []pathSegment
+	err      error
+}
+
+// pathSegment 路径中的一段，对象键或数组下标
+type pathSegment struct {
+	key   string
+	index int
+	isKey bool
+}
+
+// Path 从文档根开始构建路径，fields 依次作为对象键
+func Path(fields ...string) JSONPath {
+	var p JSONPath
+	for _, f := range fields {
+		p = p.Field(f)
+	}
+	return p
+}
+
+// ParseJSONPath 解析 MySQL/SQLite 风格的路径字符串，如 $.task_list[0]."user id"
+func ParseJSONPath(s string) (JSONPath, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "$") {
+		return JSONPath{}, fmt.Errorf("json path %q must start with $", s)
+	}
+	p := JSONPath{}
+	rest := s[1:]
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			var key string
+			if strings.HasPrefix(rest, `"`) {
+				end := strings.IndexByte(rest[1:], '"')
+				if end < 0 {
+					return JSONPath{}, fmt.Errorf("json path %q has unterminated quoted key", s)
+				}
+				key, rest = rest[1:end+1], rest[end+2:]
+			} else {
+				end := strings.IndexAny(rest, ".[")
+				if end < 0 {
+					end = len(rest)
+				}
+				key, rest = rest[:end], rest[end:]
+			}
+			p = p.Field(key)
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return JSONPath{}, fmt.Errorf("json path %q has unterminated index", s)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return JSONPath{}, fmt.Errorf("json path %q has invalid index %q", s, rest[1:end])
+			}
+			p, rest = p.Index(i), rest[end+1:]
+		default:
+			return JSONPath{}, fmt.Errorf("json path %q has unexpected character %q", s, rest[0])
+		}
+		if p.err != nil {
+			return JSONPath{}, p.err
+		}
+	}
+	return p, nil
+}
+
+// MustParseJSONPath 同 ParseJSONPath，解析失败时 panic，用于常量路径
+func MustParseJSONPath(s string) JSONPath {
+	p, err := ParseJSONPath(s)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Field 追加对象键
+func (p JSONPath) Field(name string) JSONPath {
+	if p.err != nil {
+		return p
+	}
+	if err := validatePathKey(name); err != nil {
+		p.err = err
+		return p
+	}
+	return p.append(pathSegment{key: name, isKey: true})
+}
+
+// Index 追加数组下标
+func (p JSONPath) Index(i int) JSONPath {
+	if p.err != nil {
+		return p
+	}
+	if i < 0 {
+		p.err = fmt.Errorf("json path index %d must not be negative", i)
+		return p
+	}
+	return p.append(pathSegment{index: i})
+}
+
+// append 复制后追加，保证派生路径互不影响
+func (p JSONPath) append(seg pathSegment) JSONPath {
+	segments := make([]pathSegment, len(p.segments), len(p.segments)+1)
+	copy(segments, p.segments)
+	return JSONPath{segments: append(segments, seg)}
+}
+
+// Err 返回构建路径时遇到的第一个错误
+func (p JSONPath) Err() error {
+	return p.err
+}
+
+// String 渲染为 MySQL/SQLite 风格的路径，包含特殊字符的键会加双引号
+func (p JSONPath) String() string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, seg := range p.segments {
+		if !seg.isKey {
+			b.WriteString("[" + strconv.Itoa(seg.index) + "]")
+			continue
+		}
+		b.WriteString(".")
+		if isPlainPathKey(seg.key) {
+			b.WriteString(seg.key)
+		} else {
+			b.WriteString(`"` + seg.key + `"`)
+		}
+	}
+	return b.String()
+}
+
+// Segments 以字符串形式返回每一段，供 PostgreSQL jsonb_extract_path 等按段取值的函数使用
+func (p JSONPath) Segments() []string {
+	segments := make([]string, 0, len(p.segments))
+	for _, seg := range p.segments {
+		if seg.isKey {
+			segments = append(segments, seg.key)
+		} else {
+			segments = append(segments, strconv.Itoa(seg.index))
+		}
+	}
+	return segments
+}
+
+// In 指定路径所在的 JSON 列，得到可用于 Where/Order/Select 的表达式
+func (p JSONPath) In(column string) JSONExpr {
+	return JSONExpr{Column: column, Path: p}
+}
+
+// validatePathKey 校验对象键：非空、不含双引号、反斜杠和控制字符
+//
+// 各数据库对引号键内转义的支持并不一致，直接拒绝这些字符最稳妥。
+func validatePathKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("json path key must not be empty")
+	}
+	for _, r := range key {
+		if r == '"' || r == '\\' || unicode.IsControl(r) {
+			return fmt.Errorf("json path key %q contains invalid character %q", key, r)
+		}
+	}
+	return nil
+}
+
+// isPlainPathKey 键是否可以不加引号直接写在路径中
+func isPlainPathKey(key string) bool {
+	for i, r := range key {
+		if r == '_' || r == '$' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r)) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// JSONExpr JSON 列中某个路径对应的值
+//
+// 实现了 clause.Expression，按当前连接的方言渲染，可直接用于 Where/Order/Select：
+//
+//	db.Where(Path("status").In("lark_data").Eq("PENDING"))
+//	db.Order(Path("start_time").In("lark_data").Desc())
+//	db.Select("id, ?", Path("approval_name").In("lark_data").As("approval_name"))
+type JSONExpr struct {
+	Column string
+	Path   JSONPath
+}
+
+// Build 实现 clause.Expression，渲染为去除引号的标量值
+func (e JSONExpr) Build(builder clause.Builder) {
+	e.expr(func(d JSONDialect) clause.Expression { return d.Extract(e.Column, e.Path) }).Build(builder)
+}
+
+// Eq 值等于 value
+func (e JSONExpr) Eq(value any) clause.Expression {
+	return e.expr(func(d JSONDialect) clause.Expression { return d.PathEquals(e.Column, e.Path, value) })
+}
+
+// Like 值模糊匹配 pattern
+func (e JSONExpr) Like(pattern string) clause.Expression {
+	return e.expr(func(d JSONDialect) clause.Expression { return d.PathLike(e.Column, e.Path, pattern) })
+}
+
+// Exists 路径在文档中存在
+func (e JSONExpr) Exists() clause.Expression {
+	return e.expr(func(d JSONDialect) clause.Expression { return d.PathExists(e.Column, e.Path) })
+}
+
+// ContainsObject 路径对应的数组中存在 key 等于 value 的对象
+func (e JSONExpr) ContainsObject(key string, value any) clause.Expression {
+	if err := validatePathKey(key); err != nil {
+		return jsonExprFunc{err: err}
+	}
+	return e.expr(func(d JSONDialect) clause.Expression { return d.ArrayContainsObject(e.Column, e.Path, key, value) })
+}
+
+// As 用于 Select，为提取出的值指定别名
+func (e JSONExpr) As(alias string) clause.Expression {
+	return clause.Expr{SQL: "? AS ?", Vars: []any{e, clause.Column{Name: alias}}}
+}
+
+// Asc 升序排序
+func (e JSONExpr) Asc() clause.OrderBy {
+	return clause.OrderBy{Expression: clause.Expr{SQL: "? ASC", Vars: []any{e}}}
+}
+
+// Desc 降序排序
+func (e JSONExpr) Desc() clause.OrderBy {
+	return clause.OrderBy{Expression: clause.Expr{SQL: "? DESC", Vars: []any{e}}}
+}
+
+func (e JSONExpr) expr(fn func(JSONDialect) clause.Expression) clause.Expression {
+	return jsonExprFunc{err: e.Path.Err(), fn: fn}
+}
+
+// jsonExprFunc 延迟到 Build 时再根据语句所属连接选择方言
+type jsonExprFunc struct {
+	err error
+	fn  func(JSONDialect) clause.Expression
+}
+
+func (f jsonExprFunc) Build(builder clause.Builder) {
+	if f.err != nil {
+		_ = builder.AddError(f.err)
+		return
+	}
+	f.fn(dialectOfBuilder(builder)).Build(builder)
+}
+
+// dialectOfBuilder 从 clause.Builder 中取出所属连接的方言
+func dialectOfBuilder(builder clause.Builder) JSONDialect {
+	if stmt, ok := builder.(*gorm.Statement); ok {
+		return JSONDialectOf(stmt.DB)
+	}
+	return mysqlJSONDialect{}
+}
+
+// lookupJSONPath 在解码后的文档中按路径取值，路径不存在时返回 nil
+func lookupJSONPath(doc any, p JSONPath) any {
+	node := doc
+	for _, seg := range p.segments {
+		switch v := node.(type) {
+		case map[string]any:
+			if !seg.isKey {
+				return nil
+			}
+			node = v[seg.key]
+		case []any:
+			if seg.isKey || seg.index < 0 || seg.index >= len(v) {
+				return nil
+			}
+			node = v[seg.index]
+		default:
+			return nil
+		}
+	}
+	return node
+}
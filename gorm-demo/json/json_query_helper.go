@@ -1,38 +1,34 @@
 package main
 
 import (
-	"encoding/json"
-
-	"gorm.io/datatypes"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// ApprovalMWithJSONFields 扩展 ApprovalM，增加 JSON 字段的快捷查询功能
-type ApprovalMWithJSONFields struct {
-	ApprovalM
-
-	// 以下是虚拟字段，用于 JSON 查询，不会存储在数据库中
-	ApprovalName   string `gorm:"-" json:"-"`
-	TaskListID     string `gorm:"-" json:"-"`
-	TaskListUserID string `gorm:"-" json:"-"`
-	Status         string `gorm:"-" json:"-"`
-}
-
 // JSONQueryHelper JSON 查询辅助结构体
 type JSONQueryHelper struct {
-	DB *gorm.DB
+	DB      *gorm.DB
+	Dialect JSONDialect
+	Indexes *JSONIndexManager // 可选，设置后已建索引的路径会改用生成列或索引表达式查询
 }
 
-// NewJSONQueryHelper 创建新的 JSON 查询辅助实例
+// NewJSONQueryHelper 创建新的 JSON 查询辅助实例，根据 db 的 Dialector 自动选择 JSON 方言
 func NewJSONQueryHelper(db *gorm.DB) *JSONQueryHelper {
-	return &JSONQueryHelper{DB: db}
+	return &JSONQueryHelper{DB: db, Dialect: JSONDialectOf(db)}
 }
 
 // FindByApprovalName 根据审批名称查询
 func (h *JSONQueryHelper) FindByApprovalName(name string) ([]*ApprovalM, error) {
 	var approvals []*ApprovalM
-	// MySQL 语法：data->>'$.approval_name' = 'xxx'
-	err := h.DB.Where("lark_data->>'$.approval_name' = ?", name).Find(&approvals).Error
+	// MySQL: JSON_UNQUOTE(JSON_EXTRACT(lark_data, '$.approval_name')) = 'xxx'
+	err := h.DB.Where(h.ApprovalNameIs(name)).Find(&approvals).Error
+	return approvals, err
+}
+
+// FindByApprovalNameLike 根据审批名称模糊查询，pattern 使用 LIKE 语法，如 %zhangsan%
+func (h *JSONQueryHelper) FindByApprovalNameLike(pattern string) ([]*ApprovalM, error) {
+	var approvals []*ApprovalM
+	err := h.DB.Where(h.ApprovalNameLike(pattern)).Find(&approvals).Error
 	return approvals, err
 }
 
@@ -40,7 +36,7 @@ func (h *JSONQueryHelper) FindByApprovalName(name string) ([]*ApprovalM, error)
 func (h *JSONQueryHelper) FindByTaskID(taskID string) ([]*ApprovalM, error) {
 	var approvals []*ApprovalM
 	// 查询 task_list 数组中包含指定 ID 的记录
-	err := h.DB.Where("JSON_CONTAINS(lark_data->'$.task_list', JSON_OBJECT('id', ?))", taskID).Find(&approvals).Error
+	err := h.DB.Where(h.HasTaskID(taskID)).Find(&approvals).Error
 	return approvals, err
 }
 
@@ -48,80 +44,109 @@ func (h *JSONQueryHelper) FindByTaskID(taskID string) ([]*ApprovalM, error) {
 func (h *JSONQueryHelper) FindByUserID(userID string) ([]*ApprovalM, error) {
 	var approvals []*ApprovalM
 	// 查询 task_list 数组中包含指定 user_id 的记录
-	err := h.DB.Where("JSON_CONTAINS(lark_data->'$.task_list', JSON_OBJECT('user_id', ?))", userID).Find(&approvals).Error
+	err := h.DB.Where(h.HasUserID(userID)).Find(&approvals).Error
 	return approvals, err
 }
 
 // FindByStatus 根据审批状态查询
 func (h *JSONQueryHelper) FindByStatus(status string) ([]*ApprovalM, error) {
 	var approvals []*ApprovalM
-	err := h.DB.Where("lark_data->>'$.status' = ?", status).Find(&approvals).Error
+	err := h.DB.Where(h.StatusIs(status)).Find(&approvals).Error
 	return approvals, err
 }
 
-// 以下是结构体标签的高级用法示例
+// FindByPathExists 查询 JSON 文档中存在指定路径的记录，path 形如 $.task_list[0].id
+func (h *JSONQueryHelper) FindByPathExists(path string) ([]*ApprovalM, error) {
+	cond, err := h.PathExists(path)
+	if err != nil {
+		return nil, err
+	}
+	var approvals []*ApprovalM
+	err = h.DB.Where(cond).Find(&approvals).Error
+	return approvals, err
+}
 
-// ApprovalMWithVirtualFields 使用 gorm 钩子自动处理 JSON 字段映射
-type ApprovalMWithVirtualFields struct {
-	ID           uint64         `gorm:"column:id;AUTO_INCREMENT;primary_key"`
-	InstanceID   string         `gorm:"column:instance_id;type:varchar(255);NOT NULL"`
-	ApprovalCode string         `gorm:"column:approval_code;type:varchar(255);NOT NULL"`
-	Type         string         `gorm:"column:type;type:varchar(20);NOT NULL"` // 审批实例类型, 可选值: lark, dingtalk
-	LarkData     datatypes.JSON `gorm:"column:lark_data;type:json;null"`
+// 以下方法只生成查询条件，可以组合后用于 Where、FindPage 和 Stream
 
-	// 虚拟字段，用于 JSON 数据的快速访问
-	ApprovalName string `gorm:"-"`
-	Status       string `gorm:"-"`
-	UserID       string `gorm:"-"`
+// ApprovalCodeIs approval_code 等于 code
+func (h *JSONQueryHelper) ApprovalCodeIs(code string) clause.Expression {
+	return clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: "approval_code"}, Value: code}
 }
 
-// TableName 指定表名
-func (ApprovalMWithVirtualFields) TableName() string {
-	return "approval"
+// ApprovalNameIs 审批名称等于 name
+func (h *JSONQueryHelper) ApprovalNameIs(name string) clause.Expression {
+	return h.pathEquals("lark_data", Path("approval_name"), name)
+}
+
+// ApprovalNameLike 审批名称模糊匹配，pattern 使用 LIKE 语法
+func (h *JSONQueryHelper) ApprovalNameLike(pattern string) clause.Expression {
+	return h.pathLike("lark_data", Path("approval_name"), pattern)
+}
+
+// StatusIs 审批状态等于 status
+func (h *JSONQueryHelper) StatusIs(status string) clause.Expression {
+	return h.pathEquals("lark_data", Path("status"), status)
+}
+
+// HasTaskID task_list 数组中包含指定 ID 的任务
+func (h *JSONQueryHelper) HasTaskID(taskID string) clause.Expression {
+	return h.Dialect.ArrayContainsObject("lark_data", Path("task_list"), "id", taskID)
 }
 
-// AfterFind GORM 钩子，查询后自动从 JSON 中提取数据到虚拟字段
-func (a *ApprovalMWithVirtualFields) AfterFind(tx *gorm.DB) error {
-	// 手动从 JSON 数据中提取字段到虚拟字段
-	var larkApproval LarkApproval
-	if err := json.Unmarshal(a.LarkData, &larkApproval); err == nil {
-		a.ApprovalName = larkApproval.ApprovalName
-		a.Status = larkApproval.Status
-		a.UserID = larkApproval.UserID
+// HasUserID task_list 数组中包含指定 user_id 的任务
+func (h *JSONQueryHelper) HasUserID(userID string) clause.Expression {
+	return h.Dialect.ArrayContainsObject("lark_data", Path("task_list"), "user_id", userID)
+}
+
+// PathExists JSON 文档中存在指定路径，path 形如 $.task_list[0].id
+func (h *JSONQueryHelper) PathExists(path string) (clause.Expression, error) {
+	p, err := ParseJSONPath(path)
+	if err != nil {
+		return nil, err
 	}
-	return nil
-}
-
-// BeforeSave GORM 钩子，保存前自动将虚拟字段更新到 JSON
-func (a *ApprovalMWithVirtualFields) BeforeSave(tx *gorm.DB) error {
-	// 手动将虚拟字段更新到 JSON 数据
-	var larkApproval LarkApproval
-	if err := json.Unmarshal(a.LarkData, &larkApproval); err == nil {
-		larkApproval.ApprovalName = a.ApprovalName
-		larkApproval.Status = a.Status
-		larkApproval.UserID = a.UserID
-
-		updatedData, err := json.Marshal(larkApproval)
-		if err == nil {
-			a.LarkData = updatedData
-		}
+	return h.Dialect.PathExists("lark_data", p), nil
+}
+
+// pathEquals 路径等于 value，路径已建索引时使用索引列
+func (h *JSONQueryHelper) pathEquals(column string, path JSONPath, value any) clause.Expression {
+	if idx, ok := h.Indexes.Lookup(column, path); ok {
+		return clause.Expr{SQL: "? = ?", Vars: []any{idx.Expr(), value}}
 	}
-	return nil
+	return h.Dialect.PathEquals(column, path, value)
 }
 
-// 以下是一些常用的 JSON 查询辅助函数
+// pathLike 路径模糊匹配 pattern，路径已建索引时使用索引列
+func (h *JSONQueryHelper) pathLike(column string, path JSONPath, pattern string) clause.Expression {
+	if idx, ok := h.Indexes.Lookup(column, path); ok {
+		return clause.Expr{SQL: "? LIKE ?", Vars: []any{idx.Expr(), pattern}}
+	}
+	return h.Dialect.PathLike(column, path, pattern)
+}
+
+// 以下是结构体标签的高级用法示例
+
+// ApprovalMWithVirtualFields 通过 jsonpath 标签声明虚拟字段，由 JSONPathPlugin 负责查询后填充、
+// 修改后写回以及在 Where 中使用，需要先 db.Use(NewJSONPathPlugin())
+type ApprovalMWithVirtualFields struct {
+	ID           uint64                   `gorm:"column:id;AUTO_INCREMENT;primary_key"`
+	TenantID     string                   `gorm:"column:tenant_id;type:varchar(64);NOT NULL;default:''"` // 租户ID，FieldEncryptionPlugin 解密时需要
+	InstanceID   string                   `gorm:"column:instance_id;type:varchar(255);NOT NULL"`
+	ApprovalCode string                   `gorm:"column:approval_code;type:varchar(255);NOT NULL"`
+	Type         string                   `gorm:"column:type;type:varchar(20);NOT NULL"` // 审批实例类型, 可选值: lark, dingtalk
+	LarkData     JSONColumn[LarkApproval] `gorm:"column:lark_data;type:json;null"`
 
-// JSONExtract 查询 JSON 字段的辅助函数
-func JSONExtract(jsonColumn, jsonPath string) string {
-	return "JSON_EXTRACT(" + jsonColumn + ", '" + jsonPath + "')"
+	// 虚拟字段，用于 JSON 数据的快速访问
+	ApprovalName string `gorm:"-" jsonpath:"lark_data:$.approval_name"`
+	Status       string `gorm:"-" jsonpath:"lark_data:$.status"`
+	UserID       string `gorm:"-" jsonpath:"lark_data:$.user_id"`
 }
 
-// JSONUnquote 去除 JSON 字符串引号的辅助函数
-func JSONUnquote(jsonColumn, jsonPath string) string {
-	return "JSON_UNQUOTE(JSON_EXTRACT(" + jsonColumn + ", '" + jsonPath + "'))"
+// TableName 指定表名
+func (ApprovalMWithVirtualFields) TableName() string {
+	return "approval"
 }
 
-// JSONContains 检查 JSON 数组是否包含指定值的辅助函数
-func JSONContains(jsonColumn, jsonPath, value string) string {
-	return "JSON_CONTAINS(" + jsonColumn + "->'" + jsonPath + "', JSON_OBJECT('id', '" + value + "'))"
+// JSONPathHookModel 实现 JSONPathHookModel，虚拟字段通过 ApprovalM 写回，校验、版本号和变更历史与直接更新 ApprovalM 一致
+func (ApprovalMWithVirtualFields) JSONPathHookModel() any {
+	return &ApprovalM{}
 }
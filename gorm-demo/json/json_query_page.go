@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// DefaultPageSize 未指定 PageSize 时每页的记录数
+	DefaultPageSize = 100
+	// MaxPageSize 每页最多的记录数
+	MaxPageSize = 1000
+)
+
+// ApprovalPageOrder 分页的排序方式，均为升序
+type ApprovalPageOrder string
+
+const (
+	// OrderByID 按 id 排序（默认），使用主键索引
+	OrderByID ApprovalPageOrder = "id"
+	// OrderByCreatedAt 按 (created_at, id) 排序，created_at 为 NULL 的记录不会返回
+	OrderByCreatedAt ApprovalPageOrder = "created_at"
+)
+
+// ApprovalCursor 键集分页游标，记录上一页最后一条记录的排序键
+type ApprovalCursor struct {
+	Order     ApprovalPageOrder `json:"o"`
+	ID        uint64            `json:"id"`
+	CreatedAt time.Time         `json:"ca,omitempty"`
+}
+
+// String 编码为不透明的字符串，用于在接口中传递
+func (c ApprovalCursor) String() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseApprovalCursor 解析 ApprovalCursor.String 的结果
+func ParseApprovalCursor(s string) (ApprovalCursor, error) {
+	var c ApprovalCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor: %w", err)
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("invalid cursor: %w", err)
+	}
+	return c, nil
+}
+
+// ApprovalPageRequest 分页查询参数
+type ApprovalPageRequest struct {
+	Conditions []clause.Expression // 查询条件，按 AND 组合，如 helper.ApprovalCodeIs(code)、helper.Filter(filter)，nil 会被忽略
+	Order      ApprovalPageOrder   // 排序方式，为空时按 id 排序
+	PageSize   int                 // 每页记录数，为 0 时使用 DefaultPageSize，超过 MaxPageSize 时按 MaxPageSize
+	After      string              // 上一页的 NextCursor，为空时从第一条开始
+}
+
+// ApprovalPage 一页查询结果
+type ApprovalPage struct {
+	Items      []*ApprovalM
+	NextCursor string // 为空表示没有下一页
+}
+
+// FindPage 键集分页查询
+//
+// 与 OFFSET 分页不同，翻页的代价不随页数增加，且翻页期间插入或删除记录不会导致重复或遗漏。
+// 游标必须与 Order 一致，否则返回错误。
+func (h *JSONQueryHelper) FindPage(ctx context.Context, req ApprovalPageRequest) (*ApprovalPage, error) {
+	order := req.Order
+	if order == "" {
+		order = OrderByID
+	}
+	size := req.PageSize
+	switch {
+	case size <= 0:
+		size = DefaultPageSize
+	case size > MaxPageSize:
+		size = MaxPageSize
+	}
+
+	tx := h.DB.WithContext(ctx).Model(&ApprovalM{})
+	for _, cond := range req.Conditions {
+		if cond != nil {
+			tx = tx.Where(cond)
+		}
+	}
+
+	var cursor *ApprovalCursor
+	if req.After != "" {
+		c, err := ParseApprovalCursor(req.After)
+		if err != nil {
+			return nil, err
+		}
+		if c.Order != order {
+			return nil, fmt.Errorf("cursor is for order %q, not %q", c.Order, order)
+		}
+		cursor = &c
+	}
+
+	switch order {
+	case OrderByID:
+		if cursor != nil {
+			tx = tx.Where("id > ?", cursor.ID)
+		}
+		tx = tx.Order("id")
+	case OrderByCreatedAt:
+		tx = tx.Where("created_at IS NOT NULL")
+		if cursor != nil {
+			tx = tx.Where("created_at > ? OR (created_at = ? AND id > ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+		}
+		tx = tx.Order("created_at").Order("id")
+	default:
+		return nil, fmt.Errorf("unsupported page order %q", order)
+	}
+
+	// 多查一条用于判断是否还有下一页
+	var items []*ApprovalM
+	if err := tx.Limit(size + 1).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	page := &ApprovalPage{Items: items}
+	if len(items) > size {
+		page.Items = items[:size]
+		last := page.Items[size-1]
+		page.NextCursor = ApprovalCursor{Order: order, ID: last.ID, CreatedAt: last.CreatedAt}.String()
+	}
+	return page, nil
+}
+
+// Stream 按 id 顺序逐条返回满足条件的审批记录，每次从数据库读取 batchSize 条（为 0 时使用 DefaultPageSize）
+//
+// 内部使用键集分页而不是长时间持有 *sql.Rows，内存占用与 batchSize 相关，
+// 且不会在调用方处理记录期间一直占用数据库连接。出错时返回 (nil, err) 后结束。
+//
+//	for approval, err := range helper.Stream(ctx, batchSize, helper.ApprovalCodeIs(code)) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func (h *JSONQueryHelper) Stream(ctx context.Context, batchSize int, conds ...clause.Expression) iter.Seq2[*ApprovalM, error] {
+	return func(yield func(*ApprovalM, error) bool) {
+		req := ApprovalPageRequest{Conditions: conds, PageSize: batchSize}
+		for {
+			page, err := h.FindPage(ctx, req)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, approval := range page.Items {
+				if !yield(approval, nil) {
+					return
+				}
+			}
+			if page.NextCursor == "" {
+				return
+			}
+			req.After = page.NextCursor
+		}
+	}
+}
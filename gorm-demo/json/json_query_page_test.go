@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// collectPages 从第一页开始翻到最后一页，返回每页的 instance_id
+func collectPages(t *testing.T, ctx context.Context, h *JSONQueryHelper, req ApprovalPageRequest) [][]string {
+	t.Helper()
+	var pages [][]string
+	for {
+		page, err := h.FindPage(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, a := range page.Items {
+			ids = append(ids, a.InstanceID)
+		}
+		pages = append(pages, ids)
+		if page.NextCursor == "" {
+			return pages
+		}
+		req.After = page.NextCursor
+	}
+}
+
+// countQueries 统计 db 上执行的查询次数
+func countQueries(t *testing.T, db *gorm.DB) *int {
+	t.Helper()
+	var n int
+	if err := db.Callback().Query().After("gorm:query").Register("test:count_queries", func(*gorm.DB) { n++ }); err != nil {
+		t.Fatal(err)
+	}
+	return &n
+}
+
+func TestFindPageByID(t *testing.T) {
+	db := openTestDB(t)
+	for i := 1; i <= 4; i++ {
+		seedApproval(t, db, fmt.Sprintf("i%d", i), LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	}
+	h := NewJSONQueryHelper(db)
+
+	// 记录数恰好是页大小的整数倍时没有空的最后一页
+	pages := collectPages(t, context.Background(), h, ApprovalPageRequest{PageSize: 2})
+	if want := [][]string{{"i1", "i2"}, {"i3", "i4"}}; !slices.EqualFunc(pages, want, slices.Equal) {
+		t.Errorf("pages = %v, want %v", pages, want)
+	}
+	pages = collectPages(t, context.Background(), h, ApprovalPageRequest{PageSize: 3})
+	if want := [][]string{{"i1", "i2", "i3"}, {"i4"}}; !slices.EqualFunc(pages, want, slices.Equal) {
+		t.Errorf("pages = %v, want %v", pages, want)
+	}
+
+	// 游标之后的记录被删除时返回空页，没有下一页
+	first, err := h.FindPage(context.Background(), ApprovalPageRequest{PageSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Where("instance_id = ?", "i4").Delete(&ApprovalM{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	last, err := h.FindPage(context.Background(), ApprovalPageRequest{PageSize: 3, After: first.NextCursor})
+	if err != nil || len(last.Items) != 0 || last.NextCursor != "" {
+		t.Errorf("last page = %+v, %v", last, err)
+	}
+
+	// 游标与排序方式不一致、无法解析、不支持的排序方式
+	for _, req := range []ApprovalPageRequest{
+		{Order: OrderByCreatedAt, After: first.NextCursor},
+		{After: "not a cursor"},
+		{Order: "approval_name"},
+	} {
+		if _, err := h.FindPage(context.Background(), req); err == nil {
+			t.Errorf("FindPage(%+v) should fail", req)
+		}
+	}
+}
+
+func TestFindPageByCreatedAt(t *testing.T) {
+	db := openTestDB(t)
+	t0 := time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)
+	// i2、i3、i4 的 created_at 相同，翻页边界落在它们中间时按 id 区分
+	createdAt := map[string]time.Time{"i1": t0.Add(time.Hour), "i2": t0, "i3": t0, "i4": t0, "i5": t0.Add(-time.Hour)}
+	for i := 1; i <= 5; i++ {
+		a := seedApproval(t, db, fmt.Sprintf("i%d", i), LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+		if err := db.Model(a).UpdateColumn("created_at", createdAt[a.InstanceID]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// created_at 为 NULL 的记录不返回
+	nullCreated := seedApproval(t, db, "i6", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	if err := db.Model(nullCreated).UpdateColumn("created_at", nil).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewJSONQueryHelper(db)
+	for size, want := range map[int][][]string{
+		1: {{"i5"}, {"i2"}, {"i3"}, {"i4"}, {"i1"}},
+		2: {{"i5", "i2"}, {"i3", "i4"}, {"i1"}},
+		3: {{"i5", "i2", "i3"}, {"i4", "i1"}},
+	} {
+		pages := collectPages(t, context.Background(), h, ApprovalPageRequest{Order: OrderByCreatedAt, PageSize: size})
+		if !slices.EqualFunc(pages, want, slices.Equal) {
+			t.Errorf("page size %d: pages = %v, want %v", size, pages, want)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	db := openTestDB(t)
+	for i := 1; i <= 5; i++ {
+		seedApproval(t, db, fmt.Sprintf("i%d", i), LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	}
+	h := NewJSONQueryHelper(db)
+	queries := countQueries(t, db)
+
+	// 提前结束时不再读取后面的批次
+	var got []string
+	for a, err := range h.Stream(context.Background(), 2) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, a.InstanceID)
+		if len(got) == 3 {
+			break
+		}
+	}
+	if !slices.Equal(got, []string{"i1", "i2", "i3"}) || *queries != 2 {
+		t.Errorf("got %v with %d queries, want 3 records with 2 queries", got, *queries)
+	}
+
+	// 出错时返回一次错误后结束
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var errs int
+	for a, err := range h.Stream(ctx, 2) {
+		if a != nil || err == nil {
+			t.Errorf("got %v, %v, want only an error", a, err)
+		}
+		errs++
+	}
+	if errs != 1 {
+		t.Errorf("errors = %d, want 1", errs)
+	}
+}
+
+func TestStreamWithFilterAndTenant(t *testing.T) {
+	db := seedTenants(t)
+	for _, tenant := range []string{"tenant-a", "tenant-b"} {
+		tx := db.WithContext(WithTenant(context.Background(), tenant))
+		seedApproval(t, tx, "i3", LarkApproval{ApprovalName: "差旅报销", Status: ApprovalStatusApproved})
+		seedApproval(t, tx, "i4", LarkApproval{ApprovalName: "差旅报销", Status: ApprovalStatusPending})
+	}
+	ctx := WithTenant(context.Background(), "tenant-b")
+	h := NewJSONQueryHelper(db.WithContext(ctx))
+
+	var got []*ApprovalM
+	for a, err := range h.Stream(ctx, 1, h.Filter(ApprovalFilter{ApprovalName: "差旅报销"}), nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, a)
+	}
+	if tenants := tenantsOf(got); !slices.Equal(tenants, []string{"tenant-b/i1", "tenant-b/i3", "tenant-b/i4"}) {
+		t.Errorf("stream = %v", tenants)
+	}
+
+	pages := collectPages(t, ctx, h, ApprovalPageRequest{
+		Conditions: []clause.Expression{h.Filter(ApprovalFilter{Statuses: []string{ApprovalStatusPending}})},
+		Order:      OrderByCreatedAt,
+		PageSize:   1,
+	})
+	if want := [][]string{{"i1"}, {"i4"}}; !slices.EqualFunc(pages, want, slices.Equal) {
+		t.Errorf("pages = %v, want %v", pages, want)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSONSchema 由 Go 结构体生成的 JSON Schema 子集，覆盖 type/properties/items/required/enum
+//
+// 结构体字段通过 schema 标签声明额外约束，多个约束以逗号分隔：
+//
+//	Status string `json:"status" schema:"required,enum=PENDING|APPROVED"`
+//
+// 结构体序列化时零值字段也会输出，因此空字符串视为未设置，不做枚举校验。
+type JSONSchema struct {
+	Type       string                 `json:"type"`
+	Nullable   bool                   `json:"nullable,omitempty"`
+	Properties map[string]*JSONSchema `json:"properties,omitempty"`
+	Required   []string               `json:"required,omitempty"`
+	Items      *JSONSchema            `json:"items,omitempty"`
+	Enum       []string               `json:"enum,omitempty"`
+}
+
+// SchemaViolation 一条校验失败信息，Pointer 为 RFC 6901 JSON Pointer，如 /task_list/0/id
+type SchemaViolation struct {
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+func (v SchemaViolation) String() string {
+	pointer := v.Pointer
+	if pointer == "" {
+		pointer = "/"
+	}
+	return pointer + ": " + v.Message
+}
+
+// SchemaValidationError 包含全部校验失败信息
+type SchemaValidationError struct {
+	Violations []SchemaViolation
+}
+
+func (e *SchemaValidationError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.String())
+	}
+	return "schema validation failed: " + strings.Join(messages, "; ")
+}
+
+// SchemaFor 根据结构体的 json 和 schema 标签生成 JSONSchema
+func SchemaFor(v any) *JSONSchema {
+	return schemaForType(reflect.TypeOf(v))
+}
+
+func schemaForType(t reflect.Type) *JSONSchema {
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		t, nullable = t.Elem(), true
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		s := &JSONSchema{Type: "object", Nullable: nullable, Properties: map[string]*JSONSchema{}}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			prop := schemaForType(f.Type)
+			for _, opt := range strings.Split(f.Tag.Get("schema"), ",") {
+				switch {
+				case opt == "required":
+					s.Required = append(s.Required, name)
+				case strings.HasPrefix(opt, "enum="):
+					prop.Enum = strings.Split(strings.TrimPrefix(opt, "enum="), "|")
+				}
+			}
+			s.Properties[name] = prop
+		}
+		return s
+	case reflect.Slice, reflect.Array:
+		return &JSONSchema{Type: "array", Nullable: true, Items: schemaForType(t.Elem())}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", Nullable: true}
+	case reflect.String:
+		return &JSONSchema{Type: "string", Nullable: nullable}
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean", Nullable: nullable}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number", Nullable: nullable}
+	default:
+		// interface{} 等无法推断类型的字段不做约束
+		return &JSONSchema{}
+	}
+}
+
+// Validate 校验 JSON 文档，返回全部校验失败信息；文档本身不是合法 JSON 时只返回一条
+func (s *JSONSchema) Validate(data []byte) []SchemaViolation {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return []SchemaViolation{{Pointer: "", Message: "invalid json: " + err.Error()}}
+	}
+	var violations []SchemaViolation
+	s.validate(doc, "", &violations)
+	return violations
+}
+
+func (s *JSONSchema) validate(value any, pointer string, violations *[]SchemaViolation) {
+	if s.Type == "" {
+		return
+	}
+	if value == nil {
+		if !s.Nullable {
+			*violations = append(*violations, SchemaViolation{Pointer: pointer, Message: "expected " + s.Type + ", got null"})
+		}
+		return
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			*violations = append(*violations, SchemaViolation{Pointer: pointer, Message: "expected object, got " + jsonTypeName(value)})
+			return
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				*violations = append(*violations, SchemaViolation{Pointer: pointer + "/" + escapeJSONPointer(name), Message: "is required"})
+			}
+		}
+		// 按键排序，保证错误信息顺序稳定；未声明的键允许存在
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if prop, ok := s.Properties[k]; ok {
+				prop.validate(obj[k], pointer+"/"+escapeJSONPointer(k), violations)
+			}
+		}
+	case "array":
+		arr, ok := value.([]any)
+		if !ok {
+			*violations = append(*violations, SchemaViolation{Pointer: pointer, Message: "expected array, got " + jsonTypeName(value)})
+			return
+		}
+		if s.Items != nil {
+			for i, item := range arr {
+				s.Items.validate(item, pointer+"/"+strconv.Itoa(i), violations)
+			}
+		}
+	default:
+		if got := jsonTypeName(value); got != s.Type {
+			*violations = append(*violations, SchemaViolation{Pointer: pointer, Message: "expected " + s.Type + ", got " + got})
+			return
+		}
+		if str, _ := value.(string); len(s.Enum) > 0 && str != "" {
+			for _, e := range s.Enum {
+				if str == e {
+					return
+				}
+			}
+			*violations = append(*violations, SchemaViolation{
+				Pointer: pointer,
+				Message: fmt.Sprintf("value %q is not one of [%s]", str, strings.Join(s.Enum, ", ")),
+			})
+		}
+	}
+}
+
+// jsonTypeName 返回 encoding/json 解码结果对应的 JSON 类型名
+func jsonTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// escapeJSONPointer 按 RFC 6901 转义 ~ 和 /
+func escapeJSONPointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSchemaForLarkApproval(t *testing.T) {
+	s := LarkApprovalSchema
+	if s.Type != "object" || !slices.Equal(s.Required, []string{"approval_name"}) {
+		t.Errorf("root = %s %v", s.Type, s.Required)
+	}
+	status := s.Properties["status"]
+	if status.Type != "string" || !slices.Contains(status.Enum, ApprovalStatusPending) {
+		t.Errorf("status = %+v", status)
+	}
+	tasks := s.Properties["task_list"]
+	if tasks.Type != "array" || !tasks.Nullable || tasks.Items.Type != "object" || !tasks.Items.Nullable ||
+		!slices.Equal(tasks.Items.Required, []string{"id"}) {
+		t.Errorf("task_list = %+v, items = %+v", tasks, tasks.Items)
+	}
+	if reverted := s.Properties["reverted"]; reverted.Type != "boolean" {
+		t.Errorf("reverted = %+v", reverted)
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want []string
+	}{
+		{"valid", `{"approval_name":"a","status":"PENDING","task_list":[{"id":"t1","status":"DONE"}]}`, nil},
+		{"null arrays and unknown keys", `{"approval_name":"a","task_list":null,"comment_list":[null],"extra":1}`, nil},
+		{"empty enum", `{"approval_name":"a","status":""}`, nil},
+		{"missing required", `{"status":"PENDING","task_list":[{"status":"PENDING"}]}`,
+			[]string{"/approval_name: is required", "/task_list/0/id: is required"}},
+		{"wrong types", `{"approval_name":123,"task_list":{},"reverted":"yes"}`,
+			[]string{"/approval_name: expected string, got number", "/reverted: expected boolean, got string", "/task_list: expected array, got object"}},
+		{"enum", `{"approval_name":"a","status":"pending","task_list":[{"id":"t1","status":"WAITING"}]}`,
+			[]string{`/status: value "pending" is not one of [PENDING, APPROVED, REJECTED, CANCELED, DELETED]`,
+				`/task_list/0/status: value "WAITING" is not one of [PENDING, APPROVED, REJECTED, TRANSFERRED, DONE]`}},
+		{"null string", `{"approval_name":null}`, []string{"/approval_name: expected string, got null"}},
+		{"not an object", `[]`, []string{"/: expected object, got array"}},
+		{"invalid json", `{`, []string{"/: invalid json: unexpected end of JSON input"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, v := range LarkApprovalSchema.Validate([]byte(tt.doc)) {
+				got = append(got, v.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("violations = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchemaEscapesPointer(t *testing.T) {
+	type doc struct {
+		Path string `json:"a/b~c" schema:"required"`
+	}
+	violations := SchemaFor(doc{}).Validate([]byte(`{}`))
+	if len(violations) != 1 || violations[0].Pointer != "/a~1b~0c" {
+		t.Errorf("violations = %v", violations)
+	}
+}
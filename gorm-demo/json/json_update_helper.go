@@ -1,31 +1,78 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"time"
 
-	"gorm.io/datatypes"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // JSONUpdateHelper JSON 更新辅助结构体
 type JSONUpdateHelper struct {
-	DB *gorm.DB
+	DB      *gorm.DB
+	Dialect JSONDialect
 }
 
-// NewJSONUpdateHelper 创建新的 JSON 更新辅助实例
+// NewJSONUpdateHelper 创建新的 JSON 更新辅助实例，根据 db 的 Dialector 自动选择 JSON 方言
 func NewJSONUpdateHelper(db *gorm.DB) *JSONUpdateHelper {
-	return &JSONUpdateHelper{DB: db}
+	return &JSONUpdateHelper{DB: db, Dialect: JSONDialectOf(db)}
+}
+
+// UpsertDefaults 记录不存在时创建新记录所需的 NOT NULL 字段
+type UpsertDefaults struct {
+	ApprovalCode string // 审批定义 Code
+	Type         string // 审批实例类型, 可选值: lark, dingtalk
 }
 
 // UpdateJSONField 更新JSON字段的单个属性
 func (h *JSONUpdateHelper) UpdateJSONField(instanceID string, fieldPath string, value interface{}) error {
-	// 使用 MySQL 的 JSON_SET 函数更新 JSON 字段
-	// 确保JSON路径正确格式化，不需要额外的单引号，让GORM处理参数绑定
-	// return h.DB.Model(&ApprovalM{}).Where("instance_id = ?", instanceID).Update("lark_data",
-	// 	gorm.Expr("JSON_SET(lark_data, ?, ?)", fieldPath, value)).Error
-	return h.DB.Model(&ApprovalM{}).Where("instance_id = ?", instanceID).Update("lark_data",
-		gorm.Expr("JSON_SET(lark_data, ?, ?)", fieldPath, value)).Error
+	assignments, err := buildJSONAssignments(map[string]interface{}{fieldPath: value})
+	if err != nil {
+		return err
+	}
+	// 路径和值都作为绑定参数传入，由方言生成 JSON_SET / json_set / jsonb_set
+	return h.DB.Model(&ApprovalM{}).Where("instance_id = ?", instanceID).Updates(map[string]interface{}{
+		"lark_data": h.Dialect.Set(h.Dialect.Document("lark_data"), assignments),
+		"version":   incrementVersion(),
+	}).Error
+}
+
+// UpdateJSONFieldsWithVersion 以乐观锁方式批量更新JSON字段
+//
+// 仅当记录当前的 version 等于 expectedVersion 时才会写入并将 version 加 1，
+// 版本不一致时返回 *StaleApprovalError，记录不存在时返回 gorm.ErrRecordNotFound。
+func (h *JSONUpdateHelper) UpdateJSONFieldsWithVersion(instanceID string, expectedVersion uint64, fieldValues map[string]interface{}) error {
+	assignments, err := buildJSONAssignments(fieldValues)
+	if err != nil {
+		return err
+	}
+
+	return h.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&ApprovalM{}).Where("instance_id = ? AND version = ?", instanceID, expectedVersion).Updates(map[string]interface{}{
+			"lark_data": h.Dialect.Set(h.Dialect.Document("lark_data"), assignments),
+			"version":   incrementVersion(),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			return nil
+		}
+
+		// 区分记录不存在和版本冲突
+		var count int64
+		if err := tx.Model(&ApprovalM{}).Where("instance_id = ?", instanceID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return &StaleApprovalError{InstanceID: instanceID, Version: expectedVersion}
+	})
 }
 
 // UpdateNestedJSONField 更新嵌套的JSON字段属性
@@ -42,55 +89,216 @@ func (h *JSONUpdateHelper) UpdateJSONArrayElement(instanceID string, arrayPath s
 	return h.UpdateJSONField(instanceID, fullPath, value)
 }
 
-// UpdateJSONFieldsInBatch 批量更新JSON字段的多个属性，如果记录不存在则创建
-func (h *JSONUpdateHelper) UpdateJSONFieldsInBatch(instanceID string, fieldValues map[string]interface{}) error {
-	// 构建JSON_SET表达式
-	expr := "lark_data"
-	args := []interface{}{}
+// UpdateJSONFieldsInBatch 批量更新JSON字段的多个属性，如果记录不存在则使用 defaults 创建
+//
+// 使用单条 INSERT ... ON DUPLICATE KEY UPDATE（PostgreSQL/SQLite 为 ON CONFLICT）在事务内完成，
+// 并发写入同一 instance_id 时不会因唯一索引 uk_tenant_instance_id 冲突而失败。
+// 新建记录时会按路径构建嵌套的 JSON，如 $.task_list[1].id 会生成 {"task_list":[null,{"id":...}]}。
+// 校验针对合并后的记录（见 recordCreateHistory），更新已有记录时只需给出要修改的字段。
+func (h *JSONUpdateHelper) UpdateJSONFieldsInBatch(instanceID string, fieldValues map[string]interface{}, defaults UpsertDefaults) error {
+	if len(fieldValues) == 0 {
+		return nil
+	}
 
-	for fieldPath, value := range fieldValues {
-		expr = "JSON_SET(" + expr + ", ?, ?)"
-		args = append(args, fieldPath, value)
+	assignments, err := buildJSONAssignments(fieldValues)
+	if err != nil {
+		return err
 	}
 
-	// 执行更新
-	result := h.DB.Model(&ApprovalM{}).Where("instance_id = ?", instanceID).Update("lark_data",
-		gorm.Expr(expr, args...))
+	doc, err := buildJSONDocument(assignments)
+	if err != nil {
+		return err
+	}
 
-	// 检查是否更新成功（影响行数大于0）
-	if result.Error != nil {
-		return result.Error
+	approval := &ApprovalM{
+		InstanceID:   instanceID,
+		ApprovalCode: defaults.ApprovalCode,
+		Type:         defaults.Type,
+		LarkData:     RawJSONColumn[LarkApproval](doc),
 	}
 
-	// 如果没有记录被更新（影响行数为0），则创建新记录
-	if result.RowsAffected == 0 {
-		// 创建新的审批记录
-		approval := &ApprovalM{
-			InstanceID:   instanceID,
-			ApprovalCode: "auto_generated", // 设置默认值
-			Type:         "lark",          // 设置默认类型
+	return h.DB.Transaction(func(tx *gorm.DB) error {
+		return tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "tenant_id"}, {Name: "instance_id"}},
+			// 与 uk_tenant_instance_id 的列和部分索引条件一致，PostgreSQL/SQLite 据此匹配唯一索引，已软删除的记录不参与冲突
+			TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "deleted_at IS NULL"}}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"lark_data":     h.Dialect.Set(h.Dialect.Document("lark_data"), assignments),
+				"version":       incrementVersion(),
+				"is_written_es": false,
+				"updated_at":    time.Now(),
+			}),
+		}).Create(approval).Error
+	})
+}
+
+// ApplyJSONPatch 将 RFC 6902 JSON Patch 应用到 lark_data，所有操作要么全部生效要么全部不生效
+//
+// 能等价转换时生成单条 UPDATE（JSON_SET/JSON_REMOVE/JSON_ARRAY_APPEND），并以 WHERE 条件保证路径存在；
+// 其余情况（move/copy/test、数组下标等）以及前置条件不满足时，在事务中锁定记录，读取后在应用层执行再写回。
+// 操作失败时返回 *JSONPatchError（test 失败时 errors.Is(err, ErrPatchTestFailed) 成立），
+// 记录不存在时返回 gorm.ErrRecordNotFound。
+func (h *JSONUpdateHelper) ApplyJSONPatch(instanceID string, patch JSONPatch) error {
+	if len(patch) == 0 {
+		return nil
+	}
+	if p, ok := translateJSONPatch(h.Dialect, "lark_data", patch); ok {
+		applied, err := h.updateLarkData(instanceID, p.Expr, p.Conditions...)
+		if err != nil || applied {
+			return err
 		}
+		// 记录不存在或前置条件不满足，由读取-修改-写入给出准确的错误
+	}
+	return h.readModifyWriteLarkData(instanceID, patch.Apply)
+}
 
-		// 手动构建JSON数据
-		larkData := make(map[string]interface{})
-		for fieldPath, value := range fieldValues {
-			// 移除路径中的$.前缀
-			fieldName := fieldPath[2:]
-			larkData[fieldName] = value
+// ApplyMergePatch 将 RFC 7396 JSON Merge Patch 应用到 lark_data
+//
+// MySQL 使用 JSON_MERGE_PATCH，SQLite 使用 json_patch；PostgreSQL 没有等价函数，
+// 与 patch 不是对象（整体替换）时一样在事务中读取-修改-写入。记录不存在时返回 gorm.ErrRecordNotFound。
+func (h *JSONUpdateHelper) ApplyMergePatch(instanceID string, patch json.RawMessage) error {
+	if !json.Valid(patch) {
+		return fmt.Errorf("invalid merge patch: %s", patch)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(patch), []byte("{")) {
+		if expr := h.Dialect.MergePatch(h.Dialect.Document("lark_data"), patch); expr != nil {
+			applied, err := h.updateLarkData(instanceID, expr)
+			if err == nil && !applied {
+				err = gorm.ErrRecordNotFound
+			}
+			return err
 		}
+	}
+	return h.readModifyWriteLarkData(instanceID, func(doc []byte) ([]byte, error) {
+		return MergePatch(doc, patch)
+	})
+}
+
+// updateLarkData 以 SQL 表达式更新 lark_data，返回是否有记录被更新
+func (h *JSONUpdateHelper) updateLarkData(instanceID string, expr clause.Expression, conditions ...clause.Expression) (bool, error) {
+	query := h.DB.Model(&ApprovalM{}).Where("instance_id = ?", instanceID)
+	for _, cond := range conditions {
+		query = query.Where(cond)
+	}
+	result := query.Updates(map[string]interface{}{
+		"lark_data": expr,
+		"version":   incrementVersion(),
+	})
+	return result.RowsAffected > 0, result.Error
+}
 
-		// 将map转换为JSON
-		jsonData, err := json.Marshal(larkData)
+// readModifyWriteLarkData 在事务中锁定并读取记录，调用 modify 生成新的 lark_data 后按版本写回
+func (h *JSONUpdateHelper) readModifyWriteLarkData(instanceID string, modify func(doc []byte) ([]byte, error)) error {
+	return h.DB.Transaction(func(tx *gorm.DB) error {
+		query := tx
+		// SQLite 不支持 FOR UPDATE，写事务本身是串行的
+		if h.Dialect.Name() != DialectSQLite {
+			query = tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate})
+		}
+		var approval ApprovalM
+		if err := query.Where("instance_id = ?", instanceID).First(&approval).Error; err != nil {
+			return err
+		}
+
+		doc, err := approval.LarkData.Bytes()
 		if err != nil {
 			return err
 		}
+		if doc, err = modify(doc); err != nil {
+			return err
+		}
+
+		// Model 指向读取的记录，注册了 FieldEncryptionPlugin 时新文档的密文绑定到该记录
+		result := tx.Model(&approval).Where("version = ?", approval.Version).Updates(map[string]interface{}{
+			"lark_data": RawJSONColumn[LarkApproval](doc),
+			"version":   incrementVersion(),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return &StaleApprovalError{InstanceID: instanceID, Version: approval.Version}
+		}
+		return nil
+	})
+}
+
+// buildJSONAssignments 解析路径并序列化值，按路径排序保证生成的 SQL 稳定
+func buildJSONAssignments(fieldValues map[string]interface{}) ([]JSONAssignment, error) {
+	paths := make([]string, 0, len(fieldValues))
+	for fieldPath := range fieldValues {
+		paths = append(paths, fieldPath)
+	}
+	sort.Strings(paths)
+
+	assignments := make([]JSONAssignment, 0, len(paths))
+	for _, fieldPath := range paths {
+		path, err := ParseJSONPath(fieldPath)
+		if err != nil {
+			return nil, err
+		}
+		assignment, err := NewJSONAssignment(path, fieldValues[fieldPath])
+		if err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, assignment)
+	}
+	return assignments, nil
+}
 
-		// 设置JSON字段
-		approval.LarkData = datatypes.JSON(jsonData)
+// buildJSONDocument 根据赋值列表构建一个新的 JSON 文档，缺失的对象和数组元素会被自动补齐
+func buildJSONDocument(assignments []JSONAssignment) ([]byte, error) {
+	var root any = map[string]any{}
+	for _, a := range assignments {
+		var value any
+		if err := json.Unmarshal(a.Value, &value); err != nil {
+			return nil, err
+		}
+		updated, err := setJSONValue(root, a.Path.segments, value)
+		if err != nil {
+			return nil, fmt.Errorf("set %s: %w", a.Path, err)
+		}
+		root = updated
+	}
+	return json.Marshal(root)
+}
 
-		// 创建记录
-		return h.DB.Create(approval).Error
+// setJSONValue 将 value 写入 node 中 segments 指向的位置并返回新的 node
+func setJSONValue(node any, segments []pathSegment, value any) (any, error) {
+	if len(segments) == 0 {
+		return value, nil
+	}
+	seg := segments[0]
+	if seg.isKey {
+		obj, ok := node.(map[string]any)
+		if node == nil {
+			obj, ok = map[string]any{}, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("key %q applied to non-object", seg.key)
+		}
+		child, err := setJSONValue(obj[seg.key], segments[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		obj[seg.key] = child
+		return obj, nil
 	}
 
-	return nil
+	arr, ok := node.([]any)
+	if node == nil {
+		arr, ok = []any{}, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("index %d applied to non-array", seg.index)
+	}
+	for len(arr) <= seg.index {
+		arr = append(arr, nil)
+	}
+	child, err := setJSONValue(arr[seg.index], segments[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	arr[seg.index] = child
+	return arr, nil
 }
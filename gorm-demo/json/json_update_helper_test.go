@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestUpdateJSONFieldsInBatchUpsertExisting(t *testing.T) {
+	db := openTestDB(t)
+	seedApproval(t, db, "i1", LarkApproval{
+		ApprovalName: "差旅报销",
+		Status:       ApprovalStatusPending,
+		TaskList:     []*InstanceTask{{ID: "t1", UserID: "zhangsan", Status: ApprovalStatusPending}},
+	})
+	h := NewJSONUpdateHelper(db)
+
+	// 只写入部分字段，按合并后的记录校验，不因缺少 approval_name 而失败
+	err := h.UpdateJSONFieldsInBatch("i1", map[string]interface{}{"$.status": ApprovalStatusApproved}, UpsertDefaults{ApprovalCode: "code", Type: ApprovalTypeLark})
+	if err != nil {
+		t.Fatalf("upsert one field: %v", err)
+	}
+	err = h.UpdateJSONFieldsInBatch("i1", map[string]interface{}{"$.task_list[0].user_id": "lisi", "$.task_list[1].id": "t2"}, UpsertDefaults{ApprovalCode: "code", Type: ApprovalTypeLark})
+	if err != nil {
+		t.Fatalf("upsert nested field: %v", err)
+	}
+
+	var a ApprovalM
+	if err := db.Where("instance_id = ?", "i1").First(&a).Error; err != nil {
+		t.Fatal(err)
+	}
+	data, err := a.LarkData.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.ApprovalName != "差旅报销" || data.Status != ApprovalStatusApproved || data.TaskList[0].UserID != "lisi" ||
+		len(data.TaskList) != 2 || data.TaskList[1].ID != "t2" {
+		t.Errorf("merged lark_data = %+v", data)
+	}
+	if a.Version != 2 {
+		t.Errorf("version = %d, want 2", a.Version)
+	}
+}
+
+func TestUpdateJSONFieldsInBatchUpsertNewInvalid(t *testing.T) {
+	db := openTestDB(t)
+	h := NewJSONUpdateHelper(db)
+
+	// 新建的记录只有 status，合并后的记录仍需满足校验
+	err := h.UpdateJSONFieldsInBatch("i1", map[string]interface{}{"$.status": ApprovalStatusPending}, UpsertDefaults{ApprovalCode: "code", Type: ApprovalTypeLark})
+	var verr *SchemaValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("err = %v, want SchemaValidationError", err)
+	}
+	if ids := instanceIDs(t, db); len(ids) != 0 {
+		t.Errorf("invalid upsert was written: %v", ids)
+	}
+}
+
+// patchTarget 补丁测试使用的 lark_data
+var patchTarget = LarkApproval{
+	ApprovalName: "差旅报销",
+	Status:       ApprovalStatusPending,
+	SerialNumber: "s1",
+	TaskList:     []*InstanceTask{{ID: "t1", UserID: "zhangsan", Status: TaskStatusPending}},
+}
+
+func TestApplyJSONPatchTranslatedMatchesFallback(t *testing.T) {
+	patches := map[string]JSONPatch{
+		"set and remove keys": {
+			{Op: PatchOpReplace, Path: "/approval_name", Value: []byte(`"采购"`)},
+			{Op: PatchOpAdd, Path: "/department_id", Value: []byte(`"d1"`)},
+			{Op: PatchOpRemove, Path: "/serial_number"},
+		},
+		"append tasks": {
+			{Op: PatchOpAdd, Path: "/task_list/-", Value: []byte(`{"id":"t2","status":"PENDING"}`)},
+			{Op: PatchOpAdd, Path: "/task_list/-", Value: []byte(`{"id":"t3","status":"PENDING"}`)},
+			{Op: PatchOpReplace, Path: "/status", Value: []byte(`"APPROVED"`)},
+		},
+		"escaped key": {
+			{Op: PatchOpAdd, Path: "/x~1y~0z", Value: []byte(`1`)},
+		},
+	}
+	for name, patch := range patches {
+		t.Run(name, func(t *testing.T) {
+			if _, ok := translateJSONPatch(sqliteJSONDialect{}, "lark_data", patch); !ok {
+				t.Fatal("patch is not translated")
+			}
+			db := openTestDB(t)
+			seedApproval(t, db, "translated", patchTarget)
+			seedApproval(t, db, "fallback", patchTarget)
+			h := NewJSONUpdateHelper(db)
+
+			if err := h.ApplyJSONPatch("translated", patch); err != nil {
+				t.Fatal(err)
+			}
+			if err := h.readModifyWriteLarkData("fallback", patch.Apply); err != nil {
+				t.Fatal(err)
+			}
+			translated, fallback := rawLarkData(t, db, "", "translated"), rawLarkData(t, db, "", "fallback")
+			if !sameJSON(t, []byte(translated), []byte(fallback)) {
+				t.Errorf("translated = %s\nfallback   = %s", translated, fallback)
+			}
+			// 两种方式都增加版本号并记录历史
+			for _, id := range []string{"translated", "fallback"} {
+				histories, err := FindApprovalHistory(db, id)
+				if err != nil || len(histories) != 2 || histories[1].Version != 1 {
+					t.Errorf("%s: histories = %d, %v", id, len(histories), err)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatchFallback(t *testing.T) {
+	db := openTestDB(t)
+	seedApproval(t, db, "i1", patchTarget)
+	h := NewJSONUpdateHelper(db)
+
+	// 数组下标、move/copy/test 在应用层执行
+	err := h.ApplyJSONPatch("i1", JSONPatch{
+		{Op: PatchOpTest, Path: "/task_list/0/user_id", Value: []byte(`"zhangsan"`)},
+		{Op: PatchOpReplace, Path: "/task_list/0/status", Value: []byte(`"APPROVED"`)},
+		{Op: PatchOpCopy, From: "/task_list/0", Path: "/task_list/-"},
+		{Op: PatchOpReplace, Path: "/task_list/1/id", Value: []byte(`"t2"`)},
+		{Op: PatchOpMove, From: "/serial_number", Path: "/uuid"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := tenantLarkData(t, db, "", "i1")
+	if len(data.TaskList) != 2 || data.TaskList[0].ID != "t1" || data.TaskList[1].ID != "t2" ||
+		data.TaskList[1].Status != TaskStatusApproved || data.SerialNumber != "" || data.UUID != "s1" {
+		t.Errorf("lark_data = %+v", data)
+	}
+
+	// 失败时整体不生效
+	err = h.ApplyJSONPatch("i1", JSONPatch{
+		{Op: PatchOpReplace, Path: "/approval_name", Value: []byte(`"x"`)},
+		{Op: PatchOpTest, Path: "/status", Value: []byte(`"APPROVED"`)},
+	})
+	if !errors.Is(err, ErrPatchTestFailed) {
+		t.Errorf("err = %v, want ErrPatchTestFailed", err)
+	}
+	// 可以转换的补丁前置条件不满足时，回退后返回准确的错误
+	var perr *JSONPatchError
+	if err := h.ApplyJSONPatch("i1", JSONPatch{{Op: PatchOpRemove, Path: "/no_such_key"}}); !errors.As(err, &perr) || perr.Index != 0 {
+		t.Errorf("err = %v, want JSONPatchError", err)
+	}
+	if err := h.ApplyJSONPatch("i1", JSONPatch{{Op: PatchOpAdd, Path: "/comment_list/-", Value: []byte(`{"id":"c1"}`)}}); !errors.As(err, &perr) {
+		t.Errorf("append to null: err = %v, want JSONPatchError", err)
+	}
+	if err := h.ApplyJSONPatch("missing", JSONPatch{{Op: PatchOpRemove, Path: "/status"}}); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("err = %v, want ErrRecordNotFound", err)
+	}
+	if data := tenantLarkData(t, db, "", "i1"); data.ApprovalName != patchTarget.ApprovalName {
+		t.Errorf("failed patch was written: %+v", data)
+	}
+}
+
+func TestApplyJSONPatchValidation(t *testing.T) {
+	db := openTestDB(t)
+	seedApproval(t, db, "i1", patchTarget)
+	h := NewJSONUpdateHelper(db)
+
+	// 转换为 SQL 的补丁同样按写入后的记录校验
+	tests := []struct {
+		name     string
+		patch    JSONPatch
+		pointers []string
+	}{
+		{"remove required key", JSONPatch{{Op: PatchOpRemove, Path: "/approval_name"}}, []string{"/approval_name"}},
+		{"append task without id", JSONPatch{{Op: PatchOpAdd, Path: "/task_list/-", Value: []byte(`{"status":"PENDING"}`)}}, []string{"/task_list/1/id"}},
+		{"wrong type", JSONPatch{{Op: PatchOpReplace, Path: "/approval_name", Value: []byte(`123`)}}, []string{"/approval_name"}},
+		{"fallback", JSONPatch{{Op: PatchOpRemove, Path: "/task_list/0/id"}}, []string{"/task_list/0/id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantSchemaViolations(t, h.ApplyJSONPatch("i1", tt.patch), tt.pointers...)
+		})
+	}
+	wantSchemaViolations(t, h.ApplyMergePatch("i1", []byte(`{"approval_name":null}`)), "/approval_name")
+	if violations := LarkApprovalSchema.Validate([]byte(rawLarkData(t, db, "", "i1"))); len(violations) != 0 {
+		t.Errorf("stored lark_data is invalid: %v", violations)
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	db := openTestDB(t)
+	seedApproval(t, db, "i1", patchTarget)
+	h := NewJSONUpdateHelper(db)
+
+	// null 删除键，对象递归合并，数组整体替换
+	err := h.ApplyMergePatch("i1", []byte(`{"serial_number":null,"status":"approved","task_list":[{"id":"t9"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := tenantLarkData(t, db, "", "i1")
+	if data.ApprovalName != patchTarget.ApprovalName || data.Status != ApprovalStatusApproved || data.SerialNumber != "" ||
+		len(data.TaskList) != 1 || data.TaskList[0].ID != "t9" || data.TaskList[0].UserID != "" {
+		t.Errorf("lark_data = %+v", data)
+	}
+	if raw := rawLarkData(t, db, "", "i1"); strings.Contains(raw, "serial_number") {
+		t.Errorf("serial_number was not deleted: %s", raw)
+	}
+
+	// 非对象的补丁整体替换文档，在应用层执行
+	if err := h.ApplyMergePatch("i1", []byte(`"x"`)); err == nil {
+		t.Error("replacing lark_data with a string succeeded")
+	}
+	if err := h.ApplyMergePatch("missing", []byte(`{"status":null}`)); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("err = %v, want ErrRecordNotFound", err)
+	}
+	if err := h.ApplyMergePatch("i1", []byte(`{`)); err == nil {
+		t.Error("invalid merge patch accepted")
+	}
+}
@@ -0,0 +1,540 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// jsonPathTag 虚拟字段的标签，格式为 "列名:JSON路径"
+const jsonPathTag = "jsonpath"
+
+// jsonPathPendingKey 通过 InstanceSet 在 before_update/after_update 之间传递待写回的虚拟字段
+const jsonPathPendingKey = "jsonpath:pending"
+
+// JSONPathPlugin 将带有 jsonpath 标签的虚拟字段映射到 JSON 列中的路径，标签格式为 "列名:JSON路径"：
+//
+//	Status string `gorm:"-" jsonpath:"lark_data:$.status"`
+//
+// 注册后：
+//   - 查询后从 JSON 列中取值填充虚拟字段（查询结果中需要包含 JSON 列），在 AfterFind 钩子之前执行
+//   - Save/Updates(struct) 时只把被修改的虚拟字段通过 JSON_SET 写回：Dest 与 Model 为同一对象时与 JSON 列中的值比较，
+//     否则非零值视为修改；写回使用与本次更新相同的 WHERE 条件，在同一个事务中通过模型的 Updates 执行，
+//     会触发模型的更新钩子，模型可通过 JSONPathHookModel 指定使用其他模型的钩子
+//   - Updates(map) 中的虚拟字段名会被替换为对 JSON 列的 JSON_SET
+//   - Where(map)/clause 条件中的虚拟字段名会被替换为 JSON 提取表达式，如 Where(map[string]any{"status": "PENDING"})；
+//     字符串条件（如 "status = ?"）不做替换
+//
+// 虚拟字段名既可以是 Go 字段名，也可以是按命名策略生成的列名（如 ApprovalName 和 approval_name）。
+//
+//	db.Use(NewJSONPathPlugin())
+type JSONPathPlugin struct {
+	fields sync.Map // reflect.Type -> []*jsonPathField
+}
+
+// jsonPathField 一个带 jsonpath 标签的字段
+type jsonPathField struct {
+	index  []int
+	name   string // Go 字段名
+	dbName string // 按命名策略生成的列名
+	column string // JSON 列名
+	path   JSONPath
+}
+
+// NewJSONPathPlugin 创建虚拟字段插件
+func NewJSONPathPlugin() *JSONPathPlugin {
+	return &JSONPathPlugin{}
+}
+
+// Name 实现 gorm.Plugin
+func (p *JSONPathPlugin) Name() string {
+	return "jsonpath"
+}
+
+// Initialize 实现 gorm.Plugin，注册查询、更新和删除回调
+func (p *JSONPathPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Query().Before("gorm:query").Register("jsonpath:where", p.rewriteWhere); err != nil {
+		return err
+	}
+	if err := callbacks.Query().After("gorm:query").Before("gorm:after_query").Register("jsonpath:populate", p.populate); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").Register("jsonpath:where", p.rewriteWhere); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("gorm:delete").Register("jsonpath:where", p.rewriteWhere); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("jsonpath:before_update", p.beforeUpdate); err != nil {
+		return err
+	}
+	return callbacks.Update().After("gorm:update").Register("jsonpath:after_update", p.afterUpdate)
+}
+
+// fieldsOf 返回当前模型带 jsonpath 标签的字段，按类型缓存
+func (p *JSONPathPlugin) fieldsOf(db *gorm.DB) ([]*jsonPathField, error) {
+	if db.Statement.Schema == nil {
+		return nil, nil
+	}
+	t := db.Statement.Schema.ModelType
+	if cached, ok := p.fields.Load(t); ok {
+		return cached.([]*jsonPathField), nil
+	}
+
+	var fields []*jsonPathField
+	for _, f := range reflect.VisibleFields(t) {
+		tag, ok := f.Tag.Lookup(jsonPathTag)
+		if !ok || !f.IsExported() {
+			continue
+		}
+		column, rawPath, ok := strings.Cut(tag, ":")
+		if !ok || column == "" {
+			return nil, fmt.Errorf("invalid jsonpath tag %q on %s.%s, want column:$.path", tag, t.Name(), f.Name)
+		}
+		path, err := ParseJSONPath(rawPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jsonpath tag on %s.%s: %w", t.Name(), f.Name, err)
+		}
+		if db.Statement.Schema.LookUpField(column) == nil {
+			return nil, fmt.Errorf("jsonpath tag on %s.%s refers to unknown column %q", t.Name(), f.Name, column)
+		}
+		fields = append(fields, &jsonPathField{
+			index:  f.Index,
+			name:   f.Name,
+			dbName: db.NamingStrategy.ColumnName("", f.Name),
+			column: column,
+			path:   path,
+		})
+	}
+	p.fields.Store(t, fields)
+	return fields, nil
+}
+
+func lookupJSONPathField(fields []*jsonPathField, name string) *jsonPathField {
+	for _, f := range fields {
+		if f.name == name || f.dbName == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// rewriteWhere 将 WHERE 中的虚拟字段替换为 JSON 提取表达式
+func (p *JSONPathPlugin) rewriteWhere(db *gorm.DB) {
+	fields, err := p.fieldsOf(db)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	if len(fields) == 0 {
+		return
+	}
+	c, ok := db.Statement.Clauses["WHERE"]
+	if !ok {
+		return
+	}
+	where, ok := c.Expression.(clause.Where)
+	if !ok {
+		return
+	}
+	// 复制一份，避免修改被复用的 *gorm.DB 中的条件
+	exprs := make([]clause.Expression, len(where.Exprs))
+	for i, expr := range where.Exprs {
+		exprs[i] = rewriteJSONPathColumns(expr, fields)
+	}
+	c.Expression = clause.Where{Exprs: exprs}
+	db.Statement.Clauses["WHERE"] = c
+}
+
+// rewriteJSONPathColumns 递归替换条件中的虚拟字段列
+func rewriteJSONPathColumns(expr clause.Expression, fields []*jsonPathField) clause.Expression {
+	column := func(c any) any {
+		var name string
+		switch c := c.(type) {
+		case string:
+			name = c
+		case clause.Column:
+			if c.Raw || (c.Table != "" && c.Table != clause.CurrentTable) {
+				return c
+			}
+			name = c.Name
+		default:
+			return c
+		}
+		if f := lookupJSONPathField(fields, name); f != nil {
+			// clause.Expr 会被 Statement.QuoteTo 直接构建，而不是作为标识符加引号
+			return clause.Expr{SQL: "?", Vars: []any{f.path.In(f.column)}}
+		}
+		return c
+	}
+
+	switch e := expr.(type) {
+	case clause.Eq:
+		e.Column = column(e.Column)
+		return e
+	case clause.Neq:
+		e.Column = column(e.Column)
+		return e
+	case clause.Gt:
+		e.Column = column(e.Column)
+		return e
+	case clause.Gte:
+		e.Column = column(e.Column)
+		return e
+	case clause.Lt:
+		e.Column = column(e.Column)
+		return e
+	case clause.Lte:
+		e.Column = column(e.Column)
+		return e
+	case clause.Like:
+		e.Column = column(e.Column)
+		return e
+	case clause.IN:
+		e.Column = column(e.Column)
+		return e
+	case clause.AndConditions:
+		return clause.AndConditions{Exprs: rewriteJSONPathExprs(e.Exprs, fields)}
+	case clause.OrConditions:
+		return clause.OrConditions{Exprs: rewriteJSONPathExprs(e.Exprs, fields)}
+	case clause.NotConditions:
+		return clause.NotConditions{Exprs: rewriteJSONPathExprs(e.Exprs, fields)}
+	default:
+		return expr
+	}
+}
+
+func rewriteJSONPathExprs(exprs []clause.Expression, fields []*jsonPathField) []clause.Expression {
+	rewritten := make([]clause.Expression, len(exprs))
+	for i, expr := range exprs {
+		rewritten[i] = rewriteJSONPathColumns(expr, fields)
+	}
+	return rewritten
+}
+
+// populate 查询后从 JSON 列中取值填充虚拟字段
+func (p *JSONPathPlugin) populate(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	fields, err := p.fieldsOf(db)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	if len(fields) == 0 {
+		return
+	}
+	eachStruct(db.Statement.ReflectValue, func(rv reflect.Value) {
+		docs := map[string]any{}
+		for _, f := range fields {
+			doc, ok := docs[f.column]
+			if !ok {
+				if doc, err = jsonFieldDocument(db, rv, f.column); err != nil {
+					slog.Warn("decode json column for virtual fields failed", "column", f.column, "error", err.Error())
+				}
+				docs[f.column] = doc
+			}
+			target := rv.FieldByIndex(f.index)
+			value := lookupJSONPath(doc, f.path)
+			if value == nil {
+				target.SetZero()
+				continue
+			}
+			raw, err := json.Marshal(value)
+			if err == nil {
+				err = json.Unmarshal(raw, target.Addr().Interface())
+			}
+			if err != nil {
+				slog.Warn("populate virtual field failed", "field", f.name, "path", f.path.String(), "error", err.Error())
+			}
+		}
+	})
+}
+
+// jsonPathPending 待写回的虚拟字段
+type jsonPathPending struct {
+	assignments map[string][]JSONAssignment // JSON 列 -> 赋值
+	target      reflect.Value               // Dest 与 Model 为同一对象时，写回后同步内存中的 JSON 列
+}
+
+// beforeUpdate 替换 WHERE 中的虚拟字段，并收集本次需要写回的虚拟字段
+func (p *JSONPathPlugin) beforeUpdate(db *gorm.DB) {
+	fields, err := p.fieldsOf(db)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	if len(fields) == 0 {
+		return
+	}
+	p.rewriteWhere(db)
+
+	if dest, ok := db.Statement.Dest.(map[string]interface{}); ok {
+		p.rewriteUpdateMap(db, fields, dest)
+		return
+	}
+
+	rv := reflect.ValueOf(db.Statement.Dest)
+	for rv.Kind() == reflect.Pointer {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct || rv.Type() != db.Statement.Schema.ModelType {
+		return
+	}
+	sameAsModel := db.Statement.Dest == db.Statement.Model
+
+	pending := &jsonPathPending{assignments: map[string][]JSONAssignment{}}
+	docs := map[string]any{}
+	for _, f := range fields {
+		value := rv.FieldByIndex(f.index)
+		if sameAsModel {
+			doc, ok := docs[f.column]
+			if !ok {
+				if doc, err = jsonFieldDocument(db, rv, f.column); err != nil {
+					db.AddError(err)
+					return
+				}
+				docs[f.column] = doc
+			}
+			if !jsonPathFieldChanged(doc, f.path, value) {
+				continue
+			}
+		} else if value.IsZero() {
+			continue
+		}
+		assignment, err := NewJSONAssignment(f.path, value.Interface())
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+		pending.assignments[f.column] = append(pending.assignments[f.column], assignment)
+	}
+	if len(pending.assignments) == 0 {
+		return
+	}
+
+	if sameAsModel {
+		pending.target = rv
+		// JSON 列本身未被修改时不再整体写回，避免覆盖其他写入方的修改
+		for column := range pending.assignments {
+			field := db.Statement.Schema.LookUpField(column)
+			if d, ok := rv.FieldByIndex(field.StructField.Index).Interface().(interface{ Dirty() bool }); ok && !d.Dirty() {
+				db.Statement.Omits = append(db.Statement.Omits, column)
+			}
+		}
+	}
+	db.InstanceSet(jsonPathPendingKey, pending)
+}
+
+// rewriteUpdateMap 将 Updates(map) 中的虚拟字段替换为对 JSON 列的 JSON_SET
+func (p *JSONPathPlugin) rewriteUpdateMap(db *gorm.DB, fields []*jsonPathField, dest map[string]interface{}) {
+	assignments := map[string][]JSONAssignment{}
+	updated := make(map[string]interface{}, len(dest))
+	keys := make([]string, 0, len(dest))
+	for k := range dest {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		f := lookupJSONPathField(fields, k)
+		if f == nil {
+			updated[k] = dest[k]
+			continue
+		}
+		assignment, err := NewJSONAssignment(f.path, dest[k])
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+		assignments[f.column] = append(assignments[f.column], assignment)
+	}
+	if len(assignments) == 0 {
+		return
+	}
+
+	dialect := JSONDialectOf(db)
+	for column, list := range assignments {
+		if _, ok := updated[column]; ok {
+			db.AddError(fmt.Errorf("cannot update column %s and its virtual fields at the same time", column))
+			return
+		}
+		updated[column] = dialect.Set(dialect.Document(column), list)
+	}
+	db.Statement.Dest = updated
+}
+
+// afterUpdate 以与本次更新相同的 WHERE 条件，通过 JSON_SET 写回被修改的虚拟字段，多个 JSON 列在一次 UPDATE 中写回
+func (p *JSONPathPlugin) afterUpdate(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	v, ok := db.InstanceGet(jsonPathPendingKey)
+	if !ok {
+		return
+	}
+	pending := v.(*jsonPathPending)
+	where, ok := db.Statement.Clauses["WHERE"]
+	if !ok {
+		db.AddError(gorm.ErrMissingWhereClause)
+		return
+	}
+
+	dialect := JSONDialectOf(db)
+	columns := make([]string, 0, len(pending.assignments))
+	updates := make(map[string]interface{}, len(pending.assignments))
+	for column, assignments := range pending.assignments {
+		columns = append(columns, column)
+		updates[column] = dialect.Set(dialect.Document(column), assignments)
+	}
+	sort.Strings(columns)
+	// 通过模型的 Updates 写回，版本号、校验、变更历史等钩子照常执行；WHERE 中已包含原模型的软删除条件
+	err := db.Session(&gorm.Session{NewDB: true}).
+		Model(jsonPathHookModelOf(db.Statement)).
+		Unscoped().
+		Clauses(where.Expression).
+		Updates(updates).Error
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	if !pending.target.IsValid() {
+		return
+	}
+	for _, column := range columns {
+		if err := syncJSONField(db, pending.target, column, pending.assignments[column]); err != nil {
+			db.AddError(err)
+			return
+		}
+	}
+}
+
+// JSONPathHookModel 由带虚拟字段的模型实现，返回与其共用同一张表、带有更新钩子的模型
+//
+// 如 ApprovalMWithVirtualFields 返回 &ApprovalM{}，虚拟字段通过 ApprovalM 的 Updates 写回；
+// 未实现时使用本次更新的模型类型。
+type JSONPathHookModel interface {
+	JSONPathHookModel() any
+}
+
+// jsonPathHookModelOf 返回写回虚拟字段时使用的模型
+func jsonPathHookModelOf(stmt *gorm.Statement) any {
+	model := reflect.New(stmt.Schema.ModelType).Interface()
+	if m, ok := model.(JSONPathHookModel); ok {
+		return m.JSONPathHookModel()
+	}
+	return model
+}
+
+// syncJSONField 写回成功后把赋值应用到内存中的 JSON 列，使结构体与数据库一致
+func syncJSONField(db *gorm.DB, rv reflect.Value, column string, assignments []JSONAssignment) error {
+	doc, err := jsonFieldDocument(db, rv, column)
+	if err != nil {
+		return err
+	}
+	for _, a := range assignments {
+		var value any
+		if err := json.Unmarshal(a.Value, &value); err != nil {
+			return err
+		}
+		if doc, err = setJSONValue(doc, a.Path.segments, value); err != nil {
+			return fmt.Errorf("set %s: %w", a.Path, err)
+		}
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	field := db.Statement.Schema.LookUpField(column)
+	if scanner, ok := rv.FieldByIndex(field.StructField.Index).Addr().Interface().(sql.Scanner); ok {
+		return scanner.Scan(data)
+	}
+	return nil
+}
+
+// jsonFieldDocument 解码结构体中 column 对应字段的 JSON
+func jsonFieldDocument(db *gorm.DB, rv reflect.Value, column string) (any, error) {
+	field := db.Statement.Schema.LookUpField(column)
+	if field == nil {
+		return nil, fmt.Errorf("unknown json column %q", column)
+	}
+	data, err := jsonFieldBytes(rv.FieldByIndex(field.StructField.Index).Interface())
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSONDocument(data)
+}
+
+// jsonFieldBytes 取出 JSONColumn、datatypes.JSON、[]byte 等 JSON 字段的原始内容
+func jsonFieldBytes(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case interface{ Bytes() ([]byte, error) }:
+		return v.Bytes()
+	case driver.Valuer:
+		value, err := v.Value()
+		if err != nil {
+			return nil, err
+		}
+		switch value := value.(type) {
+		case []byte:
+			return value, nil
+		case string:
+			return []byte(value), nil
+		}
+		return nil, nil
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("unsupported json field type %T", v)
+	}
+}
+
+// jsonPathFieldChanged 比较虚拟字段与 JSON 中的值，路径不存在且字段为零值时视为未修改
+func jsonPathFieldChanged(doc any, path JSONPath, value reflect.Value) bool {
+	current := lookupJSONPath(doc, path)
+	if current == nil {
+		return !value.IsZero()
+	}
+	want, err := json.Marshal(value.Interface())
+	if err != nil {
+		return true
+	}
+	got, err := json.Marshal(current)
+	if err != nil {
+		return true
+	}
+	return !bytes.Equal(want, got)
+}
+
+// eachStruct 遍历查询结果中的每个结构体
+func eachStruct(rv reflect.Value, fn func(rv reflect.Value)) {
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			eachStruct(rv.Index(i), fn)
+		}
+	case reflect.Struct:
+		if rv.CanAddr() {
+			fn(rv)
+		}
+	}
+}
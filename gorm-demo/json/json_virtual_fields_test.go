@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// openVirtualFieldDB 打开注册了 JSONPathPlugin 的测试数据库
+func openVirtualFieldDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := openTestDB(t)
+	if err := db.Use(NewJSONPathPlugin()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// approvalVersion 读取 ApprovalM 的版本号
+func approvalVersion(t *testing.T, db *gorm.DB, instanceID string) uint64 {
+	t.Helper()
+	var a ApprovalM
+	if err := db.Where("instance_id = ?", instanceID).First(&a).Error; err != nil {
+		t.Fatal(err)
+	}
+	return a.Version
+}
+
+func TestJSONPathPopulateAndWhere(t *testing.T) {
+	db := openVirtualFieldDB(t)
+	seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending, UserID: "u1"})
+	seedApproval(t, db, "i2", LarkApproval{ApprovalName: "b", Status: ApprovalStatusApproved})
+
+	var got []ApprovalMWithVirtualFields
+	if err := db.Where(map[string]any{"status": ApprovalStatusApproved}).Find(&got).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].InstanceID != "i2" || got[0].ApprovalName != "b" || got[0].UserID != "" {
+		t.Errorf("status = APPROVED: %+v", got)
+	}
+
+	// Go 字段名同样可用；路径不存在时字段为零值
+	var a ApprovalMWithVirtualFields
+	if err := db.Where(map[string]any{"ApprovalName": "a"}).First(&a).Error; err != nil {
+		t.Fatal(err)
+	}
+	if a.InstanceID != "i1" || a.Status != ApprovalStatusPending || a.UserID != "u1" {
+		t.Errorf("approval_name = a: %+v", a)
+	}
+}
+
+func TestJSONPathSaveRunsApprovalHooks(t *testing.T) {
+	db := openVirtualFieldDB(t)
+	seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending, UserID: "u1"})
+
+	var a ApprovalMWithVirtualFields
+	if err := db.Where("instance_id = ?", "i1").First(&a).Error; err != nil {
+		t.Fatal(err)
+	}
+	a.ApprovalName, a.Status = "a2", "approved"
+	if err := db.Save(&a).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 状态经 ApprovalM 的钩子统一为大写，未修改的路径保留
+	if data := tenantLarkData(t, db, "", "i1"); data.ApprovalName != "a2" || data.Status != ApprovalStatusApproved || data.UserID != "u1" {
+		t.Errorf("lark_data = %+v", data)
+	}
+	if data, err := a.LarkData.Get(); err != nil || data.ApprovalName != "a2" {
+		t.Errorf("lark_data in memory = %+v, %v", data, err)
+	}
+	if v := approvalVersion(t, db, "i1"); v != 1 {
+		t.Errorf("version = %d, want 1", v)
+	}
+	histories, err := FindApprovalHistory(db, "i1")
+	if err != nil || len(histories) != 2 || histories[1].Operation != HistoryOperationUpdate {
+		t.Fatalf("histories = %+v, %v", histories, err)
+	}
+
+	// 钩子返回错误时整个 Save 回滚
+	a.Status = "WAITING"
+	if err := db.Save(&a).Error; !errors.Is(err, ErrUnknownStatus) {
+		t.Fatalf("err = %v, want ErrUnknownStatus", err)
+	}
+	if data := tenantLarkData(t, db, "", "i1"); data.Status != ApprovalStatusApproved {
+		t.Errorf("status = %s after failed save", data.Status)
+	}
+	if histories, _ := FindApprovalHistory(db, "i1"); len(histories) != 2 {
+		t.Errorf("histories = %d after failed save", len(histories))
+	}
+}
+
+func TestJSONPathUpdates(t *testing.T) {
+	db := openVirtualFieldDB(t)
+	seedApproval(t, db, "i1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	seedApproval(t, db, "i2", LarkApproval{ApprovalName: "b", Status: ApprovalStatusPending})
+
+	// Updates(map) 中的虚拟字段替换为 JSON_SET
+	err := db.Model(&ApprovalMWithVirtualFields{}).Where("instance_id = ?", "i1").
+		Updates(map[string]any{"user_id": "u1", "approval_code": "code2"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := tenantLarkData(t, db, "", "i1"); data.UserID != "u1" || data.ApprovalName != "a" {
+		t.Errorf("lark_data = %+v", data)
+	}
+	err = db.Model(&ApprovalMWithVirtualFields{}).Where("instance_id = ?", "i1").
+		Updates(map[string]any{"user_id": "u2", "lark_data": NewJSONColumn(LarkApproval{})}).Error
+	if err == nil {
+		t.Error("updating lark_data and its virtual fields together should fail")
+	}
+
+	// Updates(struct) 只写回非零的虚拟字段，同样经过 ApprovalM 的钩子
+	var b ApprovalMWithVirtualFields
+	if err := db.Where("instance_id = ?", "i2").First(&b).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&b).Updates(ApprovalMWithVirtualFields{UserID: "u3"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if data := tenantLarkData(t, db, "", "i2"); data.UserID != "u3" || data.ApprovalName != "b" {
+		t.Errorf("lark_data = %+v", data)
+	}
+	if v := approvalVersion(t, db, "i2"); v != 1 {
+		t.Errorf("version = %d, want 1", v)
+	}
+}
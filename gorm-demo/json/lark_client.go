@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLarkBaseURL 飞书开放平台地址，Lark 国际版为 https://open.larksuite.com
+	DefaultLarkBaseURL = "https://open.feishu.cn"
+	// LarkMaxPageSize 审批实例列表接口每页最多的记录数
+	LarkMaxPageSize = 100
+
+	// tenant_access_token 过期前提前刷新的时间
+	larkTokenRefreshMargin = 5 * time.Minute
+)
+
+// 需要重新获取 tenant_access_token 的错误码
+var larkInvalidTokenCodes = []int{99991661, 99991663, 99991664, 99991665}
+
+// LarkAPIError 飞书开放平台返回的业务错误
+type LarkAPIError struct {
+	Code int
+	Msg  string
+}
+
+func (e *LarkAPIError) Error() string {
+	return fmt.Sprintf("lark api error %d: %s", e.Code, e.Msg)
+}
+
+// LarkClient 飞书审批 v4 接口客户端，tenant_access_token 在过期前缓存复用
+//   - https://open.feishu.cn/document/server-docs/authentication-management/access-token/tenant_access_token_internal
+//   - https://open.feishu.cn/document/server-docs/approval-v4/instance/list
+//   - https://open.feishu.cn/document/server-docs/approval-v4/instance/get
+type LarkClient struct {
+	BaseURL   string
+	AppID     string
+	AppSecret string
+	Client    *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpire time.Time
+}
+
+// NewLarkClient 创建飞书客户端
+func NewLarkClient(appID, appSecret string) *LarkClient {
+	return &LarkClient{
+		BaseURL:   DefaultLarkBaseURL,
+		AppID:     appID,
+		AppSecret: appSecret,
+		Client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// LarkInstancePage 审批实例 Code 列表的一页
+type LarkInstancePage struct {
+	InstanceCodes []string `json:"instance_code_list"`
+	PageToken     string   `json:"page_token"`
+	HasMore       bool     `json:"has_more"`
+}
+
+// LarkListInstancesRequest 审批实例列表查询参数
+type LarkListInstancesRequest struct {
+	ApprovalCode string
+	StartTime    time.Time // 按审批实例创建时间过滤，包含
+	EndTime      time.Time
+	PageSize     int    // 为 0 或超过 LarkMaxPageSize 时按 LarkMaxPageSize
+	PageToken    string // 上一页的 PageToken
+}
+
+// TenantAccessToken 返回缓存的 tenant_access_token，即将过期时重新获取
+func (c *LarkClient) TenantAccessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Before(c.tokenExpire) {
+		return c.token, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"app_id": c.AppID, "app_secret": c.AppSecret})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/open-apis/auth/v3/tenant_access_token/internal", nil), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	var resp struct {
+		Code   int    `json:"code"`
+		Msg    string `json:"msg"`
+		Token  string `json:"tenant_access_token"`
+		Expire int    `json:"expire"` // 剩余有效期，单位秒
+	}
+	if err := c.send(req, &resp); err != nil {
+		return "", fmt.Errorf("get tenant access token: %w", err)
+	}
+	if resp.Code != 0 {
+		return "", fmt.Errorf("get tenant access token: %w", &LarkAPIError{Code: resp.Code, Msg: resp.Msg})
+	}
+	c.token = resp.Token
+	c.tokenExpire = time.Now().Add(time.Duration(resp.Expire)*time.Second - larkTokenRefreshMargin)
+	return c.token, nil
+}
+
+// invalidateToken 丢弃缓存的 token，只有与 token 相同时才丢弃，避免覆盖其他请求刚刷新的 token
+func (c *LarkClient) invalidateToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token == token {
+		c.token = ""
+	}
+}
+
+// ListInstances 查询审批定义下的一页审批实例 Code
+func (c *LarkClient) ListInstances(ctx context.Context, r LarkListInstancesRequest) (*LarkInstancePage, error) {
+	size := r.PageSize
+	if size <= 0 || size > LarkMaxPageSize {
+		size = LarkMaxPageSize
+	}
+	query := url.Values{}
+	query.Set("approval_code", r.ApprovalCode)
+	query.Set("start_time", strconv.FormatInt(r.StartTime.UnixMilli(), 10))
+	query.Set("end_time", strconv.FormatInt(r.EndTime.UnixMilli(), 10))
+	query.Set("page_size", strconv.Itoa(size))
+	if r.PageToken != "" {
+		query.Set("page_token", r.PageToken)
+	}
+
+	var page LarkInstancePage
+	if err := c.get(ctx, "/open-apis/approval/v4/instances", query, &page); err != nil {
+		return nil, fmt.Errorf("list lark instances of %s: %w", r.ApprovalCode, err)
+	}
+	return &page, nil
+}
+
+// InstanceCodes 逐页返回 [start, end) 内创建的审批实例 Code，出错时返回 ("", err) 后结束
+func (c *LarkClient) InstanceCodes(ctx context.Context, approvalCode string, start, end time.Time) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		req := LarkListInstancesRequest{ApprovalCode: approvalCode, StartTime: start, EndTime: end}
+		for {
+			page, err := c.ListInstances(ctx, req)
+			if err != nil {
+				yield("", err)
+				return
+			}
+			for _, code := range page.InstanceCodes {
+				if !yield(code, nil) {
+					return
+				}
+			}
+			if !page.HasMore || page.PageToken == "" {
+				return
+			}
+			req.PageToken = page.PageToken
+		}
+	}
+}
+
+// GetInstance 查询审批实例详情，返回接口中 data 的原始 JSON，可直接写入 ApprovalM.LarkData
+func (c *LarkClient) GetInstance(ctx context.Context, instanceCode string) (json.RawMessage, error) {
+	var data json.RawMessage
+	if err := c.get(ctx, "/open-apis/approval/v4/instances/"+url.PathEscape(instanceCode), nil, &data); err != nil {
+		return nil, fmt.Errorf("get lark instance %s: %w", instanceCode, err)
+	}
+	return data, nil
+}
+
+// get 以 tenant_access_token 调用 GET 接口并把 data 解析到 out，token 失效时刷新后重试一次
+func (c *LarkClient) get(ctx context.Context, path string, query url.Values, out any) error {
+	for attempt := 0; ; attempt++ {
+		token, err := c.TenantAccessToken(ctx)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(path, query), nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		var resp struct {
+			Code int             `json:"code"`
+			Msg  string          `json:"msg"`
+			Data json.RawMessage `json:"data"`
+		}
+		if err := c.send(req, &resp); err != nil {
+			return err
+		}
+		if resp.Code != 0 {
+			apiErr := &LarkAPIError{Code: resp.Code, Msg: resp.Msg}
+			if attempt == 0 && slices.Contains(larkInvalidTokenCodes, resp.Code) {
+				c.invalidateToken(token)
+				continue
+			}
+			return apiErr
+		}
+		if err := json.Unmarshal(resp.Data, out); err != nil {
+			return fmt.Errorf("decode lark response data: %w", err)
+		}
+		return nil
+	}
+}
+
+// send 发送请求并解析响应体，飞书在 4xx/5xx 时同样返回带 code 的 JSON，只有无法解析时才按 HTTP 状态报错
+func (c *LarkClient) send(req *http.Request, out any) error {
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("lark api status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		}
+		return fmt.Errorf("decode lark response: %w", err)
+	}
+	return nil
+}
+
+func (c *LarkClient) url(path string, query url.Values) string {
+	u := strings.TrimRight(c.BaseURL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
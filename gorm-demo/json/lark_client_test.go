@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLark 模拟飞书开放平台的 token、审批实例列表和详情接口
+type fakeLark struct {
+	mu         sync.Mutex
+	tokens     int                         // 已签发的 token 数
+	revoked    map[string]bool             // 已失效的 token
+	pages      map[string]LarkInstancePage // page_token -> 该页
+	pageTokens []string                    // 列表接口收到的 page_token
+	instances  map[string]LarkApproval     // instance_code -> 详情
+}
+
+func newFakeLark(t *testing.T) (*fakeLark, *LarkClient) {
+	t.Helper()
+	f := &fakeLark{revoked: map[string]bool{}, pages: map[string]LarkInstancePage{}, instances: map[string]LarkApproval{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	c := NewLarkClient("app", "secret")
+	c.BaseURL = srv.URL
+	return f, c
+}
+
+// setPages 按顺序设置实例列表的各页，每页之间以 p1、p2... 衔接
+func (f *fakeLark) setPages(pages ...[]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pages = map[string]LarkInstancePage{}
+	for i, codes := range pages {
+		token := ""
+		if i > 0 {
+			token = "p" + strconv.Itoa(i)
+		}
+		page := LarkInstancePage{InstanceCodes: codes}
+		if i < len(pages)-1 {
+			page.HasMore, page.PageToken = true, "p"+strconv.Itoa(i+1)
+		}
+		f.pages[token] = page
+	}
+}
+
+func (f *fakeLark) setInstance(code string, instance LarkApproval) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.instances[code] = instance
+}
+
+func (f *fakeLark) revoke(token string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.revoked[token] = true
+}
+
+func (f *fakeLark) tokenCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tokens
+}
+
+func (f *fakeLark) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reply := func(code int, data any) {
+		json.NewEncoder(w).Encode(map[string]any{"code": code, "msg": "fake", "data": data})
+	}
+
+	if r.URL.Path == "/open-apis/auth/v3/tenant_access_token/internal" {
+		f.tokens++
+		json.NewEncoder(w).Encode(map[string]any{"code": 0, "tenant_access_token": "t" + strconv.Itoa(f.tokens), "expire": 7200})
+		return
+	}
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token == "" || f.revoked[token] {
+		w.WriteHeader(http.StatusBadRequest)
+		reply(99991663, nil)
+		return
+	}
+	switch path := r.URL.Path; {
+	case path == "/open-apis/approval/v4/instances":
+		token := r.URL.Query().Get("page_token")
+		f.pageTokens = append(f.pageTokens, token)
+		page, ok := f.pages[token]
+		if !ok {
+			reply(1390001, nil)
+			return
+		}
+		reply(0, page)
+	case strings.HasPrefix(path, "/open-apis/approval/v4/instances/"):
+		instance, ok := f.instances[strings.TrimPrefix(path, "/open-apis/approval/v4/instances/")]
+		if !ok {
+			reply(1390002, nil)
+			return
+		}
+		reply(0, instance)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestLarkClientCachesToken(t *testing.T) {
+	f, c := newFakeLark(t)
+	f.setInstance("c1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	for range 3 {
+		if _, err := c.GetInstance(t.Context(), "c1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := f.tokenCount(); n != 1 {
+		t.Errorf("token requests = %d, want 1", n)
+	}
+
+	// 即将过期的 token 会被重新获取
+	c.tokenExpire = time.Now().Add(-time.Second)
+	token, err := c.TenantAccessToken(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "t2" || f.tokenCount() != 2 {
+		t.Errorf("token = %s after %d requests, want t2 after 2", token, f.tokenCount())
+	}
+}
+
+func TestLarkClientRefreshesInvalidToken(t *testing.T) {
+	f, c := newFakeLark(t)
+	f.setInstance("c1", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	if _, err := c.GetInstance(t.Context(), "c1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 缓存的 token 被服务端提前作废，返回 99991663 后刷新 token 重试一次
+	f.revoke("t1")
+	data, err := c.GetInstance(t.Context(), "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got LarkApproval
+	if err := json.Unmarshal(data, &got); err != nil || got.ApprovalName != "a" {
+		t.Errorf("instance = %s, %v", data, err)
+	}
+	if n := f.tokenCount(); n != 2 {
+		t.Errorf("token requests = %d, want 2", n)
+	}
+
+	// 刷新后的 token 仍然无效时不再重试
+	f.revoke("t2")
+	f.revoke("t3")
+	var apiErr *LarkAPIError
+	if _, err := c.GetInstance(t.Context(), "c1"); !errors.As(err, &apiErr) || apiErr.Code != 99991663 {
+		t.Fatalf("err = %v, want lark api error 99991663", err)
+	}
+	if n := f.tokenCount(); n != 3 {
+		t.Errorf("token requests = %d, want 3", n)
+	}
+}
+
+func TestLarkClientInstanceCodes(t *testing.T) {
+	f, c := newFakeLark(t)
+	f.setPages([]string{"c1", "c2"}, []string{"c3"}, []string{"c4"})
+
+	var codes []string
+	for code, err := range c.InstanceCodes(t.Context(), "code", time.Now().Add(-time.Hour), time.Now()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		codes = append(codes, code)
+	}
+	if want := []string{"c1", "c2", "c3", "c4"}; !slices.Equal(codes, want) {
+		t.Errorf("codes = %v, want %v", codes, want)
+	}
+	if want := []string{"", "p1", "p2"}; !slices.Equal(f.pageTokens, want) {
+		t.Errorf("page tokens = %v, want %v", f.pageTokens, want)
+	}
+
+	// 提前结束遍历时不再请求后面的页
+	f.pageTokens = nil
+	for range c.InstanceCodes(t.Context(), "code", time.Now().Add(-time.Hour), time.Now()) {
+		break
+	}
+	if want := []string{""}; !slices.Equal(f.pageTokens, want) {
+		t.Errorf("page tokens after break = %v, want %v", f.pageTokens, want)
+	}
+
+	// 列表接口出错时返回错误并结束
+	f.setPages([]string{"c1"}, []string{"c2"})
+	delete(f.pages, "p1")
+	var gotErr error
+	codes = nil
+	for code, err := range c.InstanceCodes(t.Context(), "code", time.Now().Add(-time.Hour), time.Now()) {
+		if err != nil {
+			gotErr = err
+			continue
+		}
+		codes = append(codes, code)
+	}
+	var apiErr *LarkAPIError
+	if !errors.As(gotErr, &apiErr) || !slices.Equal(codes, []string{"c1"}) {
+		t.Errorf("codes = %v, err = %v", codes, gotErr)
+	}
+}
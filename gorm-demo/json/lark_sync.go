@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultLarkSyncWindow LarkSyncWorker 每轮同步最近创建的审批实例的时间范围
+	DefaultLarkSyncWindow = 7 * 24 * time.Hour
+	// LarkSyncActor 同步写入的审批历史的操作人
+	LarkSyncActor = "lark-sync"
+)
+
+// LarkSyncResult 一次同步的结果
+type LarkSyncResult struct {
+	Created   int // 新建的记录数
+	Updated   int // lark_data 发生变化而更新的记录数
+	Unchanged int // lark_data 与库中相同而跳过的记录数
+	Failed    int // 拉取或写入失败的记录数，下一轮同步时会重试
+}
+
+func (r *LarkSyncResult) add(o LarkSyncResult) {
+	r.Created += o.Created
+	r.Updated += o.Updated
+	r.Unchanged += o.Unchanged
+	r.Failed += o.Failed
+}
+
+// LarkSyncWorker 按审批定义和创建时间拉取飞书审批实例，写入 ApprovalM
+//
+// 每个实例按 instance_id 查找最近一条记录：
+//   - 不存在时新建，飞书状态为 DELETED/CANCELED 时以软删除状态写入
+//   - lark_data 与库中语义相同时跳过，不增加 version，不重新写入 ES
+//   - 否则以 version 为条件更新，并发修改时本轮记为失败，下一轮重试
+//   - 最近一条已软删除且飞书状态不是 DELETED/CANCELED 时新建一条记录（见 ApprovalLifecycle）
+//
+// 写入经过 ApprovalM 的钩子，lark_data 校验、变更历史和 ES 重新索引与其他写入方式一致。
+type LarkSyncWorker struct {
+	DB            *gorm.DB
+	Client        *LarkClient
+	ApprovalCodes []string      // Run 时同步的审批定义
+	Window        time.Duration // Run 时每轮同步 [now - Window, now) 内创建的实例
+	TenantID      string        // 不为空时写入该租户（见 TenantPlugin），每个飞书应用对应一个租户
+}
+
+// NewLarkSyncWorker 创建同步任务
+func NewLarkSyncWorker(db *gorm.DB, client *LarkClient, approvalCodes ...string) *LarkSyncWorker {
+	return &LarkSyncWorker{
+		DB:            db,
+		Client:        client,
+		ApprovalCodes: approvalCodes,
+		Window:        DefaultLarkSyncWindow,
+	}
+}
+
+// Run 每隔 interval 同步一次全部审批定义，直到 ctx 结束
+func (w *LarkSyncWorker) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		end := time.Now()
+		start := end.Add(-w.Window)
+		for _, code := range w.ApprovalCodes {
+			result, err := w.Sync(ctx, code, start, end)
+			if err != nil {
+				slog.Error("sync lark approvals failed", "approval_code", code, "result", result, "error", err.Error())
+			} else if result.Created+result.Updated+result.Failed > 0 {
+				slog.Info("sync lark approvals", "approval_code", code, "result", result)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync 同步 approvalCode 下 [start, end) 内创建的审批实例
+//
+// 单个实例拉取或写入失败时记录日志并跳过；列表接口失败时中止并返回错误。
+func (w *LarkSyncWorker) Sync(ctx context.Context, approvalCode string, start, end time.Time) (LarkSyncResult, error) {
+	var result LarkSyncResult
+	for instanceCode, err := range w.Client.InstanceCodes(ctx, approvalCode, start, end) {
+		if err != nil {
+			return result, err
+		}
+		r, err := w.SyncInstance(ctx, approvalCode, instanceCode)
+		if err != nil {
+			slog.Warn("sync lark instance failed", "approval_code", approvalCode, "instance_code", instanceCode, "error", err.Error())
+			r.Failed++
+		}
+		result.add(r)
+	}
+	return result, nil
+}
+
+// SyncInstance 拉取单个审批实例并写入，返回的结果中只有一项为 1
+func (w *LarkSyncWorker) SyncInstance(ctx context.Context, approvalCode, instanceCode string) (LarkSyncResult, error) {
+	var result LarkSyncResult
+	data, err := w.Client.GetInstance(ctx, instanceCode)
+	if err != nil {
+		return result, err
+	}
+	var instance LarkApproval
+	if err := json.Unmarshal(data, &instance); err != nil {
+		return result, fmt.Errorf("decode lark instance %s: %w", instanceCode, err)
+	}
+	// ApprovalM.InstanceID 为飞书 uuid，创建实例时未指定 uuid 的使用实例 Code
+	instanceID := instance.UUID
+	if instanceID == "" {
+		instanceID = instanceCode
+	}
+	if instance.ApprovalCode != "" {
+		approvalCode = instance.ApprovalCode
+	}
+
+	if w.TenantID != "" {
+		ctx = WithTenant(ctx, w.TenantID)
+	}
+	// Session 使后续链式调用各自复制 Statement，同时保留操作人设置
+	db := WithApprovalActor(w.DB.WithContext(ctx), LarkSyncActor).Session(&gorm.Session{})
+	var existing ApprovalM
+	err = db.Unscoped().Where("instance_id = ?", instanceID).Order("id DESC").First(&existing).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+	case err != nil:
+		return result, err
+	case !existing.DeletedAt.Valid || slices.Contains(ApprovalDeletedStatuses, instance.Status):
+		return w.update(db, &existing, approvalCode, data)
+	}
+
+	approval := &ApprovalM{
+		InstanceID:   instanceID,
+		ApprovalCode: approvalCode,
+		Type:         ApprovalTypeLark,
+		LarkData:     RawJSONColumn[LarkApproval](data),
+	}
+	if err := db.Create(approval).Error; err != nil {
+		return result, fmt.Errorf("create approval %s: %w", instanceID, err)
+	}
+	result.Created++
+	return result, nil
+}
+
+// update lark_data 发生变化时以 version 为条件更新已有记录
+func (w *LarkSyncWorker) update(db *gorm.DB, existing *ApprovalM, approvalCode string, data []byte) (LarkSyncResult, error) {
+	var result LarkSyncResult
+	current, err := existing.LarkData.Bytes()
+	if err != nil {
+		return result, err
+	}
+	if len(current) > 0 {
+		diff, err := DiffJSON(current, data)
+		if err != nil {
+			return result, fmt.Errorf("diff lark_data of %s: %w", existing.InstanceID, err)
+		}
+		if len(diff) == 0 && existing.ApprovalCode == approvalCode {
+			result.Unchanged++
+			return result, nil
+		}
+	}
+
+	tx := db.Unscoped().Model(existing).Where("version = ?", existing.Version).Updates(map[string]any{
+		"approval_code": approvalCode,
+		"lark_data":     RawJSONColumn[LarkApproval](data),
+		"version":       incrementVersion(),
+	})
+	if tx.Error != nil {
+		return result, fmt.Errorf("update approval %s: %w", existing.InstanceID, tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return result, &StaleApprovalError{InstanceID: existing.InstanceID, Version: existing.Version}
+	}
+	result.Updated++
+	return result, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLarkSyncUpserts(t *testing.T) {
+	db := openTestDB(t)
+	f, c := newFakeLark(t)
+	f.setPages([]string{"c1", "c2"}, []string{"c3"})
+	f.setInstance("c1", LarkApproval{ApprovalName: "报销", Status: ApprovalStatusPending, UUID: "u1", ApprovalCode: "code"})
+	f.setInstance("c2", LarkApproval{ApprovalName: "采购", Status: ApprovalStatusPending, ApprovalCode: "code"})
+	f.setInstance("c3", LarkApproval{ApprovalName: "请假", Status: ApprovalStatusCanceled, ApprovalCode: "code"})
+
+	w := NewLarkSyncWorker(db, c, "code")
+	sync := func(want LarkSyncResult) {
+		t.Helper()
+		result, err := w.Sync(t.Context(), "code", time.Now().Add(-time.Hour), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != want {
+			t.Errorf("result = %+v, want %+v", result, want)
+		}
+	}
+
+	sync(LarkSyncResult{Created: 3})
+	// 未指定 uuid 的实例以实例 Code 作为 instance_id，已撤回的实例以软删除状态写入
+	var approvals []*ApprovalM
+	if err := db.Unscoped().Order("id").Find(&approvals).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(approvals) != 3 {
+		t.Fatalf("approvals = %d, want 3", len(approvals))
+	}
+	for i, want := range []struct {
+		instanceID string
+		deleted    bool
+	}{{"u1", false}, {"c2", false}, {"c3", true}} {
+		a := approvals[i]
+		if a.InstanceID != want.instanceID || a.DeletedAt.Valid != want.deleted || a.ApprovalCode != "code" {
+			t.Errorf("approval %d = %s deleted=%v code=%s, want %s deleted=%v", i, a.InstanceID, a.DeletedAt.Valid, a.ApprovalCode, want.instanceID, want.deleted)
+		}
+	}
+
+	// 数据没有变化时跳过，不增加 version
+	sync(LarkSyncResult{Unchanged: 3})
+
+	f.setInstance("c2", LarkApproval{ApprovalName: "采购", Status: ApprovalStatusApproved, ApprovalCode: "code"})
+	sync(LarkSyncResult{Updated: 1, Unchanged: 2})
+
+	var c2 ApprovalM
+	if err := db.Where("instance_id = ?", "c2").First(&c2).Error; err != nil {
+		t.Fatal(err)
+	}
+	data, err := c2.LarkData.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Status != ApprovalStatusApproved || c2.Version != 1 {
+		t.Errorf("c2 status = %s version = %d, want APPROVED version 1", data.Status, c2.Version)
+	}
+
+	histories, err := FindApprovalHistory(db, "c2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(histories) != 2 || histories[1].Actor != LarkSyncActor {
+		t.Errorf("histories of c2 = %d, want create and update by %s", len(histories), LarkSyncActor)
+	}
+
+	// 单个实例拉取失败时记为失败，不影响其他实例
+	delete(f.instances, "c1")
+	sync(LarkSyncResult{Unchanged: 2, Failed: 1})
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 
-	"gorm.io/datatypes"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // 这里需要确保结构体定义，因为它们在 approval.go 中定义但需要在 main.go 中使用
@@ -18,6 +25,53 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	// 注册状态变化插件，通过 Veto 检查、Subscribe 订阅审批和任务的状态变化；它包装连接池以得知事务提交，需要最先注册
+	if err := db.Use(NewTransitionPlugin()); err != nil {
+		panic(err)
+	}
+	// 设置了 APPROVAL_ENCRYPTION_KEYS 时注册字段加密插件，加密 lark_data 中的表单和评论；需要在虚拟字段插件之前注册
+	if keys, err := encryptionKeysFromEnv(); err != nil {
+		panic(err)
+	} else if keys != nil {
+		if err := db.Use(NewFieldEncryptionPlugin(keys, LarkDataEncryptedFields)); err != nil {
+			panic(err)
+		}
+	}
+	// 注册虚拟字段插件，ApprovalMWithVirtualFields 依赖它填充和写回 jsonpath 字段
+	if err := db.Use(NewJSONPathPlugin()); err != nil {
+		panic(err)
+	}
+	// 注册列规则插件，UpdateColumn(s) 等跳过钩子的更新同样按 ApprovalColumnRules 校验不可变、非空和可空列
+	if err := db.Use(NewColumnRulesPlugin(ApprovalColumnRules)); err != nil {
+		panic(err)
+	}
+	// 设置了 APPROVAL_FORM_PROJECTION（逗号分隔的控件 custom_id 或名称，* 表示全部）时注册表单投影插件，
+	// ApprovalFilter.Form 据此按表单字段过滤；开启后执行 go run . reproject 补齐存量数据
+	if fields := os.Getenv("APPROVAL_FORM_PROJECTION"); fields != "" {
+		var names []string
+		if fields != "*" {
+			names = strings.Split(fields, ",")
+		}
+		if err := db.Use(NewFormProjectionPlugin(names...)); err != nil {
+			panic(err)
+		}
+	}
+	// 注册租户插件，context 中带有租户（WithTenant）时所有读写只访问该租户的记录
+	if err := db.Use(NewTenantPlugin(ApprovalTenantTables...)); err != nil {
+		panic(err)
+	}
+
+	// go run . export|import|reencrypt|reproject ... 执行导入导出、重新加密、重建表单投影命令，不运行演示
+	if len(os.Args) > 1 {
+		if err := runTransferCommand(db, os.Args[1:]); err != nil {
+			slog.Error("执行命令失败", "error", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 执行数据库迁移并检查模型与表结构是否一致
+	demoSchemaMigration(db)
 
 	// 演示必填字段验证功能（确保NOT NULL字段不能是零值或空值）
 	demoRequiredFieldValidation(db)
@@ -30,6 +84,251 @@ func main() {
 
 	// 演示如何使用 JSON 字段更新功能
 	demoJSONUpdateHelper(db)
+
+	// 演示软删除、恢复和过期清理
+	demoApprovalLifecycle(db)
+
+	// 演示从飞书拉取审批实例
+	demoLarkSync(db)
+
+	// 演示基于 lark_data 的审批统计
+	demoApprovalAnalytics(db)
+
+	// 演示通过 ApprovalRepository 读写，单元测试中可替换为 NewMemoryApprovalRepository()
+	demoApprovalRepository(NewGormApprovalRepository(db))
+
+	// 演示解析表单控件并按表单字段过滤
+	demoApprovalForm(db)
+}
+
+// encryptionKeysFromEnv 从 APPROVAL_ENCRYPTION_KEYS 读取主密钥，格式为逗号分隔的 "ID=base64 编码的 32 字节密钥"，
+// 最后一个为当前密钥。轮换时在末尾追加新密钥，执行 go run . reencrypt 后再删除旧密钥。未设置时返回 nil。
+func encryptionKeysFromEnv() (*StaticKeyProvider, error) {
+	env := os.Getenv("APPROVAL_ENCRYPTION_KEYS")
+	if env == "" {
+		return nil, nil
+	}
+	var keys *StaticKeyProvider
+	for _, item := range strings.Split(env, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("APPROVAL_ENCRYPTION_KEYS: invalid item %q, expected ID=base64", item)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("APPROVAL_ENCRYPTION_KEYS: key %q: %w", id, err)
+		}
+		if keys == nil {
+			keys = NewStaticKeyProvider(id, key)
+		} else {
+			keys.Rotate(id, key)
+		}
+	}
+	return keys, nil
+}
+
+// demoApprovalRepository 演示只依赖 ApprovalRepository 接口的业务代码
+func demoApprovalRepository(repo ApprovalRepository) {
+	slog.Info("开始演示 ApprovalRepository......")
+	ctx := context.Background()
+
+	approval := &ApprovalM{
+		InstanceID:   "repo_demo_1",
+		ApprovalCode: "repo_demo",
+		Type:         ApprovalTypeLark,
+		LarkData:     NewJSONColumn(LarkApproval{ApprovalName: "采购申请", Status: ApprovalStatusPending, UserID: "zhangsan"}),
+	}
+	if err := repo.Upsert(ctx, approval); err != nil {
+		slog.Error("保存审批失败", "error", err.Error())
+		return
+	}
+
+	patch := JSONPatch{{Op: "replace", Path: "/status", Value: json.RawMessage(`"` + ApprovalStatusApproved + `"`)}}
+	if err := repo.Patch(ctx, approval.InstanceID, patch); err != nil {
+		slog.Error("修改审批失败", "error", err.Error())
+		return
+	}
+
+	approvals, err := repo.Search(ctx, ApprovalQuery{
+		Filter: ApprovalFilter{ApprovalCode: "repo_demo", Statuses: []string{ApprovalStatusApproved}},
+		Sort:   []ApprovalSort{{Field: SortByCreatedAt, Desc: true}},
+		Limit:  10,
+	})
+	if err != nil {
+		slog.Error("查询审批失败", "error", err.Error())
+		return
+	}
+	slog.Info("查询到已通过的审批", "count", len(approvals))
+
+	if err := repo.Delete(ctx, approval.InstanceID); err != nil {
+		slog.Error("删除审批失败", "error", err.Error())
+	}
+}
+
+// demoApprovalForm 演示解析表单控件，以及按表单字段过滤审批（需要设置 APPROVAL_FORM_PROJECTION）
+func demoApprovalForm(db *gorm.DB) {
+	slog.Info("开始演示表单解析......")
+	var approval ApprovalM
+	if err := db.Where("type = ?", ApprovalTypeLark).Order("id DESC").First(&approval).Error; err != nil {
+		slog.Error("查询审批失败", "error", err.Error())
+		return
+	}
+	data, err := approval.LarkData.Get()
+	if err != nil {
+		slog.Error("解析 lark_data 失败", "error", err.Error())
+		return
+	}
+	form, err := data.ParseForm()
+	if err != nil {
+		// 个别控件解析失败时 form 中仍包含其余控件
+		slog.Warn("解析表单失败", "instance_id", approval.InstanceID, "error", err.Error())
+	}
+	for _, c := range form {
+		slog.Info("表单控件", "name", c.Name, "type", c.Type, "value", c.Value)
+	}
+
+	if formProjectionOf(db) == nil {
+		return
+	}
+	minAmount := 1000.0
+	var approvals []ApprovalM
+	err = db.Where(NewJSONQueryHelper(db).Filter(ApprovalFilter{
+		Form: []FormFieldMatch{{Field: "报销金额", Min: &minAmount}, {Field: "费用类型", Equals: "差旅"}},
+	})).Find(&approvals).Error
+	if err != nil {
+		slog.Error("按表单字段查询失败", "error", err.Error())
+		return
+	}
+	slog.Info("报销金额不少于 1000 的差旅报销", "count", len(approvals))
+}
+
+// demoApprovalAnalytics 演示节点耗时、审批人耗时、每日吞吐量、拒绝率和抄送规模统计
+func demoApprovalAnalytics(db *gorm.DB) {
+	slog.Info("开始统计审批数据......")
+	ctx := context.Background()
+	analytics := NewApprovalAnalytics(db)
+	// 只统计最近 30 天创建的记录
+	since := NewJSONQueryHelper(db).Filter(ApprovalFilter{CreatedAt: TimeRange{From: time.Now().AddDate(0, 0, -30)}})
+
+	nodes, err := analytics.NodeDurations(ctx, since)
+	if err != nil {
+		slog.Error("统计节点耗时失败", "error", err.Error())
+		return
+	}
+	for _, n := range nodes {
+		slog.Info("节点耗时", "node", n.NodeName, "tasks", n.Tasks, "avg", n.Avg, "max", n.Max)
+	}
+
+	approvers, err := analytics.ApproverDurations(ctx, since)
+	if err != nil {
+		slog.Error("统计审批人耗时失败", "error", err.Error())
+		return
+	}
+	for _, a := range approvers {
+		slog.Info("审批人耗时", "user_id", a.UserID, "tasks", a.Tasks, "avg", a.Avg, "max", a.Max)
+	}
+
+	days, err := analytics.DailyThroughput(ctx, since)
+	if err != nil {
+		slog.Error("统计每日吞吐量失败", "error", err.Error())
+		return
+	}
+	for _, d := range days {
+		slog.Info("每日吞吐量", "day", d.Day.Format(time.DateOnly), "started", d.Started, "finished", d.Finished, "approved", d.Approved, "rejected", d.Rejected)
+	}
+
+	rates, err := analytics.RejectionRates(ctx, since)
+	if err != nil {
+		slog.Error("统计拒绝率失败", "error", err.Error())
+		return
+	}
+	for _, r := range rates {
+		slog.Info("拒绝率", "approval_code", r.ApprovalCode, "finished", r.Finished, "rejected", r.Rejected, "rate", r.Rate)
+	}
+
+	fanOut, err := analytics.CCFanOut(ctx, since)
+	if err != nil {
+		slog.Error("统计抄送规模失败", "error", err.Error())
+		return
+	}
+	for _, f := range fanOut {
+		slog.Info("抄送规模", "approval_code", f.ApprovalCode, "instances", f.Instances, "avg", f.AvgRecipients, "max", f.MaxRecipients)
+	}
+}
+
+// demoLarkSync 演示从飞书开放平台同步审批实例，需要设置 LARK_APP_ID、LARK_APP_SECRET 和 LARK_APPROVAL_CODE
+func demoLarkSync(db *gorm.DB) {
+	appID, appSecret, approvalCode := os.Getenv("LARK_APP_ID"), os.Getenv("LARK_APP_SECRET"), os.Getenv("LARK_APPROVAL_CODE")
+	if appID == "" || appSecret == "" || approvalCode == "" {
+		slog.Info("未设置 LARK_APP_ID、LARK_APP_SECRET 或 LARK_APPROVAL_CODE，跳过飞书同步演示")
+		return
+	}
+	slog.Info("开始同步飞书审批实例......")
+
+	client := NewLarkClient(appID, appSecret)
+	worker := NewLarkSyncWorker(db, client, approvalCode)
+	// 同步最近一天创建的审批实例，常驻进程中使用 worker.Run(ctx, 10*time.Minute)
+	end := time.Now()
+	result, err := worker.Sync(context.Background(), approvalCode, end.Add(-24*time.Hour), end)
+	if err != nil {
+		slog.Error("同步飞书审批实例失败", "error", err.Error())
+		return
+	}
+	slog.Info("同步飞书审批实例完成", "created", result.Created, "updated", result.Updated, "unchanged", result.Unchanged, "failed", result.Failed)
+}
+
+// demoApprovalLifecycle 演示按飞书状态软删除、恢复和过期清理
+func demoApprovalLifecycle(db *gorm.DB) {
+	slog.Info("开始演示审批记录生命周期......")
+	ctx := context.Background()
+	// 软删除 30 天后归档到 ./archive 并硬删除，常驻进程中使用 lifecycle.Run(ctx, time.Hour)
+	lifecycle := NewApprovalLifecycle(db, 30*24*time.Hour, "archive")
+
+	// JSONUpdateHelper 通过 SQL 表达式修改 status 时钩子拿不到新值，由 SoftDeleteByStatus 补齐软删除
+	if n, err := lifecycle.SoftDeleteByStatus(ctx); err != nil {
+		slog.Error("按状态软删除失败", "error", err.Error())
+	} else {
+		slog.Info("按状态软删除完成", "deleted", n)
+	}
+
+	// 恢复软删除的记录，同一 instance_id 已有未删除记录时返回 ErrApprovalActive
+	if err := lifecycle.Undelete(ctx, "lark00011_0"); err != nil {
+		slog.Warn("恢复审批记录失败", "error", err.Error())
+	}
+
+	result, err := lifecycle.Purge(ctx)
+	if err != nil {
+		slog.Error("清理过期记录失败", "error", err.Error())
+		return
+	}
+	slog.Info("清理过期记录完成", "purged", result.Purged, "archive", result.ArchiveFile)
+}
+
+// demoSchemaMigration 演示如何执行 ddl.sql.tpl 中的迁移并检查表结构差异
+func demoSchemaMigration(db *gorm.DB) {
+	slog.Info("开始执行数据库迁移......")
+	ctx := context.Background()
+	migrator, err := NewSchemaMigrator(db)
+	if err != nil {
+		slog.Error("加载迁移失败", "error", err.Error())
+		return
+	}
+	// 已按旧版 ddl.sql.tpl 手动建表的库需要先执行 migrator.Baseline(ctx, 2)
+	applied, err := migrator.Up(ctx, 0)
+	if err != nil {
+		slog.Error("执行迁移失败", "error", err.Error())
+	} else {
+		slog.Info("执行迁移完成", "applied", len(applied))
+	}
+
+	drifts, err := CheckSchemaDrift(db, &ApprovalM{}, &ApprovalHistoryM{}, &ApprovalFormFieldM{})
+	if err != nil {
+		slog.Error("检查表结构失败", "error", err.Error())
+		return
+	}
+	for _, d := range drifts {
+		slog.Warn("模型与表结构不一致", "drift", d.String())
+	}
 }
 
 // demoJSONQueryHelper 演示如何使用 JSON 查询辅助工具
@@ -47,8 +346,7 @@ func demoJSONQueryHelper(db *gorm.DB) {
 	} else {
 		slog.Info("根据审批名称精确查询结果", "count", len(approvalsByName))
 		for _, approval := range approvalsByName {
-			var larkApproval LarkApproval
-			if err = json.Unmarshal(approval.LarkData, &larkApproval); err == nil {
+			if larkApproval, err := approval.LarkData.Get(); err == nil {
 				slog.Info("精确查询到的审批", "name", larkApproval.ApprovalName)
 			}
 		}
@@ -62,10 +360,28 @@ func demoJSONQueryHelper(db *gorm.DB) {
 		slog.Info("根据任务ID查询结果", "count", len(approvalsByTaskID), "task_id", "10")
 	}
 
+	// 示例3：组合查询条件并按游标分页，适合结果集很大的场景
+	req := ApprovalPageRequest{
+		Conditions: []clause.Expression{helper.ApprovalCodeIs("aaaaaa"), helper.StatusIs(ApprovalStatusPending)},
+		PageSize:   10,
+	}
+	for pageNo := 1; ; pageNo++ {
+		page, err := helper.FindPage(context.Background(), req)
+		if err != nil {
+			slog.Error("分页查询失败", "error", err.Error())
+			break
+		}
+		slog.Info("分页查询结果", "page", pageNo, "count", len(page.Items))
+		if page.NextCursor == "" {
+			break
+		}
+		req.After = page.NextCursor
+	}
+
 	// 2. 使用虚拟字段结构体（推荐用于需要频繁访问JSON内部字段的场景）
 	// 优点：可以像访问普通结构体字段一样访问JSON数据
 	var virtualApprovals []ApprovalMWithVirtualFields
-	// 注意：这里不需要在Select中指定JSON字段，AfterFind钩子会自动处理
+	// 注意：查询结果需要包含 lark_data 列，JSONPathPlugin 会根据 jsonpath 标签自动填充虚拟字段
 	result := db.Find(&virtualApprovals)
 	if result.Error != nil {
 		slog.Error("使用虚拟字段查询失败", "error", result.Error.Error())
@@ -79,23 +395,32 @@ func demoJSONQueryHelper(db *gorm.DB) {
 		}
 	}
 
-	// 3. 使用原始SQL查询（推荐用于复杂的JSON查询场景）
-	// 优点：灵活性最高，可以实现任何复杂的JSON查询逻辑
-	var rawResults []*ApprovalM
-	// 查询approval_name包含"zhangsan"且task_list中包含id字段的记录
-	sqlQuery := "SELECT * FROM approval WHERE lark_data->>'$.approval_name' LIKE ? AND JSON_EXTRACT(lark_data, '$.task_list[*].id') IS NOT NULL"
-	if err := db.Raw(sqlQuery, "%zhangsan%").Scan(&rawResults).Error; err != nil {
-		slog.Error("原始SQL查询失败", "error", err.Error())
+	// 3. 使用 ApprovalFilter 组合复杂条件（推荐用于多条件搜索场景）
+	// 优点：无需手写 SQL，AND/OR 分组、时间范围和数组元素匹配编译为一条查询，且自动适配数据库方言
+	// 查询approval_name包含"zhangsan"且task_list非空，或者存在用户 zhangsan0 待处理任务的记录，按发起时间倒序
+	filterResults, err := helper.Search(context.Background(), ApprovalQuery{
+		Filter: ApprovalFilter{
+			Or: []ApprovalFilter{
+				{ApprovalNameLike: "%zhangsan%", Task: &TaskMatch{}},
+				{Task: &TaskMatch{UserID: "zhangsan0", Status: ApprovalStatusPending}},
+			},
+		},
+		Sort:  []ApprovalSort{{Field: SortByStartTime, Desc: true}},
+		Limit: 20,
+	})
+	if err != nil {
+		slog.Error("组合条件查询失败", "error", err.Error())
 	} else {
-		slog.Info("原始SQL复杂查询结果", "count", len(rawResults))
+		slog.Info("组合条件查询结果", "count", len(filterResults))
 	}
 
-	// 4. 使用JSON查询辅助函数构建条件
-	// 优点：避免手写SQL字符串，减少错误
+	// 4. 使用类型安全的JSON路径构建条件
+	// 优点：避免手写SQL字符串，路径和值都作为绑定参数传入，且自动适配数据库方言
 	var helperResults []*ApprovalM
-	// 构建条件：approval_name包含"zhangsan"的记录
-	queryCondition := JSONUnquote("lark_data", "$.approval_name") + " LIKE ?"
-	if err := db.Where(queryCondition, "%zhangsan%").Find(&helperResults).Error; err != nil {
+	// 构建条件：approval_name包含"zhangsan"的记录，并按第一个任务的 user_id 排序
+	queryCondition := Path("approval_name").In("lark_data").Like("%zhangsan%")
+	orderBy := Path("task_list").Index(0).Field("user_id").In("lark_data").Asc()
+	if err := db.Where(queryCondition).Order(orderBy).Find(&helperResults).Error; err != nil {
 		slog.Error("使用辅助函数查询失败", "error", err.Error())
 	} else {
 		slog.Info("使用辅助函数查询结果", "count", len(helperResults))
@@ -116,8 +441,8 @@ func demoJSONQueryHelper(db *gorm.DB) {
 	/* 总结：选择合适的JSON查询方式
 	1. 简单查询：使用封装的查询辅助方法（FindByXXX）
 	2. 频繁访问JSON内部字段：使用虚拟字段结构体
-	3. 复杂查询条件：使用原始SQL或JSON查询辅助函数
-	4. 性能考量：对于频繁查询的JSON字段，考虑在数据库中创建索引
+	3. 复杂查询条件：使用原始SQL或类型安全的JSON路径（Path）
+	4. 性能考量：对于频繁查询的JSON字段，使用 JSONIndexManager 创建生成列和索引，并设置到 JSONQueryHelper.Indexes
 	*/
 }
 
@@ -142,10 +467,10 @@ func demoJSONUpdateHelper(db *gorm.DB) {
 
 	// 2. 更新嵌套的JSON字段
 	// 添加 status 字段并设置值
-	if err := helper.UpdateNestedJSONField(targetInstanceID, "$.status", "approved"); err != nil {
+	if err := helper.UpdateNestedJSONField(targetInstanceID, "$.status", ApprovalStatusApproved); err != nil {
 		slog.Error("更新嵌套JSON字段失败", "instance_id", targetInstanceID, "error", err.Error())
 	} else {
-		slog.Info("更新嵌套JSON字段成功", "instance_id", targetInstanceID, "field", "status", "value", "approved")
+		slog.Info("更新嵌套JSON字段成功", "instance_id", targetInstanceID, "field", "status", "value", ApprovalStatusApproved)
 	}
 
 	// 3. 更新JSON数组中的元素
@@ -164,7 +489,8 @@ func demoJSONUpdateHelper(db *gorm.DB) {
 		"$.last_updated":    "2023-12-25",
 		"$.task_list[1].id": "task_updated_2",
 	}
-	if err := helper.UpdateJSONFieldsInBatch(targetInstanceID, fieldValues); err != nil {
+	defaults := UpsertDefaults{ApprovalCode: "aaaaaa", Type: "lark"}
+	if err := helper.UpdateJSONFieldsInBatch(targetInstanceID, fieldValues, defaults); err != nil {
 		slog.Error("批量更新JSON字段失败", "instance_id", targetInstanceID, "error", err.Error())
 	} else {
 		slog.Info("批量更新JSON字段成功", "instance_id", targetInstanceID, "field_count", len(fieldValues))
@@ -175,8 +501,7 @@ func demoJSONUpdateHelper(db *gorm.DB) {
 	if err := db.Where("instance_id = ?", targetInstanceID).First(&updatedApproval).Error; err != nil {
 		slog.Error("获取更新后的记录失败", "instance_id", targetInstanceID, "error", err.Error())
 	} else {
-		var larkApproval LarkApproval
-		if err := json.Unmarshal(updatedApproval.LarkData, &larkApproval); err == nil {
+		if larkApproval, err := updatedApproval.LarkData.Get(); err == nil {
 			slog.Info("验证更新结果成功", "instance_id", targetInstanceID)
 			slog.Info("更新后的审批名称", "instance_id", targetInstanceID, "approval_name", larkApproval.ApprovalName)
 			if len(larkApproval.TaskList) > 0 {
@@ -193,8 +518,8 @@ func demoJSONUpdateHelper(db *gorm.DB) {
 		slog.Info("记录不存在, 使用 JSONUpdateHelper 创建新记录", "instance_id", "lark00021_1")
 		if err := helper.UpdateJSONFieldsInBatch("lark00021_1", map[string]interface{}{
 			"$.approval_name": "使用虚拟字段更新的审批名称",
-			"$.status":        "pending",
-		}); err != nil {
+			"$.status":        ApprovalStatusPending,
+		}, defaults); err != nil {
 			slog.Error("创建记录失败", "error", err.Error())
 		} else {
 			slog.Info("创建记录成功", "instance_id", "lark00021_1")
@@ -202,9 +527,9 @@ func demoJSONUpdateHelper(db *gorm.DB) {
 	} else {
 		// 直接修改虚拟字段
 		virtualApproval.ApprovalName = "使用虚拟字段更新的审批名称"
-		virtualApproval.Status = "pending"
+		virtualApproval.Status = ApprovalStatusPending
 
-		// 保存时会自动更新JSON数据（通过BeforeSave钩子）
+		// 保存时 JSONPathPlugin 只把修改过的虚拟字段通过 JSON_SET 写回 lark_data
 		if err := db.Save(&virtualApproval).Error; err != nil {
 			slog.Error("使用虚拟字段更新失败", "error", err.Error())
 		} else {
@@ -217,11 +542,11 @@ func demoJSONUpdateHelper(db *gorm.DB) {
 	2. 使用UpdateNestedJSONField方法更新嵌套字段
 	3. 使用UpdateJSONArrayElement方法更新数组元素
 	4. 使用UpdateJSONFieldsInBatch方法批量更新多个字段
-	5. 使用虚拟字段结构体（ApprovalMWithVirtualFields）结合GORM钩子自动更新
+	5. 使用虚拟字段结构体（ApprovalMWithVirtualFields）结合 JSONPathPlugin 自动更新
 
 	注意事项：
-	- JSON路径需要使用单引号包裹，如：'$.approval_name'
-	- 批量更新时使用事务确保原子性
+	- JSON路径和值都作为绑定参数传入，无需手动加引号，如：$.approval_name
+	- 批量更新使用单条 upsert 语句并在事务中执行，记录不存在时使用 UpsertDefaults 创建
 	- 虚拟字段更新方式更加面向对象，适合频繁修改的场景
 	*/
 	slog.Info("JSON 字段更新功能演示完成")
@@ -236,6 +561,17 @@ func demoRequiredFieldValidation(db *gorm.DB) {
 
 	// 创建验证失败的记录（缺少Type字段）
 	createInvalidRecord(db)
+
+	// 通过 Updates(map) 修改不可变的 instance_id，会被 BeforeUpdate 钩子拒绝
+	err := db.Model(&ApprovalM{}).
+		Where("instance_id = ?", "lark_valid_"+strconv.Itoa(100)).
+		Updates(map[string]any{"instance_id": "lark_valid_modified"}).Error
+	var ruleErr *ColumnRuleError
+	if errors.As(err, &ruleErr) {
+		slog.Info("不可变字段验证成功", "column", ruleErr.Column, "rule", ruleErr.Rule, "error", err.Error())
+	} else {
+		slog.Error("不可变字段验证失败：应该拒绝修改 instance_id", "error", err)
+	}
 }
 
 // createValidRecord 创建有效记录（所有必填字段都有值）
@@ -246,12 +582,6 @@ func createValidRecord(db *gorm.DB) {
 			{ID: "valid_task_1", UserID: "valid_user"},
 		},
 	}
-	jsonData, err := json.Marshal(larkApproval)
-	if err != nil {
-		slog.Error("marshal lark approval failed", "error", err.Error())
-		return
-	}
-
 	// 使用新的instanceID避免重复
 	instanceID := "lark_valid_" + strconv.Itoa(100)
 
@@ -260,10 +590,10 @@ func createValidRecord(db *gorm.DB) {
 		InstanceID:   instanceID,
 		ApprovalCode: "valid_approval_code",
 		Type:         "lark",
-		LarkData:     datatypes.JSON(jsonData),
+		LarkData:     NewJSONColumn(*larkApproval),
 	}
 
-	err = db.Create(approval).Error
+	err := db.Create(approval).Error
 	if err != nil {
 		slog.Error("创建有效记录失败", "error", err.Error())
 	} else {
@@ -279,22 +609,16 @@ func createInvalidRecord(db *gorm.DB) {
 			{ID: "invalid_task_1", UserID: "invalid_user"},
 		},
 	}
-	jsonData, err := json.Marshal(larkApproval)
-	if err != nil {
-		slog.Error("marshal lark approval failed", "error", err.Error())
-		return
-	}
-
 	instanceID := "lark_invalid_" + strconv.Itoa(100)
 
 	// 故意不设置Type字段，触发验证错误
 	approval := &ApprovalM{
 		InstanceID:   instanceID,
 		ApprovalCode: "invalid_approval_code",
-		LarkData:     datatypes.JSON(jsonData),
+		LarkData:     NewJSONColumn(*larkApproval),
 	}
 
-	err = db.Create(approval).Error
+	err := db.Create(approval).Error
 	if err != nil {
 		// 预期会失败，因为Type字段为空
 		slog.Info("验证失败测试成功", "error", err.Error())
@@ -318,12 +642,6 @@ func demoJSONCreate(db *gorm.DB) {
 				{ID: lisiTaskID, UserID: lisiUserID},
 			},
 		}
-		jsonData, err := json.Marshal(larkApproval)
-		if err != nil {
-			slog.Error("marshal lark approval failed", "error", err.Error())
-			return
-		}
-
 		instanceID := "lark00011_" + strconv.Itoa(i)
 
 		approvals := []*ApprovalM{
@@ -331,11 +649,11 @@ func demoJSONCreate(db *gorm.DB) {
 				InstanceID:   instanceID,
 				ApprovalCode: "aaaaaa",
 				Type:         "lark",
-				LarkData:     datatypes.JSON(jsonData),
+				LarkData:     NewJSONColumn(*larkApproval),
 			},
 		}
 
-		err = db.Create(approvals).Error
+		err := db.Create(approvals).Error
 		if err != nil {
 			slog.Error("create approval failed", "error", err.Error())
 			return
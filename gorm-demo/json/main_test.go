@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB 打开 SQLite 内存数据库并建表
+//
+// 每个连接都是独立的内存数据库，限制为一个连接；钩子中的查询都使用同一个事务，不会互相等待。
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&ApprovalM{}, &ApprovalHistoryM{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// seedApproval 写入一条飞书审批记录
+func seedApproval(t *testing.T, db *gorm.DB, instanceID string, data LarkApproval) *ApprovalM {
+	t.Helper()
+	a := &ApprovalM{InstanceID: instanceID, ApprovalCode: "code", Type: ApprovalTypeLark, LarkData: NewJSONColumn(data)}
+	if err := db.Create(a).Error; err != nil {
+		t.Fatalf("seed %s: %v", instanceID, err)
+	}
+	return a
+}
+
+// instanceIDs 按 id 顺序查询满足条件的记录的 instance_id
+func instanceIDs(t *testing.T, db *gorm.DB, conds ...any) []string {
+	t.Helper()
+	var ids []string
+	query := db.Model(&ApprovalM{}).Order("id")
+	if len(conds) > 0 {
+		query = query.Where(conds[0], conds[1:]...)
+	}
+	if err := query.Pluck("instance_id", &ids).Error; err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// 表结构差异类型
+const (
+	DriftMissingTable  = "missing_table"  // 模型对应的表不存在
+	DriftMissingColumn = "missing_column" // 模型中的列在表中不存在
+	DriftExtraColumn   = "extra_column"   // 表中的列在模型中没有声明
+	DriftNullability   = "nullability"    // 模型与表的 NOT NULL 不一致
+	DriftMissingIndex  = "missing_index"  // 模型标签中声明的索引在表中不存在
+)
+
+// SchemaDrift 模型与数据库表结构的一处差异
+type SchemaDrift struct {
+	Table  string
+	Column string // 索引差异时为索引名
+	Kind   string
+	Detail string
+}
+
+func (d SchemaDrift) String() string {
+	if d.Column == "" {
+		return fmt.Sprintf("%s: %s (%s)", d.Table, d.Kind, d.Detail)
+	}
+	return fmt.Sprintf("%s.%s: %s (%s)", d.Table, d.Column, d.Kind, d.Detail)
+}
+
+// CheckSchemaDrift 比较 GORM 模型标签与数据库中实际的表结构，返回所有差异
+//
+// 检查表和列是否存在、非主键列的可空性以及标签中声明的索引，不比较列类型：
+// 各数据库返回的类型名与标签中的写法并不一一对应（如 json 与 jsonb、bigint unsigned 与 integer）。
+//
+//	drifts, err := CheckSchemaDrift(db, &ApprovalM{}, &ApprovalHistoryM{})
+func CheckSchemaDrift(db *gorm.DB, models ...any) ([]SchemaDrift, error) {
+	var drifts []SchemaDrift
+	migrator := db.Migrator()
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, fmt.Errorf("parse model %T: %w", model, err)
+		}
+		table := stmt.Schema.Table
+		if !migrator.HasTable(model) {
+			drifts = append(drifts, SchemaDrift{Table: table, Kind: DriftMissingTable, Detail: fmt.Sprintf("model %s", stmt.Schema.Name)})
+			continue
+		}
+
+		columnTypes, err := migrator.ColumnTypes(model)
+		if err != nil {
+			return nil, fmt.Errorf("read columns of %s: %w", table, err)
+		}
+		live := make(map[string]gorm.ColumnType, len(columnTypes))
+		for _, ct := range columnTypes {
+			live[ct.Name()] = ct
+		}
+
+		declared := map[string]bool{}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" || field.IgnoreMigration {
+				continue
+			}
+			declared[field.DBName] = true
+			ct, ok := live[field.DBName]
+			if !ok {
+				drifts = append(drifts, SchemaDrift{Table: table, Column: field.DBName, Kind: DriftMissingColumn, Detail: fmt.Sprintf("field %s", field.Name)})
+				continue
+			}
+			if field.PrimaryKey {
+				continue
+			}
+			if nullable, ok := ct.Nullable(); ok && nullable == field.NotNull {
+				drifts = append(drifts, SchemaDrift{
+					Table:  table,
+					Column: field.DBName,
+					Kind:   DriftNullability,
+					Detail: fmt.Sprintf("model %s, table %s", nullability(!field.NotNull), nullability(nullable)),
+				})
+			}
+		}
+
+		var extra []string
+		for name := range live {
+			if !declared[name] {
+				extra = append(extra, name)
+			}
+		}
+		sort.Strings(extra)
+		for _, name := range extra {
+			drifts = append(drifts, SchemaDrift{Table: table, Column: name, Kind: DriftExtraColumn, Detail: "not declared in model"})
+		}
+
+		for _, idx := range stmt.Schema.ParseIndexes() {
+			if !migrator.HasIndex(model, idx.Name) {
+				drifts = append(drifts, SchemaDrift{Table: table, Column: idx.Name, Kind: DriftMissingIndex, Detail: fmt.Sprintf("%d column(s)", len(idx.Fields))})
+			}
+		}
+	}
+	return drifts, nil
+}
+
+func nullability(nullable bool) string {
+	if nullable {
+		return "NULL"
+	}
+	return "NOT NULL"
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCheckSchemaDrift(t *testing.T) {
+	db := openTestDB(t)
+	if drifts, err := CheckSchemaDrift(db, &ApprovalM{}, &ApprovalHistoryM{}); err != nil || len(drifts) != 0 {
+		t.Fatalf("drifts after AutoMigrate = %v, %v", drifts, err)
+	}
+
+	for _, stmt := range []string{
+		"alter table approval drop column dingtalk_data",
+		"alter table approval add column legacy varchar(10)",
+		"drop index uk_tenant_instance_id",
+		"alter table approval_history rename to approval_history_old",
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	drifts, err := CheckSchemaDrift(db, &ApprovalM{}, &ApprovalHistoryM{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range drifts {
+		got = append(got, d.String())
+	}
+	want := []string{
+		"approval.dingtalk_data: missing_column (field DingTalkData)",
+		"approval.legacy: extra_column (not declared in model)",
+		"approval.uk_tenant_instance_id: missing_index (2 column(s))",
+		"approval_history: missing_table (model ApprovalHistoryM)",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("drifts = %q, want %q", got, want)
+	}
+}
+
+func TestCheckSchemaDriftNullability(t *testing.T) {
+	db := openEmptyDB(t)
+	if err := db.Exec(`create table approval_form_field (
+		id integer primary key autoincrement, tenant_id varchar(64) not null default '', approval_id integer null,
+		control_id varchar(255) not null, custom_id varchar(255) not null default '', name varchar(255) not null,
+		type varchar(32) not null, parent_id varchar(255) not null default '', row_index int not null default 0,
+		value_index int not null default 0, text_value varchar(512) not null default '', number_value decimal(20,6) null,
+		time_value datetime null)`).Error; err != nil {
+		t.Fatal(err)
+	}
+	drifts, err := CheckSchemaDrift(db, &ApprovalFormFieldM{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range drifts {
+		if d.Kind == DriftNullability {
+			got = append(got, d.String())
+		}
+	}
+	want := []string{
+		"approval_form_field.approval_id: nullability (model NOT NULL, table NULL)",
+		"approval_form_field.text_value: nullability (model NULL, table NOT NULL)",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("drifts = %q, want %q", got, want)
+	}
+}
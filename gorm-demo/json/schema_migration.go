@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed ddl.sql.tpl
+var ddlTemplate string
+
+// migrationTemplateName 迁移模板名，如 0001_create_approval.up
+var migrationTemplateName = regexp.MustCompile(`^(\d{4})_([a-z0-9_]+)\.(up|down)$`)
+
+// SchemaMigrationM 已执行的迁移版本
+type SchemaMigrationM struct {
+	Version   int       `gorm:"column:version;primaryKey;autoIncrement:false" json:"version"`
+	Name      string    `gorm:"column:name;type:varchar(255);NOT NULL" json:"name"`
+	AppliedAt time.Time `gorm:"column:applied_at;NOT NULL" json:"applied_at"`
+}
+
+// TableName 指定表名
+func (SchemaMigrationM) TableName() string {
+	return "schema_migrations"
+}
+
+// Migration 渲染后的一个迁移版本
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string // 升级语句
+	Down    []string // 回滚语句
+}
+
+// MigrationStatus 迁移版本的执行状态
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time // 未执行时为 nil
+}
+
+// SchemaMigrator 执行 ddl.sql.tpl 中定义的版本化迁移，已执行的版本记录在 schema_migrations 表中
+//
+// 每个版本的语句和版本记录在同一个事务中执行。MySQL 的 DDL 会隐式提交事务，
+// 执行到一半失败时需要手动处理已生效的语句后再重试。
+type SchemaMigrator struct {
+	DB         *gorm.DB
+	migrations []Migration
+}
+
+// NewSchemaMigrator 按 db 的方言渲染迁移模板
+func NewSchemaMigrator(db *gorm.DB) (*SchemaMigrator, error) {
+	migrations, err := LoadMigrations(db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &SchemaMigrator{DB: db, migrations: migrations}, nil
+}
+
+// LoadMigrations 按方言渲染 ddl.sql.tpl 中的全部迁移，按版本号升序返回
+func LoadMigrations(dialect string) ([]Migration, error) {
+	switch dialect {
+	case DialectMySQL, DialectPostgres, DialectSQLite:
+	default:
+		return nil, fmt.Errorf("unsupported migration dialect %q", dialect)
+	}
+	tpl, err := template.New("ddl.sql.tpl").Parse(ddlTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("parse ddl.sql.tpl: %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	data := ddlDialect{name: dialect}
+	for _, t := range tpl.Templates() {
+		m := migrationTemplateName.FindStringSubmatch(t.Name())
+		if m == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(m[1])
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		} else if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %04d has conflicting names %q and %q", version, migration.Name, m[2])
+		}
+
+		var b strings.Builder
+		if err := tpl.ExecuteTemplate(&b, t.Name(), data); err != nil {
+			return nil, fmt.Errorf("render %s: %w", t.Name(), err)
+		}
+		statements := splitSQLStatements(b.String())
+		if m[3] == "up" {
+			migration.Up = statements
+		} else {
+			migration.Down = statements
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if len(m.Up) == 0 || len(m.Down) == 0 {
+			return nil, fmt.Errorf("migration %04d_%s must define both up and down", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrations 全部迁移，按版本号升序
+func (m *SchemaMigrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Status 全部迁移及其执行状态
+func (m *SchemaMigrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Migration: migration}
+		if record, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &record.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Up 依次执行版本号不超过 target 的未执行迁移，target <= 0 时执行全部，返回本次执行的迁移
+func (m *SchemaMigrator) Up(ctx context.Context, target int) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, migration := range m.migrations {
+		if target > 0 && migration.Version > target {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err := m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := execStatements(tx, migration.Up); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigrationM{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migrate up %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		slog.Info("migration applied", "version", migration.Version, "name", migration.Name)
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down 按版本号从大到小回滚最近执行的 steps 个迁移，返回本次回滚的迁移
+func (m *SchemaMigrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		err := m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := execStatements(tx, migration.Down); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigrationM{}, "version = ?", migration.Version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migrate down %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		slog.Info("migration rolled back", "version", migration.Version, "name", migration.Name)
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Baseline 将版本号不超过 version 的迁移标记为已执行但不执行语句
+//
+// 用于接入迁移之前已按旧版 ddl.sql.tpl 建好的库：旧版文件对应 0001 和 0002，执行 Baseline(ctx, 2) 后再 Up。
+func (m *SchemaMigrator) Baseline(ctx context.Context, version int) error {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+	return m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, migration := range m.migrations {
+			if migration.Version > version {
+				break
+			}
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := tx.Create(&SchemaMigrationM{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// applied 已执行的迁移，schema_migrations 表不存在时自动创建
+func (m *SchemaMigrator) applied(ctx context.Context) (map[int]SchemaMigrationM, error) {
+	db := m.DB.WithContext(ctx)
+	if err := db.AutoMigrate(&SchemaMigrationM{}); err != nil {
+		return nil, fmt.Errorf("auto migrate schema_migrations: %w", err)
+	}
+	var records []SchemaMigrationM
+	if err := db.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]SchemaMigrationM, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+func execStatements(tx *gorm.DB, statements []string) error {
+	for _, stmt := range statements {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("%w\n%s", err, stmt)
+		}
+	}
+	return nil
+}
+
+// splitSQLStatements 按分号拆分语句，忽略引号内的分号和 -- 注释，丢弃空语句
+func splitSQLStatements(sql string) []string {
+	var (
+		statements []string
+		current    strings.Builder
+		quote      rune
+		comment    bool
+	)
+	flush := func() {
+		if s := strings.TrimSpace(current.String()); s != "" {
+			statements = append(statements, s)
+		}
+		current.Reset()
+	}
+	runes := []rune(sql)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case comment:
+			if r == '\n' {
+				comment = false
+				current.WriteRune(r)
+			}
+			continue
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			comment = true
+			continue
+		case r == ';':
+			flush()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	flush()
+	return statements
+}
+
+// ddlDialect ddl.sql.tpl 的模板数据，提供方言相关的类型和写法
+type ddlDialect struct {
+	name string
+	vars map[string]string
+}
+
+// Is 当前方言是否为 name
+func (d ddlDialect) Is(name string) bool {
+	return d.name == name
+}
+
+// PK 自增主键
+func (d ddlDialect) PK() string {
+	switch d.name {
+	case DialectPostgres:
+		return "bigserial primary key"
+	case DialectSQLite:
+		return "integer primary key autoincrement"
+	default:
+		return "bigint unsigned auto_increment primary key"
+	}
+}
+
+// BigInt 无符号 64 位整数，PostgreSQL 和 SQLite 没有无符号类型
+func (d ddlDialect) BigInt() string {
+	switch d.name {
+	case DialectPostgres:
+		return "bigint"
+	case DialectSQLite:
+		return "integer"
+	default:
+		return "bigint unsigned"
+	}
+}
+
+// Bool 布尔类型
+func (d ddlDialect) Bool() string {
+	if d.name == DialectMySQL {
+		return "tinyint(1)"
+	}
+	return "boolean"
+}
+
+// False 布尔类型的默认值 false
+func (d ddlDialect) False() string {
+	if d.name == DialectPostgres {
+		return "false"
+	}
+	return "0"
+}
+
+// JSON JSON 类型，PostgreSQL 使用 jsonb
+func (d ddlDialect) JSON() string {
+	if d.name == DialectPostgres {
+		return "jsonb"
+	}
+	return "json"
+}
+
+// Datetime 时间类型
+func (d ddlDialect) Datetime() string {
+	if d.name == DialectPostgres {
+		return "timestamptz"
+	}
+	return "datetime"
+}
+
+// Comment 列注释，只有 MySQL 支持行内注释
+func (d ddlDialect) Comment(text string) string {
+	if d.name != DialectMySQL {
+		return ""
+	}
+	return " comment '" + strings.ReplaceAll(text, "'", "''") + "'"
+}
+
+// Var 取出 With 设置的变量
+func (d ddlDialect) Var(key string) (string, error) {
+	v, ok := d.vars[key]
+	if !ok {
+		return "", fmt.Errorf("template variable %q is not set", key)
+	}
+	return v, nil
+}
+
+// With 返回设置了变量的副本，用于向子模板传参，参数为成对的 key, value
+func (d ddlDialect) With(kv ...string) (ddlDialect, error) {
+	if len(kv)%2 != 0 {
+		return d, fmt.Errorf("With expects key/value pairs")
+	}
+	vars := make(map[string]string, len(d.vars)+len(kv)/2)
+	for k, v := range d.vars {
+		vars[k] = v
+	}
+	for i := 0; i < len(kv); i += 2 {
+		vars[kv[i]] = kv[i+1]
+	}
+	return ddlDialect{name: d.name, vars: vars}, nil
+}
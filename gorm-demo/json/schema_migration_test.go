@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openEmptyDB 打开不建表的 SQLite 内存数据库
+func openEmptyDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// migrationVersions 返回迁移的版本号
+func migrationVersions(migrations []Migration) []int {
+	versions := make([]int, 0, len(migrations))
+	for _, m := range migrations {
+		versions = append(versions, m.Version)
+	}
+	return versions
+}
+
+func TestLoadMigrations(t *testing.T) {
+	for _, dialect := range []string{DialectMySQL, DialectPostgres, DialectSQLite} {
+		t.Run(dialect, func(t *testing.T) {
+			migrations, err := LoadMigrations(dialect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := migrationVersions(migrations); !slices.Equal(got, []int{1, 2, 3, 4, 5, 6, 7}) {
+				t.Errorf("versions = %v", got)
+			}
+			for _, m := range migrations {
+				for _, stmt := range slices.Concat(m.Up, m.Down) {
+					if strings.Contains(stmt, "{{") || strings.HasSuffix(stmt, ";") {
+						t.Errorf("%04d_%s: malformed statement %q", m.Version, m.Name, stmt)
+					}
+				}
+			}
+		})
+	}
+	if _, err := LoadMigrations("sqlserver"); err == nil {
+		t.Error("sqlserver should be unsupported")
+	}
+
+	// 方言相关的写法
+	mysql, _ := LoadMigrations(DialectMySQL)
+	postgres, _ := LoadMigrations(DialectPostgres)
+	if up := mysql[0].Up[0]; !strings.Contains(up, "bigint unsigned auto_increment primary key") || !strings.Contains(up, " comment '") {
+		t.Errorf("mysql 0001 = %s", up)
+	}
+	if up := postgres[0].Up[0]; !strings.Contains(up, "bigserial primary key") || !strings.Contains(up, "jsonb") || strings.Contains(up, "comment") {
+		t.Errorf("postgres 0001 = %s", up)
+	}
+}
+
+func TestSplitSQLStatements(t *testing.T) {
+	sql := `create table a (x varchar(10) default ';'); -- 注释中的 ; 不拆分
+insert into a values ("a;b"), ('it''s; fine');
+
+  ;update a set x = ` + "`;`" + `;`
+	want := []string{
+		"create table a (x varchar(10) default ';')",
+		`insert into a values ("a;b"), ('it''s; fine')`,
+		"update a set x = `;`",
+	}
+	if got := splitSQLStatements(sql); !slices.Equal(got, want) {
+		t.Errorf("statements = %q, want %q", got, want)
+	}
+}
+
+func TestSchemaMigratorSQLite(t *testing.T) {
+	ctx := context.Background()
+	db := openEmptyDB(t)
+	m, err := NewSchemaMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 0003 在 SQLite 上重建 approval 表，已有数据需要原样保留
+	if done, err := m.Up(ctx, 2); err != nil || !slices.Equal(migrationVersions(done), []int{1, 2}) {
+		t.Fatalf("up to 2: %v, %v", migrationVersions(done), err)
+	}
+	err = db.Exec(`insert into approval (id, created_at, updated_at, deleted_at, instance_id, approval_code, type, is_written_es, lark_data, dingtalk_data, version)
+		values (7, '2024-01-01 00:00:00', '2024-01-02 00:00:00', null, 'i1', 'code', 'lark', 1, '{"approval_name":"a"}', null, 3)`).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done, err := m.Up(ctx, 0); err != nil || !slices.Equal(migrationVersions(done), []int{3, 4, 5, 6, 7}) {
+		t.Fatalf("up: %v, %v", migrationVersions(done), err)
+	}
+	var a ApprovalM
+	if err := db.Where("instance_id = ?", "i1").First(&a).Error; err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := a.LarkData.Get(); a.ID != 7 || a.Version != 3 || !a.IsWrittenES || a.ApprovalCode != "code" || a.CreatedAt.Day() != 1 || data.ApprovalName != "a" {
+		t.Errorf("approval after rebuild = %+v", a)
+	}
+
+	// 迁移后的结构与模型一致，uk_tenant_instance_id 只约束未删除的记录
+	drifts, err := CheckSchemaDrift(db, &ApprovalM{}, &ApprovalHistoryM{}, &ApprovalFormFieldM{})
+	if err != nil || len(drifts) != 0 {
+		t.Errorf("drifts = %v, %v", drifts, err)
+	}
+	if err := db.Delete(&a).Error; err != nil {
+		t.Fatal(err)
+	}
+	seedApproval(t, db, "i1", LarkApproval{ApprovalName: "b", Status: ApprovalStatusPending})
+	if err := db.Create(&ApprovalM{InstanceID: "i1", ApprovalCode: "code", Type: ApprovalTypeLark}).Error; err == nil {
+		t.Error("duplicate active instance_id was created")
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil || len(statuses) != 7 {
+		t.Fatalf("status = %v, %v", statuses, err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			t.Errorf("%04d not applied", s.Version)
+		}
+	}
+
+	// 回滚 0005 之前需要清理同一 instance_id 的重复记录
+	if err := db.Unscoped().Where("deleted_at IS NOT NULL").Delete(&ApprovalM{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if done, err := m.Down(ctx, 7); err != nil || !slices.Equal(migrationVersions(done), []int{7, 6, 5, 4, 3, 2, 1}) {
+		t.Fatalf("down: %v, %v", migrationVersions(done), err)
+	}
+	if db.Migrator().HasTable("approval") || db.Migrator().HasTable("approval_history") {
+		t.Error("tables left after rolling back all migrations")
+	}
+	if done, err := m.Up(ctx, 0); err != nil || len(done) != 7 {
+		t.Errorf("up again: %v, %v", migrationVersions(done), err)
+	}
+}
+
+func TestSchemaMigratorBaseline(t *testing.T) {
+	ctx := context.Background()
+	db := openEmptyDB(t)
+	m, err := NewSchemaMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 旧版 ddl.sql.tpl 建好的库
+	legacy := m.Migrations()[:2]
+	for _, migration := range legacy {
+		if err := execStatements(db, migration.Up); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Baseline(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if done, err := m.Up(ctx, 3); err != nil || !slices.Equal(migrationVersions(done), []int{3}) {
+		t.Fatalf("up: %v, %v", migrationVersions(done), err)
+	}
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var applied []int
+	for _, s := range statuses {
+		if s.AppliedAt != nil {
+			applied = append(applied, s.Version)
+		}
+	}
+	if !slices.Equal(applied, []int{1, 2, 3}) {
+		t.Errorf("applied = %v", applied)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TenantColumn 租户列名
+const TenantColumn = "tenant_id"
+
+// ApprovalTenantTables 按租户隔离的表
+var ApprovalTenantTables = []string{"approval", "approval_history", "approval_form_field"}
+
+var (
+	// ErrTenantRequired TenantPlugin.Required 时 context 中没有租户
+	ErrTenantRequired = errors.New("tenant is required in context")
+	// ErrTenantMismatch 写入的记录属于 context 中租户以外的租户
+	ErrTenantMismatch = errors.New("tenant of record does not match context")
+	// ErrTenantUnscoped 语句引用了租户表，但无法为其加上租户条件，如 LEFT JOIN 租户表或无法解析的 Table 表达式
+	ErrTenantUnscoped = errors.New("tenant table cannot be scoped")
+)
+
+type tenantContextKey struct{}
+
+// tenantScope context 中保存的租户，all 为 true 时表示显式访问全部租户
+type tenantScope struct {
+	id  string
+	all bool
+}
+
+// WithTenant 返回带有租户的 context，经过 TenantPlugin 的查询、更新和删除只会访问该租户的记录，新建的记录写入该租户
+//
+//	ctx = WithTenant(ctx, "tenant-a")
+//	helper := NewJSONQueryHelper(db.WithContext(ctx))
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenantScope{id: tenantID})
+}
+
+// WithAllTenants 返回显式访问全部租户的 context，用于迁移、过期清理等跨租户的后台任务
+func WithAllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenantScope{all: true})
+}
+
+// TenantFromContext 取出 WithTenant 设置的租户
+func TenantFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	scope, ok := ctx.Value(tenantContextKey{}).(tenantScope)
+	if !ok || scope.all {
+		return "", false
+	}
+	return scope.id, true
+}
+
+// TenantPlugin 根据 context 中的租户为租户表的读写加上 tenant_id 条件
+//
+// 通过 db.WithContext(WithTenant(ctx, id)) 传入租户后：
+//   - Find/First/Count/Scan/Pluck、Update(s)/UpdateColumn(s)/Save、Delete 都会加上 tenant_id = id 条件，
+//     子查询同样生效，JSONQueryHelper、JSONUpdateHelper 等以该 db 创建的工具无需修改
+//   - Create 时 tenant_id 为空的记录写入该租户，属于其他租户的记录返回 ErrTenantMismatch
+//
+// 租户条件以 SQL 中引用表的名字限定：db.Table("approval AS a") 的条件为 a.tenant_id，
+// Joins("JOIN approval_history h ON ...") 同时为 h 加上条件。LEFT/RIGHT/FULL JOIN 租户表时在 WHERE 中加条件会改变语义，
+// 以及 Table 中有无法解析的租户表引用时，返回 ErrTenantUnscoped 而不是不加条件执行。
+//
+// context 中没有租户时不做处理，已有的单租户调用保持不变；Required 为 true 时返回 ErrTenantRequired，
+// 需要跨租户访问时使用 WithAllTenants。Exec/Raw 不经过插件。
+//
+//	db.Use(NewTenantPlugin(ApprovalTenantTables...))
+type TenantPlugin struct {
+	Required bool
+
+	tables map[string]bool
+}
+
+// NewTenantPlugin 创建租户插件，tables 为包含 tenant_id 列的表
+func NewTenantPlugin(tables ...string) *TenantPlugin {
+	p := &TenantPlugin{tables: make(map[string]bool, len(tables))}
+	for _, t := range tables {
+		p.tables[t] = true
+	}
+	return p
+}
+
+// Name 实现 gorm.Plugin
+func (p *TenantPlugin) Name() string {
+	return "tenant"
+}
+
+// Initialize 实现 gorm.Plugin
+//
+// 条件在钩子之前加上，BeforeUpdate 中读取旧数据、列规则检查不可变列时复制的 WHERE 已包含租户条件。
+func (p *TenantPlugin) Initialize(db *gorm.DB) error {
+	if err := db.Callback().Create().Before("gorm:before_create").Register("tenant:create", p.assignTenant); err != nil {
+		return err
+	}
+	if err := db.Callback().Query().Before("gorm:query").Register("tenant:query", p.scopeQuery); err != nil {
+		return err
+	}
+	if err := db.Callback().Row().Before("gorm:row").Register("tenant:row", p.scopeQuery); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().Before("gorm:before_update").Register("tenant:update", p.scopeWrite); err != nil {
+		return err
+	}
+	return db.Callback().Delete().Before("gorm:before_delete").Register("tenant:delete", p.scopeWrite)
+}
+
+// tenantTableRef 语句中引用的一个租户表
+type tenantTableRef struct {
+	name  string // 表名
+	alias string // SQL 中引用该表的名字，为空表示主表，条件使用 clause.CurrentTable
+	join  string // JOIN 的类型，如 INNER、LEFT，FROM 中的表为空
+}
+
+// tenantOf 返回本次操作的租户和需要加条件的租户表，ok 为 false 表示不需要处理
+func (p *TenantPlugin) tenantOf(db *gorm.DB) (tenantID string, refs []tenantTableRef, ok bool) {
+	if db.Error != nil {
+		return "", nil, false
+	}
+	refs, err := p.tenantTables(db.Statement)
+	if len(refs) == 0 && err == nil {
+		return "", nil, false
+	}
+	scope, found := db.Statement.Context.Value(tenantContextKey{}).(tenantScope)
+	switch {
+	case found && scope.all:
+		return "", nil, false
+	case found && err != nil:
+		db.AddError(err)
+	case found:
+		return scope.id, refs, true
+	case p.Required:
+		table := db.Statement.Table
+		if len(refs) > 0 {
+			table = refs[0].name
+		}
+		db.AddError(fmt.Errorf("%s: %w", table, ErrTenantRequired))
+	}
+	return "", nil, false
+}
+
+// tenantTables 返回语句的 FROM 和 JOIN 中引用的租户表
+func (p *TenantPlugin) tenantTables(stmt *gorm.Statement) ([]tenantTableRef, error) {
+	var refs []tenantTableRef
+	if stmt.TableExpr == nil {
+		table := stmt.Table
+		if table == "" && stmt.Schema != nil {
+			table = stmt.Schema.Table
+		}
+		if p.tables[table] {
+			refs = append(refs, tenantTableRef{name: table})
+		}
+	} else {
+		// db.Table 传入的表达式，可能带有别名或多个表
+		for _, item := range splitTopLevel(stmt.TableExpr.SQL, ',') {
+			name, alias, ok := parseTableRef(item)
+			if !ok {
+				if p.mentionsTenantTable(item) {
+					return nil, fmt.Errorf("table %q: %w", stmt.TableExpr.SQL, ErrTenantUnscoped)
+				}
+				continue
+			}
+			if p.tables[name] {
+				refs = append(refs, tenantTableRef{name: name, alias: alias})
+			}
+		}
+	}
+
+	// Joins 传入的 SQL，如 Joins("JOIN approval_history h ON h.approval_id = approval.id")
+	for _, j := range stmt.Joins {
+		if !strings.ContainsAny(j.Name, " \t\n") {
+			continue // 关联名，由 GORM 按模型生成
+		}
+		m := joinPattern.FindStringSubmatch(j.Name)
+		if m == nil {
+			if p.mentionsTenantTable(j.Name) {
+				return nil, fmt.Errorf("join %q: %w", j.Name, ErrTenantUnscoped)
+			}
+			continue
+		}
+		name, alias, ok := parseTableRef(m[2])
+		if !ok {
+			if p.mentionsTenantTable(m[2]) {
+				return nil, fmt.Errorf("join %q: %w", j.Name, ErrTenantUnscoped)
+			}
+			continue
+		}
+		if p.tables[name] {
+			kind := "INNER"
+			if fields := strings.Fields(m[1]); len(fields) > 0 {
+				kind = strings.ToUpper(fields[0])
+			}
+			refs = append(refs, tenantTableRef{name: name, alias: alias, join: kind})
+		}
+	}
+	return refs, nil
+}
+
+// mentionsTenantTable s 中是否出现租户表名
+func (p *TenantPlugin) mentionsTenantTable(s string) bool {
+	return slices.ContainsFunc(identifierPattern.FindAllString(s, -1), func(w string) bool { return p.tables[w] })
+}
+
+var (
+	// joinPattern [NATURAL] [LEFT|RIGHT|FULL|INNER|CROSS] [OUTER] JOIN table [[AS] alias] [ON|USING ...]
+	joinPattern = regexp.MustCompile(`(?is)^\s*((?:NATURAL\s+)?(?:(?:LEFT|RIGHT|FULL|INNER|CROSS)\s+)?(?:OUTER\s+)?)JOIN\s+(.+?)(?:\s+(?:ON|USING)\b.*)?$`)
+	// identifierPattern SQL 中的标识符
+	identifierPattern = regexp.MustCompile(`\w+`)
+)
+
+// parseTableRef 解析 "table"、"table alias"、"table AS alias"，表名和别名可以带引号，表名可以带库名
+//
+// 表函数、子查询等不是表名的引用 name 为空，ok 为 true；其他无法解析的写法 ok 为 false。
+func parseTableRef(s string) (name, alias string, ok bool) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "(") || strings.Contains(strings.SplitN(s, " ", 2)[0], "(") {
+		return "", "", true
+	}
+	fields := strings.Fields(strings.NewReplacer("`", "", `"`, "", "[", "", "]", "").Replace(s))
+	switch {
+	case len(fields) == 1:
+	case len(fields) == 2:
+		alias = fields[1]
+	case len(fields) == 3 && strings.EqualFold(fields[1], "AS"):
+		alias = fields[2]
+	default:
+		return "", "", false
+	}
+	name = fields[0]
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	if alias == "" {
+		alias = name
+	}
+	return name, alias, true
+}
+
+// splitTopLevel 按不在括号和引号中的 sep 拆分 s
+func splitTopLevel(s string, sep byte) []string {
+	var (
+		parts []string
+		depth int
+		quote byte
+		start int
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// tenantConditions 为 refs 生成租户条件，LEFT/RIGHT/FULL JOIN 的租户表无法在 WHERE 中限定，返回 ErrTenantUnscoped
+func tenantConditions(tenantID string, refs []tenantTableRef) ([]clause.Expression, error) {
+	exprs := make([]clause.Expression, 0, len(refs))
+	for _, ref := range refs {
+		switch ref.join {
+		case "LEFT", "RIGHT", "FULL", "NATURAL":
+			return nil, fmt.Errorf("%s JOIN %s: %w", ref.join, ref.name, ErrTenantUnscoped)
+		}
+		if ref.alias == "" {
+			exprs = append(exprs, tenantCondition(tenantID))
+		} else {
+			exprs = append(exprs, clause.Eq{Column: clause.Column{Table: ref.alias, Name: TenantColumn}, Value: tenantID})
+		}
+	}
+	return exprs, nil
+}
+
+func (p *TenantPlugin) scopeQuery(db *gorm.DB) {
+	tenantID, refs, ok := p.tenantOf(db)
+	if !ok {
+		return
+	}
+	exprs, err := tenantConditions(tenantID, refs)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: exprs})
+}
+
+// scopeWrite 为更新和删除加上租户条件
+//
+// GORM 在没有 WHERE 条件也没有主键时拒绝全表更新/删除，租户条件不应绕过这一检查。
+func (p *TenantPlugin) scopeWrite(db *gorm.DB) {
+	tenantID, refs, ok := p.tenantOf(db)
+	if !ok {
+		return
+	}
+	stmt := db.Statement
+	if _, hasWhere := stmt.Clauses["WHERE"]; !hasWhere && !db.AllowGlobalUpdate &&
+		!hasPrimaryKeyValue(stmt, reflect.ValueOf(stmt.Model)) && !hasPrimaryKeyValue(stmt, reflect.ValueOf(stmt.Dest)) {
+		db.AddError(gorm.ErrMissingWhereClause)
+		return
+	}
+	exprs, err := tenantConditions(tenantID, refs)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	stmt.AddClause(clause.Where{Exprs: exprs})
+}
+
+// assignTenant 为新建的记录写入租户
+func (p *TenantPlugin) assignTenant(db *gorm.DB) {
+	tenantID, _, ok := p.tenantOf(db)
+	if !ok {
+		return
+	}
+	stmt := db.Statement
+	check := func(current any) bool {
+		if s, _ := current.(string); s != "" && s != tenantID {
+			db.AddError(fmt.Errorf("%s tenant %q: %w", stmt.Table, s, ErrTenantMismatch))
+			return false
+		}
+		return true
+	}
+
+	switch dest := stmt.Dest.(type) {
+	case map[string]interface{}:
+		if check(dest[TenantColumn]) {
+			dest[TenantColumn] = tenantID
+		}
+	case []map[string]interface{}:
+		for _, m := range dest {
+			if !check(m[TenantColumn]) {
+				return
+			}
+			m[TenantColumn] = tenantID
+		}
+	default:
+		if stmt.Schema == nil {
+			return
+		}
+		field := stmt.Schema.LookUpField(TenantColumn)
+		if field == nil {
+			return
+		}
+		eachStruct(stmt.ReflectValue, func(rv reflect.Value) {
+			if db.Error != nil || rv.Type() != stmt.Schema.ModelType {
+				return
+			}
+			current, _ := field.ValueOf(stmt.Context, rv)
+			if check(current) {
+				if err := field.Set(stmt.Context, rv, tenantID); err != nil {
+					db.AddError(err)
+				}
+			}
+		})
+	}
+}
+
+// tenantCondition 当前表的 tenant_id 条件，列名带表名，与 JOIN 的 JSON 表函数等同名列区分
+func tenantCondition(tenantID string) clause.Expression {
+	return clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: TenantColumn}, Value: tenantID}
+}
+
+// hasPrimaryKeyValue v 是否为主键非零的模型结构体，GORM 会据此自动加上主键条件
+func hasPrimaryKeyValue(stmt *gorm.Statement, v reflect.Value) bool {
+	if stmt.Schema == nil || !v.IsValid() {
+		return false
+	}
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct || v.Type() != stmt.Schema.ModelType {
+		return false
+	}
+	for _, field := range stmt.Schema.PrimaryFields {
+		if _, isZero := field.ValueOf(stmt.Context, v); !isZero {
+			return true
+		}
+	}
+	return false
+}
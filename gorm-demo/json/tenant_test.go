@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// seedTenants 为 tenant-a、tenant-b 写入相同 instance_id 的记录，返回注册了 TenantPlugin 的 db
+func seedTenants(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := openTestDB(t)
+	if err := db.Use(NewTenantPlugin(ApprovalTenantTables...)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tenant := range []string{"tenant-a", "tenant-b"} {
+		tx := db.WithContext(WithTenant(context.Background(), tenant))
+		seedApproval(t, tx, "i1", LarkApproval{
+			ApprovalName: "差旅报销",
+			Status:       ApprovalStatusPending,
+			TaskList:     []*InstanceTask{{ID: "t1", UserID: "zhangsan", Status: ApprovalStatusPending}},
+		})
+		seedApproval(t, tx, "i2", LarkApproval{ApprovalName: "采购申请", Status: ApprovalStatusApproved})
+	}
+	return db
+}
+
+// tenantLarkData 不经过租户条件读取 tenant 中 instanceID 的审批数据，记录不存在时返回零值
+func tenantLarkData(t *testing.T, db *gorm.DB, tenant, instanceID string) LarkApproval {
+	t.Helper()
+	var approvals []*ApprovalM
+	if err := db.Where("tenant_id = ? AND instance_id = ?", tenant, instanceID).Find(&approvals).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(approvals) == 0 {
+		return LarkApproval{}
+	}
+	data, err := approvals[0].LarkData.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// tenantsOf 按字典序返回记录的 租户/instance_id
+func tenantsOf(approvals []*ApprovalM) []string {
+	var tenants []string
+	for _, a := range approvals {
+		tenants = append(tenants, a.TenantID+"/"+a.InstanceID)
+	}
+	slices.Sort(tenants)
+	return tenants
+}
+
+func TestTenantQueryIsolation(t *testing.T) {
+	db := seedTenants(t)
+	ctx := WithTenant(t.Context(), "tenant-a")
+	h := NewJSONQueryHelper(db.WithContext(ctx))
+
+	tests := []struct {
+		name string
+		find func() ([]*ApprovalM, error)
+		want []string
+	}{
+		{"FindByApprovalName", func() ([]*ApprovalM, error) { return h.FindByApprovalName("差旅报销") }, []string{"tenant-a/i1"}},
+		{"FindByApprovalNameLike", func() ([]*ApprovalM, error) { return h.FindByApprovalNameLike("%申请") }, []string{"tenant-a/i2"}},
+		{"FindByTaskID", func() ([]*ApprovalM, error) { return h.FindByTaskID("t1") }, []string{"tenant-a/i1"}},
+		{"FindByUserID", func() ([]*ApprovalM, error) { return h.FindByUserID("zhangsan") }, []string{"tenant-a/i1"}},
+		{"FindByStatus", func() ([]*ApprovalM, error) { return h.FindByStatus(ApprovalStatusApproved) }, []string{"tenant-a/i2"}},
+		{"FindByPathExists", func() ([]*ApprovalM, error) { return h.FindByPathExists("$.task_list[0].id") }, []string{"tenant-a/i1"}},
+		{"Search", func() ([]*ApprovalM, error) {
+			return h.Search(ctx, ApprovalQuery{Filter: ApprovalFilter{InstanceIDs: []string{"i1", "i2"}}})
+		}, []string{"tenant-a/i1", "tenant-a/i2"}},
+		{"FindPage", func() ([]*ApprovalM, error) {
+			var all []*ApprovalM
+			req := ApprovalPageRequest{PageSize: 1}
+			for {
+				page, err := h.FindPage(ctx, req)
+				if err != nil {
+					return nil, err
+				}
+				all = append(all, page.Items...)
+				if page.NextCursor == "" {
+					return all, nil
+				}
+				req.After = page.NextCursor
+			}
+		}, []string{"tenant-a/i1", "tenant-a/i2"}},
+		{"Stream", func() ([]*ApprovalM, error) {
+			var all []*ApprovalM
+			for a, err := range h.Stream(ctx, 1) {
+				if err != nil {
+					return nil, err
+				}
+				all = append(all, a)
+			}
+			return all, nil
+		}, []string{"tenant-a/i1", "tenant-a/i2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			approvals, err := tt.find()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tenantsOf(approvals); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 显式访问全部租户
+	all := NewJSONQueryHelper(db.WithContext(WithAllTenants(t.Context())))
+	approvals, err := all.FindByApprovalName("差旅报销")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tenantsOf(approvals); !slices.Equal(got, []string{"tenant-a/i1", "tenant-b/i1"}) {
+		t.Errorf("all tenants: %v", got)
+	}
+}
+
+func TestTenantTableAliasAndJoins(t *testing.T) {
+	db := seedTenants(t)
+	ta := db.WithContext(WithTenant(t.Context(), "tenant-a"))
+	all := db.WithContext(WithAllTenants(t.Context()))
+
+	// 条件以别名限定
+	for _, table := range []string{"approval AS a", "approval a", "`approval` AS a", "main.approval AS a"} {
+		var approvals []*ApprovalM
+		if err := ta.Table(table).Where("a.instance_id = ?", "i1").Find(&approvals).Error; err != nil {
+			t.Fatalf("%s: %v", table, err)
+		}
+		if got := tenantsOf(approvals); !slices.Equal(got, []string{"tenant-a/i1"}) {
+			t.Errorf("%s: got %v", table, got)
+		}
+	}
+
+	// 按 instance_id 关联时每个租户的 i1 都能关联到两个租户的历史，JOIN 的租户表同样加上条件
+	count := func(tx *gorm.DB) int64 {
+		t.Helper()
+		var n int64
+		if err := tx.Count(&n).Error; err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	join := "JOIN approval_history h ON h.instance_id = approval.instance_id"
+	if n := count(ta.Model(&ApprovalM{}).Joins(join).Where("approval.instance_id = ?", "i1")); n != 1 {
+		t.Errorf("join count = %d, want 1", n)
+	}
+	if n := count(all.Model(&ApprovalM{}).Joins(join).Where("approval.instance_id = ?", "i1")); n != 4 {
+		t.Errorf("join count of all tenants = %d, want 4", n)
+	}
+	if n := count(ta.Table("approval a, approval_history AS h").Where("h.instance_id = a.instance_id AND a.instance_id = ?", "i1")); n != 1 {
+		t.Errorf("comma join count = %d, want 1", n)
+	}
+	// 子查询本身按租户限定
+	if n := count(ta.Table("(?) AS s", ta.Model(&ApprovalM{}).Select("instance_id"))); n != 2 {
+		t.Errorf("subquery count = %d, want 2", n)
+	}
+
+	// 无法在 WHERE 中限定的写法不执行
+	for _, tx := range []*gorm.DB{
+		ta.Model(&ApprovalM{}).Joins("LEFT JOIN approval_history h ON h.instance_id = approval.instance_id"),
+		ta.Table("approval a JOIN approval_history h ON h.approval_id = a.id"),
+	} {
+		var n int64
+		if err := tx.Count(&n).Error; !errors.Is(err, ErrTenantUnscoped) {
+			t.Errorf("err = %v, want ErrTenantUnscoped", err)
+		}
+	}
+	if n := count(all.Model(&ApprovalM{}).Joins("LEFT JOIN approval_history h ON h.instance_id = approval.instance_id")); n != 8 {
+		t.Errorf("left join count of all tenants = %d, want 8", n)
+	}
+
+	// 更新同样以别名限定
+	if err := ta.Table("approval AS a").Where("a.instance_id = ?", "i2").UpdateColumn("approval_code", "changed").Error; err != nil {
+		t.Fatal(err)
+	}
+	var codes []string
+	if err := all.Model(&ApprovalM{}).Where("instance_id = ?", "i2").Order("tenant_id").Pluck("approval_code", &codes).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(codes, []string{"changed", "code"}) {
+		t.Errorf("approval codes = %v", codes)
+	}
+}
+
+func TestTenantUpdateIsolation(t *testing.T) {
+	db := seedTenants(t)
+	u := NewJSONUpdateHelper(db.WithContext(WithTenant(t.Context(), "tenant-a")))
+	seedApproval(t, db.WithContext(WithTenant(t.Context(), "tenant-b")), "i4", LarkApproval{ApprovalName: "请假", Status: ApprovalStatusPending})
+
+	// 已有记录：只合并 tenant-a 的 i1；新记录写入 tenant-a
+	defaults := UpsertDefaults{ApprovalCode: "code", Type: ApprovalTypeLark}
+	if err := u.UpdateJSONFieldsInBatch("i1", map[string]any{"$.status": ApprovalStatusApproved}, defaults); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.UpdateJSONFieldsInBatch("i3", map[string]any{"$.approval_name": "请假", "$.status": ApprovalStatusPending}, defaults); err != nil {
+		t.Fatal(err)
+	}
+
+	// 单条 UPDATE 和读取-修改-写入两种方式
+	if err := u.ApplyJSONPatch("i2", JSONPatch{{Op: "replace", Path: "/approval_name", Value: []byte(`"采购"`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.ApplyJSONPatch("i1", JSONPatch{
+		{Op: "test", Path: "/status", Value: []byte(`"APPROVED"`)},
+		{Op: "replace", Path: "/approval_name", Value: []byte(`"报销"`)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// 其他租户独有的实例对 tenant-a 不存在
+	if err := u.ApplyJSONPatch("i4", JSONPatch{{Op: "replace", Path: "/approval_name", Value: []byte(`"x"`)}}); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("patch missing instance: err = %v, want ErrRecordNotFound", err)
+	}
+
+	tests := []struct {
+		tenant, instanceID, wantName, wantStatus string
+	}{
+		{"tenant-a", "i1", "报销", ApprovalStatusApproved},
+		{"tenant-a", "i2", "采购", ApprovalStatusApproved},
+		{"tenant-a", "i3", "请假", ApprovalStatusPending},
+		{"tenant-b", "i1", "差旅报销", ApprovalStatusPending},
+		{"tenant-b", "i2", "采购申请", ApprovalStatusApproved},
+		{"tenant-b", "i3", "", ""},
+		{"tenant-b", "i4", "请假", ApprovalStatusPending},
+	}
+	for _, tt := range tests {
+		got := tenantLarkData(t, db, tt.tenant, tt.instanceID)
+		if got.ApprovalName != tt.wantName || got.Status != tt.wantStatus {
+			t.Errorf("%s/%s = %s/%s, want %s/%s", tt.tenant, tt.instanceID, got.ApprovalName, got.Status, tt.wantName, tt.wantStatus)
+		}
+	}
+}
+
+func TestTenantDeleteIsolation(t *testing.T) {
+	db := seedTenants(t)
+	ta := db.WithContext(WithTenant(t.Context(), "tenant-a"))
+
+	result := ta.Where("instance_id = ?", "i1").Delete(&ApprovalM{})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if result.RowsAffected != 1 {
+		t.Errorf("rows affected = %d, want 1", result.RowsAffected)
+	}
+	if got := instanceIDs(t, db, "tenant_id = ?", "tenant-b"); !slices.Equal(got, []string{"i1", "i2"}) {
+		t.Errorf("tenant-b: %v, want [i1 i2]", got)
+	}
+	if got := instanceIDs(t, db, "tenant_id = ?", "tenant-a"); !slices.Equal(got, []string{"i2"}) {
+		t.Errorf("tenant-a: %v, want [i2]", got)
+	}
+
+	// 带租户时没有 WHERE 条件的删除仍然被拒绝
+	if err := ta.Delete(&ApprovalM{}).Error; !errors.Is(err, gorm.ErrMissingWhereClause) {
+		t.Errorf("err = %v, want ErrMissingWhereClause", err)
+	}
+}
+
+func TestTenantCreateMismatch(t *testing.T) {
+	db := seedTenants(t)
+	ta := db.WithContext(WithTenant(t.Context(), "tenant-a"))
+
+	other := &ApprovalM{TenantID: "tenant-b", InstanceID: "i9", ApprovalCode: "code", Type: ApprovalTypeLark,
+		LarkData: NewJSONColumn(LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})}
+	if err := ta.Create(other).Error; !errors.Is(err, ErrTenantMismatch) {
+		t.Fatalf("err = %v, want ErrTenantMismatch", err)
+	}
+	if got := instanceIDs(t, db, "instance_id = ?", "i9"); len(got) != 0 {
+		t.Errorf("created: %v", got)
+	}
+
+	// 同一租户或未指定租户的记录写入 context 中的租户
+	same := &ApprovalM{TenantID: "tenant-a", InstanceID: "i9", ApprovalCode: "code", Type: ApprovalTypeLark,
+		LarkData: NewJSONColumn(LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})}
+	if err := ta.Create(same).Error; err != nil {
+		t.Fatal(err)
+	}
+	unset := seedApproval(t, ta, "i10", LarkApproval{ApprovalName: "a", Status: ApprovalStatusPending})
+	if unset.TenantID != "tenant-a" {
+		t.Errorf("tenant of i10 = %q, want tenant-a", unset.TenantID)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// runTransferCommand 执行 export/import/reencrypt/reproject 子命令
+//
+//	go run . export -format ndjson -o approvals.ndjson -approval-code CODE
+//	go run . export -format csv -path '$.status' -path '$.task_list[0].user_id' > approvals.csv
+//	go run . import -policy merge -dry-run approvals.ndjson
+//	go run . import -tenant tenant-b approvals.ndjson
+//	go run . reencrypt -batch 500
+//	go run . reproject -tenant tenant-a
+func runTransferCommand(db *gorm.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: export|import|reencrypt|reproject [flags]")
+	}
+	switch args[0] {
+	case "export":
+		return runExportCommand(db, args[1:])
+	case "import":
+		return runImportCommand(db, args[1:])
+	case "reencrypt":
+		return runReencryptCommand(db, args[1:])
+	case "reproject":
+		return runReprojectCommand(db, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q, expected export, import, reencrypt or reproject", args[0])
+	}
+}
+
+// pathFlags 可重复的 -path 参数
+type pathFlags []JSONPath
+
+func (p *pathFlags) String() string {
+	paths := make([]string, 0, len(*p))
+	for _, path := range *p {
+		paths = append(paths, path.String())
+	}
+	return strings.Join(paths, ",")
+}
+
+func (p *pathFlags) Set(s string) error {
+	path, err := ParseJSONPath(s)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, path)
+	return nil
+}
+
+func runExportCommand(db *gorm.DB, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "ndjson", "ndjson or csv")
+	output := fs.String("o", "", "output file, stdout if empty")
+	approvalCode := fs.String("approval-code", "", "only export approvals of this approval_code")
+	tenant := fs.String("tenant", "", "only export approvals of this tenant")
+	var paths pathFlags
+	fs.Var(&paths, "path", "lark_data path exported as a csv column, repeatable")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	var conds []clause.Expression
+	if *approvalCode != "" {
+		conds = append(conds, NewJSONQueryHelper(db).ApprovalCodeIs(*approvalCode))
+	}
+
+	ctx := context.Background()
+	if *tenant != "" {
+		ctx = WithTenant(ctx, *tenant)
+	}
+	transfer := NewApprovalTransfer(db)
+	var (
+		n   int
+		err error
+	)
+	switch *format {
+	case "ndjson":
+		n, err = transfer.ExportNDJSON(ctx, w, conds...)
+	case "csv":
+		n, err = transfer.ExportCSV(ctx, w, paths, conds...)
+	default:
+		return fmt.Errorf("unknown export format %q", *format)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d approvals\n", n)
+	return nil
+}
+
+func runImportCommand(db *gorm.DB, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	policy := fs.String("policy", string(ConflictSkip), "conflict policy: skip, overwrite or merge")
+	batchSize := fs.Int("batch", DefaultTransferBatchSize, "records per batch insert")
+	dryRun := fs.Bool("dry-run", false, "validate and report without writing")
+	tenant := fs.String("tenant", "", "import into this tenant instead of the tenant_id in the file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: import [flags] FILE")
+	}
+	p, err := ParseConflictPolicy(*policy)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx := context.Background()
+	if *tenant != "" {
+		ctx = WithTenant(ctx, *tenant)
+	}
+	result, err := NewApprovalTransfer(db).ImportNDJSON(ctx, f, ImportOptions{Policy: p, BatchSize: *batchSize, DryRun: *dryRun})
+	for _, e := range result.Invalid {
+		slog.Warn("import approval failed", "line", e.Line, "instance_id", e.InstanceID, "error", e.Err.Error())
+	}
+	fmt.Fprintf(os.Stderr, "read %d, created %d, updated %d, skipped %d, invalid %d, dry run %t\n",
+		result.Read, result.Created, result.Updated, result.Skipped, len(result.Invalid), *dryRun)
+	return err
+}
+
+// runReencryptCommand 以当前主密钥重新加密已有的审批记录和变更历史，默认处理所有租户
+func runReencryptCommand(db *gorm.DB, args []string) error {
+	fs := flag.NewFlagSet("reencrypt", flag.ContinueOnError)
+	batchSize := fs.Int("batch", DefaultReencryptBatchSize, "rows per batch")
+	tenant := fs.String("tenant", "", "only reencrypt approvals of this tenant")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := WithAllTenants(context.Background())
+	if *tenant != "" {
+		ctx = WithTenant(context.Background(), *tenant)
+	}
+	result, err := ReencryptApprovals(ctx, db, *batchSize)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "scanned %d, reencrypted %d approvals and %d histories\n", result.Scanned, result.Approvals, result.Histories)
+	return nil
+}
+
+// runReprojectCommand 按 APPROVAL_FORM_PROJECTION 重建已有审批记录的表单投影，默认处理所有租户
+func runReprojectCommand(db *gorm.DB, args []string) error {
+	fs := flag.NewFlagSet("reproject", flag.ContinueOnError)
+	batchSize := fs.Int("batch", DefaultFormProjectionBatchSize, "approvals per batch")
+	tenant := fs.String("tenant", "", "only reproject approvals of this tenant")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := WithAllTenants(context.Background())
+	if *tenant != "" {
+		ctx = WithTenant(context.Background(), *tenant)
+	}
+	n, err := RebuildApprovalFormFields(ctx, db, *batchSize)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "reprojected form fields of %d approvals\n", n)
+	return nil
+}